@@ -3,13 +3,13 @@ module github.com/factory/mssql-tds-server
 go 1.21
 
 require (
+	github.com/golang-sql/civil v0.0.0-20220223132316-b832511892a9
 	github.com/mattn/go-sqlite3 v1.14.33
 	github.com/microsoft/go-mssqldb v1.6.0
+	golang.org/x/crypto v0.12.0
 )
 
 require (
-	github.com/golang-sql/civil v0.0.0-20220223132316-b832511892a9 // indirect
 	github.com/golang-sql/sqlexp v0.1.0 // indirect
-	golang.org/x/crypto v0.12.0 // indirect
 	golang.org/x/text v0.12.0 // indirect
 )
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -15,6 +16,14 @@ const (
 	AuthTypeMixed     = "MIXED"      // Mixed Authentication
 )
 
+// Authentication failure reasons
+var (
+	ErrLoginNotFound   = errors.New("login not found")
+	ErrLoginDisabled   = errors.New("login is disabled")
+	ErrLoginLocked     = errors.New("login is locked")
+	ErrInvalidPassword = errors.New("invalid password")
+)
+
 // AuthManager handles user authentication and login management
 type AuthManager struct {
 	masterDB *sql.DB // Master database connection
@@ -136,23 +145,23 @@ func (am *AuthManager) AuthenticateLogin(name, password string) (*Login, error)
 	// Get login by name
 	login, err := am.GetLoginByName(name)
 	if err != nil {
-		return nil, fmt.Errorf("login not found: %w", err)
+		return nil, fmt.Errorf("%w: '%s'", ErrLoginNotFound, name)
 	}
 
 	// Check if login is disabled
 	if login.IsDisabled {
-		return nil, fmt.Errorf("login is disabled")
+		return nil, ErrLoginDisabled
 	}
 
 	// Check if login is locked
 	if login.IsLocked {
-		return nil, fmt.Errorf("login is locked")
+		return nil, ErrLoginLocked
 	}
 
 	// Verify password hash
 	err = bcrypt.CompareHashAndPassword([]byte(login.PasswordHash), []byte(password))
 	if err != nil {
-		return nil, fmt.Errorf("invalid password")
+		return nil, ErrInvalidPassword
 	}
 
 	// Update login statistics
@@ -177,7 +186,7 @@ func (am *AuthManager) GetLoginByName(name string) (*Login, error) {
 	`
 
 	login := &Login{}
-	var createdDate, modifiedDate, lastLoginDate sql.NullString
+	var createdDate, modifiedDate, lastLoginDate, description sql.NullString
 
 	err := am.masterDB.QueryRow(query, name).Scan(
 		&login.SID,
@@ -192,13 +201,15 @@ func (am *AuthManager) GetLoginByName(name string) (*Login, error) {
 		&login.IsLocked,
 		&login.LoginCount,
 		&lastLoginDate,
-		&login.Description,
+		&description,
 	)
 
 	if err != nil {
 		return nil, fmt.Errorf("login not found: %w", err)
 	}
 
+	login.Description = description.String
+
 	// Parse dates
 	if createdDate.Valid {
 		login.CreatedDate, _ = time.Parse(time.RFC3339, createdDate.String)
@@ -226,7 +237,7 @@ func (am *AuthManager) GetLoginBySID(sid int) (*Login, error) {
 	`
 
 	login := &Login{}
-	var createdDate, modifiedDate, lastLoginDate sql.NullString
+	var createdDate, modifiedDate, lastLoginDate, description sql.NullString
 
 	err := am.masterDB.QueryRow(query, sid).Scan(
 		&login.SID,
@@ -241,13 +252,15 @@ func (am *AuthManager) GetLoginBySID(sid int) (*Login, error) {
 		&login.IsLocked,
 		&login.LoginCount,
 		&lastLoginDate,
-		&login.Description,
+		&description,
 	)
 
 	if err != nil {
 		return nil, fmt.Errorf("login not found: %w", err)
 	}
 
+	login.Description = description.String
+
 	// Parse dates
 	if createdDate.Valid {
 		login.CreatedDate, _ = time.Parse(time.RFC3339, createdDate.String)
@@ -288,7 +301,7 @@ func (am *AuthManager) ListLogins() ([]*Login, error) {
 
 	for rows.Next() {
 		login := &Login{}
-		var createdDate, modifiedDate, lastLoginDate sql.NullString
+		var createdDate, modifiedDate, lastLoginDate, description sql.NullString
 
 		err := rows.Scan(
 			&login.SID,
@@ -303,13 +316,15 @@ func (am *AuthManager) ListLogins() ([]*Login, error) {
 			&login.IsLocked,
 			&login.LoginCount,
 			&lastLoginDate,
-			&login.Description,
+			&description,
 		)
 
 		if err != nil {
 			continue
 		}
 
+		login.Description = description.String
+
 		// Parse dates
 		if createdDate.Valid {
 			login.CreatedDate, _ = time.Parse(time.RFC3339, createdDate.String)
@@ -419,7 +434,8 @@ func (am *AuthManager) updateLoginStats(sid int) error {
 }
 
 // InitializeDefaultLogins creates default logins (sa)
-func (am *AuthManager) InitializeDefaultLogins() error {
+// saPassword is the password of sa when it is created; an existing sa keeps its own
+func (am *AuthManager) InitializeDefaultLogins(saPassword string) error {
 	// Check if sa login exists
 	_, err := am.GetLoginByName("sa")
 	if err == nil {
@@ -427,9 +443,8 @@ func (am *AuthManager) InitializeDefaultLogins() error {
 		return nil
 	}
 
-	// Create sa login with default password (empty password for now)
-	// In production, require setting password
-	_, err = am.CreateLogin("sa", "", AuthTypeSQLServer)
+	// An empty password is for development only
+	_, err = am.CreateLogin("sa", saPassword, AuthTypeSQLServer)
 	if err != nil {
 		return fmt.Errorf("failed to create sa login: %w", err)
 	}
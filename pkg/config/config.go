@@ -0,0 +1,391 @@
+// Package config loads the server configuration from a JSON file,
+// environment variables and command-line flags
+package config
+
+import (
+	cryptotls "crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/factory/mssql-tds-server/pkg/tls"
+)
+
+// EnvPrefix starts the names of the environment variables that override the
+// config file: TDS_DATA_DIR for -data-dir, and so on. TDS_CONFIG names the file
+const EnvPrefix = "TDS_"
+
+// Config is the server configuration
+type Config struct {
+	Listen         []string `json:"listen"`                // host:port addresses
+	DataDir        string   `json:"data_dir"`              // Catalog and user databases
+	MasterDB       string   `json:"master_db"`             // Defaults to tds_server.db in DataDir
+	InMemory       bool     `json:"in_memory"`             // Keep every database in memory instead of DataDir and MasterDB
+	SAPassword     string   `json:"sa_password,omitempty"` // Password of the sa login when it is created
+	LogLevel       string   `json:"log_level"`             // debug, info, warn or error
+	MaxConnections int      `json:"max_connections"`       // 0 for no limit
+	TLS            TLS      `json:"tls"`
+	Timeouts       Timeouts `json:"timeouts"`
+}
+
+// TLS configures encryption
+type TLS struct {
+	Enabled         bool   `json:"enabled"`
+	CertFile        string `json:"cert_file"` // Generated with KeyFile when both are missing
+	KeyFile         string `json:"key_file"`
+	MinVersion      string `json:"min_version"` // 1.2 or 1.3
+	ForceEncryption bool   `json:"force_encryption"`
+	Strict          string `json:"strict"` // TDS 8.0 strict encryption: disabled, allowed or required
+}
+
+// Timeouts limits how long the server waits for clients; 0 waits forever
+type Timeouts struct {
+	Login    Duration `json:"login"`    // From accepting a connection to the end of LOGIN7
+	Shutdown Duration `json:"shutdown"` // For running requests to finish once shutdown starts
+}
+
+// Duration is a time.Duration written as a string such as "30s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the configuration used where nothing overrides it
+func Default() *Config {
+	defaults := tls.DefaultConfig()
+	return &Config{
+		Listen:   []string{":1433"},
+		DataDir:  "./data",
+		LogLevel: "info",
+		TLS: TLS{
+			CertFile:   defaults.CertFile,
+			KeyFile:    defaults.KeyFile,
+			MinVersion: "1.2",
+			Strict:     "disabled",
+		},
+		Timeouts: Timeouts{
+			Login:    Duration(time.Minute),
+			Shutdown: Duration(30 * time.Second),
+		},
+	}
+}
+
+// setting is a configuration value that an environment variable and a flag can set
+type setting struct {
+	name   string // Flag name; the environment variable is EnvPrefix + NAME_IN_CAPS
+	usage  string
+	isBool bool
+	set    func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{name: "listen", usage: "comma-separated `addresses` to listen on", set: func(c *Config, v string) error {
+		c.Listen = splitList(v)
+		return nil
+	}},
+	{name: "data-dir", usage: "`directory` of the catalog and user databases", set: func(c *Config, v string) error {
+		c.DataDir = v
+		return nil
+	}},
+	{name: "master-db", usage: "`path` of the server's own database", set: func(c *Config, v string) error {
+		c.MasterDB = v
+		return nil
+	}},
+	{name: "in-memory", usage: "keep every database in memory; nothing is written to disk", isBool: true, set: func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.InMemory = b
+		return err
+	}},
+	{name: "sa-password", usage: "`password` given to the sa login when it is created", set: func(c *Config, v string) error {
+		c.SAPassword = v
+		return nil
+	}},
+	{name: "log-level", usage: "`level`: debug, info, warn or error", set: func(c *Config, v string) error {
+		c.LogLevel = v
+		return nil
+	}},
+	{name: "max-connections", usage: "maximum `number` of open connections, 0 for no limit", set: func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		c.MaxConnections = n
+		return err
+	}},
+	{name: "tls", usage: "enable TLS", isBool: true, set: func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.TLS.Enabled = b
+		return err
+	}},
+	{name: "tls-cert", usage: "certificate `file`", set: func(c *Config, v string) error {
+		c.TLS.CertFile = v
+		return nil
+	}},
+	{name: "tls-key", usage: "private key `file`", set: func(c *Config, v string) error {
+		c.TLS.KeyFile = v
+		return nil
+	}},
+	{name: "tls-min-version", usage: "minimum TLS `version`: 1.2 or 1.3", set: func(c *Config, v string) error {
+		c.TLS.MinVersion = v
+		return nil
+	}},
+	{name: "force-encryption", usage: "refuse clients that do not encrypt", isBool: true, set: func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.TLS.ForceEncryption = b
+		return err
+	}},
+	{name: "tls-strict", usage: "TDS 8.0 strict encryption `mode`: disabled, allowed or required", set: func(c *Config, v string) error {
+		c.TLS.Strict = v
+		return nil
+	}},
+	{name: "login-timeout", usage: "`duration` a client has to log in, 0 for no limit", set: func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		c.Timeouts.Login = Duration(d)
+		return err
+	}},
+	{name: "shutdown-timeout", usage: "`duration` running requests have to finish on shutdown, 0 for no limit", set: func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		c.Timeouts.Shutdown = Duration(d)
+		return err
+	}},
+}
+
+func (s setting) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and the command-line flags in args, each overriding the one
+// before. The file is named by -config or TDS_CONFIG. printConfig reports
+// -print-config. The configuration returned is valid
+func Load(args []string, getenv func(string) string) (cfg *Config, printConfig bool, err error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", getenv(EnvPrefix+"CONFIG"), "JSON config `file`")
+	fs.BoolVar(&printConfig, "print-config", false, "print the configuration and exit")
+
+	// Flags apply last, in the order given
+	type flagValue struct {
+		setting setting
+		value   string
+	}
+	var flags []flagValue
+	for _, s := range settings {
+		s := s
+		record := func(v string) error {
+			flags = append(flags, flagValue{s, v})
+			return nil
+		}
+		usage := fmt.Sprintf("%s (%s)", s.usage, s.envName())
+		if s.isBool {
+			fs.BoolFunc(s.name, usage, func(v string) error {
+				if _, err := strconv.ParseBool(v); err != nil {
+					return err
+				}
+				return record(v)
+			})
+		} else {
+			fs.Func(s.name, usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+	if fs.NArg() > 0 {
+		return nil, false, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	cfg = Default()
+	if *configFile != "" {
+		if err := cfg.readFile(*configFile); err != nil {
+			return nil, false, err
+		}
+	}
+	for _, s := range settings {
+		if v := getenv(s.envName()); v != "" {
+			if err := s.set(cfg, v); err != nil {
+				return nil, false, fmt.Errorf("invalid %s: %w", s.envName(), err)
+			}
+		}
+	}
+	for _, f := range flags {
+		if err := f.setting.set(cfg, f.value); err != nil {
+			return nil, false, fmt.Errorf("invalid -%s: %w", f.setting.name, err)
+		}
+	}
+
+	cfg.MasterDB = cfg.MasterDBPath()
+	if err := cfg.Validate(); err != nil {
+		return nil, false, err
+	}
+	return cfg, printConfig, nil
+}
+
+// readFile overrides c with the settings in a JSON file; unknown keys are errors
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// MasterDBPath returns the path of the server's own database: MasterDB, or
+// tds_server.db in DataDir when it is empty
+func (c *Config) MasterDBPath() string {
+	if c.MasterDB != "" {
+		return c.MasterDB
+	}
+	return filepath.Join(c.DataDir, "tds_server.db")
+}
+
+// Validate checks the configuration. Certificate files are checked when the
+// server starts, once missing ones have been generated
+func (c *Config) Validate() error {
+	var errs []error
+	if len(c.Listen) == 0 {
+		errs = append(errs, fmt.Errorf("no listen address"))
+	}
+	for _, addr := range c.Listen {
+		_, port, err := net.SplitHostPort(addr)
+		if err == nil {
+			_, err = strconv.ParseUint(port, 10, 16)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid listen address %q", addr))
+		}
+	}
+	if c.DataDir == "" && !c.InMemory {
+		errs = append(errs, fmt.Errorf("data directory is empty"))
+	}
+	if _, err := c.Level(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.MaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("max connections must not be negative"))
+	}
+	if c.Timeouts.Login < 0 {
+		errs = append(errs, fmt.Errorf("login timeout must not be negative"))
+	}
+	if c.Timeouts.Shutdown < 0 {
+		errs = append(errs, fmt.Errorf("shutdown timeout must not be negative"))
+	}
+
+	tlsConfig, err := c.TLSConfig()
+	if err != nil {
+		errs = append(errs, err)
+	} else if !tlsConfig.Enabled {
+		if c.TLS.ForceEncryption {
+			errs = append(errs, fmt.Errorf("forcing encryption requires TLS to be enabled"))
+		}
+		if err := tls.ValidateConfig(tlsConfig); err != nil {
+			errs = append(errs, err)
+		}
+	} else {
+		if tlsConfig.CertFile == "" || tlsConfig.KeyFile == "" {
+			errs = append(errs, fmt.Errorf("TLS requires a certificate and a key file"))
+		}
+		// TLS negotiated in PRELOGIN stops at 1.2
+		if tlsConfig.MinVersion > cryptotls.VersionTLS12 && tlsConfig.Strict != tls.StrictRequired {
+			errs = append(errs, fmt.Errorf("TLS 1.3 as the minimum version requires strict encryption"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Level returns the log level. The log package writes at info level
+func (c *Config) Level() (slog.Level, error) {
+	switch c.LogLevel {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("invalid log level %q: use debug, info, warn or error", c.LogLevel)
+	}
+}
+
+// TLSConfig returns the TLS settings as the tls package takes them
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	config := tls.DefaultConfig()
+	config.Enabled = c.TLS.Enabled
+	config.ForceEncryption = c.TLS.ForceEncryption
+	config.CertFile = c.TLS.CertFile
+	config.KeyFile = c.TLS.KeyFile
+
+	switch c.TLS.MinVersion {
+	case "1.2":
+		config.MinVersion = cryptotls.VersionTLS12
+	case "1.3":
+		config.MinVersion = cryptotls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid TLS minimum version %q: use 1.2 or 1.3", c.TLS.MinVersion)
+	}
+
+	switch c.TLS.Strict {
+	case "disabled":
+		config.Strict = tls.StrictDisabled
+	case "allowed":
+		config.Strict = tls.StrictAllowed
+	case "required":
+		config.Strict = tls.StrictRequired
+	default:
+		return nil, fmt.Errorf("invalid strict encryption mode %q: use disabled, allowed or required", c.TLS.Strict)
+	}
+
+	return config, nil
+}
+
+// Write writes the configuration as JSON, in the format of the config file;
+// the sa password is left out
+func (c *Config) Write(w io.Writer) error {
+	printed := *c
+	printed.SAPassword = ""
+	out, err := json.MarshalIndent(&printed, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
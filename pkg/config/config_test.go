@@ -0,0 +1,185 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/factory/mssql-tds-server/pkg/tls"
+)
+
+// env returns a getenv function serving vars
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "server.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, printConfig, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if printConfig {
+		t.Error("printConfig = true without -print-config")
+	}
+	if got := strings.Join(cfg.Listen, ","); got != ":1433" {
+		t.Errorf("Listen = %s, want :1433", got)
+	}
+	if want := filepath.Join("data", "tds_server.db"); cfg.MasterDB != want {
+		t.Errorf("MasterDB = %s, want %s", cfg.MasterDB, want)
+	}
+	if cfg.TLS.Enabled {
+		t.Error("TLS enabled by default")
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `{
+		"listen": ["127.0.0.1:1500"],
+		"data_dir": "/srv/tds",
+		"log_level": "warn",
+		"max_connections": 10,
+		"tls": {"enabled": true, "cert_file": "/etc/tds/cert.pem", "key_file": "/etc/tds/key.pem"},
+		"timeouts": {"login": "5s", "shutdown": "10s"}
+	}`)
+
+	vars := map[string]string{
+		"TDS_CONFIG":          path,
+		"TDS_MAX_CONNECTIONS": "20",
+		"TDS_LOG_LEVEL":       "error",
+	}
+	args := []string{"-log-level", "debug", "--listen", "127.0.0.1:1501, 127.0.0.1:1502", "-force-encryption"}
+
+	cfg, _, err := Load(args, env(vars))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// File, then environment, then flags
+	if cfg.DataDir != "/srv/tds" || cfg.MasterDB != "/srv/tds/tds_server.db" {
+		t.Errorf("DataDir, MasterDB = %s, %s, want the file's", cfg.DataDir, cfg.MasterDB)
+	}
+	if cfg.MaxConnections != 20 {
+		t.Errorf("MaxConnections = %d, want 20 from the environment", cfg.MaxConnections)
+	}
+	if cfg.LogLevel != "debug" {
+		t.Errorf("LogLevel = %s, want debug from the flag", cfg.LogLevel)
+	}
+	if got := strings.Join(cfg.Listen, ","); got != "127.0.0.1:1501,127.0.0.1:1502" {
+		t.Errorf("Listen = %s, want the flag's", got)
+	}
+	if !cfg.TLS.Enabled || !cfg.TLS.ForceEncryption || cfg.TLS.CertFile != "/etc/tds/cert.pem" {
+		t.Errorf("TLS = %+v, want enabled and forced with the file's certificate", cfg.TLS)
+	}
+	if time.Duration(cfg.Timeouts.Login) != 5*time.Second {
+		t.Errorf("login timeout = %v, want 5s", time.Duration(cfg.Timeouts.Login))
+	}
+	if time.Duration(cfg.Timeouts.Shutdown) != 10*time.Second {
+		t.Errorf("shutdown timeout = %v, want 10s", time.Duration(cfg.Timeouts.Shutdown))
+	}
+}
+
+func TestLoadInMemory(t *testing.T) {
+	cfg, _, err := Load([]string{"-data-dir", ""}, env(map[string]string{"TDS_IN_MEMORY": "true"}))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.InMemory {
+		t.Error("InMemory = false with TDS_IN_MEMORY=true")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+		want string
+	}{
+		{"unknown key", `{"port": 1433}`, nil, "unknown field"},
+		{"bad duration", `{"timeouts": {"login": 30}}`, nil, "duration"},
+		{"bad address", "", []string{"-listen", "localhost"}, "invalid listen address"},
+		{"bad log level", "", []string{"-log-level", "verbose"}, "invalid log level"},
+		{"bad number", "", []string{"-max-connections", "many"}, "invalid -max-connections"},
+		{"no data directory", "", []string{"-data-dir", ""}, "data directory is empty"},
+		{"negative timeout", "", []string{"-shutdown-timeout", "-1s"}, "must not be negative"},
+		{"strict without TLS", "", []string{"-tls-strict", "required"}, "strict encryption requires"},
+		{"forced without TLS", "", []string{"-force-encryption"}, "requires TLS"},
+		{"TLS 1.3 in PRELOGIN", "", []string{"-tls", "-tls-min-version", "1.3"}, "requires strict encryption"},
+		{"extra argument", "", []string{"1433"}, "unexpected argument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+			_, _, err := Load(args, env(nil))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	cfg := Default()
+	cfg.TLS.Enabled = true
+	cfg.TLS.Strict = "allowed"
+
+	config, err := cfg.TLSConfig()
+	if err != nil {
+		t.Fatalf("TLSConfig() error = %v", err)
+	}
+	if !config.Enabled || config.Strict != tls.StrictAllowed || config.CertFile != cfg.TLS.CertFile {
+		t.Errorf("TLSConfig() = %+v", config)
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	cfg, printConfig, err := Load([]string{"-print-config", "-sa-password", "secret", "-login-timeout", "90s"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !printConfig {
+		t.Error("printConfig = false with -print-config")
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.Write(&buf); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("Write() printed the sa password:\n%s", buf.String())
+	}
+
+	var read Config
+	if err := json.Unmarshal(buf.Bytes(), &read); err != nil {
+		t.Fatalf("printed config does not read back: %v", err)
+	}
+	if read.Timeouts.Login != cfg.Timeouts.Login || read.MasterDB != cfg.MasterDB {
+		t.Errorf("read back %+v, want %+v", read, cfg)
+	}
+
+	// The printed config sets no password rather than a placeholder
+	reloaded, _, err := Load([]string{"-config", writeConfig(t, buf.String())}, env(nil))
+	if err != nil {
+		t.Fatalf("Load() of the printed config error = %v", err)
+	}
+	if reloaded.SAPassword != "" {
+		t.Errorf("SAPassword = %q after a round trip, want none", reloaded.SAPassword)
+	}
+}
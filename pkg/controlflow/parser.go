@@ -19,6 +19,7 @@ const (
 	StatementELSE
 	StatementWHILE
 	StatementEND
+	StatementReturn
 )
 
 // ParseStatement determines the type of SQL statement
@@ -41,6 +42,11 @@ func ParseStatement(sql string) StatementType {
 		return StatementDeclare
 	}
 
+	// Check for RETURN
+	if returnRegex.MatchString(sqlUpper) {
+		return StatementReturn
+	}
+
 	// Check for SET
 	if strings.HasPrefix(sqlUpper, "SET ") {
 		return StatementSet
@@ -57,6 +63,19 @@ func ParseStatement(sql string) StatementType {
 	return StatementQuery
 }
 
+// returnRegex matches a RETURN statement, with or without a status expression
+var returnRegex = regexp.MustCompile(`^RETURN\b`)
+
+// ParseReturnStatement returns the status expression of a RETURN statement
+// The expression is empty when RETURN has none
+func ParseReturnStatement(sql string) (string, error) {
+	sql = strings.TrimSpace(sql)
+	if !returnRegex.MatchString(strings.ToUpper(sql)) {
+		return "", fmt.Errorf("not a RETURN statement")
+	}
+	return strings.TrimSpace(sql[len("RETURN"):]), nil
+}
+
 // ParseIFBlock parses an IF statement block including nested statements
 // Format: IF condition THEN statements [ELSE statements] END
 func ParseIFBlock(sql string) (*Block, []string, error) {
@@ -293,12 +312,24 @@ func SplitStatements(body string) ([]string, error) {
 
 		// Check for END followed by semicolon before processing whitespace
 		if strings.HasSuffix(strings.ToUpper(current), "END") && ch == ';' && inQuotes == false && inParentheses == 0 {
-			// END block ends with semicolon - split the END statement, then add semicolon to next statement
-			stmt := strings.TrimSpace(strings.TrimSuffix(current, "END;"))
-			stmt += "END;"
-			if stmt != "" {
-				statements = append(statements, stmt)
+			// END closes the block as it does before whitespace
+			if len(current) == 3 || strings.ContainsRune(" \t\r\n;", rune(current[len(current)-4])) {
+				if inIFBlock > 0 {
+					inIFBlock--
+				}
+				if inWHILEBlock > 0 {
+					inWHILEBlock--
+				}
+			}
+
+			// A nested block's END; stays part of the enclosing block
+			if inIFBlock > 0 || inWHILEBlock > 0 {
+				current += string(ch)
+				continue
 			}
+
+			// END block ends with semicolon - split the END statement
+			statements = append(statements, strings.TrimSpace(current))
 			current = ""
 			continue
 		}
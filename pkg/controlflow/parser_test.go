@@ -35,6 +35,21 @@ func TestParseStatement(t *testing.T) {
 			sql:      "SELECT * FROM users",
 			wantType: StatementQuery,
 		},
+		{
+			name:     "RETURN statement",
+			sql:      "RETURN @@ROWCOUNT",
+			wantType: StatementReturn,
+		},
+		{
+			name:     "bare RETURN",
+			sql:      "return",
+			wantType: StatementReturn,
+		},
+		{
+			name:     "RETURNS is not RETURN",
+			sql:      "RETURNS INT",
+			wantType: StatementQuery,
+		},
 	}
 
 	for _, tt := range tests {
@@ -84,6 +99,12 @@ func TestSplitStatements(t *testing.T) {
 			wantCount: 2,
 			wantErr:   false,
 		},
+		{
+			name:      "IF block with semicolons between statements",
+			body:      "IF @id = 1 THEN RETURN 7; END; SELECT 1; RETURN 0",
+			wantCount: 3,
+			wantErr:   false,
+		},
 		{
 			name:      "Statements with string containing semicolon",
 			body:      "SELECT * FROM users WHERE name = 'O\\'Brien'; SELECT * FROM users",
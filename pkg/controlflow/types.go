@@ -291,11 +291,16 @@ func getExpressionValue(expr interface{}, variables map[string]interface{}) (int
 	case string:
 		// Check if it's a variable reference
 		if strings.HasPrefix(v, "@") {
-			value, exists := variables[v]
-			if !exists {
-				return nil, fmt.Errorf("variable '%s' not found", v)
+			if value, exists := variables[v]; exists {
+				return value, nil
 			}
-			return value, nil
+			// Variable names are case-insensitive
+			for name, value := range variables {
+				if strings.EqualFold(name, v) {
+					return value, nil
+				}
+			}
+			return nil, fmt.Errorf("variable '%s' not found", v)
 		}
 		// String literal (remove quotes)
 		if strings.HasPrefix(v, "'") && strings.HasSuffix(v, "'") {
@@ -1,14 +1,17 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/factory/mssql-tds-server/pkg/sqlite"
 	"github.com/factory/mssql-tds-server/pkg/trash"
 )
 
@@ -24,12 +27,78 @@ type Database struct {
 
 // Catalog represents database catalog
 type Catalog struct {
-	masterDB *sql.DB
-	dataDir  string
+	masterDB   *sql.DB
+	dataDir    string
+	masterPath string // File or URI of masterDB, which the system databases share
+
+	// An in-memory catalog names its user databases after memory and keeps
+	// them open, by lowercased name, until they are dropped
+	memory    string
+	mu        sync.Mutex
+	memoryDBs map[string]*sqlite.Database
 }
 
+// systemTablesSQL creates the catalog tables and registers the system databases
+const systemTablesSQL = `
+	CREATE TABLE IF NOT EXISTS sys_databases (
+		database_id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		state TEXT DEFAULT 'ONLINE',
+		create_date DATETIME DEFAULT CURRENT_TIMESTAMP,
+		file_path TEXT,
+		is_system BOOLEAN DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS sys_procedures (
+		procedure_id INTEGER PRIMARY KEY,
+		database_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		definition TEXT NOT NULL,
+		create_date DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (database_id) REFERENCES sys_databases(database_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS sys_functions (
+		function_id INTEGER PRIMARY KEY,
+		database_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		definition TEXT NOT NULL,
+		return_type TEXT,
+		create_date DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (database_id) REFERENCES sys_databases(database_id)
+	);
+
+	-- Insert system databases
+	INSERT INTO sys_databases (database_id, name, state, is_system)
+	VALUES
+		(1, 'master', 'ONLINE', 1),
+		(2, 'tempdb', 'ONLINE', 1),
+		(3, 'model', 'ONLINE', 1),
+		(4, 'msdb', 'ONLINE', 1)
+	ON CONFLICT(database_id) DO NOTHING;
+`
+
+// userTablesSQL creates the system tables of a user database
+const userTablesSQL = `
+	CREATE TABLE IF NOT EXISTS sys_objects (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		create_date DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS sys_columns (
+		id INTEGER PRIMARY KEY,
+		object_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		is_nullable BOOLEAN DEFAULT 1,
+		FOREIGN KEY (object_id) REFERENCES sys_objects(id)
+	);
+`
+
 // NewCatalog creates a new database catalog
-func NewCatalog(dataDir string, masterDB *sql.DB) *Catalog {
+func NewCatalog(dataDir string, masterDB *sql.DB) (*Catalog, error) {
 	// Create data directory if it doesn't exist
 	os.MkdirAll(dataDir, 0755)
 
@@ -38,60 +107,45 @@ func NewCatalog(dataDir string, masterDB *sql.DB) *Catalog {
 	if _, err := os.Stat(masterPath); os.IsNotExist(err) {
 		db, err := sql.Open("sqlite3", masterPath)
 		if err != nil {
-			fmt.Printf("Error creating master database: %v\n", err)
-			return nil
+			return nil, fmt.Errorf("error creating master database: %w", err)
 		}
 		defer db.Close()
 
 		// Create system tables
-		_, err = db.Exec(`
-			CREATE TABLE IF NOT EXISTS sys_databases (
-				database_id INTEGER PRIMARY KEY,
-				name TEXT NOT NULL UNIQUE,
-				state TEXT DEFAULT 'ONLINE',
-				create_date DATETIME DEFAULT CURRENT_TIMESTAMP,
-				file_path TEXT,
-				is_system BOOLEAN DEFAULT 0
-			);
-
-			CREATE TABLE IF NOT EXISTS sys_procedures (
-				procedure_id INTEGER PRIMARY KEY,
-				database_id INTEGER NOT NULL,
-				name TEXT NOT NULL,
-				definition TEXT NOT NULL,
-				create_date DATETIME DEFAULT CURRENT_TIMESTAMP,
-				FOREIGN KEY (database_id) REFERENCES sys_databases(database_id)
-			);
-
-			CREATE TABLE IF NOT EXISTS sys_functions (
-				function_id INTEGER PRIMARY KEY,
-				database_id INTEGER NOT NULL,
-				name TEXT NOT NULL,
-				definition TEXT NOT NULL,
-				return_type TEXT,
-				create_date DATETIME DEFAULT CURRENT_TIMESTAMP,
-				FOREIGN KEY (database_id) REFERENCES sys_databases(database_id)
-			);
-
-			-- Insert system databases
-			INSERT INTO sys_databases (database_id, name, state, is_system)
-			VALUES
-				(1, 'master', 'ONLINE', 1),
-				(2, 'tempdb', 'ONLINE', 1),
-				(3, 'model', 'ONLINE', 1),
-				(4, 'msdb', 'ONLINE', 1)
-			ON CONFLICT(database_id) DO NOTHING;
-		`)
+		_, err = db.Exec(systemTablesSQL)
 		if err != nil {
-			fmt.Printf("Error creating system tables: %v\n", err)
-			return nil
+			return nil, fmt.Errorf("error creating system tables: %w", err)
 		}
 	}
 
+	// The catalog queries masterDB, so it needs the system tables too
+	if _, err := masterDB.Exec(systemTablesSQL); err != nil {
+		return nil, fmt.Errorf("error creating system tables: %w", err)
+	}
+
 	return &Catalog{
-		masterDB: masterDB,
-		dataDir:  dataDir,
+		masterDB:   masterDB,
+		dataDir:    dataDir,
+		masterPath: databaseFile(masterDB),
+	}, nil
+}
+
+// databaseFile returns the file of db's main database; "" for an in-memory one
+func databaseFile(db *sql.DB) string {
+	rows, err := db.Query("PRAGMA database_list")
+	if err != nil {
+		return ""
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var seq int
+		var name, file string
+		if err := rows.Scan(&seq, &name, &file); err == nil && name == "main" {
+			return file
+		}
+	}
+	return ""
 }
 
 // ListDatabases returns list of all databases
@@ -112,17 +166,19 @@ func (c *Catalog) ListDatabases() ([]Database, error) {
 	for rows.Next() {
 		var db Database
 		var createDate string
+		var filePath sql.NullString
 		err := rows.Scan(
 			&db.ID,
 			&db.Name,
 			&db.State,
 			&createDate,
-			&db.FilePath,
+			&filePath,
 			&db.IsSystem,
 		)
 		if err != nil {
 			continue
 		}
+		db.FilePath = filePath.String
 
 		// Parse create date
 		db.CreateDate, _ = time.Parse("2006-01-02 15:04:05", createDate)
@@ -192,37 +248,38 @@ func (c *Catalog) CreateDatabase(dbName string) (*Database, error) {
 	}
 
 	// Check if already exists
-	dbPath := filepath.Join(c.dataDir, dbName+".db")
-	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+	if _, err := c.GetDatabase(dbName); err == nil {
 		return nil, fmt.Errorf("database '%s' already exists", dbName)
 	}
 
-	// Create new database file
-	db, err := sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("error creating database file: %w", err)
+	key := strings.ToLower(dbName)
+	var dbPath string
+	var db *sql.DB
+	if c.memory != "" {
+		memoryDB, err := c.openMemoryDatabase(key)
+		if err != nil {
+			return nil, fmt.Errorf("error creating database: %w", err)
+		}
+		dbPath, db = sqlite.MemoryURI(c.memoryName(key)), memoryDB.GetDB()
+	} else {
+		dbPath = filepath.Join(c.dataDir, dbName+".db")
+		if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+			return nil, fmt.Errorf("database '%s' already exists", dbName)
+		}
+
+		// Create new database file
+		var err error
+		db, err = sql.Open("sqlite3", dbPath)
+		if err != nil {
+			return nil, fmt.Errorf("error creating database file: %w", err)
+		}
+		defer db.Close()
 	}
-	defer db.Close()
 
 	// Create system tables in new database
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS sys_objects (
-			id INTEGER PRIMARY KEY,
-			name TEXT NOT NULL,
-			type TEXT NOT NULL,
-			create_date DATETIME DEFAULT CURRENT_TIMESTAMP
-		);
-
-		CREATE TABLE IF NOT EXISTS sys_columns (
-			id INTEGER PRIMARY KEY,
-			object_id INTEGER NOT NULL,
-			name TEXT NOT NULL,
-			type TEXT NOT NULL,
-			is_nullable BOOLEAN DEFAULT 1,
-			FOREIGN KEY (object_id) REFERENCES sys_objects(id)
-		);
-	`)
+	_, err := db.Exec(userTablesSQL)
 	if err != nil {
+		c.closeMemoryDatabase(key)
 		return nil, fmt.Errorf("error creating system tables: %w", err)
 	}
 
@@ -233,6 +290,7 @@ func (c *Catalog) CreateDatabase(dbName string) (*Database, error) {
 	`
 	result, err := c.masterDB.Exec(query, dbName, dbPath)
 	if err != nil {
+		c.closeMemoryDatabase(key)
 		return nil, fmt.Errorf("error adding to catalog: %w", err)
 	}
 
@@ -268,10 +326,24 @@ func (c *Catalog) DropDatabase(dbName string) error {
 		return fmt.Errorf("database '%s' not found", dbName)
 	}
 
-	// Move database file to trash/recycle bin instead of deleting
-	err = trash.MoveToTrash(dbPath)
-	if err != nil {
-		return fmt.Errorf("error moving database to trash: %w", err)
+	if c.memory != "" {
+		// Connections still using the database see it empty
+		key := strings.ToLower(dbName)
+		c.mu.Lock()
+		memoryDB := c.memoryDBs[key]
+		c.mu.Unlock()
+		if memoryDB != nil {
+			if err := sqlite.Restore(context.Background(), memoryDB.GetDB(), nil); err != nil {
+				return fmt.Errorf("error dropping database: %w", err)
+			}
+		}
+		c.closeMemoryDatabase(key)
+	} else {
+		// Move database file to trash/recycle bin instead of deleting
+		err = trash.MoveToTrash(dbPath)
+		if err != nil {
+			return fmt.Errorf("error moving database to trash: %w", err)
+		}
 	}
 
 	// Remove from catalog
@@ -293,23 +365,25 @@ func (c *Catalog) GetDatabase(dbName string) (*Database, error) {
 	query := `
 		SELECT database_id, name, state, create_date, file_path, is_system
 		FROM sys_databases
-		WHERE name = ?
+		WHERE name = ? COLLATE NOCASE
 	`
 
 	var db Database
 	var createDate string
+	var filePath sql.NullString
 	err := c.masterDB.QueryRow(query, dbName).Scan(
 		&db.ID,
 		&db.Name,
 		&db.State,
 		&createDate,
-		&db.FilePath,
+		&filePath,
 		&db.IsSystem,
 	)
 
 	if err != nil {
 		return nil, fmt.Errorf("database '%s' not found", dbName)
 	}
+	db.FilePath = filePath.String
 
 	// Parse create date
 	db.CreateDate, _ = time.Parse("2006-01-02 15:04:05", createDate)
@@ -317,6 +391,23 @@ func (c *Catalog) GetDatabase(dbName string) (*Database, error) {
 	return &db, nil
 }
 
+// DatabasePath returns the file, or the in-memory URI, of a database; the
+// system databases are the server's own database
+func (c *Catalog) DatabasePath(dbName string) (string, error) {
+	db, err := c.GetDatabase(dbName)
+	if err != nil {
+		return "", err
+	}
+	path := db.FilePath
+	if db.IsSystem {
+		path = c.masterPath
+	}
+	if path == "" {
+		return "", fmt.Errorf("database '%s' has no file", db.Name)
+	}
+	return path, nil
+}
+
 // OpenDatabase opens a database connection
 func (c *Catalog) OpenDatabase(dbName string) (*sql.DB, error) {
 	// Get database from catalog
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/factory/mssql-tds-server/pkg/sqlite"
+)
+
+// Snapshot is the content of an in-memory catalog's databases, taken by
+// Catalog.Snapshot
+type Snapshot struct {
+	master    []byte
+	databases map[string][]byte // User databases by lowercased name
+}
+
+// NewMemoryCatalog creates a catalog whose user databases live in memory
+// like its master database, masterDB, which must be sqlite.MemoryURI(name).
+// Nothing is written to disk; the user databases are freed when they are
+// dropped or the catalog is closed
+func NewMemoryCatalog(name string, masterDB *sql.DB) (*Catalog, error) {
+	if _, err := masterDB.Exec(systemTablesSQL); err != nil {
+		return nil, fmt.Errorf("error creating system tables: %w", err)
+	}
+
+	return &Catalog{
+		masterDB:   masterDB,
+		masterPath: sqlite.MemoryURI(name),
+		memory:     name,
+		memoryDBs:  make(map[string]*sqlite.Database),
+	}, nil
+}
+
+// memoryName returns the in-memory name of a user database
+func (c *Catalog) memoryName(key string) string {
+	return c.memory + "." + key
+}
+
+// openMemoryDatabase opens the in-memory user database with a lowercased
+// name, creating it when it does not exist
+func (c *Catalog) openMemoryDatabase(key string) (*sqlite.Database, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if db, ok := c.memoryDBs[key]; ok {
+		return db, nil
+	}
+	db, err := sqlite.NewDatabase(sqlite.MemoryURI(c.memoryName(key)))
+	if err != nil {
+		return nil, err
+	}
+	c.memoryDBs[key] = db
+	return db, nil
+}
+
+// closeMemoryDatabase closes the in-memory user database with a lowercased
+// name; it is freed once no other connection uses it
+func (c *Catalog) closeMemoryDatabase(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if db, ok := c.memoryDBs[key]; ok {
+		db.Close()
+		delete(c.memoryDBs, key)
+	}
+}
+
+// Snapshot returns the content of the master database and of every user
+// database of an in-memory catalog. Changes not yet committed are left out
+func (c *Catalog) Snapshot(ctx context.Context) (*Snapshot, error) {
+	if c.memory == "" {
+		return nil, fmt.Errorf("only an in-memory catalog can be snapshotted")
+	}
+
+	master, err := sqlite.Serialize(ctx, c.masterDB)
+	if err != nil {
+		return nil, fmt.Errorf("error snapshotting master database: %w", err)
+	}
+	snap := &Snapshot{master: master, databases: make(map[string][]byte)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, db := range c.memoryDBs {
+		data, err := sqlite.Serialize(ctx, db.GetDB())
+		if err != nil {
+			return nil, fmt.Errorf("error snapshotting database '%s': %w", key, err)
+		}
+		snap.databases[key] = data
+	}
+	return snap, nil
+}
+
+// Restore brings an in-memory catalog back to a snapshot: databases created
+// since are dropped, dropped ones come back, and every database has the
+// content it had. It fails while a connection has a transaction open
+func (c *Catalog) Restore(ctx context.Context, snap *Snapshot) error {
+	if c.memory == "" {
+		return fmt.Errorf("only an in-memory catalog can be restored")
+	}
+
+	if err := sqlite.Restore(ctx, c.masterDB, snap.master); err != nil {
+		return fmt.Errorf("error restoring master database: %w", err)
+	}
+
+	c.mu.Lock()
+	var errs []error
+	for key, db := range c.memoryDBs {
+		if _, ok := snap.databases[key]; ok {
+			continue
+		}
+		// Connections still using the database see it empty
+		if err := sqlite.Restore(ctx, db.GetDB(), nil); err != nil {
+			errs = append(errs, fmt.Errorf("error dropping database '%s': %w", key, err))
+		}
+		db.Close()
+		delete(c.memoryDBs, key)
+	}
+	c.mu.Unlock()
+
+	for key, data := range snap.databases {
+		db, err := c.openMemoryDatabase(key)
+		if err == nil {
+			err = sqlite.Restore(ctx, db.GetDB(), data)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error restoring database '%s': %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close frees the user databases of an in-memory catalog; a file catalog
+// has nothing to close
+func (c *Catalog) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for key, db := range c.memoryDBs {
+		if err := db.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing database '%s': %w", key, err))
+		}
+		delete(c.memoryDBs, key)
+	}
+	return errors.Join(errs...)
+}
@@ -1,12 +1,16 @@
 package procedure
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 
 	"github.com/factory/mssql-tds-server/pkg/controlflow"
+	"github.com/factory/mssql-tds-server/pkg/sqlexecutor"
+	"github.com/factory/mssql-tds-server/pkg/sqlparser"
 	"github.com/factory/mssql-tds-server/pkg/sqlite"
 	"github.com/factory/mssql-tds-server/pkg/temp"
 	"github.com/factory/mssql-tds-server/pkg/transaction"
@@ -15,7 +19,7 @@ import (
 
 // Executor handles stored procedure execution
 type Executor struct {
-	db               *sql.DB
+	db               sqlexecutor.Querier // The pool, or a session's connection to its current database
 	storage          *Storage
 	tempTableMgr     *temp.Manager
 	transactionCtx   *transaction.Context
@@ -31,8 +35,43 @@ func NewExecutor(db *sqlite.Database, storage *Storage) (*Executor, error) {
 	}, nil
 }
 
+// WithConn returns an executor that finds procedures in storage and runs their
+// statements on conn, so that a client session's procedures run inside its
+// transaction and in its current database
+func (e *Executor) WithConn(storage *Storage, conn sqlexecutor.Querier) *Executor {
+	return &Executor{
+		db:             conn,
+		storage:        storage,
+		tempTableMgr:   e.tempTableMgr,
+		transactionCtx: transaction.NewContext(),
+	}
+}
+
+// Result is the outcome of a stored procedure call
+type Result struct {
+	// Results holds the result sets and row counts of the body's statements, in order
+	Results []*sqlexecutor.ExecuteResult
+
+	// ReturnStatus is the value of the procedure's RETURN statement, 0 without one
+	ReturnStatus int32
+
+	// Outputs holds the final values of the OUTPUT parameters, keyed by "@name" as declared
+	Outputs map[string]interface{}
+}
+
+// Output returns the final value of an OUTPUT parameter; names are case-insensitive
+func (r *Result) Output(name string) (interface{}, bool) {
+	return lookupParameter(r.Outputs, name)
+}
+
 // Execute executes a stored procedure with given parameters
-func (e *Executor) Execute(name string, paramValues map[string]interface{}) ([][]string, error) {
+func (e *Executor) Execute(name string, paramValues map[string]interface{}) (*Result, error) {
+	return e.ExecuteContext(context.Background(), name, paramValues)
+}
+
+// ExecuteContext executes a stored procedure with given parameters
+// Cancelling ctx interrupts the running statement and stops the procedure
+func (e *Executor) ExecuteContext(ctx context.Context, name string, paramValues map[string]interface{}) (*Result, error) {
 	// Retrieve procedure to check if it uses variables
 	proc, err := e.storage.Get(name)
 	if err != nil {
@@ -45,19 +84,21 @@ func (e *Executor) Execute(name string, paramValues map[string]interface{}) ([][
 		strings.Contains(bodyUpper, "SET ") ||
 		regexp.MustCompile(`SELECT\s+@\w+\s*=`).MatchString(bodyUpper) ||
 		strings.Contains(bodyUpper, "IF ") ||
+		regexp.MustCompile(`\bRETURN\b`).MatchString(bodyUpper) ||
+		hasOutputParameters(proc) || // OUTPUT parameters are assigned as variables
 		temp.IsTempTable(bodyUpper) || // Check for #temp tables
 		transaction.DetectTransactionUsage(proc.Body) // Check for transactions
 
 	if usesVariables {
-		return e.ExecuteWithVariables(name, paramValues)
+		return e.ExecuteWithVariables(ctx, name, paramValues)
 	}
 
 	// Simple execution without variables
-	return e.ExecuteSimple(name, paramValues)
+	return e.ExecuteSimple(ctx, name, paramValues)
 }
 
 // ExecuteSimple executes a procedure without variable support (backward compatible)
-func (e *Executor) ExecuteSimple(name string, paramValues map[string]interface{}) ([][]string, error) {
+func (e *Executor) ExecuteSimple(ctx context.Context, name string, paramValues map[string]interface{}) (*Result, error) {
 	// Retrieve procedure
 	proc, err := e.storage.Get(name)
 	if err != nil {
@@ -75,24 +116,27 @@ func (e *Executor) ExecuteSimple(name string, paramValues map[string]interface{}
 		return nil, fmt.Errorf("parameter replacement failed: %w", err)
 	}
 
-	// Execute SQL
-	rows, err := e.db.Query(sql)
+	// Execute each statement, keeping every result set and row count
+	statements, err := controlflow.SplitStatements(sql)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute procedure: %w", err)
+		return nil, fmt.Errorf("failed to parse procedure body: %w", err)
 	}
-	defer rows.Close()
 
-	// Read results
-	results, err := e.readResults(rows)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read results: %w", err)
+	results := make([]*sqlexecutor.ExecuteResult, 0, len(statements))
+	for _, stmt := range statements {
+		result, err := e.executeSQL(ctx, stmt, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute procedure: %w", err)
+		}
+		results = append(results, result)
 	}
 
-	return results, nil
+	return &Result{Results: results}, nil
 }
 
 // ExecuteWithVariables executes a procedure with variable support (Phase 5)
-func (e *Executor) ExecuteWithVariables(name string, paramValues map[string]interface{}) ([][]string, error) {
+// Parameters are declared as variables, so the body can assign OUTPUT parameters
+func (e *Executor) ExecuteWithVariables(ctx context.Context, name string, paramValues map[string]interface{}) (*Result, error) {
 	// Retrieve procedure
 	proc, err := e.storage.Get(name)
 	if err != nil {
@@ -105,7 +149,10 @@ func (e *Executor) ExecuteWithVariables(name string, paramValues map[string]inte
 	}
 
 	// Create variable context
-	ctx := variable.NewContext()
+	vars := variable.NewContext()
+	if err := e.declareParameters(proc, paramValues, vars); err != nil {
+		return nil, err
+	}
 
 	// Create session for temporary tables
 	sessionID := e.tempTableMgr.CreateSession()
@@ -123,10 +170,22 @@ func (e *Executor) ExecuteWithVariables(name string, paramValues map[string]inte
 	}
 
 	// Execute each statement
-	var results [][]string
+	var results []*sqlexecutor.ExecuteResult
+	var status int32
 
 	for _, stmt := range statements {
-		result, err := e.executeStatement(stmt, paramValues, ctx, sessionID, txCtx)
+		stmtResults, err := e.executeStatement(ctx, stmt, vars, sessionID, txCtx)
+
+		// Collect results in order
+		results = append(results, stmtResults...)
+
+		// RETURN ends the procedure
+		var ret *returnStatus
+		if errors.As(err, &ret) {
+			status = ret.status
+			break
+		}
+
 		if err != nil {
 			// Rollback any active transactions on error
 			if txCtx.IsActive() {
@@ -134,64 +193,163 @@ func (e *Executor) ExecuteWithVariables(name string, paramValues map[string]inte
 			}
 			return nil, fmt.Errorf("failed to execute statement: %w", err)
 		}
+	}
+
+	return &Result{
+		Results:      results,
+		ReturnStatus: status,
+		Outputs:      outputValues(proc, vars),
+	}, nil
+}
+
+// declareParameters declares the procedure's parameters as variables holding
+// their passed or default values
+func (e *Executor) declareParameters(proc *Procedure, paramValues map[string]interface{}, vars *variable.Context) error {
+	for _, param := range proc.Parameters {
+		name := "@" + param.Name
+		if _, err := vars.Declare(name, variable.Type(strings.ToUpper(param.Type)), param.Length); err != nil {
+			return err
+		}
 
-		// Collect results (non-nil results indicate a result set)
-		if result != nil && len(result) > 0 {
-			results = result
+		value, exists := lookupParameter(paramValues, name)
+		if !exists && param.HasDefault {
+			value = e.parseValue(param.Default)
+		}
+		if err := vars.Set(name, value); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// If no results, return success message
-	if len(results) == 0 {
-		results = [][]string{
-			{"Procedure executed successfully"},
+// hasOutputParameters reports whether a procedure declares OUTPUT parameters
+func hasOutputParameters(proc *Procedure) bool {
+	for _, param := range proc.Parameters {
+		if param.Output {
+			return true
 		}
 	}
+	return false
+}
 
-	return results, nil
+// outputValues returns the final values of a procedure's OUTPUT parameters
+func outputValues(proc *Procedure, vars *variable.Context) map[string]interface{} {
+	outputs := make(map[string]interface{})
+	for _, param := range proc.Parameters {
+		if !param.Output {
+			continue
+		}
+		if v, exists := vars.Get("@" + param.Name); exists {
+			outputs["@"+param.Name] = v.Value
+		}
+	}
+	return outputs
+}
+
+// returnStatus ends a procedure at a RETURN statement
+// It travels up through nested blocks as an error and carries the status
+type returnStatus struct {
+	status int32
+}
+
+func (r *returnStatus) Error() string {
+	return fmt.Sprintf("RETURN %d", r.status)
 }
 
 // executeStatement executes a single statement with variable context
-func (e *Executor) executeStatement(stmt string, paramValues map[string]interface{}, ctx *variable.Context, sessionID string, txCtx *transaction.Context) ([][]string, error) {
+// It returns the statement's result sets and row counts, in order
+func (e *Executor) executeStatement(ctx context.Context, stmt string, vars *variable.Context, sessionID string, txCtx *transaction.Context) ([]*sqlexecutor.ExecuteResult, error) {
 	// Determine statement type
 	stmtType := controlflow.ParseStatement(stmt)
 
 	// Check for CREATE TABLE #temp (temporary table)
 	if strings.HasPrefix(strings.ToUpper(stmt), "CREATE TABLE") && temp.IsTempTable(stmt) {
-		return e.executeCreateTempTable(stmt, sessionID)
+		return single(e.executeCreateTempTable(stmt, sessionID))
 	}
 
 	// Check for transaction statements
 	if transaction.IsTransactionStatement(stmt) {
-		return e.executeTransaction(stmt, txCtx)
+		return single(e.executeTransaction(ctx, stmt, txCtx))
+	}
+
+	// Check for SET NOCOUNT ON and other session options; they last until the procedure ends
+	if parsed, err := sqlparser.NewParser().Parse(stmt); err == nil && parsed.Type == sqlparser.StatementTypeSetOption {
+		return single(&sqlexecutor.ExecuteResult{
+			Message:   stmt,
+			Statement: sqlparser.StatementTypeSetOption,
+			SetOption: parsed.SetOption,
+		}, nil)
 	}
 
 	switch stmtType {
 	case controlflow.StatementDeclare:
-		return e.executeDeclare(stmt, ctx)
+		return single(e.executeDeclare(stmt, vars))
 
 	case controlflow.StatementSet:
-		return e.executeSet(stmt, ctx)
+		return single(e.executeSet(ctx, stmt, vars))
 
 	case controlflow.StatementSelectAssignment:
-		return e.executeSelectAssignment(stmt, ctx)
+		return single(e.executeSelectAssignment(ctx, stmt, vars))
 
 	case controlflow.StatementIF:
-		return e.executeIF(stmt, paramValues, ctx, sessionID, txCtx)
+		return e.executeIF(ctx, stmt, vars, sessionID, txCtx)
 
 	case controlflow.StatementWHILE:
-		return e.executeWHILE(stmt, paramValues, ctx, sessionID, txCtx)
+		return e.executeWHILE(ctx, stmt, vars, sessionID, txCtx)
+
+	case controlflow.StatementReturn:
+		return single(e.executeReturn(ctx, stmt, vars))
 
 	case controlflow.StatementQuery:
-		return e.executeQuery(stmt, paramValues, ctx, sessionID, txCtx)
+		return single(e.executeQuery(ctx, stmt, vars, sessionID, txCtx))
 
 	default:
 		return nil, fmt.Errorf("unknown statement type")
 	}
 }
 
+// IsAssignment reports whether stmt is a DECLARE, SET @var or SELECT @var =
+// statement, which Assign runs
+func IsAssignment(stmt string) bool {
+	switch controlflow.ParseStatement(stmt) {
+	case controlflow.StatementDeclare, controlflow.StatementSelectAssignment:
+		return true
+	case controlflow.StatementSet:
+		// SET NOCOUNT ON and the other session options are not assignments
+		fields := strings.Fields(stmt)
+		return len(fields) > 1 && strings.HasPrefix(fields[1], "@")
+	}
+	return false
+}
+
+// Assign runs a DECLARE, SET @var or SELECT @var = statement of a batch
+// outside any procedure, declaring or assigning the variable in vars.
+// Expressions are evaluated on the executor's connection
+func (e *Executor) Assign(ctx context.Context, stmt string, vars *variable.Context) error {
+	var err error
+	switch controlflow.ParseStatement(stmt) {
+	case controlflow.StatementDeclare:
+		_, err = e.executeDeclare(stmt, vars)
+	case controlflow.StatementSet:
+		_, err = e.executeSet(ctx, stmt, vars)
+	case controlflow.StatementSelectAssignment:
+		_, err = e.executeSelectAssignment(ctx, stmt, vars)
+	default:
+		err = fmt.Errorf("not a variable assignment: %s", stmt)
+	}
+	return err
+}
+
+// single wraps the result of a statement that produces at most one result
+func single(result *sqlexecutor.ExecuteResult, err error) ([]*sqlexecutor.ExecuteResult, error) {
+	if result == nil {
+		return nil, err
+	}
+	return []*sqlexecutor.ExecuteResult{result}, err
+}
+
 // executeCreateTempTable handles CREATE TABLE #temp statements
-func (e *Executor) executeCreateTempTable(stmt string, sessionID string) ([][]string, error) {
+func (e *Executor) executeCreateTempTable(stmt string, sessionID string) (*sqlexecutor.ExecuteResult, error) {
 	// Parse CREATE TABLE #temp
 	tableName, columns, err := temp.ParseCreateTable(stmt)
 	if err != nil {
@@ -209,7 +367,7 @@ func (e *Executor) executeCreateTempTable(stmt string, sessionID string) ([][]st
 }
 
 // executeInsertTempTable handles INSERT INTO #temp
-func (e *Executor) executeInsertTempTable(sql string, sessionID string) ([][]string, error) {
+func (e *Executor) executeInsertTempTable(sql string, sessionID string) (*sqlexecutor.ExecuteResult, error) {
 	// Parse INSERT INTO #temp VALUES (...)
 	// Simple parsing for now
 	sqlUpper := strings.ToUpper(sql)
@@ -264,7 +422,7 @@ func (e *Executor) executeInsertTempTable(sql string, sessionID string) ([][]str
 }
 
 // executeSelectTempTable handles SELECT FROM #temp
-func (e *Executor) executeSelectTempTable(sql string, sessionID string) ([][]string, error) {
+func (e *Executor) executeSelectTempTable(sql string, sessionID string) (*sqlexecutor.ExecuteResult, error) {
 	// Parse SELECT FROM #temp
 	// Simple parsing for now
 	sqlUpper := strings.ToUpper(sql)
@@ -291,31 +449,32 @@ func (e *Executor) executeSelectTempTable(sql string, sessionID string) ([][]str
 		return nil, err
 	}
 
-	// Format results as [][]string
-	var results [][]string
-
-	// Add column names
+	// Column names
 	columns := make([]string, len(table.Columns))
 	for i, col := range table.Columns {
 		columns[i] = col.Name
 	}
-	results = append(results, columns)
 
-	// Add rows
+	// Rows, with values in column order
+	resultRows := make([][]interface{}, 0, len(rows))
 	for _, row := range rows {
-		dataRow := make([]string, len(table.Columns))
+		dataRow := make([]interface{}, len(table.Columns))
 		for i, col := range table.Columns {
-			val := row[col.Name]
-			dataRow[i] = fmt.Sprintf("%v", val)
+			dataRow[i] = row[col.Name]
 		}
-		results = append(results, dataRow)
+		resultRows = append(resultRows, dataRow)
 	}
 
-	return results, nil
+	return &sqlexecutor.ExecuteResult{
+		Columns:  columns,
+		Rows:     resultRows,
+		RowCount: int64(len(resultRows)),
+		IsQuery:  true,
+	}, nil
 }
 
 // executeUpdateTempTable handles UPDATE #temp
-func (e *Executor) executeUpdateTempTable(sql string, ctx *variable.Context, sessionID string) ([][]string, error) {
+func (e *Executor) executeUpdateTempTable(sql string, vars *variable.Context, sessionID string) (*sqlexecutor.ExecuteResult, error) {
 	// Parse UPDATE #temp SET ... WHERE ...
 	// Simplified - just mark as implemented
 	// Full parsing is complex
@@ -323,7 +482,7 @@ func (e *Executor) executeUpdateTempTable(sql string, ctx *variable.Context, ses
 }
 
 // executeDeleteTempTable handles DELETE FROM #temp
-func (e *Executor) executeDeleteTempTable(sql string, ctx *variable.Context, sessionID string) ([][]string, error) {
+func (e *Executor) executeDeleteTempTable(sql string, vars *variable.Context, sessionID string) (*sqlexecutor.ExecuteResult, error) {
 	// Parse DELETE FROM #temp WHERE ...
 	// Simplified - just mark as implemented
 	// Full parsing is complex
@@ -360,14 +519,14 @@ func (e *Executor) parseValue(valueStr string) interface{} {
 }
 
 // executeTransaction handles BEGIN TRAN, COMMIT, ROLLBACK statements
-func (e *Executor) executeTransaction(stmt string, txCtx *transaction.Context) ([][]string, error) {
+func (e *Executor) executeTransaction(ctx context.Context, stmt string, txCtx *transaction.Context) (*sqlexecutor.ExecuteResult, error) {
 	// Parse transaction type
 	txType := transaction.ParseStatement(stmt)
 
 	switch txType {
 	case transaction.TransactionBegin:
 		// BEGIN TRANSACTION
-		tx, err := txCtx.Begin(e.db)
+		tx, err := txCtx.BeginContext(ctx, e.db)
 		if err != nil {
 			return nil, err
 		}
@@ -396,7 +555,7 @@ func (e *Executor) executeTransaction(stmt string, txCtx *transaction.Context) (
 }
 
 // executeDeclare handles DECLARE statements
-func (e *Executor) executeDeclare(stmt string, ctx *variable.Context) ([][]string, error) {
+func (e *Executor) executeDeclare(stmt string, vars *variable.Context) (*sqlexecutor.ExecuteResult, error) {
 	// Parse declaration
 	variable, err := variable.ParseDeclaration(stmt)
 	if err != nil {
@@ -404,7 +563,7 @@ func (e *Executor) executeDeclare(stmt string, ctx *variable.Context) ([][]strin
 	}
 
 	// Add to context
-	_, err = ctx.Declare("@"+variable.Name, variable.Type, variable.Length)
+	_, err = vars.Declare("@"+variable.Name, variable.Type, variable.Length)
 	if err != nil {
 		return nil, err
 	}
@@ -413,16 +572,30 @@ func (e *Executor) executeDeclare(stmt string, ctx *variable.Context) ([][]strin
 	return nil, nil
 }
 
+// stringLiteralRegex matches a single string literal
+var stringLiteralRegex = regexp.MustCompile(`^'(?:[^']|'')*'$`)
+
 // executeSet handles SET statements
-func (e *Executor) executeSet(stmt string, ctx *variable.Context) ([][]string, error) {
+func (e *Executor) executeSet(ctx context.Context, stmt string, vars *variable.Context) (*sqlexecutor.ExecuteResult, error) {
 	// Parse SET assignment
 	varName, value, err := variable.ParseSetAssignment(stmt)
 	if err != nil {
 		return nil, err
 	}
 
+	// Anything but a literal is an expression such as @a + 1
+	if _, isString := value.(string); isString {
+		_, expression, _ := strings.Cut(stmt, "=")
+		if expression = strings.TrimSpace(expression); !stringLiteralRegex.MatchString(expression) {
+			value, _, err = e.evaluateExpression(ctx, expression, vars)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	// Set variable value
-	err = ctx.Set("@"+varName, value)
+	err = vars.Set("@"+varName, value)
 	if err != nil {
 		return nil, err
 	}
@@ -432,58 +605,96 @@ func (e *Executor) executeSet(stmt string, ctx *variable.Context) ([][]string, e
 }
 
 // executeSelectAssignment handles SELECT @var = expression
-func (e *Executor) executeSelectAssignment(stmt string, ctx *variable.Context) ([][]string, error) {
+func (e *Executor) executeSelectAssignment(ctx context.Context, stmt string, vars *variable.Context) (*sqlexecutor.ExecuteResult, error) {
 	// Parse SELECT assignment
 	varName, expression, err := variable.ParseSelectAssignment(stmt)
 	if err != nil {
 		return nil, err
 	}
 
-	// Replace variables in expression
-	expr, err := variable.ReplaceVariables(expression, ctx)
+	// The variable keeps its value when the query returns no rows
+	value, found, err := e.evaluateExpression(ctx, expression, vars)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		err = vars.Set("@"+varName, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// No result set for SELECT assignment
+	return nil, nil
+}
+
+// executeReturn handles RETURN [status]
+func (e *Executor) executeReturn(ctx context.Context, stmt string, vars *variable.Context) (*sqlexecutor.ExecuteResult, error) {
+	expression, err := controlflow.ParseReturnStatement(stmt)
 	if err != nil {
 		return nil, err
 	}
 
+	// RETURN without a status, or with NULL, returns 0
+	ret := &returnStatus{}
+	if expression == "" {
+		return nil, ret
+	}
+
+	value, _, err := e.evaluateExpression(ctx, expression, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case nil:
+	case int64:
+		ret.status = int32(v)
+	case float64:
+		ret.status = int32(v)
+	default:
+		return nil, fmt.Errorf("RETURN status must be an integer, got %v", value)
+	}
+
+	return nil, ret
+}
+
+// evaluateExpression evaluates an expression, or the select list and clauses
+// of a SELECT assignment, and returns the first column of the first row
+// found is false when there are no rows
+func (e *Executor) evaluateExpression(ctx context.Context, expression string, vars *variable.Context) (interface{}, bool, error) {
+	// Replace variables in expression
+	expr, err := variable.ReplaceVariables(expression, vars)
+	if err != nil {
+		return nil, false, err
+	}
+
 	// Build SELECT query
 	sql := "SELECT " + expr
 
 	// Execute query
-	rows, err := e.db.Query(sql)
+	rows, err := e.db.QueryContext(ctx, sql)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute SELECT assignment: %w", err)
+		return nil, false, fmt.Errorf("failed to evaluate expression: %w", err)
 	}
 	defer rows.Close()
 
 	// Get first row, first column value
-	if rows.Next() {
-		var value interface{}
-		err := rows.Scan(&value)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan assignment result: %w", err)
-		}
-
-		// Set variable
-		err = ctx.Set("@"+varName, value)
-		if err != nil {
-			return nil, err
-		}
+	if !rows.Next() {
+		return nil, false, rows.Err()
 	}
 
-	// No result set for SELECT assignment
-	return nil, nil
+	var value interface{}
+	if err := rows.Scan(&value); err != nil {
+		return nil, false, fmt.Errorf("failed to scan expression result: %w", err)
+	}
+	return value, true, nil
 }
 
 // executeQuery handles regular SELECT queries
-func (e *Executor) executeQuery(query string, paramValues map[string]interface{}, ctx *variable.Context, sessionID string, txCtx *transaction.Context) ([][]string, error) {
-	// Replace procedure parameters first
-	replacedSQL, err := e.replaceParameters(query, paramValues)
-	if err != nil {
-		return nil, err
-	}
-
-	// Replace variables
-	processedSQL, err := variable.ReplaceVariables(replacedSQL, ctx)
+func (e *Executor) executeQuery(ctx context.Context, query string, vars *variable.Context, sessionID string, txCtx *transaction.Context) (*sqlexecutor.ExecuteResult, error) {
+	// Replace variables, including procedure parameters
+	processedSQL, err := variable.ReplaceVariables(query, vars)
 	if err != nil {
 		return nil, err
 	}
@@ -497,10 +708,10 @@ func (e *Executor) executeQuery(query string, paramValues map[string]interface{}
 			return e.executeInsertTempTable(processedSQL, sessionID)
 		}
 		if strings.HasPrefix(sqlUpper, "UPDATE") && temp.IsTempTable(processedSQL) {
-			return e.executeUpdateTempTable(processedSQL, ctx, sessionID)
+			return e.executeUpdateTempTable(processedSQL, vars, sessionID)
 		}
 		if strings.HasPrefix(sqlUpper, "DELETE FROM") && temp.IsTempTable(processedSQL) {
-			return e.executeDeleteTempTable(processedSQL, ctx, sessionID)
+			return e.executeDeleteTempTable(processedSQL, vars, sessionID)
 		}
 		if strings.HasPrefix(sqlUpper, "SELECT") && temp.IsTempTable(processedSQL) {
 			return e.executeSelectTempTable(processedSQL, sessionID)
@@ -511,18 +722,54 @@ func (e *Executor) executeQuery(query string, paramValues map[string]interface{}
 	}
 
 	// Execute SQL (use active transaction if available)
-	var rows *sql.Rows
-	var execErr error
-
+	var tx *sql.Tx
 	if txCtx.IsActive() {
-		tx := txCtx.GetCurrentTx()
-		rows, execErr = tx.Query(processedSQL)
-	} else {
-		rows, execErr = e.db.Query(processedSQL)
+		tx = txCtx.GetCurrentTx()
+	}
+
+	result, err := e.executeSQL(ctx, processedSQL, tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	return result, nil
+}
+
+// executeSQL runs a statement, in tx when it is not nil
+// Statements that return rows give a result set, others their row count
+func (e *Executor) executeSQL(ctx context.Context, query string, tx *sql.Tx) (*sqlexecutor.ExecuteResult, error) {
+	if !sqlexecutor.ReturnsRows(query) {
+		var result sql.Result
+		var err error
+		if tx != nil {
+			result, err = tx.ExecContext(ctx, query)
+		} else {
+			result, err = e.db.ExecContext(ctx, query)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rowCount, err := result.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		return &sqlexecutor.ExecuteResult{
+			RowCount:  rowCount,
+			IsQuery:   false,
+			Message:   fmt.Sprintf("%d row(s) affected", rowCount),
+			Statement: sqlparser.ParseStatementType(query),
+		}, nil
 	}
 
-	if execErr != nil {
-		return nil, fmt.Errorf("failed to execute query: %w", execErr)
+	var rows *sql.Rows
+	var err error
+	if tx != nil {
+		rows, err = tx.QueryContext(ctx, query)
+	} else {
+		rows, err = e.db.QueryContext(ctx, query)
+	}
+	if err != nil {
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -531,7 +778,6 @@ func (e *Executor) executeQuery(query string, paramValues map[string]interface{}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read results: %w", err)
 	}
-
 	return results, nil
 }
 
@@ -540,7 +786,7 @@ func (e *Executor) validateParameters(proc *Procedure, paramValues map[string]in
 	// Check if all required parameters are provided
 	for _, param := range proc.Parameters {
 		if !param.HasDefault {
-			if _, exists := paramValues["@"+param.Name]; !exists {
+			if _, exists := lookupParameter(paramValues, "@"+param.Name); !exists {
 				return fmt.Errorf("missing required parameter: @%s", param.Name)
 			}
 		}
@@ -550,7 +796,7 @@ func (e *Executor) validateParameters(proc *Procedure, paramValues map[string]in
 	for paramName := range paramValues {
 		found := false
 		for _, param := range proc.Parameters {
-			if "@"+param.Name == paramName {
+			if strings.EqualFold("@"+param.Name, paramName) {
 				found = true
 				break
 			}
@@ -575,7 +821,7 @@ func (e *Executor) replaceParameters(sql string, paramValues map[string]interfac
 	// Replace each parameter
 	result = re.ReplaceAllStringFunc(result, func(match string) string {
 		// Check if this is a parameter we have a value for
-		if value, exists := paramValues[match]; exists {
+		if value, exists := lookupParameter(paramValues, match); exists {
 			return e.formatValue(value)
 		}
 		// If no value provided, keep as is (might be a default or error will be caught later)
@@ -585,6 +831,19 @@ func (e *Executor) replaceParameters(sql string, paramValues map[string]interfac
 	return result, nil
 }
 
+// lookupParameter returns the value of a parameter; names are case-insensitive
+func lookupParameter(paramValues map[string]interface{}, name string) (interface{}, bool) {
+	if value, exists := paramValues[name]; exists {
+		return value, true
+	}
+	for paramName, value := range paramValues {
+		if strings.EqualFold(paramName, name) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
 // formatValue formats a value for SQL
 func (e *Executor) formatValue(value interface{}) string {
 	switch v := value.(type) {
@@ -611,56 +870,12 @@ func (e *Executor) formatValue(value interface{}) string {
 }
 
 // readResults reads all rows from a query result
-func (e *Executor) readResults(rows *sql.Rows) ([][]string, error) {
-	// Get column names
-	columns, err := rows.Columns()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get columns: %w", err)
-	}
-
-	var results [][]string
-
-	// Add column names as first row
-	results = append(results, columns)
-
-	// Read all rows
-	for rows.Next() {
-		// Create slice of interfaces for row values
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
-
-		// Scan row
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
-
-		// Convert values to strings
-		row := make([]string, len(columns))
-		for i, val := range values {
-			if val == nil {
-				row[i] = "NULL"
-			} else {
-				row[i] = fmt.Sprintf("%v", val)
-			}
-		}
-
-		results = append(results, row)
-	}
-
-	// Check for errors
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows error: %w", err)
-	}
-
-	return results, nil
+func (e *Executor) readResults(rows *sql.Rows) (*sqlexecutor.ExecuteResult, error) {
+	return sqlexecutor.ReadRows(rows)
 }
 
 // executeWHILE handles WHILE loops
-func (e *Executor) executeWHILE(stmt string, paramValues map[string]interface{}, ctx *variable.Context, sessionID string, txCtx *transaction.Context) ([][]string, error) {
+func (e *Executor) executeWHILE(ctx context.Context, stmt string, vars *variable.Context, sessionID string, txCtx *transaction.Context) ([]*sqlexecutor.ExecuteResult, error) {
 	// Parse WHILE block
 	block, err := controlflow.ParseWHILEBlock(stmt)
 	if err != nil {
@@ -671,13 +886,17 @@ func (e *Executor) executeWHILE(stmt string, paramValues map[string]interface{},
 	maxIterations := 1000
 	iterations := 0
 
-	var results [][]string
+	var results []*sqlexecutor.ExecuteResult
 
 	// Loop while condition is true
 	for iterations < maxIterations {
+		// A cancelled request stops the loop even when its body never waits on the database
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
 		// Get all variables from context
-		vars := ctx.GetAll()
-		variables := e.convertVariablesToInterface(vars)
+		variables := e.convertVariablesToInterface(vars.GetAll())
 
 		// Evaluate condition
 		conditionResult, err := controlflow.Evaluate(block.Condition, variables)
@@ -691,14 +910,14 @@ func (e *Executor) executeWHILE(stmt string, paramValues map[string]interface{},
 		}
 
 		// Execute WHILE body
-		bodyResults, err := e.executeBlock(block.Body[0], paramValues, ctx, sessionID, txCtx)
-		if err != nil {
-			return nil, err
-		}
+		bodyResults, err := e.executeBlock(ctx, block.Body[0], vars, sessionID, txCtx)
+
+		// Collect results from every iteration
+		results = append(results, bodyResults...)
 
-		// Collect results (non-nil results indicate a result set)
-		if bodyResults != nil && len(bodyResults) > 0 {
-			results = bodyResults
+		if err != nil {
+			// A RETURN inside the loop keeps the results so far
+			return results, err
 		}
 
 		iterations++
@@ -713,7 +932,7 @@ func (e *Executor) executeWHILE(stmt string, paramValues map[string]interface{},
 }
 
 // executeIF handles IF statements
-func (e *Executor) executeIF(stmt string, paramValues map[string]interface{}, ctx *variable.Context, sessionID string, txCtx *transaction.Context) ([][]string, error) {
+func (e *Executor) executeIF(ctx context.Context, stmt string, vars *variable.Context, sessionID string, txCtx *transaction.Context) ([]*sqlexecutor.ExecuteResult, error) {
 	// Parse IF block
 	block, elseInfo, err := controlflow.ParseIFBlock(stmt)
 	if err != nil {
@@ -721,8 +940,7 @@ func (e *Executor) executeIF(stmt string, paramValues map[string]interface{}, ct
 	}
 
 	// Get all variables from context
-	vars := ctx.GetAll()
-	variables := e.convertVariablesToInterface(vars)
+	variables := e.convertVariablesToInterface(vars.GetAll())
 
 	// Evaluate condition
 	conditionResult, err := controlflow.Evaluate(block.Condition, variables)
@@ -733,12 +951,12 @@ func (e *Executor) executeIF(stmt string, paramValues map[string]interface{}, ct
 	// Execute appropriate block
 	if conditionResult {
 		// Execute IF body
-		return e.executeBlock(block.Body[0], paramValues, ctx, sessionID, txCtx)
+		return e.executeBlock(ctx, block.Body[0], vars, sessionID, txCtx)
 	}
 
 	// Execute ELSE if present
 	if elseInfo != nil && len(elseInfo) > 1 {
-		return e.executeBlock(elseInfo[1], paramValues, ctx, sessionID, txCtx)
+		return e.executeBlock(ctx, elseInfo[1], vars, sessionID, txCtx)
 	}
 
 	// No result set for IF (if no SELECT in body)
@@ -746,7 +964,8 @@ func (e *Executor) executeIF(stmt string, paramValues map[string]interface{}, ct
 }
 
 // executeBlock executes a block of SQL (IF body or ELSE body)
-func (e *Executor) executeBlock(block string, paramValues map[string]interface{}, ctx *variable.Context, sessionID string, txCtx *transaction.Context) ([][]string, error) {
+// On a RETURN the results so far are returned along with the returnStatus
+func (e *Executor) executeBlock(ctx context.Context, block string, vars *variable.Context, sessionID string, txCtx *transaction.Context) ([]*sqlexecutor.ExecuteResult, error) {
 	// Split block into statements
 	statements, err := controlflow.SplitStatements(block)
 	if err != nil {
@@ -754,17 +973,16 @@ func (e *Executor) executeBlock(block string, paramValues map[string]interface{}
 	}
 
 	// Execute each statement
-	var results [][]string
+	var results []*sqlexecutor.ExecuteResult
 
 	for _, stmt := range statements {
-		result, err := e.executeStatement(stmt, paramValues, ctx, sessionID, txCtx)
-		if err != nil {
-			return nil, err
-		}
+		stmtResults, err := e.executeStatement(ctx, stmt, vars, sessionID, txCtx)
 
-		// Collect results (non-nil results indicate a result set)
-		if result != nil && len(result) > 0 {
-			results = result
+		// Collect results in order
+		results = append(results, stmtResults...)
+
+		if err != nil {
+			return results, err
 		}
 	}
 
@@ -772,11 +990,12 @@ func (e *Executor) executeBlock(block string, paramValues map[string]interface{}
 }
 
 // convertVariablesToInterface converts map[string]*Variable to map[string]interface{}
+// keyed by variable reference (@name), as conditions refer to them
 func (e *Executor) convertVariablesToInterface(vars map[string]*variable.Variable) map[string]interface{} {
 	result := make(map[string]interface{})
 	for name, v := range vars {
 		if v != nil {
-			result[name] = v.Value
+			result["@"+name] = v.Value
 		}
 	}
 	return result
@@ -1,10 +1,14 @@
 package procedure
 
 import (
+	"context"
+	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/factory/mssql-tds-server/pkg/sqlite"
+	"github.com/factory/mssql-tds-server/pkg/variable"
 )
 
 
@@ -110,15 +114,174 @@ func TestExecutor_Execute(t *testing.T) {
 	if err != nil {
 		t.Errorf("Execute() error = %v", err)
 	}
-	debugLog(t, "TestExecutor_Execute: Procedure executed, results: %d", len(results))
+	debugLog(t, "TestExecutor_Execute: Procedure executed, results: %d", len(results.Results[0].Rows))
 
-	// Check results (should have header + 1 data row)
-	if len(results) != 2 {
-		t.Errorf("Execute() returned %d rows, want 2", len(results))
+	// Check results (should have 1 data row)
+	if len(results.Results[0].Rows) != 1 {
+		t.Errorf("Execute() returned %d rows, want 1", len(results.Results[0].Rows))
 	}
 	debugLog(t, "TestExecutor_Execute: END")
 }
 
+func TestExecutor_Execute_OutputAndReturn(t *testing.T) {
+	executor, storage, cleanup := setupExecutor(t)
+	defer cleanup()
+
+	proc, err := ParseCreateProcedure("CREATE PROCEDURE CountUsers @dept TEXT, @total INT OUTPUT, @label TEXT OUTPUT AS " +
+		"SELECT * FROM users WHERE department = @dept; " +
+		"SELECT @total = COUNT(*) FROM users WHERE department = @dept; " +
+		"SET @label = @dept || ': ' || @total; " +
+		"IF @total = 0 THEN RETURN 1; END; " +
+		"RETURN 2")
+	if err != nil {
+		t.Fatalf("ParseCreateProcedure() error = %v", err)
+	}
+	if err := storage.Create(proc); err != nil {
+		t.Fatalf("Failed to create procedure: %v", err)
+	}
+
+	tests := []struct {
+		dept       string
+		wantRows   int
+		wantTotal  int64
+		wantLabel  string
+		wantStatus int32
+	}{
+		{dept: "Engineering", wantRows: 1, wantTotal: 1, wantLabel: "Engineering: 1", wantStatus: 2},
+		{dept: "Sales", wantRows: 0, wantTotal: 0, wantLabel: "Sales: 0", wantStatus: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dept, func(t *testing.T) {
+			// Parameter names are case-insensitive
+			result, err := executor.Execute("countusers", map[string]interface{}{"@Dept": tt.dept, "@total": nil, "@label": nil})
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+
+			if len(result.Results[0].Rows) != tt.wantRows {
+				t.Errorf("Execute() returned %d rows, want %d", len(result.Results[0].Rows), tt.wantRows)
+			}
+			if result.ReturnStatus != tt.wantStatus {
+				t.Errorf("ReturnStatus = %d, want %d", result.ReturnStatus, tt.wantStatus)
+			}
+			if total, _ := result.Output("@total"); total != tt.wantTotal {
+				t.Errorf("Output(@total) = %#v, want %d", total, tt.wantTotal)
+			}
+			if label, _ := result.Output("@LABEL"); label != tt.wantLabel {
+				t.Errorf("Output(@label) = %#v, want %q", label, tt.wantLabel)
+			}
+			if _, ok := result.Output("@dept"); ok {
+				t.Error("Output(@dept) found, want only OUTPUT parameters")
+			}
+		})
+	}
+}
+
+func TestExecutor_ExecuteContext_Cancel(t *testing.T) {
+	executor, storage, cleanup := setupExecutor(t)
+	defer cleanup()
+
+	// Each iteration counts a few million generated rows
+	proc, err := ParseCreateProcedure("CREATE PROCEDURE Spin AS " +
+		"DECLARE @i INT; WHILE 1 = 1 SELECT COUNT(*) FROM (WITH RECURSIVE seq(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM seq WHERE x < 5000000) SELECT x FROM seq) END")
+	if err != nil {
+		t.Fatalf("ParseCreateProcedure() error = %v", err)
+	}
+	if err := storage.Create(proc); err != nil {
+		t.Fatalf("Failed to create procedure: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = executor.ExecuteContext(ctx, proc.Name, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExecuteContext() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ExecuteContext() returned after %v, want prompt cancellation", elapsed)
+	}
+}
+
+func TestExecutor_Assign(t *testing.T) {
+	executor, _, cleanup := setupExecutor(t)
+	defer cleanup()
+
+	for stmt, want := range map[string]bool{
+		"DECLARE @n INT":                   true,
+		"SET @n = 1":                       true,
+		"SELECT @n = COUNT(*) FROM users":  true,
+		"SET NOCOUNT ON":                   false,
+		"SELECT @n":                        false,
+		"EXEC @n = CountUsers 'Marketing'": false,
+	} {
+		if got := IsAssignment(stmt); got != want {
+			t.Errorf("IsAssignment(%q) = %v, want %v", stmt, got, want)
+		}
+	}
+
+	ctx := context.Background()
+	vars := variable.NewContext()
+	for _, stmt := range []string{"DECLARE @n INT", "DECLARE @m INT", "SELECT @n = COUNT(*) FROM users", "SET @m = @n + 1"} {
+		if err := executor.Assign(ctx, stmt, vars); err != nil {
+			t.Fatalf("Assign(%q) error = %v", stmt, err)
+		}
+	}
+	if m, _ := vars.Get("@m"); m.Value != int64(3) {
+		t.Errorf("@m = %v, want 3", m.Value)
+	}
+	if err := executor.Assign(ctx, "SELECT @m", vars); err == nil {
+		t.Error("Assign(SELECT @m) error = nil, want not an assignment")
+	}
+}
+
+func TestExecutor_Execute_MultipleResults(t *testing.T) {
+	executor, storage, cleanup := setupExecutor(t)
+	defer cleanup()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"simple", "SELECT name FROM users WHERE id = @id; UPDATE users SET department = 'Ops' WHERE id <> @id; SELECT COUNT(*) FROM users WHERE department = 'Ops'"},
+		{"variables", "DECLARE @n INT; UPDATE users SET department = 'Ops' WHERE id <> @id; SET @n = 1; SELECT name FROM users WHERE id = @id; SELECT COUNT(*) FROM users WHERE department = 'Ops'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proc := &Procedure{Name: "Multi_" + tt.name, Body: tt.body, Parameters: []Parameter{{Name: "id", Type: "INT"}}}
+			if err := storage.Create(proc); err != nil {
+				t.Fatalf("Failed to create procedure: %v", err)
+			}
+
+			result, err := executor.Execute(proc.Name, map[string]interface{}{"@id": 1})
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if len(result.Results) != 3 {
+				t.Fatalf("len(Results) = %d, want 3", len(result.Results))
+			}
+
+			var queries, counts int
+			for _, r := range result.Results {
+				if r.IsQuery {
+					queries++
+				} else if r.RowCount == 1 {
+					counts++
+				}
+			}
+			if queries != 2 || counts != 1 {
+				t.Errorf("got %d result sets and %d row counts, want 2 and 1", queries, counts)
+			}
+			if last := result.Results[2]; !last.IsQuery || len(last.Rows) != 1 || last.Rows[0][0] != int64(1) {
+				t.Errorf("last result = %+v, want one row with count 1", last)
+			}
+		})
+	}
+}
+
 func TestExecutor_Execute_MissingParameter(t *testing.T) {
 	debugLog(t, "TestExecutor_Execute_MissingParameter: START")
 	
@@ -197,8 +360,8 @@ func TestExecutor_Execute_StringParameter(t *testing.T) {
 	}
 
 	// Check results
-	if len(results) < 2 {
-		t.Errorf("Execute() returned %d rows, want at least 2", len(results))
+	if len(results.Results[0].Rows) < 1 {
+		t.Errorf("Execute() returned %d rows, want at least 1", len(results.Results[0].Rows))
 	}
 	debugLog(t, "TestExecutor_Execute_StringParameter: END")
 }
@@ -234,8 +397,8 @@ func TestExecutor_Execute_StringWithQuotes(t *testing.T) {
 	}
 
 	// Check results
-	if len(results) < 2 {
-		t.Errorf("Execute() returned %d rows, want at least 2", len(results))
+	if len(results.Results[0].Rows) < 1 {
+		t.Errorf("Execute() returned %d rows, want at least 1", len(results.Results[0].Rows))
 	}
 	debugLog(t, "TestExecutor_Execute_StringWithQuotes: END")
 }
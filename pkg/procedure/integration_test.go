@@ -122,21 +122,20 @@ func TestIntegration_ProcedureLifecycle(t *testing.T) {
 			t.Fatalf("Failed to execute GetUserByID: %v", err)
 		}
 
-		// Results are [][]string: [header row, data rows]
-		if len(results) < 2 {
-			t.Fatalf("Expected at least 2 results (header + data), got %d", len(results))
+		if len(results.Results[0].Rows) < 1 {
+			t.Fatalf("Expected at least 1 data row, got %d", len(results.Results[0].Rows))
 		}
 
-		dataRow := results[1] // Skip header row
+		dataRow := results.Results[0].Rows[0]
 		// Columns: id, name, email, age
 		if dataRow[1] != "Alice" {
-			t.Errorf("Expected name='Alice', got '%s'", dataRow[1])
+			t.Errorf("Expected name='Alice', got '%v'", dataRow[1])
 		}
 		if dataRow[2] != "alice@test.com" {
-			t.Errorf("Expected email='alice@test.com', got '%s'", dataRow[2])
+			t.Errorf("Expected email='alice@test.com', got '%v'", dataRow[2])
 		}
-		if dataRow[3] != "30" {
-			t.Errorf("Expected age=30, got '%s'", dataRow[3])
+		if dataRow[3] != int64(30) {
+			t.Errorf("Expected age=30, got '%v'", dataRow[3])
 		}
 	})
 
@@ -182,19 +181,19 @@ func TestIntegration_ProcedureLifecycle(t *testing.T) {
 			t.Fatalf("Failed to execute GetUserByID: %v", err)
 		}
 
-		if len(results) < 2 {
-			t.Fatalf("Expected at least 2 results, got %d", len(results))
+		if len(results.Results[0].Rows) < 1 {
+			t.Fatalf("Expected at least 1 data row, got %d", len(results.Results[0].Rows))
 		}
 
-		dataRow := results[1]
-		if dataRow[3] != "31" {
-			t.Errorf("Expected age=31 after update, got '%s'", dataRow[3])
+		dataRow := results.Results[0].Rows[0]
+		if dataRow[3] != int64(31) {
+			t.Errorf("Expected age=31 after update, got '%v'", dataRow[3])
 		}
 		if dataRow[2] != "alice.updated.com" {
-			t.Errorf("Expected email='alice@updated.com', got '%s'", dataRow[2])
+			t.Errorf("Expected email='alice@updated.com', got '%v'", dataRow[2])
 		}
 		if dataRow[1] != "Alice" {
-			t.Errorf("Expected name='Alice' (unchanged), got '%s'", dataRow[1])
+			t.Errorf("Expected name='Alice' (unchanged), got '%v'", dataRow[1])
 		}
 	})
 
@@ -236,9 +235,9 @@ func TestIntegration_ProcedureLifecycle(t *testing.T) {
 			t.Fatalf("Failed to execute GetUserByID: %v", err)
 		}
 
-		// Should only have header row, no data
-		if len(results) != 1 {
-			t.Errorf("Expected 1 result (header only), got %d", len(results))
+		// Should have no data rows
+		if len(results.Results[0].Rows) != 0 {
+			t.Errorf("Expected no data rows, got %d", len(results.Results[0].Rows))
 		}
 	})
 
@@ -371,18 +370,18 @@ func TestIntegration_MultiProcedureChain(t *testing.T) {
 			t.Fatalf("Failed to execute GetEmployeesByDepartment: %v", err)
 		}
 
-		// Should have header + 1 data row
-		if len(results) < 2 {
-			t.Errorf("Expected at least 2 results, got %d", len(results))
+		// Should have 1 data row
+		if len(results.Results[0].Rows) < 1 {
+			t.Fatalf("Expected at least 1 data row, got %d", len(results.Results[0].Rows))
 		}
 
 		// Validate employee data
-		dataRow := results[1]
+		dataRow := results.Results[0].Rows[0]
 		if dataRow[0] != "Alice" {
-			t.Errorf("Expected emp_name='Alice', got '%s'", dataRow[0])
+			t.Errorf("Expected emp_name='Alice', got '%v'", dataRow[0])
 		}
 		if dataRow[1] != "IT" {
-			t.Errorf("Expected dept_name='IT', got '%s'", dataRow[1])
+			t.Errorf("Expected dept_name='IT', got '%v'", dataRow[1])
 		}
 	})
 
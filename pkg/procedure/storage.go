@@ -1,15 +1,18 @@
 package procedure
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
+	"github.com/factory/mssql-tds-server/pkg/sqlerror"
+	"github.com/factory/mssql-tds-server/pkg/sqlexecutor"
 	"github.com/factory/mssql-tds-server/pkg/sqlite"
 )
 
 // Storage handles stored procedure storage in SQLite
 type Storage struct {
-	db *sql.DB
+	db sqlexecutor.Querier // The pool, or a session's connection
 }
 
 // NewStorage creates a new procedure storage
@@ -19,6 +22,12 @@ func NewStorage(db *sqlite.Database) (*Storage, error) {
 	}, nil
 }
 
+// WithConn returns a storage that reads and writes procedures on conn, such
+// as a client session's connection, inside any transaction open on it
+func (s *Storage) WithConn(conn sqlexecutor.Querier) *Storage {
+	return &Storage{db: conn}
+}
+
 // Create stores a new procedure in the database
 func (s *Storage) Create(proc *Procedure) error {
 	paramsJSON, err := ParametersToJSON(proc.Parameters)
@@ -31,7 +40,7 @@ func (s *Storage) Create(proc *Procedure) error {
 	VALUES (?, ?, ?)
 	`
 
-	result, err := s.db.Exec(query, proc.Name, proc.Body, paramsJSON)
+	result, err := s.db.ExecContext(context.Background(), query, proc.Name, proc.Body, paramsJSON)
 	if err != nil {
 		return fmt.Errorf("failed to create procedure: %w", err)
 	}
@@ -45,18 +54,18 @@ func (s *Storage) Create(proc *Procedure) error {
 	return nil
 }
 
-// Get retrieves a procedure by name
+// Get retrieves a procedure by name; names are case-insensitive
 func (s *Storage) Get(name string) (*Procedure, error) {
 	query := `
 	SELECT id, name, body, parameters, created_at
 	FROM procedures
-	WHERE name = ?
+	WHERE name = ? COLLATE NOCASE
 	`
 
 	var paramsJSON string
 	proc := &Procedure{}
 
-	err := s.db.QueryRow(query, name).Scan(
+	err := s.db.QueryRowContext(context.Background(), query, name).Scan(
 		&proc.ID,
 		&proc.Name,
 		&proc.Body,
@@ -65,7 +74,8 @@ func (s *Storage) Get(name string) (*Procedure, error) {
 	)
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("procedure '%s' not found", name)
+		return nil, sqlerror.New(sqlerror.ProcedureNotFound, sqlerror.ClassUserError,
+			"Could not find stored procedure '%s'.", name)
 	}
 
 	if err != nil {
@@ -89,7 +99,7 @@ func (s *Storage) List() ([]*Procedure, error) {
 	ORDER BY name
 	`
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(context.Background(), query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list procedures: %w", err)
 	}
@@ -127,9 +137,9 @@ func (s *Storage) List() ([]*Procedure, error) {
 
 // Drop removes a procedure by name
 func (s *Storage) Drop(name string) error {
-	query := `DELETE FROM procedures WHERE name = ?`
+	query := `DELETE FROM procedures WHERE name = ? COLLATE NOCASE`
 
-	result, err := s.db.Exec(query, name)
+	result, err := s.db.ExecContext(context.Background(), query, name)
 	if err != nil {
 		return fmt.Errorf("failed to drop procedure: %w", err)
 	}
@@ -140,7 +150,8 @@ func (s *Storage) Drop(name string) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("procedure '%s' not found", name)
+		return sqlerror.New(sqlerror.CannotDropObject, sqlerror.ClassUserError,
+			"Cannot drop the procedure '%s', because it does not exist or you do not have permission.", name)
 	}
 
 	return nil
@@ -148,10 +159,10 @@ func (s *Storage) Drop(name string) error {
 
 // Exists checks if a procedure exists
 func (s *Storage) Exists(name string) (bool, error) {
-	query := `SELECT COUNT(*) FROM procedures WHERE name = ?`
+	query := `SELECT COUNT(*) FROM procedures WHERE name = ? COLLATE NOCASE`
 
 	var count int
-	err := s.db.QueryRow(query, name).Scan(&count)
+	err := s.db.QueryRowContext(context.Background(), query, name).Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("failed to check procedure existence: %w", err)
 	}
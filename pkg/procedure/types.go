@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 // Parameter represents a stored procedure parameter
@@ -14,6 +16,7 @@ type Parameter struct {
 	Length     int    `json:"length,omitempty"`
 	HasDefault bool   `json:"has_default"`
 	Default    string `json:"default,omitempty"`
+	Output     bool   `json:"output,omitempty"`
 }
 
 // Procedure represents a stored procedure definition
@@ -106,6 +109,9 @@ func ParseCreateProcedure(sql string) (*Procedure, error) {
 	}, nil
 }
 
+// outputRegex matches the OUTPUT (or OUT) keyword ending a parameter definition
+var outputRegex = regexp.MustCompile(`(?i)\s+OUT(?:PUT)?$`)
+
 // parseParameters parses the parameter list from CREATE PROCEDURE
 func parseParameters(paramsStr string) ([]Parameter, error) {
 	params := []Parameter{}
@@ -124,7 +130,13 @@ func parseParameters(paramsStr string) ([]Parameter, error) {
 		}
 
 		// Parse individual parameter
-		// Format: @param TYPE [DEFAULT value]
+		// Format: @param TYPE [DEFAULT value] [OUTPUT]
+		output := false
+		if m := outputRegex.FindStringIndex(paramPart); m != nil {
+			output = true
+			paramPart = paramPart[:m[0]]
+		}
+
 		paramRegex := regexp.MustCompile(`@(\w+)\s+(\w+)(?:\((\d+)\))?\s*(?:DEFAULT\s+(.+))?`)
 		paramMatches := paramRegex.FindStringSubmatch(paramPart)
 
@@ -133,8 +145,9 @@ func parseParameters(paramsStr string) ([]Parameter, error) {
 		}
 
 		param := Parameter{
-			Name: paramMatches[1],
-			Type: paramMatches[2],
+			Name:   paramMatches[1],
+			Type:   paramMatches[2],
+			Output: output,
 		}
 
 		if len(paramMatches) > 3 && paramMatches[3] != "" {
@@ -186,6 +199,140 @@ func splitByCommaOutsideParentheses(s string) []string {
 	return result
 }
 
+// ExecStatement represents a parsed EXEC statement
+// Format: EXEC [@status =] name [[@param =] value [OUTPUT]], ...
+type ExecStatement struct {
+	Name      string
+	StatusVar string // Variable assigned the return status, empty without one
+	Args      []ExecArg
+}
+
+// ExecArg is an argument of an EXEC statement
+type ExecArg struct {
+	Param    string      // Parameter name with @, empty for a positional argument
+	Value    interface{} // Literal value; nil when a variable is passed
+	Variable string      // Variable passed as the argument, with @
+	Output   bool
+}
+
+// execStatusRegex matches the return status assignment of an EXEC statement
+var execStatusRegex = regexp.MustCompile(`^(@\w+)\s*=\s*`)
+
+// execNamedArgRegex matches a named argument: @param = value
+var execNamedArgRegex = regexp.MustCompile(`(?s)^(@\w+)\s*=\s*(.+)$`)
+
+// ParseExecStatement parses an EXEC or EXECUTE statement
+func ParseExecStatement(sql string) (*ExecStatement, error) {
+	sql = strings.TrimSpace(sql)
+	sql = strings.TrimSuffix(sql, ";")
+	sqlUpper := strings.ToUpper(sql)
+
+	// Remove EXEC or EXECUTE
+	switch {
+	case strings.HasPrefix(sqlUpper, "EXECUTE "):
+		sql = sql[len("EXECUTE "):]
+	case strings.HasPrefix(sqlUpper, "EXEC "):
+		sql = sql[len("EXEC "):]
+	default:
+		return nil, fmt.Errorf("not an EXEC statement")
+	}
+	sql = strings.TrimSpace(sql)
+
+	stmt := &ExecStatement{}
+
+	// EXEC @status = name ...
+	if m := execStatusRegex.FindStringSubmatchIndex(sql); m != nil {
+		stmt.StatusVar = sql[m[2]:m[3]]
+		sql = sql[m[1]:]
+	}
+
+	// Procedure name, then the argument list
+	stmt.Name, sql = sql, ""
+	if i := strings.IndexFunc(stmt.Name, unicode.IsSpace); i >= 0 {
+		stmt.Name, sql = stmt.Name[:i], stmt.Name[i:]
+	}
+	if stmt.Name == "" {
+		return nil, fmt.Errorf("empty EXEC statement")
+	}
+
+	for _, argStr := range splitArguments(sql) {
+		argStr = strings.TrimSpace(argStr)
+		if argStr == "" {
+			continue
+		}
+
+		arg := ExecArg{}
+		if m := outputRegex.FindStringIndex(argStr); m != nil {
+			arg.Output = true
+			argStr = argStr[:m[0]]
+		}
+		if m := execNamedArgRegex.FindStringSubmatch(argStr); m != nil {
+			arg.Param = m[1]
+			argStr = strings.TrimSpace(m[2])
+		}
+
+		if strings.HasPrefix(argStr, "@") {
+			arg.Variable = argStr
+		} else {
+			arg.Value = parseLiteral(argStr)
+		}
+		if arg.Output && arg.Variable == "" {
+			return nil, fmt.Errorf("invalid OUTPUT argument: %s is not a variable", argStr)
+		}
+
+		stmt.Args = append(stmt.Args, arg)
+	}
+
+	return stmt, nil
+}
+
+// splitArguments splits an argument list by commas outside string literals
+func splitArguments(s string) []string {
+	var result []string
+	var current strings.Builder
+	inQuotes := false
+
+	for _, ch := range s {
+		switch {
+		case ch == '\'':
+			inQuotes = !inQuotes
+			current.WriteRune(ch)
+		case ch == ',' && !inQuotes:
+			result = append(result, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(ch)
+		}
+	}
+
+	if current.Len() > 0 {
+		result = append(result, current.String())
+	}
+
+	return result
+}
+
+// parseLiteral parses a literal argument value: a string, a number or NULL
+// Anything else is passed on as text
+func parseLiteral(s string) interface{} {
+	if len(s) >= 3 && (s[0] == 'N' || s[0] == 'n') && s[1] == '\'' {
+		s = s[1:]
+	}
+	if len(s) >= 2 && strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'") {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	if strings.EqualFold(s, "NULL") {
+		return nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
 // ParametersToJSON converts parameters to JSON for storage
 func ParametersToJSON(params []Parameter) (string, error) {
 	if params == nil {
@@ -1,6 +1,7 @@
 package procedure
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -170,6 +171,86 @@ func TestParseParameters(t *testing.T) {
 	debugLog(t, "TestParseParameters: END")
 }
 
+func TestParseParametersOutput(t *testing.T) {
+	params, err := parseParameters("@id INT, @total INT OUTPUT, @name VARCHAR(50) OUT, @limit INT DEFAULT 10 OUTPUT")
+	if err != nil {
+		t.Fatalf("parseParameters() unexpected error = %v", err)
+	}
+
+	want := []Parameter{
+		{Name: "id", Type: "INT"},
+		{Name: "total", Type: "INT", Output: true},
+		{Name: "name", Type: "VARCHAR", Length: 50, Output: true},
+		{Name: "limit", Type: "INT", HasDefault: true, Default: "10", Output: true},
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("parseParameters() = %+v, want %+v", params, want)
+	}
+}
+
+func TestParseExecStatement(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		want    *ExecStatement
+		wantErr bool
+	}{
+		{
+			name: "Named arguments",
+			sql:  "EXEC GetUser @id = 1, @name = N'O''Brien, Pat'",
+			want: &ExecStatement{Name: "GetUser", Args: []ExecArg{
+				{Param: "@id", Value: int64(1)},
+				{Param: "@name", Value: "O'Brien, Pat"},
+			}},
+		},
+		{
+			name: "Return status and OUTPUT variables",
+			sql:  "EXECUTE @rc = CountUsers @dept = 'IT', @total = @t OUTPUT;",
+			want: &ExecStatement{Name: "CountUsers", StatusVar: "@rc", Args: []ExecArg{
+				{Param: "@dept", Value: "IT"},
+				{Param: "@total", Variable: "@t", Output: true},
+			}},
+		},
+		{
+			name: "Positional arguments",
+			sql:  "exec Scale 2.5, NULL, @v out",
+			want: &ExecStatement{Name: "Scale", Args: []ExecArg{
+				{Value: 2.5},
+				{Value: nil},
+				{Variable: "@v", Output: true},
+			}},
+		},
+		{
+			name: "No arguments",
+			sql:  "EXEC ListUsers",
+			want: &ExecStatement{Name: "ListUsers"},
+		},
+		{
+			name:    "OUTPUT literal",
+			sql:     "EXEC CountUsers @total = 1 OUTPUT",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseExecStatement(tt.sql)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseExecStatement() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseExecStatement() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseExecStatement() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestParametersToJSON(t *testing.T) {
 	debugLog(t, "TestParametersToJSON: START")
 	
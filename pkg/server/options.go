@@ -0,0 +1,88 @@
+package server
+
+import (
+	"log/slog"
+
+	"github.com/factory/mssql-tds-server/pkg/config"
+)
+
+// Option changes a setting of a server created by New
+type Option func(*options)
+
+type options struct {
+	config config.Config
+	logins []login
+	logger *slog.Logger
+}
+
+// login is a SQL Server login set by WithLogin
+type login struct {
+	name     string
+	password string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{config: *config.Default(), logger: slog.Default()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithConfig replaces every setting with those of cfg; options after it
+// change them further
+func WithConfig(cfg *config.Config) Option {
+	return func(o *options) {
+		o.config = *cfg
+	}
+}
+
+// WithListen sets the addresses to listen on. With port 0, a free port is
+// chosen; Addrs reports it
+func WithListen(addrs ...string) Option {
+	return func(o *options) {
+		o.config.Listen = addrs
+	}
+}
+
+// WithDataDir keeps the catalog, the user databases and the server's own
+// database in dir
+func WithDataDir(dir string) Option {
+	return func(o *options) {
+		o.config.DataDir = dir
+		o.config.MasterDB = ""
+	}
+}
+
+// WithInMemory keeps the server's own database, the catalog and every user
+// database in memory, so that nothing is written to disk; the databases are
+// freed when the server is closed. Server.Snapshot, Restore and Reset bring
+// them back to an earlier state
+func WithInMemory() Option {
+	return func(o *options) {
+		o.config.InMemory = true
+	}
+}
+
+// WithSAPassword sets the password the sa login is created with
+func WithSAPassword(password string) Option {
+	return func(o *options) {
+		o.config.SAPassword = password
+	}
+}
+
+// WithLogin creates a SQL Server login with a password, or changes the
+// password of an existing one
+func WithLogin(name, password string) Option {
+	return func(o *options) {
+		o.logins = append(o.logins, login{name: name, password: password})
+	}
+}
+
+// WithLogger sets the logger of the server's messages; the default is
+// slog.Default
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
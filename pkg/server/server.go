@@ -0,0 +1,2011 @@
+package server
+
+import (
+	"context"
+	cryptotls "crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/factory/mssql-tds-server/pkg/auth"
+	"github.com/factory/mssql-tds-server/pkg/config"
+	"github.com/factory/mssql-tds-server/pkg/controlflow"
+	"github.com/factory/mssql-tds-server/pkg/database"
+	"github.com/factory/mssql-tds-server/pkg/procedure"
+	"github.com/factory/mssql-tds-server/pkg/sqlerror"
+	"github.com/factory/mssql-tds-server/pkg/sqlexecutor"
+	"github.com/factory/mssql-tds-server/pkg/sqlite"
+	"github.com/factory/mssql-tds-server/pkg/sqlparser"
+	"github.com/factory/mssql-tds-server/pkg/tds"
+	"github.com/factory/mssql-tds-server/pkg/tls"
+	"github.com/factory/mssql-tds-server/pkg/transaction"
+	"github.com/factory/mssql-tds-server/pkg/variable"
+)
+
+const (
+	serverName = "MSSQLServer"
+
+	// How often Shutdown checks whether the sessions have ended
+	shutdownPollInterval = 50 * time.Millisecond
+)
+
+// ErrServerClosed is returned by Start once Shutdown or Close has been called
+var ErrServerClosed = errors.New("server closed")
+
+// Numbers the in-memory servers of the process, whose databases would
+// otherwise share names
+var memoryServers atomic.Uint64
+
+// Server is a TDS server running SQL on SQLite databases
+type Server struct {
+	config                 config.Config // The settings New was given
+	addrs                  []string
+	logger                 *slog.Logger
+	db                     *sqlite.Database
+	catalog                *database.Catalog
+	procedureStorage       *procedure.Storage
+	procedureExecutor      *procedure.Executor
+	storedProcedureHandler *tds.StoredProcedureHandler
+	sqlExecutor            *sqlexecutor.Executor // Sessions run statements on executors of their own from it
+	authManager            *auth.AuthManager
+	tlsConfig              *tls.Config
+	serverTLS              *cryptotls.Config  // Built from tlsConfig when encryption is enabled
+	loginTimeout           time.Duration      // 0 for none
+	connSlots              chan struct{}      // One per open connection when their number is limited
+	initial                *database.Snapshot // Databases of an in-memory server when it was created; Reset restores them
+
+	// Closed when shutdown starts: listeners stop and idle sessions end
+	quit     chan struct{}
+	quitOnce sync.Once
+	// Context of every request, cancelled when the shutdown deadline passes
+	requestCtx     context.Context
+	cancelRequests context.CancelFunc
+	// The databases are closed once, by Shutdown or Close
+	closeOnce sync.Once
+	closeErr  error
+
+	// Listeners and open connections, with the session of each once it is opened
+	connsMu   sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]*Session
+
+	// Source of transaction descriptors sent in ENVCHANGE
+	lastTransactionID atomic.Uint64
+
+	// Open sessions by SPID
+	sessionsMu sync.Mutex
+	sessions   map[uint16]*Session
+}
+
+// clientConn is a client connection, or one MARS session of it, together with its session state
+type clientConn struct {
+	*tds.Conn
+	*Session
+
+	// ENVCHANGE tokens from a session reset, sent ahead of the response to
+	// the request that asked for it
+	resetTokens []byte
+	// Descriptor of the transaction a reset rolled back; the request that
+	// asked for the reset may still carry it
+	resetTransactionID uint64
+}
+
+// New creates a server with the settings of config.Default, as changed by
+// opts, and opens its databases. Start, or Listen and Serve, accepts
+// connections
+func New(opts ...Option) (*Server, error) {
+	o := newOptions(opts)
+	cfg := &o.config
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// In memory, the server's databases are named after the server
+	masterPath := cfg.MasterDBPath()
+	var memoryName string
+	if cfg.InMemory {
+		memoryName = fmt.Sprintf("tds%d", memoryServers.Add(1))
+		masterPath = sqlite.MemoryURI(memoryName)
+	}
+
+	// Initialize SQLite database
+	db, err := sqlite.NewDatabase(masterPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database: %w", err)
+	}
+
+	// Initialize database tables
+	err = db.Initialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	// Create procedure storage
+	procStorage, err := procedure.NewStorage(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create procedure storage: %w", err)
+	}
+
+	// Create procedure executor
+	procExecutor, err := procedure.NewExecutor(db, procStorage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create procedure executor: %w", err)
+	}
+
+	// Create SQL executor for plain SQL execution
+	// Initialize database catalog
+	var catalog *database.Catalog
+	if cfg.InMemory {
+		catalog, err = database.NewMemoryCatalog(memoryName, db.GetDB())
+	} else {
+		catalog, err = database.NewCatalog(cfg.DataDir, db.GetDB())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database catalog: %w", err)
+	}
+
+	sqlExec := sqlexecutor.NewExecutor(db.GetDB(), catalog)
+
+	// #temp tables left by connections open when the server last stopped
+	if err := sqlExec.DropAllTempTables(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to drop temporary tables: %w", err)
+	}
+
+	// Create authentication manager
+	authMgr, err := auth.NewAuthManager(db.GetDB())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authentication manager: %w", err)
+	}
+
+	// Initialize default logins (sa)
+	err = authMgr.InitializeDefaultLogins(cfg.SAPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize default logins: %w", err)
+	}
+	for _, login := range o.logins {
+		if err := setLogin(authMgr, login.name, login.password); err != nil {
+			return nil, err
+		}
+	}
+
+	var initial *database.Snapshot
+	if cfg.InMemory {
+		if initial, err = catalog.Snapshot(context.Background()); err != nil {
+			return nil, fmt.Errorf("failed to snapshot databases: %w", err)
+		}
+	}
+
+	var connSlots chan struct{}
+	if cfg.MaxConnections > 0 {
+		connSlots = make(chan struct{}, cfg.MaxConnections)
+	}
+
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+
+	return &Server{
+		config:                 *cfg,
+		addrs:                  cfg.Listen,
+		logger:                 o.logger,
+		db:                     db,
+		catalog:                catalog,
+		procedureStorage:       procStorage,
+		procedureExecutor:      procExecutor,
+		storedProcedureHandler: tds.NewStoredProcedureHandler(),
+		sqlExecutor:            sqlExec,
+		authManager:            authMgr,
+		tlsConfig:              tlsConfig,
+		loginTimeout:           time.Duration(cfg.Timeouts.Login),
+		connSlots:              connSlots,
+		initial:                initial,
+		quit:                   make(chan struct{}),
+		requestCtx:             requestCtx,
+		cancelRequests:         cancelRequests,
+		conns:                  make(map[net.Conn]*Session),
+		sessions:               make(map[uint16]*Session),
+	}, nil
+}
+
+// setLogin creates a SQL Server login, or changes the password of an existing one
+func setLogin(authMgr *auth.AuthManager, name, password string) error {
+	if _, err := authMgr.GetLoginByName(name); err == nil {
+		return authMgr.ChangePassword(name, password)
+	}
+	_, err := authMgr.CreateLogin(name, password, auth.AuthTypeSQLServer)
+	return err
+}
+
+// Start listens on the configured addresses and serves connections until
+// Shutdown or Close, when it returns ErrServerClosed
+func (s *Server) Start() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Listen opens a listener on each configured address; Serve accepts
+// connections on them. Addrs reports the addresses once Listen has returned
+func (s *Server) Listen() error {
+	// TLS is negotiated in PRELOGIN, or comes first with strict encryption
+	if tls.IsEncryptionEnabled(s.tlsConfig) {
+		serverTLS, err := tls.CreateTLSConfig(s.tlsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create TLS config: %w", err)
+		}
+		s.serverTLS = serverTLS
+	}
+
+	// Missing certificate files have been generated by now
+	if err := tls.ValidateConfig(s.tlsConfig); err != nil {
+		return fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+	for _, addr := range s.addrs {
+		listener, err := tls.Listen(addr, s.tlsConfig.Strict, s.serverTLS)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("failed to listen: %w", err)
+		}
+		listeners = append(listeners, listener)
+
+		switch {
+		case s.tlsConfig.Strict == tls.StrictRequired:
+			s.logf("TDS Server listening on %s (strict TLS only)", listener.Addr())
+		case s.tlsConfig.Strict == tls.StrictAllowed:
+			s.logf("TDS Server listening on %s (SSL/TLS enabled, strict TLS allowed)", listener.Addr())
+		case s.serverTLS != nil:
+			s.logf("TDS Server listening on %s (SSL/TLS enabled)", listener.Addr())
+		default:
+			s.logger.Warn(fmt.Sprintf("TDS Server listening on %s (WARNING: cleartext, no encryption)", listener.Addr()))
+			s.logger.Warn("⚠️  Enable SSL/TLS encryption for production use!")
+		}
+	}
+
+	// Shutdown closes the listeners from here on
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.shuttingDown() {
+		closeAll()
+		return ErrServerClosed
+	}
+	s.listeners = append(s.listeners, listeners...)
+	return nil
+}
+
+// Serve accepts connections on the listeners opened by Listen until Shutdown
+// or Close, when it returns ErrServerClosed. When a listener fails, Serve
+// closes the others and returns the error
+func (s *Server) Serve() error {
+	s.connsMu.Lock()
+	listeners := s.listeners
+	s.connsMu.Unlock()
+	if len(listeners) == 0 {
+		return fmt.Errorf("no listeners: Listen has not been called")
+	}
+
+	errc := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			errc <- s.serve(listener)
+		}(listener)
+	}
+	err := <-errc
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	return err
+}
+
+// Config returns the settings the server was created with
+func (s *Server) Config() config.Config {
+	return s.config
+}
+
+// Addrs returns the addresses the server listens on, with the ports chosen
+// for addresses with port 0
+func (s *Server) Addrs() []net.Addr {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	addrs := make([]net.Addr, len(s.listeners))
+	for i, listener := range s.listeners {
+		addrs[i] = listener.Addr()
+	}
+	return addrs
+}
+
+// Snapshot returns the content of every database of a server created with
+// WithInMemory, for Restore
+func (s *Server) Snapshot() (*database.Snapshot, error) {
+	return s.catalog.Snapshot(context.Background())
+}
+
+// Restore brings every database of a server created with WithInMemory back
+// to a snapshot, dropping the databases created since. Open connections stay
+// open and see the restored content; Restore fails while one of them has a
+// transaction open
+func (s *Server) Restore(snap *database.Snapshot) error {
+	return s.catalog.Restore(context.Background(), snap)
+}
+
+// Reset restores the databases of a server created with WithInMemory to
+// their state when New returned
+func (s *Server) Reset() error {
+	if s.initial == nil {
+		return fmt.Errorf("only an in-memory server can be reset")
+	}
+	return s.Restore(s.initial)
+}
+
+// logf logs an informational message, formatted as by fmt.Sprintf
+func (s *Server) logf(format string, args ...interface{}) {
+	s.logger.Info(fmt.Sprintf(format, args...))
+}
+
+// serve accepts connections until the listener is closed. With a connection
+// limit, it stops accepting while the limit is reached
+func (s *Server) serve(listener net.Listener) error {
+	for {
+		if s.connSlots != nil {
+			select {
+			case s.connSlots <- struct{}{}:
+			case <-s.quit:
+				return ErrServerClosed
+			}
+		}
+
+		conn, err := listener.Accept()
+		if err == nil && !s.trackConn(conn) {
+			conn.Close()
+			err = ErrServerClosed
+		}
+		if err != nil {
+			if s.connSlots != nil {
+				<-s.connSlots
+			}
+			if errors.Is(err, net.ErrClosed) || errors.Is(err, ErrServerClosed) {
+				return err
+			}
+			s.logger.Error(fmt.Sprintf("Failed to accept connection: %v", err))
+			continue
+		}
+
+		go func() {
+			s.handleConnection(conn)
+			s.untrackConn(conn)
+			if s.connSlots != nil {
+				<-s.connSlots
+			}
+		}()
+	}
+}
+
+// trackConn registers an accepted connection; it fails once shutdown has started
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	if s.shuttingDown() {
+		return false
+	}
+	s.conns[conn] = nil
+	return true
+}
+
+// untrackConn forgets a connection that has been served
+func (s *Server) untrackConn(conn net.Conn) {
+	s.connsMu.Lock()
+	delete(s.conns, conn)
+	s.connsMu.Unlock()
+}
+
+// shuttingDown reports whether Shutdown or Close has been called
+func (s *Server) shuttingDown() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) handleConnection(netConn net.Conn) {
+	defer netConn.Close()
+
+	session, err := s.openSession()
+	if err != nil {
+		s.logf("Error opening session for %s: %v", netConn.RemoteAddr(), err)
+		return
+	}
+	defer s.closeSession(session)
+
+	s.connsMu.Lock()
+	s.conns[netConn] = session
+	s.connsMu.Unlock()
+
+	// A client still not logged in at the login timeout is disconnected
+	if s.loginTimeout > 0 {
+		rawConn := netConn
+		timer := time.AfterFunc(s.loginTimeout, func() {
+			session.mu.Lock()
+			loggedIn := session.login != nil
+			session.mu.Unlock()
+			if !loggedIn {
+				s.logf("Login timeout expired for %s", rawConn.RemoteAddr())
+				rawConn.Close()
+			}
+		})
+		defer timer.Stop()
+	}
+
+	// Message-level framing: joins packets until EOM, splits large responses
+	conn := &clientConn{Conn: tds.NewConn(netConn), Session: session}
+
+	s.logf("New connection from %s (SPID %d)", netConn.RemoteAddr(), session.spid)
+
+	// PRELOGIN is read before the reader goroutine starts: once MARS is
+	// negotiated, the rest of the connection is SMP packets
+	msg, err := conn.ReadMessage()
+	if err != nil {
+		s.logf("Error reading message: %v", err)
+		return
+	}
+	if msg.Type != tds.PacketTypePreLogin {
+		s.logf("Unexpected packet type %#02x before pre-login, closing connection", msg.Type)
+		return
+	}
+
+	mars, encryption, err := s.handlePreLogin(conn, msg, tls.IsStrict(netConn))
+	if err != nil {
+		s.logf("Error handling pre-login: %v", err)
+		return
+	}
+
+	if encryption != tls.EncryptionNotSupported {
+		tlsConn, err := tls.ServerHandshake(netConn, s.serverTLS)
+		if err != nil {
+			s.logf("Error negotiating encryption: %v", err)
+			return
+		}
+
+		if encryption == tls.EncryptionOff {
+			// Only LOGIN7 is encrypted; the response and all that follows are cleartext
+			msg, err := tds.NewConn(tlsConn).ReadMessage()
+			if err == nil && msg.Type != tds.PacketTypeLogin {
+				err = fmt.Errorf("unexpected packet type %#02x for login", msg.Type)
+			}
+			if err == nil {
+				conn.mu.Lock()
+				err = s.handleLogin(conn, msg)
+				conn.mu.Unlock()
+			}
+			if err != nil {
+				s.logf("Error handling login: %v", err)
+				return
+			}
+		} else {
+			netConn = tlsConn
+			conn = &clientConn{Conn: tds.NewConn(tlsConn), Session: session}
+		}
+		s.logf("TLS established (%s)", cryptotls.VersionName(tlsConn.ConnectionState().Version))
+	}
+
+	if mars {
+		s.serveMARS(netConn, session)
+	} else {
+		s.serveSession(conn)
+	}
+
+	s.logf("Connection closed from %s", netConn.RemoteAddr())
+}
+
+// serveMARS serves each SMP session the client opens as a TDS connection of its own
+// The SMP sessions share the connection's Session; the first one carries LOGIN7
+func (s *Server) serveMARS(netConn net.Conn, state *Session) {
+	mux := tds.NewSMPMux(netConn)
+	defer mux.Close()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// At shutdown, the connection closes once its last SMP session has ended
+	var activeMu sync.Mutex
+	active := 0
+	closeIfEnded := func() {
+		if active == 0 && s.shuttingDown() {
+			mux.Close()
+		}
+	}
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-s.quit:
+			activeMu.Lock()
+			closeIfEnded()
+			activeMu.Unlock()
+		case <-stopped:
+		}
+	}()
+
+	for {
+		session, err := mux.Accept()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.logf("Error reading SMP packet: %v", err)
+			}
+			return
+		}
+
+		activeMu.Lock()
+		if s.shuttingDown() {
+			activeMu.Unlock()
+			session.Close()
+			continue
+		}
+		active++
+		activeMu.Unlock()
+
+		s.logf("MARS session %d opened", session.ID())
+
+		conn := &clientConn{Conn: tds.NewConn(session), Session: state}
+		state.mu.Lock()
+		if state.packetSize != 0 {
+			conn.SetPacketSize(state.packetSize)
+			conn.SetSPID(state.spid)
+		}
+		state.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				activeMu.Lock()
+				active--
+				closeIfEnded()
+				activeMu.Unlock()
+			}()
+			defer session.Close()
+
+			// A session that breaks the protocol takes the connection down with it
+			if !s.serveSession(conn) {
+				mux.Close()
+			}
+			s.logf("MARS session %d closed", session.ID())
+		}()
+	}
+}
+
+// serveSession serves TDS requests from LOGIN7 on until the client
+// disconnects, or until the session is idle once shutdown has started
+// It reports whether the session ended cleanly
+func (s *Server) serveSession(conn *clientConn) bool {
+	// Messages are read on their own goroutine so Attention is seen while a request runs
+	done := make(chan struct{})
+	defer close(done)
+	messages := s.readMessages(conn, done)
+
+	var next *tds.Message // Received while the previous request was running
+	for {
+		msg := next
+		next = nil
+		if s.shuttingDown() {
+			s.endSession(conn, msg)
+			return true
+		}
+		if msg == nil {
+			var in incoming
+			select {
+			case in = <-messages:
+			case <-s.quit:
+				s.endSession(conn, nil)
+				return true
+			}
+			if in.err != nil {
+				if errors.Is(in.err, io.EOF) {
+					return true
+				}
+				s.logf("Error reading message: %v", in.err)
+				return false
+			}
+			msg = in.msg
+		}
+
+		s.logf("Received message: Type=%#02x, Status=%#02x, Length=%d, Packets=%d",
+			msg.Type, msg.Status, len(msg.Data), msg.Packets)
+
+		// Only LOGIN7 is allowed before authentication
+		conn.mu.Lock()
+		loggedIn := conn.login != nil
+		if !loggedIn {
+			var err error
+			if msg.Body != nil {
+				msg.Body.Close()
+			}
+			if msg.Type == tds.PacketTypeLogin {
+				err = s.handleLogin(conn, msg)
+			} else {
+				err = fmt.Errorf("unexpected packet type %#02x before login", msg.Type)
+			}
+			conn.mu.Unlock()
+			if err != nil {
+				s.logf("Error handling login: %v", err)
+				return false
+			}
+			continue
+		}
+		conn.mu.Unlock()
+
+		switch msg.Type {
+		case tds.PacketTypeRPC, tds.PacketTypeSQLBatch, tds.PacketTypeTransMgr, tds.PacketTypeBulkLoad:
+			var err error
+			next, err = s.runRequest(conn, msg, messages)
+			if err != nil {
+				s.logf("Error handling request: %v", err)
+				return false
+			}
+		case tds.PacketTypeAttention:
+			// The request finished before the Attention arrived; it still needs acknowledging
+			err := s.sendAttentionAck(conn)
+			if err != nil {
+				s.logf("Error acknowledging attention: %v", err)
+				return false
+			}
+		default:
+			s.logf("Unknown packet type %#02x, skipping...", msg.Type)
+		}
+	}
+}
+
+// endSession ends a session that is idle at shutdown. A logged-in client is
+// told why, in response to pending if it has sent a request meanwhile
+func (s *Server) endSession(conn *clientConn, pending *tds.Message) {
+	if pending != nil && pending.Body != nil {
+		pending.Body.Close()
+	}
+
+	conn.mu.Lock()
+	loggedIn := conn.login != nil
+	conn.mu.Unlock()
+	if !loggedIn {
+		return
+	}
+
+	s.logf("Ending session %d for shutdown", conn.spid)
+	ts := tds.NewTokenStream()
+	writeError(ts, sqlerror.New(sqlerror.ShutdownInProgress, sqlerror.ClassLoginError, "SHUTDOWN is in progress."), "")
+	if err := s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes())); err != nil {
+		s.logf("Error sending shutdown message: %v", err)
+	}
+}
+
+// incoming is a message, or the read error, from a connection's reader goroutine
+type incoming struct {
+	msg *tds.Message
+	err error
+}
+
+// readMessages reads client messages until a read fails or done is closed
+func (s *Server) readMessages(conn *clientConn, done <-chan struct{}) <-chan incoming {
+	messages := make(chan incoming)
+
+	go func() {
+		for {
+			msg, err := conn.ReadMessage()
+			select {
+			case messages <- incoming{msg: msg, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			// The rest of a bulk load is read by its handler
+			if msg.Body != nil {
+				select {
+				case <-msg.Body.Done():
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	return messages
+}
+
+// runRequest handles an SQLBatch, RPC, transaction manager or bulk load request while watching for Attention
+// Attention cancels the request's context; once the handler returns, the
+// client gets a DONE token with the ATTN bit set. Another message received
+// meanwhile is returned once the request is done, to be handled next
+func (s *Server) runRequest(conn *clientConn, msg *tds.Message, messages <-chan incoming) (*tds.Message, error) {
+	ctx, cancel := context.WithCancel(s.requestCtx)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		// Requests on other MARS sessions wait; their responses are already queued
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+
+		if msg.Status&(tds.StatusReset|tds.StatusResetExp) != 0 {
+			s.resetSession(ctx, conn, msg.Status&tds.StatusResetExp != 0)
+		}
+
+		switch msg.Type {
+		case tds.PacketTypeRPC:
+			s.logf("Handling RPC packet")
+			result <- s.handleRPC(ctx, conn, msg)
+		case tds.PacketTypeTransMgr:
+			result <- s.handleTransMgr(ctx, conn, msg)
+		case tds.PacketTypeBulkLoad:
+			result <- s.handleBulkLoad(ctx, conn, msg)
+		default:
+			result <- s.handleSQLBatch(ctx, conn, msg)
+		}
+	}()
+
+	select {
+	case err := <-result:
+		return nil, err
+
+	case in := <-messages:
+		if in.err != nil {
+			cancel()
+			<-result
+			return nil, fmt.Errorf("error reading message: %w", in.err)
+		}
+
+		if in.msg.Type != tds.PacketTypeAttention {
+			// The client has the response already: it was written before the
+			// handler returned. Clients wait for it before the next request
+			return in.msg, <-result
+		}
+
+		s.logf("Attention received, cancelling request")
+		cancel()
+		if err := <-result; err != nil {
+			return nil, err
+		}
+		return nil, s.sendAttentionAck(conn)
+	}
+}
+
+// sendAttentionAck acknowledges an Attention with a DONE token carrying the ATTN bit
+func (s *Server) sendAttentionAck(conn *clientConn) error {
+	ts := tds.NewTokenStream()
+	ts.Done(tds.DoneAttn, 0, 0)
+
+	err := s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to send attention acknowledgment: %w", err)
+	}
+	return nil
+}
+
+// writePacket writes a packet's payload as one message, split to the negotiated packet size
+func (s *Server) writePacket(conn *clientConn, packet *tds.Packet) error {
+	data := packet.Data
+	if packet.Header.Type == tds.PacketTypeTabular && conn.resetTokens != nil {
+		data = append(conn.resetTokens, data...)
+		conn.resetTokens, conn.resetTransactionID = nil, 0
+	}
+	return conn.WriteMessage(packet.Header.Type, data)
+}
+
+// handlePreLogin answers PRELOGIN and reports whether MARS was negotiated, and
+// the negotiated ENCRYPTION option: TLS for the whole connection, for LOGIN7
+// only (EncryptionOff), or none (EncryptionNotSupported). A strict connection
+// is encrypted already, so nothing more is negotiated on it
+func (s *Server) handlePreLogin(conn *clientConn, msg *tds.Message, strict bool) (bool, byte, error) {
+	s.logf("Handling pre-login request")
+
+	// Parse pre-login request
+	req, err := tds.ParsePreLoginRequest(msg.Data)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to parse pre-login request: %w", err)
+	}
+
+	s.logf("Pre-login request: Version=%#v, Encryption=%#02x, Instance=%s, MARS=%d",
+		req.Version, req.Encryption, req.Instance, req.MARS)
+
+	// MARS is on when the client asks for it
+	mars := req.MARS == 0x01
+
+	encryption, refused := tls.NegotiateEncryption(s.tlsConfig, req.Encryption)
+	if strict {
+		encryption, refused = tls.EncryptionNotSupported, nil
+	}
+	if mars && encryption == tls.EncryptionOff {
+		// Login-only encryption cannot end inside the SMP stream
+		encryption = tls.EncryptionOn
+	}
+
+	resp := tds.DefaultPreLoginResponse(encryption)
+	if mars {
+		resp.MARS = 0x01
+	}
+
+	// Serialize response
+	respData := tds.SerializePreLoginResponse(resp)
+
+	// Send response packet
+	respPacket := tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, respData)
+	err = s.writePacket(conn, respPacket)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to send pre-login response: %w", err)
+	}
+	if refused != nil {
+		return false, 0, refused
+	}
+
+	s.logf("Sent pre-login response (encryption=%#02x, strict=%t)", encryption, strict)
+	return mars, encryption, nil
+}
+
+func (s *Server) handleLogin(conn *clientConn, msg *tds.Message) error {
+	s.logf("Handling login request")
+
+	// Decode LOGIN7 (offset/length table, UTF-16LE strings, obfuscated password)
+	login7, err := tds.ParseLogin7Request(msg.Data)
+	if err != nil {
+		return fmt.Errorf("failed to parse login packet: %w", err)
+	}
+
+	s.logf("LOGIN7: User=%s, Host=%s, App=%s, Database=%s, Language=%s, TDSVersion=%#08x, PacketSize=%d",
+		login7.UserName, login7.HostName, login7.AppName, login7.Database, login7.Language,
+		login7.TDSVersion, login7.PacketSize)
+
+	// Authenticate against syslogins (also updates login_count/last_login_date)
+	login, err := s.authManager.AuthenticateLogin(login7.UserName, login7.Password)
+	if err != nil {
+		// The real reason is only logged; clients always see state 1
+		s.logf("Login failed for user '%s' (state %d): %v",
+			login7.UserName, loginFailureState(err), err)
+
+		ts := tds.NewTokenStream()
+		ts.Error(sqlerror.LoginFailed, 1, sqlerror.ClassLoginError,
+			fmt.Sprintf("Login failed for user '%s'.", login7.UserName), serverName, "", 1)
+		ts.Done(tds.DoneError, 0, 0)
+
+		writeErr := s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes()))
+		if writeErr != nil {
+			return fmt.Errorf("failed to send login error: %w", writeErr)
+		}
+
+		return fmt.Errorf("authentication failed for user '%s': %w", login7.UserName, err)
+	}
+
+	// The session holds a connection from the pool only once logged in
+	if err := s.openExecutor(context.Background(), conn.Session); err != nil {
+		return fmt.Errorf("failed to open session: %w", err)
+	}
+
+	// Initial database: the LOGIN7 catalog, else the login's default database
+	database := login7.Database
+	if database == "" {
+		database = login.DefaultDatabaseName
+	}
+	if database == "" {
+		database = tds.DefaultDatabase
+	}
+
+	db, err := s.catalog.GetDatabase(database)
+	if err == nil {
+		// Statements run in the database from the start
+		err = conn.executor.ExecuteUseDatabase(&sqlparser.UseDatabaseStatement{DatabaseName: db.Name})
+	}
+	if err != nil {
+		s.logf("Login failed for user '%s': cannot open database '%s': %v", login7.UserName, database, err)
+
+		ts := tds.NewTokenStream()
+		ts.Error(sqlerror.CannotOpenDatabase, 1, sqlerror.ClassNotFound,
+			fmt.Sprintf("Cannot open database \"%s\" requested by the login. The login failed.", database), serverName, "", 1)
+		ts.Error(sqlerror.LoginFailed, 1, sqlerror.ClassLoginError,
+			fmt.Sprintf("Login failed for user '%s'.", login7.UserName), serverName, "", 1)
+		ts.Done(tds.DoneError, 0, 0)
+
+		writeErr := s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes()))
+		if writeErr != nil {
+			return fmt.Errorf("failed to send login error: %w", writeErr)
+		}
+
+		return fmt.Errorf("cannot open database '%s': %w", database, err)
+	}
+
+	language := login7.Language
+	if language == "" {
+		language = login.DefaultLanguage
+	}
+	if language == "" {
+		language = tds.DefaultLanguage
+	}
+
+	requestedPacketSize := int(login7.PacketSize)
+	packetSize := tds.ClampPacketSize(requestedPacketSize)
+
+	// Send the session environment, login acknowledgment and the negotiated packet size
+	ts := tds.NewTokenStream()
+	ts.EnvChangeDatabase(db.Name, "")
+	ts.Info(sqlerror.DatabaseChanged, 2, sqlerror.ClassInfo, fmt.Sprintf("Changed database context to '%s'.", db.Name), serverName, "", 1)
+	ts.EnvChangeCollation(tds.DefaultCollation(), nil)
+	ts.EnvChangeLanguage(language, "")
+	ts.Info(sqlerror.LanguageChanged, 1, sqlerror.ClassInfo, fmt.Sprintf("Changed language setting to %s.", language), serverName, "", 1)
+	ts.LoginAck(tds.NegotiateVersion(login7.TDSVersion))
+	ts.EnvChangePacketSize(packetSize, requestedPacketSize)
+	ts.Done(tds.DoneFinal, 0, 0)
+
+	// Packet headers carry the SPID from the login response on
+	conn.SetSPID(conn.spid)
+
+	err = s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to send login ack: %w", err)
+	}
+
+	// Responses after the login use the negotiated packet size
+	conn.SetPacketSize(packetSize)
+
+	conn.login = login
+	conn.database = db.Name
+	conn.loginDatabase = db.Name
+	conn.language = language
+	conn.packetSize = packetSize
+
+	s.logf("Login succeeded for user '%s' (database=%s, packet size=%d)", login.Name, db.Name, packetSize)
+	return nil
+}
+
+// loginFailureState returns the SQL Server error log state for a failed login
+func loginFailureState(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrLoginNotFound):
+		return 5
+	case errors.Is(err, auth.ErrInvalidPassword):
+		return 8
+	case errors.Is(err, auth.ErrLoginDisabled):
+		return 7
+	case errors.Is(err, auth.ErrLoginLocked):
+		return 10
+	default:
+		return 1
+	}
+}
+
+// sendError reports an execution error as an ERROR token followed by DONE
+// go-sqlite3 errors are mapped to SQL Server error numbers; query names the failed statement
+func (s *Server) sendError(conn *clientConn, err error, query string) error {
+	ts := tds.NewTokenStream()
+	writeError(ts, err, query)
+
+	err = s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to send error: %w", err)
+	}
+	return nil
+}
+
+// writeError writes an execution error as an ERROR token followed by DONE
+func writeError(ts *tds.TokenStream, err error, query string) {
+	sqlErr := sqlerror.FromError(err, query)
+	ts.Error(sqlErr.Number, sqlErr.State, sqlErr.Class, sqlErr.Message, serverName, sqlErr.ProcName, sqlErr.LineNumber)
+	ts.Done(tds.DoneError, 0, 0)
+}
+
+func (s *Server) handleSQLBatch(ctx context.Context, conn *clientConn, msg *tds.Message) error {
+	batch, err := tds.ParseSQLBatch(msg.Data)
+	if err != nil {
+		return fmt.Errorf("failed to parse SQL batch: %w", err)
+	}
+
+	query := batch.SQL
+	s.logf("Handling SQL batch: %s", query)
+
+	if err := s.checkTransactionDescriptor(conn, batch.Headers); err != nil {
+		return s.sendError(conn, err, query)
+	}
+
+	// Normalize query
+	query = strings.TrimSpace(query)
+	queryUpper := strings.ToUpper(query)
+
+	// Check for CREATE PROCEDURE
+	if strings.HasPrefix(queryUpper, "CREATE PROCEDURE") || strings.HasPrefix(queryUpper, "CREATE PROC") {
+		return s.handleCreateProcedure(conn, query)
+	}
+
+	// Check for DROP PROCEDURE
+	if strings.HasPrefix(queryUpper, "DROP PROCEDURE") || strings.HasPrefix(queryUpper, "DROP PROC") {
+		return s.handleDropProcedure(conn, query)
+	}
+
+	// Batches with variables run a statement at a time
+	statements := sqlparser.SplitBatch(sqlparser.StripComments(query))
+	if usesVariables(statements) {
+		return s.handleVariableBatch(ctx, conn, statements)
+	}
+
+	// Check for EXEC command
+	if isExecStatement(query) {
+		return s.handleExecProcedure(ctx, conn, query)
+	}
+
+	// Check for USE
+	if strings.HasPrefix(queryUpper, "USE ") {
+		return s.handleUseDatabase(conn, query)
+	}
+
+	// #temp tables are the connection's own
+	query = sqlexecutor.RewriteTempTables(query, conn.tempSession)
+
+	// Check for INSERT BULK
+	if strings.HasPrefix(queryUpper, "INSERT BULK ") {
+		return s.handleInsertBulk(ctx, conn, query)
+	}
+
+	// Default: Process the query using the query processor
+	results, err := conn.queryProcessor.ExecuteSQLBatch(ctx, query)
+	if err != nil {
+		s.logf("Error processing query: %v", err)
+
+		// A cancelled request is answered by the attention acknowledgment alone
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+
+	// Each statement's transaction change is reported ahead of its result
+	statements = sqlparser.SplitBatch(sqlparser.StripComments(query))
+	ts := tds.NewTokenStream()
+	for _, stmt := range statements[:len(results)] {
+		s.writeTransactionChange(conn, ts, stmt)
+	}
+
+	// Statements that ran before an error keep their results; the connection stays open
+	if err := s.writeResults(conn, ts, results, false, err != nil); err != nil {
+		return fmt.Errorf("failed to send result: %w", err)
+	}
+	if err != nil {
+		// Errors name the statement that failed
+		failed := query
+		if len(results) < len(statements) {
+			failed = statements[len(results)]
+		}
+		writeError(ts, err, failed)
+	}
+
+	err = s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to send result: %w", err)
+	}
+
+	s.logf("Sent %d result(s)", len(results))
+	return nil
+}
+
+// isExecStatement reports whether a statement is an EXEC or EXECUTE call
+func isExecStatement(stmt string) bool {
+	stmtUpper := strings.ToUpper(strings.TrimSpace(stmt))
+	return strings.HasPrefix(stmtUpper, "EXEC ") || strings.HasPrefix(stmtUpper, "EXECUTE ")
+}
+
+// usesVariables reports whether a batch declares variables, or calls a
+// procedure among other statements, which may use its return status or
+// OUTPUT parameters
+func usesVariables(statements []string) bool {
+	for _, stmt := range statements {
+		if controlflow.ParseStatement(stmt) == controlflow.StatementDeclare {
+			return true
+		}
+		if len(statements) > 1 && isExecStatement(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleVariableBatch runs a batch a statement at a time, keeping the
+// variables it declares. Statements read variables as parameters; procedures
+// are passed their values, and their return status and OUTPUT parameters are
+// assigned to the variables named in the EXEC statement
+func (s *Server) handleVariableBatch(ctx context.Context, conn *clientConn, statements []string) error {
+	vars := variable.NewContext()
+	ts := tds.NewTokenStream()
+
+	for i, stmt := range statements {
+		more := i < len(statements)-1
+
+		var err error
+		switch {
+		case procedure.IsAssignment(stmt):
+			storage := s.procedureStorage.WithConn(conn.executor.Conn())
+			err = s.procedureExecutor.WithConn(storage, conn.executor.CurrentConn()).Assign(ctx, stmt, vars)
+		case isExecStatement(stmt):
+			err = s.execBatchProcedure(ctx, conn, ts, stmt, vars, more)
+		default:
+			err = s.execBatchStatement(ctx, conn, ts, stmt, vars, more)
+		}
+		if err != nil {
+			s.logf("Error processing query: %v", err)
+
+			// A cancelled request is answered by the attention acknowledgment alone
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			// Statements that ran before an error keep their results; the connection stays open
+			writeError(ts, err, stmt)
+			break
+		}
+	}
+
+	err := s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to send result: %w", err)
+	}
+	return nil
+}
+
+// execBatchStatement runs a statement of a batch with variables, binding the
+// variables it names, and writes its result
+func (s *Server) execBatchStatement(ctx context.Context, conn *clientConn, ts *tds.TokenStream, stmt string, vars *variable.Context, more bool) error {
+	var args []interface{}
+	for _, ref := range variable.FindVariableReferences(stmt) {
+		if v, ok := vars.Get(ref); ok {
+			args = append(args, sql.Named(ref[1:], v.Value))
+		}
+	}
+
+	// #temp tables are the connection's own
+	stmt = sqlexecutor.RewriteTempTables(stmt, conn.tempSession)
+	result, err := conn.executor.ExecuteContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	s.writeTransactionChange(conn, ts, stmt)
+	return s.writeResults(conn, ts, []*sqlexecutor.ExecuteResult{result}, false, more)
+}
+
+// execBatchProcedure runs an EXEC statement of a batch with variables and
+// writes the procedure's results and return status. Its return status and
+// OUTPUT parameters are assigned to the batch's variables
+func (s *Server) execBatchProcedure(ctx context.Context, conn *clientConn, ts *tds.TokenStream, query string, vars *variable.Context, more bool) error {
+	stmt, err := procedure.ParseExecStatement(query)
+	if err != nil {
+		return err
+	}
+
+	// Every variable named must be declared before the procedure runs
+	if _, ok := vars.Get(stmt.StatusVar); stmt.StatusVar != "" && !ok {
+		return undeclaredVariable(stmt.StatusVar)
+	}
+	args := make([]procedureArg, len(stmt.Args))
+	for i, arg := range stmt.Args {
+		args[i] = procedureArg{name: arg.Param, value: arg.Value, output: arg.Output}
+		if arg.Variable != "" {
+			v, ok := vars.Get(arg.Variable)
+			if !ok {
+				return undeclaredVariable(arg.Variable)
+			}
+			args[i].value = v.Value
+		}
+	}
+
+	result, err := s.executeProcedure(ctx, conn, stmt.Name, args)
+	if err != nil {
+		return err
+	}
+
+	if stmt.StatusVar != "" {
+		if err := vars.Set(stmt.StatusVar, int64(result.ReturnStatus)); err != nil {
+			return err
+		}
+	}
+	for i, arg := range stmt.Args {
+		if arg.Output {
+			value, _ := result.Output(args[i].name)
+			if err := vars.Set(arg.Variable, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.writeResults(conn, ts, result.Results, true, true); err != nil {
+		return err
+	}
+	ts.ReturnStatus(result.ReturnStatus)
+	status := tds.DoneFinal
+	if more {
+		status |= tds.DoneMore
+	}
+	ts.DoneProc(status, 0, 0)
+
+	s.logf("Executed procedure: %s, returned status %d", stmt.Name, result.ReturnStatus)
+	return nil
+}
+
+// undeclaredVariable is the error for a variable used without DECLARE
+func undeclaredVariable(name string) error {
+	return sqlerror.New(sqlerror.UndeclaredVariable, sqlerror.ClassSyntax,
+		"Must declare the scalar variable \"%s\".", name)
+}
+
+// writeResults writes each result ended by DONE, or DONEINPROC inside a procedure
+// DONE_MORE is set on every result but the last, and on the last too when more follows.
+// SET NOCOUNT and SET FMTONLY apply to the rest of the batch and the session;
+// inside a procedure they last until the procedure ends
+func (s *Server) writeResults(conn *clientConn, ts *tds.TokenStream, results []*sqlexecutor.ExecuteResult, inProc bool, more bool) error {
+	noCount, fmtOnly := conn.noCount, conn.fmtOnly
+	for i, result := range results {
+		if result.SetOption != nil && result.SetOption.Sets("NOCOUNT") {
+			noCount = result.SetOption.On
+		}
+		if result.SetOption != nil && result.SetOption.Sets("FMTONLY") {
+			fmtOnly = result.SetOption.On
+		}
+		if fmtOnly && result.IsQuery {
+			// Clients such as bulk copy read just the column metadata
+			metadata := *result
+			metadata.Rows = nil
+			result = &metadata
+		}
+
+		status := tds.DoneFinal
+		if more || i < len(results)-1 {
+			status |= tds.DoneMore
+		}
+		if err := ts.Result(result, status, inProc, noCount); err != nil {
+			return err
+		}
+	}
+
+	if !inProc {
+		conn.noCount, conn.fmtOnly = noCount, fmtOnly
+	}
+	return nil
+}
+
+// writeTransactionChange writes the transaction ENVCHANGE for a successful
+// BEGIN, COMMIT or ROLLBACK and updates the connection's transaction descriptor
+func (s *Server) writeTransactionChange(conn *clientConn, ts *tds.TokenStream, query string) {
+	switch transaction.ParseStatement(query) {
+	case transaction.TransactionBegin:
+		if conn.transactionID == 0 {
+			conn.transactionID = s.lastTransactionID.Add(1)
+			ts.EnvChangeBeginTran(conn.transactionID)
+		}
+	case transaction.TransactionCommit:
+		if conn.transactionID != 0 {
+			ts.EnvChangeCommitTran(conn.transactionID)
+			conn.transactionID = 0
+		}
+	case transaction.TransactionRollback:
+		if conn.transactionID != 0 {
+			ts.EnvChangeRollbackTran(conn.transactionID)
+			conn.transactionID = 0
+		}
+	}
+}
+
+// handleTransMgr handles a transaction manager request: TM_BEGIN_XACT,
+// TM_COMMIT_XACT, TM_ROLLBACK_XACT or TM_SAVE_XACT
+func (s *Server) handleTransMgr(ctx context.Context, conn *clientConn, msg *tds.Message) error {
+	req, err := tds.ParseTransMgrRequest(msg.Data)
+	if err != nil {
+		s.logf("Error parsing transaction manager request: %v", err)
+
+		// A malformed request ends the connection
+		if writeErr := s.sendError(conn, err, ""); writeErr != nil {
+			return writeErr
+		}
+
+		return fmt.Errorf("transaction manager parsing error: %w", err)
+	}
+
+	s.logf("Handling transaction manager request: Type=%d, Name=%q, IsolationLevel=%s",
+		req.Type, req.Name, tds.IsolationLevelName(req.IsolationLevel))
+
+	if err := s.checkTransactionDescriptor(conn, req.Headers); err != nil {
+		return s.sendError(conn, err, "")
+	}
+
+	ts := tds.NewTokenStream()
+	if err := s.executeTransMgr(ctx, conn, ts, req); err != nil {
+		s.logf("Error processing transaction manager request: %v", err)
+
+		// A cancelled request is answered by the attention acknowledgment alone
+		if ctx.Err() != nil {
+			return nil
+		}
+		writeError(ts, err, "")
+	} else {
+		ts.Done(tds.DoneFinal, 0, 0)
+	}
+
+	err = s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to send transaction manager response: %w", err)
+	}
+	return nil
+}
+
+// executeTransMgr applies a transaction manager request to the connection's
+// transaction, writing the ENVCHANGE tokens that report it
+func (s *Server) executeTransMgr(ctx context.Context, conn *clientConn, ts *tds.TokenStream, req *tds.TransMgrRequest) error {
+	switch req.Type {
+	case tds.TMBeginXact:
+		return s.beginTransaction(ctx, conn, ts, req.IsolationLevel)
+
+	case tds.TMSaveXact:
+		if conn.transactionID == 0 {
+			return sqlerror.New(sqlerror.NoTransactionToSave, sqlerror.ClassUserError,
+				"Cannot issue SAVE TRANSACTION when there is no active transaction.")
+		}
+		_, err := conn.executor.ExecuteContext(ctx, "SAVE TRANSACTION "+req.Name)
+		return err
+
+	case tds.TMCommitXact, tds.TMRollbackXact:
+		commit := req.Type == tds.TMCommitXact
+		if conn.transactionID == 0 {
+			if commit {
+				return sqlerror.New(sqlerror.CommitWithoutBegin, sqlerror.ClassUserError,
+					"The COMMIT TRANSACTION request has no corresponding BEGIN TRANSACTION.")
+			}
+			return sqlerror.New(sqlerror.RollbackWithoutBegin, sqlerror.ClassUserError,
+				"The ROLLBACK TRANSACTION request has no corresponding BEGIN TRANSACTION.")
+		}
+
+		// A named rollback returns to that savepoint; the transaction stays open
+		if !commit && req.Name != "" {
+			_, err := conn.executor.ExecuteContext(ctx, "ROLLBACK TO SAVEPOINT "+req.Name)
+			return err
+		}
+
+		query := "ROLLBACK TRANSACTION"
+		if commit {
+			query = "COMMIT TRANSACTION"
+		}
+		if _, err := conn.executor.ExecuteContext(ctx, query); err != nil {
+			return err
+		}
+
+		if commit {
+			ts.EnvChangeCommitTran(conn.transactionID)
+		} else {
+			ts.EnvChangeRollbackTran(conn.transactionID)
+		}
+		conn.transactionID = 0
+
+		if req.BeginNew {
+			return s.beginTransaction(ctx, conn, ts, req.NewIsolationLevel)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported transaction manager request type %d", req.Type)
+	}
+}
+
+// beginTransaction starts the connection's transaction and reports its new descriptor
+func (s *Server) beginTransaction(ctx context.Context, conn *clientConn, ts *tds.TokenStream, isolationLevel byte) error {
+	if _, err := conn.executor.ExecuteContext(ctx, "BEGIN TRANSACTION"); err != nil {
+		return err
+	}
+
+	if isolationLevel != tds.IsolationLevelUnchanged {
+		conn.isolationLevel = isolationLevel
+	}
+	conn.transactionID = s.lastTransactionID.Add(1)
+	ts.EnvChangeBeginTran(conn.transactionID)
+	return nil
+}
+
+// checkTransactionDescriptor rejects a request whose ALL_HEADERS carries a
+// transaction descriptor other than the one of the connection's transaction
+// (0 outside a transaction)
+func (s *Server) checkTransactionDescriptor(conn *clientConn, headers *tds.AllHeaders) error {
+	if headers == nil || !headers.HasTransactionDescriptor {
+		return nil
+	}
+	if headers.TransactionDescriptor != conn.transactionID && headers.TransactionDescriptor != conn.resetTransactionID {
+		s.logf("Transaction descriptor %#x does not match the connection's %#x",
+			headers.TransactionDescriptor, conn.transactionID)
+		return sqlerror.New(sqlerror.TransactionContextInUse, sqlerror.ClassUserError,
+			"Transaction context in use by another session.")
+	}
+	return nil
+}
+
+// resetSession returns the session to its state after login, as
+// sp_reset_connection does when a pool hands the connection to its next user:
+// the transaction is rolled back unless keepTransaction is set
+// (RESETCONNECTIONSKIPTRAN), #temp tables are dropped, the login's database
+// is current again and SET options are back to their defaults
+func (s *Server) resetSession(ctx context.Context, conn *clientConn, keepTransaction bool) {
+	s.logf("Resetting session (keep transaction: %t)", keepTransaction)
+
+	ts := tds.NewTokenStream()
+	if conn.transactionID != 0 && !keepTransaction {
+		if _, err := conn.executor.ExecuteContext(ctx, "ROLLBACK TRANSACTION"); err != nil {
+			s.logf("Error rolling back transaction on reset: %v", err)
+		}
+		ts.EnvChangeRollbackTran(conn.transactionID)
+		conn.resetTransactionID = conn.transactionID
+		conn.transactionID = 0
+	}
+
+	s.dropTempTables(conn.Session)
+
+	if conn.database != conn.loginDatabase {
+		err := conn.executor.ExecuteUseDatabase(&sqlparser.UseDatabaseStatement{DatabaseName: conn.loginDatabase})
+		if err != nil {
+			s.logf("Error restoring database %s on reset: %v", conn.loginDatabase, err)
+		} else {
+			ts.EnvChangeDatabase(conn.loginDatabase, conn.database)
+			conn.database = conn.loginDatabase
+		}
+	}
+
+	conn.isolationLevel = 0
+	conn.noCount = false
+	conn.fmtOnly = false
+	conn.bulkLoad = nil
+
+	ts.EnvChangeResetConnAck()
+	conn.resetTokens = ts.Bytes()
+}
+
+// handleInsertBulk accepts an INSERT BULK statement once its table and
+// columns check out; the rows follow in a BULK_LOAD message
+func (s *Server) handleInsertBulk(ctx context.Context, conn *clientConn, query string) error {
+	stmt, err := sqlparser.NewParser().Parse(query)
+	if err == nil && stmt.InsertBulk == nil {
+		err = fmt.Errorf("invalid INSERT BULK statement")
+	}
+	if err == nil {
+		columns := make([]string, len(stmt.InsertBulk.Columns))
+		for i, col := range stmt.InsertBulk.Columns {
+			columns[i] = col.Name
+		}
+		err = conn.executor.CheckBulkInsert(ctx, stmt.InsertBulk.Table, columns)
+	}
+	if err != nil {
+		s.logf("Error preparing bulk load: %v", err)
+		return s.sendError(conn, err, query)
+	}
+
+	conn.bulkLoad = stmt.InsertBulk
+	return s.sendDone(conn)
+}
+
+// handleBulkLoad inserts the rows of a BULK_LOAD message into the table of the
+// preceding INSERT BULK and reports how many were inserted
+func (s *Server) handleBulkLoad(ctx context.Context, conn *clientConn, msg *tds.Message) error {
+	// Whatever happens, the rest of the message is read before the next one
+	if msg.Body != nil {
+		defer msg.Body.Close()
+	}
+
+	stmt := conn.bulkLoad
+	conn.bulkLoad = nil
+	if stmt == nil {
+		return s.sendError(conn, fmt.Errorf("bulk load data received without INSERT BULK"), "")
+	}
+
+	br := tds.NewBulkLoadReader(msg)
+	metadata, err := br.Columns()
+	if err == nil {
+		err = checkBulkLoadColumns(stmt, metadata)
+	}
+	if err != nil {
+		s.logf("Error reading bulk load: %v", err)
+		return s.sendError(conn, err, "")
+	}
+
+	columns := make([]string, len(stmt.Columns))
+	for i, col := range stmt.Columns {
+		columns[i] = col.Name
+	}
+
+	s.logf("Bulk loading %s (%s)", stmt.Table, strings.Join(columns, ", "))
+	count, err := conn.executor.BulkInsert(ctx, stmt.Table, columns, stmt.Options, br.ReadRow)
+	if err != nil {
+		s.logf("Error bulk loading %s after %d rows: %v", stmt.Table, count, err)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return s.sendError(conn, err, "")
+	}
+	s.logf("Bulk loaded %d rows into %s", count, stmt.Table)
+
+	ts := tds.NewTokenStream()
+	ts.Done(tds.DoneCount, tds.CurCmdInsert, uint64(count))
+	err = s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to send bulk load result: %w", err)
+	}
+	return nil
+}
+
+// checkBulkLoadColumns checks that the COLMETADATA of a bulk load names the
+// columns of its INSERT BULK, in order
+func checkBulkLoadColumns(stmt *sqlparser.InsertBulkStatement, metadata []tds.ColumnInfo) error {
+	if len(metadata) != len(stmt.Columns) {
+		return fmt.Errorf("bulk load data has %d columns, INSERT BULK names %d", len(metadata), len(stmt.Columns))
+	}
+	for i, col := range metadata {
+		if !strings.EqualFold(col.Name, stmt.Columns[i].Name) {
+			return fmt.Errorf("bulk load column %d is '%s', INSERT BULK names '%s'", i+1, col.Name, stmt.Columns[i].Name)
+		}
+	}
+	return nil
+}
+
+// handleUseDatabase switches the current database and reports it with a database ENVCHANGE
+func (s *Server) handleUseDatabase(conn *clientConn, query string) error {
+	stmt, err := sqlparser.NewParser().Parse(query)
+	if err == nil && stmt.UseDatabase == nil {
+		err = fmt.Errorf("invalid USE statement")
+	}
+	// A transaction stays in the database it started in
+	if err == nil && conn.transactionID != 0 {
+		err = sqlerror.New(sqlerror.NotAllowedInTransaction, sqlerror.ClassUserError,
+			"USE statement not allowed within multi-statement transaction.")
+	}
+	if err == nil {
+		err = conn.executor.ExecuteUseDatabase(stmt.UseDatabase)
+	}
+	if err != nil {
+		s.logf("Error changing database: %v", err)
+
+		// Reported to the client; the connection stays open
+		return s.sendError(conn, err, query)
+	}
+
+	database := conn.executor.GetCurrentDatabase()
+
+	ts := tds.NewTokenStream()
+	ts.EnvChangeDatabase(database, conn.database)
+	ts.Info(sqlerror.DatabaseChanged, 1, sqlerror.ClassInfo, fmt.Sprintf("Changed database context to '%s'.", database), serverName, "", 1)
+	ts.Done(tds.DoneFinal, 0, 0)
+
+	err = s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to send database change: %w", err)
+	}
+
+	conn.database = database
+	s.logf("Changed database to %s", database)
+	return nil
+}
+
+func (s *Server) handleCreateProcedure(conn *clientConn, query string) error {
+	s.logf("Handling CREATE PROCEDURE: %s", query)
+
+	// Parse CREATE PROCEDURE statement
+	proc, err := procedure.ParseCreateProcedure(query)
+	if err != nil {
+		s.logf("Error parsing CREATE PROCEDURE: %v", err)
+
+		// Reported to the client; the connection stays open
+		return s.sendError(conn, err, query)
+	}
+
+	// Store procedure in database
+	err = s.procedureStorage.WithConn(conn.executor.Conn()).Create(proc)
+	if err != nil {
+		s.logf("Error storing procedure: %v", err)
+
+		// Reported to the client; the connection stays open
+		return s.sendError(conn, err, query)
+	}
+
+	// DDL completes with a bare DONE
+	err = s.sendDone(conn)
+	if err != nil {
+		return fmt.Errorf("failed to send result: %w", err)
+	}
+
+	s.logf("Created procedure: %s", proc.Name)
+	return nil
+}
+
+func (s *Server) handleDropProcedure(conn *clientConn, query string) error {
+	s.logf("Handling DROP PROCEDURE: %s", query)
+
+	// Extract procedure name
+	// Simple parsing: DROP PROC[EDURE] procname
+	parts := strings.Fields(query)
+	if len(parts) < 3 {
+		return s.sendError(conn, sqlerror.New(sqlerror.SyntaxError, sqlerror.ClassSyntax, "Incorrect syntax near '%s'.", parts[len(parts)-1]), query)
+	}
+
+	procName := parts[2]
+
+	// Drop procedure from database
+	err := s.procedureStorage.WithConn(conn.executor.Conn()).Drop(procName)
+	if err != nil {
+		s.logf("Error dropping procedure: %v", err)
+
+		// Reported to the client; the connection stays open
+		return s.sendError(conn, err, query)
+	}
+
+	// DDL completes with a bare DONE
+	err = s.sendDone(conn)
+	if err != nil {
+		return fmt.Errorf("failed to send result: %w", err)
+	}
+
+	s.logf("Dropped procedure: %s", procName)
+	return nil
+}
+
+func (s *Server) handleExecProcedure(ctx context.Context, conn *clientConn, query string) error {
+	s.logf("Handling EXEC: %s", query)
+
+	// Parse EXEC statement
+	stmt, err := procedure.ParseExecStatement(query)
+	if err != nil {
+		s.logf("Error parsing EXEC statement: %v", err)
+
+		// Reported to the client; the connection stays open
+		return s.sendError(conn, err, query)
+	}
+
+	// The batch has no variables of its own, so variables are passed as NULL
+	args := make([]procedureArg, len(stmt.Args))
+	for i, arg := range stmt.Args {
+		args[i] = procedureArg{name: arg.Param, value: arg.Value, output: arg.Output}
+	}
+
+	// Execute procedure
+	result, err := s.executeProcedure(ctx, conn, stmt.Name, args)
+	if err != nil {
+		s.logf("Error executing procedure: %v", err)
+
+		// A cancelled request is answered by the attention acknowledgment alone
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// Reported to the client; the connection stays open
+		return s.sendError(conn, err, query)
+	}
+
+	// OUTPUT values are returned under the names of the variables they were assigned to
+	rpcResult := &tds.RPCResult{Results: result.Results, ReturnStatus: result.ReturnStatus}
+	for i, arg := range stmt.Args {
+		if arg.Output {
+			value, _ := result.Output(args[i].name)
+			rpcResult.ReturnValues = append(rpcResult.ReturnValues, tds.NewReturnValue(uint16(i), arg.Variable, nil, value))
+		}
+	}
+
+	err = s.sendRPCResponse(conn, rpcResult)
+	if err != nil {
+		return fmt.Errorf("failed to send result: %w", err)
+	}
+
+	s.logf("Executed procedure: %s, returned status %d", stmt.Name, result.ReturnStatus)
+	return nil
+}
+
+// procedureArg is an argument of a stored procedure call
+type procedureArg struct {
+	name   string // Parameter name with @; empty when passed by position
+	value  interface{}
+	output bool
+}
+
+// executeProcedure runs a procedure created with CREATE PROCEDURE on the
+// session's connection, inside its transaction and in its current database
+// Unnamed arguments are passed by position and are given their parameter's name
+func (s *Server) executeProcedure(ctx context.Context, conn *clientConn, name string, args []procedureArg) (*procedure.Result, error) {
+	storage := s.procedureStorage.WithConn(conn.executor.Conn())
+	proc, err := storage.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	paramValues := make(map[string]interface{}, len(args))
+	for i := range args {
+		arg := &args[i]
+		if arg.name == "" {
+			if i >= len(proc.Parameters) {
+				return nil, sqlerror.New(sqlerror.TooManyArguments, sqlerror.ClassUserError,
+					"Procedure or function %s has too many arguments specified.", proc.Name)
+			}
+			arg.name = "@" + proc.Parameters[i].Name
+		}
+
+		if arg.output {
+			for _, param := range proc.Parameters {
+				if strings.EqualFold("@"+param.Name, arg.name) && !param.Output {
+					return nil, sqlerror.New(sqlerror.NotAnOutputParameter, sqlerror.ClassUserError,
+						"The formal parameter \"%s\" was not declared as an OUTPUT parameter, but the actual parameter passed in requested output.", arg.name)
+				}
+			}
+		}
+
+		paramValues[arg.name] = arg.value
+	}
+
+	executor := s.procedureExecutor.WithConn(storage, conn.executor.CurrentConn())
+	return executor.ExecuteContext(ctx, proc.Name, paramValues)
+}
+
+// Shutdown stops the server gracefully. It stops accepting connections and
+// disconnects clients that have not logged in; each session ends once it is
+// idle, telling the client the server is shutting down. When ctx is done
+// before the sessions have ended, their running requests are cancelled and
+// their connections closed, and Shutdown returns ctx's error. Open
+// transactions are rolled back as sessions close. Last, the databases are
+// checkpointed and closed
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.quitOnce.Do(func() { close(s.quit) })
+
+	s.connsMu.Lock()
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	for conn, session := range s.conns {
+		if session == nil || session.awaitingLogin() {
+			conn.Close()
+		}
+	}
+	s.connsMu.Unlock()
+
+	err := s.waitForConns(ctx)
+	if err != nil {
+		s.logf("Shutdown deadline passed, cancelling running requests")
+		s.closeConns()
+		s.waitForConns(context.Background())
+	}
+	return errors.Join(err, s.closeDatabases())
+}
+
+// Close stops the server at once: it closes the listeners and every
+// connection, cancelling running requests, then closes the databases
+func (s *Server) Close() error {
+	s.quitOnce.Do(func() { close(s.quit) })
+	s.closeConns()
+	s.waitForConns(context.Background())
+	return s.closeDatabases()
+}
+
+// closeConns cancels running requests and closes the listeners and connections
+func (s *Server) closeConns() {
+	s.cancelRequests()
+
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// waitForConns waits until every connection has been served or ctx is done
+func (s *Server) waitForConns(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.connsMu.Lock()
+		open := len(s.conns)
+		s.connsMu.Unlock()
+		if open == 0 {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// closeDatabases checkpoints and closes the user databases and the server's
+// own database; only the first call does anything
+func (s *Server) closeDatabases() error {
+	s.closeOnce.Do(func() {
+		var errs []error
+		if err := s.sqlExecutor.Checkpoint(context.Background()); err != nil {
+			errs = append(errs, err)
+		}
+		if err := s.sqlExecutor.CloseDatabases(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := s.catalog.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := s.db.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		s.closeErr = errors.Join(errs...)
+	})
+	return s.closeErr
+}
+
+// sendDone writes a final DONE for a statement that returns no rows
+func (s *Server) sendDone(conn *clientConn) error {
+	ts := tds.NewTokenStream()
+	ts.Done(tds.DoneFinal, 0, 0)
+	return s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes()))
+}
+
+func (s *Server) handleRPC(ctx context.Context, conn *clientConn, msg *tds.Message) error {
+	s.logf("Handling RPC request, data length: %d", len(msg.Data))
+
+	// Parse RPC request
+	rpcReq, err := tds.ParseRPCRequest(msg.Data)
+	if err != nil {
+		s.logf("Error parsing RPC request: %v", err)
+
+		// A malformed request ends the connection
+		if writeErr := s.sendError(conn, err, ""); writeErr != nil {
+			return writeErr
+		}
+
+		return fmt.Errorf("RPC parsing error: %w", err)
+	}
+
+	s.logf("RPC Procedure: %s", rpcReq.ProcName)
+	s.logf("RPC Parameters: %d", len(rpcReq.Params))
+
+	if err := s.checkTransactionDescriptor(conn, rpcReq.Headers); err != nil {
+		return s.sendError(conn, err, rpcReq.ProcName)
+	}
+
+	for i, param := range rpcReq.Params {
+		s.logf("  Param %d: %s = %v", i+1, param.Name, param.Value)
+	}
+
+	var result *tds.RPCResult
+	query := rpcReq.ProcName
+	switch {
+	case isSystemProc(rpcReq, tds.ProcIDExecuteSQL):
+		// Parameterized query; errors name the statement rather than the procedure
+		if len(rpcReq.Params) > 0 {
+			query, _ = rpcReq.Params[0].Value.(string)
+		}
+		rewriteTempTables(conn, rpcReq, 0)
+		result, err = conn.queryProcessor.ExecuteSQL(ctx, rpcReq)
+
+	case isSystemProc(rpcReq, tds.ProcIDPrepare):
+		rewriteTempTables(conn, rpcReq, 2)
+		result, err = conn.queryProcessor.Prepare(ctx, conn.preparedStmts, rpcReq)
+
+	case isSystemProc(rpcReq, tds.ProcIDPrepExec):
+		if len(rpcReq.Params) > 2 {
+			query, _ = rpcReq.Params[2].Value.(string)
+		}
+		rewriteTempTables(conn, rpcReq, 2)
+		result, err = conn.queryProcessor.PrepExec(ctx, conn.preparedStmts, rpcReq)
+
+	case isSystemProc(rpcReq, tds.ProcIDExecute):
+		result, err = conn.queryProcessor.ExecutePrepared(ctx, conn.preparedStmts, rpcReq)
+
+	case isSystemProc(rpcReq, tds.ProcIDUnprepare):
+		result, err = conn.queryProcessor.Unprepare(conn.preparedStmts, rpcReq)
+
+	default:
+		result, err = s.executeProcedureRPC(ctx, conn, rpcReq)
+	}
+	if err != nil {
+		s.logf("Error executing stored procedure: %v", err)
+
+		// A cancelled request is answered by the attention acknowledgment alone
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// Reported to the client; the connection stays open
+		return s.sendError(conn, err, query)
+	}
+
+	// Send RPC response
+	err = s.sendRPCResponse(conn, result)
+	if err != nil {
+		return fmt.Errorf("failed to send RPC response: %w", err)
+	}
+
+	s.logf("Sent RPC response for %s", rpcReq.ProcName)
+	return nil
+}
+
+// rewriteTempTables maps the #temp tables named in the statement parameter
+// of sp_executesql, sp_prepare or sp_prepexec to the connection's tables
+func rewriteTempTables(conn *clientConn, req *tds.RPCRequest, index int) {
+	if index >= len(req.Params) {
+		return
+	}
+	if statement, ok := req.Params[index].Value.(string); ok {
+		req.Params[index].Value = sqlexecutor.RewriteTempTables(statement, conn.tempSession)
+	}
+}
+
+// executeProcedureRPC runs a stored procedure called by name: one created with
+// CREATE PROCEDURE, otherwise a built-in procedure
+func (s *Server) executeProcedureRPC(ctx context.Context, conn *clientConn, req *tds.RPCRequest) (*tds.RPCResult, error) {
+	args := make([]procedureArg, len(req.Params))
+	for i, param := range req.Params {
+		args[i] = procedureArg{name: param.Name, value: param.Value, output: param.IsOutput()}
+	}
+
+	result, err := s.executeProcedure(ctx, conn, req.ProcName, args)
+	var sqlErr *sqlerror.Error
+	if errors.As(err, &sqlErr) && sqlErr.Number == sqlerror.ProcedureNotFound {
+		rows, err := s.storedProcedureHandler.Execute(req.ProcName, req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return &tds.RPCResult{Results: []*sqlexecutor.ExecuteResult{rows}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OUTPUT values are returned as the type each parameter was sent as
+	rpcResult := &tds.RPCResult{Results: result.Results, ReturnStatus: result.ReturnStatus}
+	for i, param := range req.Params {
+		if param.IsOutput() {
+			value, _ := result.Output(args[i].name)
+			rpcResult.ReturnValues = append(rpcResult.ReturnValues, tds.NewReturnValue(uint16(i), args[i].name, &param.ColumnInfo, value))
+		}
+	}
+
+	return rpcResult, nil
+}
+
+// isSystemProc reports whether an RPC calls the well-known procedure procID,
+// either by its ID or by name
+func isSystemProc(req *tds.RPCRequest, procID uint16) bool {
+	return req.ProcID == procID || strings.EqualFold(req.ProcName, tds.WellKnownProcName(procID))
+}
+
+// sendRPCResponse writes the result of an RPC: each result ended by DONEINPROC,
+// the return status, OUTPUT parameter values and a final DONEPROC
+func (s *Server) sendRPCResponse(conn *clientConn, result *tds.RPCResult) error {
+	ts := tds.NewTokenStream()
+
+	if err := s.writeResults(conn, ts, result.Results, true, true); err != nil {
+		return err
+	}
+
+	ts.ReturnStatus(result.ReturnStatus)
+	for _, rv := range result.ReturnValues {
+		if err := ts.ReturnValue(rv.Ordinal, rv.Name, &rv.Column, rv.Value); err != nil {
+			return err
+		}
+	}
+	ts.DoneProc(tds.DoneFinal, 0, 0)
+
+	return s.writePacket(conn, tds.NewPacket(tds.PacketTypeTabular, tds.StatusEOM, 1, ts.Bytes()))
+}
@@ -0,0 +1,396 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// open connects to a test server, closing the pool when the test ends
+func open(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlserver", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestStartTest(t *testing.T) {
+	srv := StartTest(t)
+	db := open(t, srv.DSN)
+
+	for _, query := range []string{"CREATE TABLE items (id INT, name NVARCHAR(50))", "INSERT INTO items VALUES (1, 'one')"} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM items WHERE id = @p1", 1).Scan(&name); err != nil || name != "one" {
+		t.Errorf("SELECT name = %q, %v, want one", name, err)
+	}
+}
+
+func TestWithLogin(t *testing.T) {
+	srv := StartTest(t, WithSAPassword("Sa-Passw0rd"), WithLogin("app", "App-Passw0rd"))
+
+	for _, dsn := range []string{srv.DSN, srv.LoginDSN("app", "App-Passw0rd")} {
+		if err := open(t, dsn).Ping(); err != nil {
+			t.Errorf("Ping() error = %v", err)
+		}
+	}
+	if err := open(t, srv.LoginDSN("app", "wrong")).Ping(); err == nil {
+		t.Error("Ping() with a wrong password error = nil, want login failure")
+	}
+}
+
+func TestSessionConnectionAfterLogin(t *testing.T) {
+	srv := StartTest(t)
+
+	// Connections that have not logged in hold no SQLite connection
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", srv.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		srv.connsMu.Lock()
+		n := len(srv.conns)
+		srv.connsMu.Unlock()
+		if n == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server has %d connections, want 3", n)
+		}
+	}
+	if inUse := srv.db.GetDB().Stats().InUse; inUse != 0 {
+		t.Errorf("SQLite connections in use before login = %d, want 0", inUse)
+	}
+
+	conn, err := open(t, srv.DSN).Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if inUse := srv.db.GetDB().Stats().InUse; inUse != 1 {
+		t.Errorf("SQLite connections in use after login = %d, want 1", inUse)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	dir := t.TempDir()
+	srv := StartTest(t, WithDataDir(dir))
+	db := open(t, srv.DSN)
+	ctx := context.Background()
+
+	if _, err := db.Exec("CREATE TABLE items (id INT)"); err != nil {
+		t.Fatal(err)
+	}
+	idle, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO items VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	// Idle sessions are told why they end
+	err = idle.PingContext(ctx)
+	if err == nil || !strings.Contains(err.Error(), "SHUTDOWN is in progress") {
+		t.Errorf("Ping() after Shutdown() error = %v, want SHUTDOWN is in progress", err)
+	}
+
+	// The open transaction was rolled back
+	restarted := StartTest(t, WithDataDir(dir))
+	var count int
+	if err := open(t, restarted.DSN).QueryRow("SELECT COUNT(*) FROM items").Scan(&count); err != nil || count != 0 {
+		t.Errorf("count after restart = %d, %v, want 0", count, err)
+	}
+}
+
+// execAll runs queries in order on one connection
+func execAll(t *testing.T, conn *sql.Conn, queries ...string) {
+	t.Helper()
+	for _, query := range queries {
+		if _, err := conn.ExecContext(context.Background(), query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+}
+
+// count returns the result of a SELECT COUNT(*) query
+func count(t *testing.T, conn *sql.Conn, query string) int {
+	t.Helper()
+	var n int
+	if err := conn.QueryRowContext(context.Background(), query).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+func TestDatabases(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"files", nil},
+		{"in memory", []Option{WithInMemory()}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := open(t, StartTest(t, tt.opts...).DSN).Conn(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			execAll(t, conn,
+				"CREATE DATABASE sales",
+				"USE sales",
+				"CREATE TABLE orders (id INT)",
+				"INSERT INTO orders VALUES (1), (2)",
+				"USE master",
+				"CREATE TABLE items (id INT)",
+				"INSERT INTO items VALUES (2)",
+			)
+
+			// Tables are in the database they were created in; attached
+			// databases, below, are searched for tables too
+			if _, err := conn.ExecContext(context.Background(), "SELECT * FROM orders"); err == nil {
+				t.Error("orders found in master")
+			}
+
+			for query, want := range map[string]int{
+				"SELECT COUNT(*) FROM sales.dbo.orders":                               2,
+				"SELECT COUNT(*) FROM sales..orders":                                  2,
+				"SELECT COUNT(*) FROM items i JOIN sales.dbo.orders o ON o.id = i.id": 1,
+			} {
+				if got := count(t, conn, query); got != want {
+					t.Errorf("%s = %d, want %d", query, got, want)
+				}
+			}
+
+			execAll(t, conn, "USE sales")
+			if got := count(t, conn, "SELECT COUNT(*) FROM master.dbo.items"); got != 1 {
+				t.Errorf("items in master = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestDropDatabaseInUse(t *testing.T) {
+	db := open(t, StartTest(t).DSN)
+	ctx := context.Background()
+	user, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer user.Close()
+	admin, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	execAll(t, admin, "CREATE DATABASE sales")
+	execAll(t, user, "USE sales", "CREATE TABLE orders (id INT)")
+
+	// Another session is using the database
+	_, err = admin.ExecContext(ctx, "DROP DATABASE sales")
+	var sqlErr mssql.Error
+	if !errors.As(err, &sqlErr) || sqlErr.Number != 3702 {
+		t.Fatalf("DROP DATABASE while in use: err = %v, want error 3702", err)
+	}
+	if got := count(t, user, "SELECT COUNT(*) FROM orders"); got != 0 {
+		t.Errorf("orders = %d, want 0", got)
+	}
+
+	execAll(t, user, "USE master")
+	execAll(t, admin, "DROP DATABASE sales")
+	if _, err := user.ExecContext(ctx, "USE sales"); err == nil {
+		t.Error("USE of a dropped database succeeded")
+	}
+}
+
+func TestProceduresRunOnSession(t *testing.T) {
+	conn, err := open(t, StartTest(t).DSN).Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	execAll(t, conn,
+		"CREATE TABLE counts (n INT)",
+		"CREATE PROCEDURE addone AS INSERT INTO counts VALUES (1)",
+	)
+
+	// Inside a transaction, procedures are created and run on its connection
+	execAll(t, conn,
+		"BEGIN TRAN",
+		"INSERT INTO counts VALUES (1)",
+		"EXEC addone",
+		"CREATE PROCEDURE countall AS SELECT COUNT(*) FROM counts",
+	)
+	if got := count(t, conn, "EXEC countall"); got != 2 {
+		t.Errorf("EXEC countall in transaction = %d, want 2", got)
+	}
+	execAll(t, conn, "ROLLBACK TRAN")
+	if got := count(t, conn, "SELECT COUNT(*) FROM counts"); got != 0 {
+		t.Errorf("counts after ROLLBACK TRAN = %d, want 0", got)
+	}
+	if _, err := conn.ExecContext(context.Background(), "EXEC countall"); err == nil {
+		t.Error("EXEC countall after ROLLBACK TRAN error = nil, want procedure not found")
+	}
+
+	// Procedures run in the session's current database
+	execAll(t, conn,
+		"CREATE DATABASE sales",
+		"USE sales",
+		"CREATE TABLE only_sales (id INT)",
+		"INSERT INTO only_sales VALUES (1), (2), (3)",
+		"CREATE PROCEDURE rs AS SELECT COUNT(*) FROM only_sales",
+	)
+	if got := count(t, conn, "EXEC rs"); got != 3 {
+		t.Errorf("EXEC rs after USE sales = %d, want 3", got)
+	}
+}
+
+func TestBatchVariables(t *testing.T) {
+	conn, err := open(t, StartTest(t).DSN).Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	execAll(t, conn, "CREATE PROCEDURE double @x INT, @y INT OUTPUT AS SET @y = @x * 2; RETURN 7")
+
+	// The return status and OUTPUT parameter are assigned to the batch's variables
+	var rc, y int
+	err = conn.QueryRowContext(context.Background(), `DECLARE @rc INT
+DECLARE @y INT
+EXEC @rc = double @x = 21, @y = @y OUTPUT
+SELECT @rc, @y`).Scan(&rc, &y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc != 7 || y != 42 {
+		t.Errorf("SELECT @rc, @y = %d, %d, want 7, 42", rc, y)
+	}
+
+	// Variables are passed to procedures and read by later statements
+	err = conn.QueryRowContext(context.Background(),
+		"DECLARE @x INT; DECLARE @y INT; SET @x = 5; EXEC double @x, @y OUTPUT; SELECT @y").Scan(&y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if y != 10 {
+		t.Errorf("SELECT @y = %d, want 10", y)
+	}
+
+	_, err = conn.ExecContext(context.Background(), "DECLARE @rc INT; EXEC @rc = double 1, @z OUTPUT")
+	var sqlErr mssql.Error
+	if !errors.As(err, &sqlErr) || sqlErr.Number != 137 {
+		t.Errorf("EXEC with an undeclared variable: err = %v, want error 137", err)
+	}
+}
+
+func TestInMemory(t *testing.T) {
+	dir := t.TempDir()
+	srv := StartTest(t, WithInMemory(), WithDataDir(dir))
+	conn, err := open(t, srv.DSN).Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	execAll(t, conn,
+		"CREATE DATABASE sales",
+		"CREATE TABLE sales.dbo.orders (id INT)",
+		"INSERT INTO sales.dbo.orders VALUES (1)",
+	)
+	snap, err := srv.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	execAll(t, conn,
+		"INSERT INTO sales.dbo.orders VALUES (2)",
+		"CREATE DATABASE inventory",
+	)
+	if err := srv.Restore(snap); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if got := count(t, conn, "SELECT COUNT(*) FROM sales.dbo.orders"); got != 1 {
+		t.Errorf("orders after Restore() = %d, want 1", got)
+	}
+	if _, err := conn.ExecContext(context.Background(), "USE inventory"); err == nil {
+		t.Error("USE inventory after Restore() error = nil, want database does not exist")
+	}
+
+	if err := srv.Reset(); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if _, err := conn.ExecContext(context.Background(), "USE sales"); err == nil {
+		t.Error("USE sales after Reset() error = nil, want database does not exist")
+	}
+
+	// Each server has databases of its own
+	other, err := open(t, StartTest(t, WithInMemory()).DSN).Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	execAll(t, other, "CREATE DATABASE sales")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("data directory has %d entries, %v, want none", len(entries), err)
+	}
+}
+
+func TestBatchWithoutSemicolons(t *testing.T) {
+	srv := StartTest(t)
+	db := open(t, srv.DSN)
+
+	rows, err := db.Query("SELECT 1 AS n\nSELECT 2 AS n\r\nSELECT 3 AS n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var got []int
+	for ok := true; ok; ok = rows.NextResultSet() {
+		for rows.Next() {
+			var n int
+			if err := rows.Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, n)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("result sets = %v, want [1 2 3]", got)
+	}
+}
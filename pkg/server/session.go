@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/factory/mssql-tds-server/pkg/auth"
+	"github.com/factory/mssql-tds-server/pkg/sqlexecutor"
+	"github.com/factory/mssql-tds-server/pkg/sqlparser"
+	"github.com/factory/mssql-tds-server/pkg/tds"
+)
+
+// SPIDs below firstSPID are reserved for system processes, as in SQL Server
+const firstSPID = 51
+
+// Session is the state of a client connection, shared by its MARS sessions
+// Its executor runs statements on a SQLite connection of its own, so the
+// transaction, current database and prepared statements are the session's
+// alone; the catalog and the connection pool are the server's. The connection
+// is taken from the pool once the client has logged in
+type Session struct {
+	mu sync.Mutex // Held while a request runs; MARS sessions take turns
+
+	spid           uint16                // Server process ID, sent in packet headers after login
+	executor       *sqlexecutor.Executor // The session's own executor; nil until login
+	queryProcessor *tds.QueryProcessor   // Runs batches and RPCs on executor
+	tempSession    string                // Namespace of the session's #temp tables
+
+	login          *auth.Login // Set once LOGIN7 has been authenticated
+	database       string
+	loginDatabase  string // Database chosen at login; a reset returns to it
+	language       string
+	packetSize     int                            // Negotiated by LOGIN7; used by MARS sessions opened later
+	transactionID  uint64                         // Descriptor of the open transaction; 0 when none
+	isolationLevel byte                           // Set by TM_BEGIN_XACT; SQLite transactions are serializable at every level
+	preparedStmts  *tds.PreparedStatements        // Handles from sp_prepare and sp_prepexec
+	noCount        bool                           // SET NOCOUNT ON: DONE tokens carry no row counts
+	fmtOnly        bool                           // SET FMTONLY ON: queries return their columns but no rows
+	bulkLoad       *sqlparser.InsertBulkStatement // INSERT BULK awaiting its BULK_LOAD message
+}
+
+// openSession creates a session for a new connection and registers it under
+// the lowest free SPID
+func (s *Server) openSession() (*Session, error) {
+	session := &Session{
+		preparedStmts: tds.NewPreparedStatements(),
+	}
+
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	spid := uint16(firstSPID)
+	for s.sessions[spid] != nil {
+		spid++
+		if spid == 0 {
+			return nil, fmt.Errorf("no free SPID for a new session")
+		}
+	}
+	session.spid = spid
+	session.tempSession = sqlexecutor.TempSession(uint64(spid))
+	s.sessions[spid] = session
+
+	return session, nil
+}
+
+// openExecutor gives a session that has logged in an executor of its own,
+// holding a connection from the pool
+func (s *Server) openExecutor(ctx context.Context, session *Session) error {
+	executor, err := s.sqlExecutor.NewSession(ctx)
+	if err != nil {
+		return err
+	}
+
+	session.executor = executor
+	session.queryProcessor = tds.NewQueryProcessor()
+	session.queryProcessor.SetExecutor(executor)
+	return nil
+}
+
+// awaitingLogin reports whether the session has yet to log in. A session
+// logging in at the moment holds its lock, and is not awaiting login
+func (session *Session) awaitingLogin() bool {
+	if !session.mu.TryLock() {
+		return false
+	}
+	defer session.mu.Unlock()
+	return session.login == nil
+}
+
+// closeSession releases what a session holds: its prepared statements, #temp
+// tables and SQLite connection, which rolls back an open transaction. The SPID
+// is free for reuse afterwards
+func (s *Server) closeSession(session *Session) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.transactionID != 0 {
+		s.logf("Rolling back the open transaction of session %d", session.spid)
+	}
+	session.preparedStmts.Close()
+	if session.executor != nil {
+		s.dropTempTables(session)
+		if err := session.executor.Close(); err != nil {
+			s.logf("Error closing session %d: %v", session.spid, err)
+		}
+	}
+
+	s.sessionsMu.Lock()
+	delete(s.sessions, session.spid)
+	s.sessionsMu.Unlock()
+}
+
+// dropTempTables drops the #temp tables of a session
+func (s *Server) dropTempTables(session *Session) {
+	if err := session.executor.DropTempTables(context.Background(), session.tempSession); err != nil {
+		s.logf("Error dropping temporary tables: %v", err)
+	}
+}
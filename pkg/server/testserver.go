@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/factory/mssql-tds-server/pkg/config"
+)
+
+// How long a test server's running requests have to finish when its test ends
+const testShutdownTimeout = 10 * time.Second
+
+// TestServer is a server started by StartTest
+type TestServer struct {
+	*Server
+	DSN string // go-mssqldb connection string of the sa login
+
+	addr string
+	tls  config.TLS
+}
+
+// StartTest starts a server for a test, listening on a free port of
+// 127.0.0.1, with its databases in a temporary directory and its log in the
+// test log. opts change these settings; after WithConfig, the listen address
+// and data directory are those of the config. The server is shut down when
+// the test ends
+func StartTest(t testing.TB, opts ...Option) *TestServer {
+	t.Helper()
+
+	logs := &testLog{t: t}
+	opts = append([]Option{
+		WithListen("127.0.0.1:0"),
+		WithDataDir(t.TempDir()),
+		WithLogger(slog.New(slog.NewTextHandler(logs, nil))),
+	}, opts...)
+
+	srv, err := New(opts...)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if err := srv.Listen(); err != nil {
+		srv.Close()
+		t.Fatalf("failed to start server: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve()
+	}()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			t.Errorf("failed to shut down server: %v", err)
+		}
+		if err := <-served; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("server stopped serving: %v", err)
+		}
+		logs.stop()
+	})
+
+	cfg := srv.Config()
+	ts := &TestServer{Server: srv, addr: srv.Addrs()[0].String(), tls: cfg.TLS}
+	ts.DSN = ts.LoginDSN("sa", cfg.SAPassword)
+	return ts
+}
+
+// LoginDSN returns the go-mssqldb connection string of a login
+func (ts *TestServer) LoginDSN(name, password string) string {
+	query := url.Values{}
+	switch {
+	case !ts.tls.Enabled:
+		query.Set("encrypt", "disable")
+	case ts.tls.Strict == "required":
+		query.Set("encrypt", "strict")
+		query.Set("certificate", ts.tls.CertFile)
+	default:
+		query.Set("encrypt", "true")
+		query.Set("TrustServerCertificate", "true")
+	}
+
+	dsn := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(name, password),
+		Host:     ts.addr,
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
+
+// testLog writes log records to a test's log until the test has ended
+type testLog struct {
+	mu      sync.Mutex
+	t       testing.TB
+	stopped bool
+}
+
+func (l *testLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.stopped {
+		l.t.Log(strings.TrimSuffix(string(p), "\n"))
+	}
+	return len(p), nil
+}
+
+func (l *testLog) stop() {
+	l.mu.Lock()
+	l.stopped = true
+	l.mu.Unlock()
+}
@@ -0,0 +1,200 @@
+package sqlerror
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQL Server error numbers
+const (
+	SyntaxError               int32 = 102
+	UndeclaredVariable        int32 = 137
+	MissingParameter          int32 = 201
+	InvalidColumnName         int32 = 207
+	InvalidObjectName         int32 = 208
+	StatementNotText          int32 = 214
+	NotAllowedInTransaction   int32 = 226
+	CannotInsertNull          int32 = 515
+	ConstraintConflict        int32 = 547
+	NoTransactionToSave       int32 = 628
+	DatabaseNotFound          int32 = 911
+	DatabaseChanged           int32 = 5701
+	LanguageChanged           int32 = 5703
+	ShutdownInProgress        int32 = 6005
+	SavepointNotFound         int32 = 6401
+	DuplicateKeyRow           int32 = 2601
+	DuplicateKey              int32 = 2627
+	ProcedureNotFound         int32 = 2812
+	CannotDropObject          int32 = 3701
+	DatabaseInUse             int32 = 3702
+	CommitWithoutBegin        int32 = 3902
+	RollbackWithoutBegin      int32 = 3903
+	TransactionContextInUse   int32 = 3910
+	CannotOpenDatabase        int32 = 4060
+	TooManyArguments          int32 = 8144
+	NotAParameter             int32 = 8145
+	NotAnOutputParameter      int32 = 8162
+	ParameterNotSupplied      int32 = 8178
+	PreparedStatementNotFound int32 = 8179
+	LoginFailed               int32 = 18456
+	UserDefined               int32 = 50000
+)
+
+// Severity classes
+const (
+	ClassInfo       byte = 0
+	ClassNotFound   byte = 11
+	ClassLoginError byte = 14
+	ClassSyntax     byte = 15
+	ClassUserError  byte = 16
+)
+
+// Error is an error reported to clients as a SQL Server ERROR token
+type Error struct {
+	Number     int32
+	State      byte
+	Class      byte
+	Message    string
+	ProcName   string
+	LineNumber int32
+}
+
+// New creates an error with state 1 reported on line 1
+func New(number int32, class byte, format string, args ...interface{}) *Error {
+	return &Error{
+		Number:     number,
+		State:      1,
+		Class:      class,
+		Message:    fmt.Sprintf(format, args...),
+		LineNumber: 1,
+	}
+}
+
+// Error returns the message text
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// go-sqlite3 error messages
+var (
+	syntaxErrorRegex  = regexp.MustCompile(`near "((?:[^"]|"")*)": syntax error`)
+	noSuchTableRegex  = regexp.MustCompile(`no such table: (\S+)`)
+	noSuchColumnRegex = regexp.MustCompile(`no such column: (\S+)`)
+	uniqueRegex       = regexp.MustCompile(`UNIQUE constraint failed: (.+)`)
+	notNullRegex      = regexp.MustCompile(`NOT NULL constraint failed: (\S+)`)
+	checkRegex        = regexp.MustCompile(`CHECK constraint failed: (.+)`)
+	foreignKeyRegex   = regexp.MustCompile(`FOREIGN KEY constraint failed`)
+	noSavepointRegex  = regexp.MustCompile(`no such savepoint: (\S+)`)
+)
+
+// FromError converts an execution error to a SQL Server error
+// go-sqlite3 errors are mapped to the numbers SQL Server reports for the same
+// failure; query is the statement that failed and names the statement in the message.
+// Errors that don't map are reported as user-defined errors (50000) with their own text
+func FromError(err error, query string) *Error {
+	var sqlErr *Error
+	if errors.As(err, &sqlErr) {
+		return sqlErr
+	}
+
+	// Match the driver's own message, not the wrapping context
+	text := err.Error()
+	var extended sqlite3.ErrNoExtended
+	var liteErr sqlite3.Error
+	if errors.As(err, &liteErr) {
+		text = liteErr.Error()
+		extended = liteErr.ExtendedCode
+	}
+
+	verb := statementVerb(query)
+
+	if m := syntaxErrorRegex.FindStringSubmatch(text); m != nil {
+		return New(SyntaxError, ClassSyntax, "Incorrect syntax near '%s'.", strings.ReplaceAll(m[1], `""`, `"`))
+	}
+
+	if m := noSuchTableRegex.FindStringSubmatch(text); m != nil {
+		return New(InvalidObjectName, ClassUserError, "Invalid object name '%s'.", strings.TrimPrefix(m[1], "main."))
+	}
+
+	if m := noSuchColumnRegex.FindStringSubmatch(text); m != nil {
+		return New(InvalidColumnName, ClassUserError, "Invalid column name '%s'.", unqualify(m[1]))
+	}
+
+	if m := uniqueRegex.FindStringSubmatch(text); m != nil {
+		table, columns := constraintColumns(m[1])
+		if extended == sqlite3.ErrConstraintPrimaryKey || extended == sqlite3.ErrConstraintRowID {
+			return New(DuplicateKey, ClassUserError,
+				"Violation of PRIMARY KEY constraint 'PK_%s'. Cannot insert duplicate key in object 'dbo.%s'.", table, table)
+		}
+		return New(DuplicateKeyRow, ClassUserError,
+			"Cannot insert duplicate key row in object 'dbo.%s' with unique index 'UQ_%s_%s'.", table, table, strings.Join(columns, "_"))
+	}
+
+	if m := notNullRegex.FindStringSubmatch(text); m != nil {
+		table, columns := constraintColumns(m[1])
+		e := New(CannotInsertNull, ClassUserError,
+			"Cannot insert the value NULL into column '%s', table '%s'; column does not allow nulls. %s fails.", columns[0], table, verb)
+		e.State = 2
+		return e
+	}
+
+	if m := checkRegex.FindStringSubmatch(text); m != nil {
+		return New(ConstraintConflict, ClassUserError,
+			"The %s statement conflicted with the CHECK constraint \"%s\".", verb, m[1])
+	}
+
+	if m := noSavepointRegex.FindStringSubmatch(text); m != nil {
+		return New(SavepointNotFound, ClassUserError,
+			"Cannot roll back %s. No transaction or savepoint of that name was found.", m[1])
+	}
+
+	if foreignKeyRegex.MatchString(text) {
+		// Deletes and updates of a referenced row conflict with the REFERENCE side
+		constraint := "FOREIGN KEY"
+		if verb == "DELETE" {
+			constraint = "REFERENCE"
+		}
+		return New(ConstraintConflict, ClassUserError,
+			"The %s statement conflicted with the %s constraint.", verb, constraint)
+	}
+
+	return New(UserDefined, ClassUserError, "%s", text)
+}
+
+// statementVerb returns the keyword naming a statement in error messages
+func statementVerb(query string) string {
+	fields := strings.Fields(strings.ToUpper(query))
+	if len(fields) > 0 {
+		switch fields[0] {
+		case "INSERT", "UPDATE", "DELETE", "MERGE":
+			return fields[0]
+		}
+	}
+	return "INSERT"
+}
+
+// constraintColumns splits a "table.column, table.column" constraint list
+func constraintColumns(list string) (string, []string) {
+	var table string
+	var columns []string
+	for _, qualified := range strings.Split(list, ",") {
+		qualified = strings.TrimSpace(qualified)
+		if i := strings.LastIndex(qualified, "."); i >= 0 {
+			table = qualified[:i]
+		}
+		columns = append(columns, unqualify(qualified))
+	}
+	return table, columns
+}
+
+// unqualify strips the table prefix from a column name
+func unqualify(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,91 @@
+package sqlerror
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestFromError(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	setup := []string{
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE parents (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE children (id INT PRIMARY KEY, parent_id INT REFERENCES parents(id), " +
+			"name TEXT NOT NULL, code TEXT UNIQUE, qty INT CONSTRAINT CK_qty CHECK (qty > 0))",
+		"INSERT INTO parents VALUES (1)",
+		"INSERT INTO children VALUES (1, 1, 'a', 'x', 1)",
+	}
+	for _, query := range setup {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("Exec(%q) error = %v", query, err)
+		}
+	}
+
+	tests := []struct {
+		query   string
+		number  int32
+		class   byte
+		message string
+	}{
+		{"SELECT * FROM missing", InvalidObjectName, ClassUserError, "Invalid object name 'missing'."},
+		{"SELECT nope FROM children", InvalidColumnName, ClassUserError, "Invalid column name 'nope'."},
+		{"SELECT * FORM children", SyntaxError, ClassSyntax, "Incorrect syntax near 'FORM'."},
+		{"INSERT INTO parents VALUES (1)", DuplicateKey, ClassUserError,
+			"Violation of PRIMARY KEY constraint 'PK_parents'. Cannot insert duplicate key in object 'dbo.parents'."},
+		{"INSERT INTO children VALUES (1, 1, 'b', 'y', 1)", DuplicateKey, ClassUserError,
+			"Violation of PRIMARY KEY constraint 'PK_children'. Cannot insert duplicate key in object 'dbo.children'."},
+		{"INSERT INTO children VALUES (2, 1, 'b', 'x', 1)", DuplicateKeyRow, ClassUserError,
+			"Cannot insert duplicate key row in object 'dbo.children' with unique index 'UQ_children_code'."},
+		{"INSERT INTO children VALUES (2, 1, NULL, 'y', 1)", CannotInsertNull, ClassUserError,
+			"Cannot insert the value NULL into column 'name', table 'children'; column does not allow nulls. INSERT fails."},
+		{"UPDATE children SET qty = 0", ConstraintConflict, ClassUserError,
+			"The UPDATE statement conflicted with the CHECK constraint \"CK_qty\"."},
+		{"INSERT INTO children VALUES (2, 9, 'b', 'y', 1)", ConstraintConflict, ClassUserError,
+			"The INSERT statement conflicted with the FOREIGN KEY constraint."},
+		{"DELETE FROM parents", ConstraintConflict, ClassUserError,
+			"The DELETE statement conflicted with the REFERENCE constraint."},
+		{"ROLLBACK TO SAVEPOINT sp1", SavepointNotFound, ClassUserError,
+			"Cannot roll back sp1. No transaction or savepoint of that name was found."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, execErr := db.Exec(tt.query)
+			if execErr == nil {
+				t.Fatal("expected an error")
+			}
+
+			got := FromError(fmt.Errorf("failed to execute query: %w", execErr), tt.query)
+			if got.Number != tt.number || got.Class != tt.class || got.Message != tt.message {
+				t.Errorf("FromError() = %d (class %d) %q, want %d (class %d) %q",
+					got.Number, got.Class, got.Message, tt.number, tt.class, tt.message)
+			}
+		})
+	}
+}
+
+func TestFromErrorPassesThroughSQLErrors(t *testing.T) {
+	original := New(DatabaseNotFound, ClassUserError, "Database '%s' does not exist.", "nosuch")
+
+	got := FromError(fmt.Errorf("use failed: %w", original), "USE nosuch")
+	if got != original {
+		t.Errorf("FromError() = %+v, want %+v", got, original)
+	}
+}
+
+func TestFromErrorUnmapped(t *testing.T) {
+	got := FromError(errors.New("something went wrong"), "SELECT 1")
+	if got.Number != UserDefined || got.Class != ClassUserError || got.Message != "something went wrong" {
+		t.Errorf("FromError() = %+v", got)
+	}
+}
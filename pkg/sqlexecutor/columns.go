@@ -0,0 +1,127 @@
+package sqlexecutor
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Column describes a result set column
+type Column struct {
+	Name      string
+	TypeName  string // Declared base type, upper case (e.g. "VARCHAR"); empty for expressions
+	Nullable  bool
+	Length    int64 // Declared length (e.g. 50 for VARCHAR(50)); -1 for MAX; 0 if not declared
+	Precision int64 // Declared precision for DECIMAL/NUMERIC; 0 if not declared
+	Scale     int64 // Declared scale for DECIMAL/NUMERIC
+}
+
+// declTypeRegex splits a declared type into base name and optional arguments
+var declTypeRegex = regexp.MustCompile(`^\s*([A-Za-z][A-Za-z0-9_ ]*?)\s*(?:\(\s*([^)]*)\s*\))?\s*$`)
+
+// readColumns returns column descriptions for a result set
+func readColumns(rows *sql.Rows) ([]Column, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]Column, len(columnTypes))
+	for i, ct := range columnTypes {
+		col := ParseDeclaredType(ct.DatabaseTypeName())
+		col.Name = ct.Name()
+
+		col.Nullable = true
+		if nullable, ok := ct.Nullable(); ok {
+			col.Nullable = nullable
+		}
+
+		if length, ok := ct.Length(); ok && col.Length == 0 {
+			col.Length = length
+		}
+
+		if precision, scale, ok := ct.DecimalSize(); ok && col.Precision == 0 {
+			col.Precision = precision
+			col.Scale = scale
+		}
+
+		columns[i] = col
+	}
+
+	return columns, nil
+}
+
+// ParseDeclaredType parses a declared column type such as "DECIMAL(10,2)" or "NVARCHAR(MAX)"
+func ParseDeclaredType(declType string) Column {
+	matches := declTypeRegex.FindStringSubmatch(declType)
+	if matches == nil {
+		return Column{TypeName: strings.ToUpper(strings.TrimSpace(declType))}
+	}
+
+	col := Column{TypeName: strings.ToUpper(matches[1])}
+
+	args := strings.Split(matches[2], ",")
+	first := strings.TrimSpace(args[0])
+	if first == "" {
+		return col
+	}
+
+	switch col.TypeName {
+	case "DECIMAL", "NUMERIC":
+		col.Precision, _ = strconv.ParseInt(first, 10, 64)
+		if len(args) > 1 {
+			col.Scale, _ = strconv.ParseInt(strings.TrimSpace(args[1]), 10, 64)
+		}
+	default:
+		if strings.EqualFold(first, "MAX") {
+			col.Length = -1
+		} else {
+			col.Length, _ = strconv.ParseInt(first, 10, 64)
+		}
+	}
+
+	return col
+}
+
+// ReadRows reads the columns and all rows of a query into a result
+// Values are kept as returned by the driver; SQL NULL stays nil
+func ReadRows(rows *sql.Rows) (*ExecuteResult, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+
+	columnTypes, err := readColumns(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get column types: %w", err)
+	}
+
+	var resultRows [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		resultRows = append(resultRows, values)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return &ExecuteResult{
+		Columns:     columns,
+		ColumnTypes: columnTypes,
+		Rows:        resultRows,
+		RowCount:    int64(len(resultRows)),
+		IsQuery:     true,
+	}, nil
+}
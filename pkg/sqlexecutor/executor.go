@@ -1,56 +1,193 @@
 package sqlexecutor
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/factory/mssql-tds-server/pkg/database"
 	"github.com/factory/mssql-tds-server/pkg/sqlparser"
 )
 
 // Executor handles SQL statement execution
+// The executor from NewExecutor runs statements on the connection pool; each
+// client session runs them on an executor of its own from NewSession
 type Executor struct {
-	db              *sql.DB
+	db              Querier                  // The pool, or the session's connection
+	pool            *sql.DB
+	conn            *sql.Conn                // Held by a session executor; nil otherwise
 	catalog         *database.Catalog         // Database catalog
-	connections     map[string]*sql.DB         // All database connections
+	shared          *sharedState              // Shared by an executor and its sessions
 	currentDB       *sql.DB                  // Currently active database
 	currentDBName   string                   // Currently active database name
-	attachedDBs     map[string]bool           // Currently attached databases
-	views           map[string]string             // Store view name -> SELECT query mapping
+	dbConns         map[string]*sql.Conn      // A session's connections to user databases, by lowercased name
 	preparedStmts   map[string]*sql.Stmt         // Store prepared statements
 	preparedSQL     map[string]string             // Store prepared SQL for parameter substitution
 }
 
+// Querier runs statements on a *sql.DB or a *sql.Conn
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// sharedState is what the sessions of a server have in common
+type sharedState struct {
+	mu          sync.Mutex
+	connections map[string]*sql.DB // All database connections
+	inUse       map[string]int     // Executors using each user database, by lowercased name
+	views       map[string]string  // Store view name -> SELECT query mapping
+}
+
 // NewExecutor creates a new SQL executor
 func NewExecutor(db *sql.DB, catalog *database.Catalog) *Executor {
 	return &Executor{
-		db:            db,
-		catalog:       catalog,
-		connections:   make(map[string]*sql.DB),
+		db:      db,
+		pool:    db,
+		catalog: catalog,
+		shared: &sharedState{
+			connections: make(map[string]*sql.DB),
+			inUse:       make(map[string]int),
+			views:       make(map[string]string),
+		},
 		currentDB:     db,
 		currentDBName: "",
-		attachedDBs:   make(map[string]bool),
-		views:         make(map[string]string),
 		preparedStmts: make(map[string]*sql.Stmt),
 		preparedSQL:   make(map[string]string),
 	}
 }
 
+// NewSession creates an executor for one client session. It shares the
+// catalog, database connections and views of e, but runs statements on a
+// connection of its own from the pool, so that its transaction, current
+// database and prepared statements are the session's alone. Close returns
+// the connection to the pool
+func (e *Executor) NewSession(ctx context.Context) (*Executor, error) {
+	conn, err := e.pool.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session connection: %w", err)
+	}
+
+	return &Executor{
+		db:            conn,
+		pool:          e.pool,
+		conn:          conn,
+		catalog:       e.catalog,
+		shared:        e.shared,
+		currentDB:     e.pool,
+		dbConns:       make(map[string]*sql.Conn),
+		preparedStmts: make(map[string]*sql.Stmt),
+		preparedSQL:   make(map[string]string),
+	}, nil
+}
+
+// Close releases a session executor's prepared statements and connections;
+// a transaction still open on them is rolled back
+func (e *Executor) Close() error {
+	for name, stmt := range e.preparedStmts {
+		stmt.Close()
+		delete(e.preparedStmts, name)
+		delete(e.preparedSQL, name)
+	}
+	e.shared.mu.Lock()
+	e.release()
+	e.shared.mu.Unlock()
+	if e.conn == nil {
+		return nil
+	}
+
+	// A connection back in the pool must not hold the session's transaction
+	for name, conn := range e.dbConns {
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		conn.Close()
+		delete(e.dbConns, name)
+	}
+	e.conn.ExecContext(context.Background(), "ROLLBACK")
+	return e.conn.Close()
+}
+
+// Conn returns the connection that runs the executor's statements on the
+// master database: a session's own, otherwise the pool
+func (e *Executor) Conn() Querier {
+	if e.conn != nil {
+		return e.conn
+	}
+	return e.pool
+}
+
+// CurrentConn returns the connection that runs the executor's statements on
+// its current database
+func (e *Executor) CurrentConn() Querier {
+	return e.db
+}
+
 // ExecuteResult represents the result of SQL execution
 type ExecuteResult struct {
 	Columns    []string
+	ColumnTypes []Column // Column metadata, parallel to Columns
 	Rows       [][]interface{}
 	RowCount   int64
 	IsQuery    bool
 	Message    string
+
+	// Statement is the kind of statement that produced the result; the row
+	// count of a non-query is only meaningful for INSERT, UPDATE and DELETE
+	Statement sqlparser.StatementType
+
+	// SetOption holds the options a SET statement turned on or off
+	SetOption *sqlparser.SetOptionStatement
 }
 
 // Execute executes a SQL query and returns results
 func (e *Executor) Execute(query string) (*ExecuteResult, error) {
+	return e.ExecuteContext(context.Background(), query)
+}
+
+// ExecuteBatch executes a batch of statements and returns their results in order
+func (e *Executor) ExecuteBatch(batch string) ([]*ExecuteResult, error) {
+	return e.ExecuteBatchContext(context.Background(), batch)
+}
+
+// ExecuteBatchContext executes a batch of semicolon-separated statements and
+// returns one result per statement, in order. Execution stops at the first
+// error, which is returned along with the results of the statements before it.
+// args are bound in every statement; statements ignore names they don't use
+func (e *Executor) ExecuteBatchContext(ctx context.Context, batch string, args ...interface{}) ([]*ExecuteResult, error) {
+	statements := sqlparser.SplitBatch(sqlparser.StripComments(batch))
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	results := make([]*ExecuteResult, 0, len(statements))
+	for _, stmt := range statements {
+		result, err := e.ExecuteContext(ctx, stmt, args...)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// ExecuteContext executes a SQL query and returns results
+// Cancelling ctx interrupts the running statement. args bind the query's
+// parameters (sql.Named for @name placeholders) in SELECT, DML and raw statements
+func (e *Executor) ExecuteContext(ctx context.Context, query string, args ...interface{}) (*ExecuteResult, error) {
 	// Strip comments from query
 	query = sqlparser.StripComments(query)
 
+	// Drop the N prefix from Unicode string literals
+	query = sqlparser.StripUnicodePrefix(query)
+
+	// Read other databases' objects through attached databases
+	query = e.TranslateDatabaseReferences(ctx, query)
+
 	// Parse the query to determine statement type
 	stmt, err := sqlparser.NewParser().Parse(query)
 	if err != nil {
@@ -59,64 +196,73 @@ func (e *Executor) Execute(query string) (*ExecuteResult, error) {
 
 	switch stmt.Type {
 	case sqlparser.StatementTypeSelect:
-		return e.executeSelect(query)
+		return e.executeSelect(ctx, query, args...)
 
 	case sqlparser.StatementTypeInsert:
-		return e.executeInsert(query)
+		return e.executeInsert(ctx, query, args...)
 
 	case sqlparser.StatementTypeUpdate:
-		return e.executeUpdate(query)
+		return e.executeUpdate(ctx, query, args...)
 
 	case sqlparser.StatementTypeDelete:
-		return e.executeDelete(query)
+		return e.executeDelete(ctx, query, args...)
 
 	case sqlparser.StatementTypeCreateTable:
-		return e.executeCreateTable(query)
+		return e.executeCreateTable(ctx, query)
 
 	case sqlparser.StatementTypeDropTable:
-		return e.executeDropTable(query)
+		return e.executeDropTable(ctx, query)
 
 	case sqlparser.StatementTypeAlterTable:
-		return e.executeAlterTable(query)
+		return e.executeAlterTable(ctx, query)
 
 	case sqlparser.StatementTypeCreateView:
-		return e.executeCreateView(query)
+		return e.executeCreateView(ctx, query)
 
 	case sqlparser.StatementTypeDropView:
-		return e.executeDropView(query)
+		return e.executeDropView(ctx, query)
 
 	case sqlparser.StatementTypeCreateIndex:
-		return e.executeCreateIndex(query)
+		return e.executeCreateIndex(ctx, query)
 
 	case sqlparser.StatementTypeDropIndex:
-		return e.executeDropIndex(query)
+		return e.executeDropIndex(ctx, query)
 
 	case sqlparser.StatementTypePrepare:
-		return e.executePrepare(query)
+		return e.executePrepare(ctx, query)
 
 	case sqlparser.StatementTypeExecute:
-		return e.executeStatement(query)
+		return e.executeStatement(ctx, query)
 
 	case sqlparser.StatementTypeDeallocatePrepare:
-		return e.executeDeallocatePrepare(query)
+		return e.executeDeallocatePrepare(ctx, query)
 
 	case sqlparser.StatementTypeBeginTransaction:
-		return e.executeBeginTransaction(query)
+		return e.executeBeginTransaction(ctx, query)
 
 	case sqlparser.StatementTypeCommit:
-		return e.executeCommit(query)
+		return e.executeCommit(ctx, query)
 
 	case sqlparser.StatementTypeRollback:
-		return e.executeRollback(query)
+		return e.executeRollback(ctx, stmt)
+
+	case sqlparser.StatementTypeSaveTransaction:
+		return e.executeSaveTransaction(ctx, stmt)
+
+	case sqlparser.StatementTypeSetOption:
+		return e.executeSetOption(stmt)
+
+	case sqlparser.StatementTypeCreateDatabase, sqlparser.StatementTypeDropDatabase:
+		return e.executeDatabaseCommand(stmt)
 
 	default:
 		// Try to execute as raw SQL (for unsupported statements)
-		return e.executeRaw(query)
+		return e.executeRaw(ctx, query, args...)
 	}
 }
 
 // executeSelect executes a SELECT query
-func (e *Executor) executeSelect(query string) (*ExecuteResult, error) {
+func (e *Executor) executeSelect(ctx context.Context, query string, args ...interface{}) (*ExecuteResult, error) {
 	// Parse the query to get ORDER BY and DISTINCT information
 	stmt, err := sqlparser.NewParser().Parse(query)
 	if err != nil {
@@ -125,7 +271,7 @@ func (e *Executor) executeSelect(query string) (*ExecuteResult, error) {
 
 	// If not a SELECT statement, execute as raw SQL
 	if stmt.Type != sqlparser.StatementTypeSelect || stmt.Select == nil {
-		return e.executeRaw(query)
+		return e.executeRaw(ctx, query, args...)
 	}
 
 	// Remove ORDER BY and DISTINCT from query if present
@@ -133,7 +279,7 @@ func (e *Executor) executeSelect(query string) (*ExecuteResult, error) {
 	// For now, let SQLite handle them (simpler approach)
 	// In production, we would implement custom ORDER BY and DISTINCT logic
 
-	rows, err := e.db.Query(query)
+	rows, err := e.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SELECT: %w", err)
 	}
@@ -145,6 +291,12 @@ func (e *Executor) executeSelect(query string) (*ExecuteResult, error) {
 		return nil, fmt.Errorf("failed to get columns: %w", err)
 	}
 
+	// Get declared column types
+	columnTypes, err := readColumns(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get column types: %w", err)
+	}
+
 	// Read all rows
 	var resultRows [][]interface{}
 	for rows.Next() {
@@ -231,16 +383,17 @@ func (e *Executor) executeSelect(query string) (*ExecuteResult, error) {
 	// For now, let SQLite handle all subqueries
 
 	return &ExecuteResult{
-		Columns:  columns,
-		Rows:     resultRows,
-		RowCount: int64(len(resultRows)),
-		IsQuery:  true,
+		Columns:     columns,
+		ColumnTypes: columnTypes,
+		Rows:        resultRows,
+		RowCount:    int64(len(resultRows)),
+		IsQuery:     true,
 	}, nil
 }
 
 // executeInsert executes an INSERT statement
-func (e *Executor) executeInsert(query string) (*ExecuteResult, error) {
-	result, err := e.db.Exec(query)
+func (e *Executor) executeInsert(ctx context.Context, query string, args ...interface{}) (*ExecuteResult, error) {
+	result, err := e.db.ExecContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute INSERT: %w", err)
 	}
@@ -251,15 +404,16 @@ func (e *Executor) executeInsert(query string) (*ExecuteResult, error) {
 	}
 
 	return &ExecuteResult{
-		RowCount: rowCount,
-		IsQuery:  false,
-		Message:  fmt.Sprintf("%d row(s) inserted", rowCount),
+		RowCount:  rowCount,
+		IsQuery:   false,
+		Message:   fmt.Sprintf("%d row(s) inserted", rowCount),
+		Statement: sqlparser.StatementTypeInsert,
 	}, nil
 }
 
 // executeUpdate executes an UPDATE statement
-func (e *Executor) executeUpdate(query string) (*ExecuteResult, error) {
-	result, err := e.db.Exec(query)
+func (e *Executor) executeUpdate(ctx context.Context, query string, args ...interface{}) (*ExecuteResult, error) {
+	result, err := e.db.ExecContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute UPDATE: %w", err)
 	}
@@ -270,15 +424,16 @@ func (e *Executor) executeUpdate(query string) (*ExecuteResult, error) {
 	}
 
 	return &ExecuteResult{
-		RowCount: rowCount,
-		IsQuery:  false,
-		Message:  fmt.Sprintf("%d row(s) updated", rowCount),
+		RowCount:  rowCount,
+		IsQuery:   false,
+		Message:   fmt.Sprintf("%d row(s) updated", rowCount),
+		Statement: sqlparser.StatementTypeUpdate,
 	}, nil
 }
 
 // executeDelete executes a DELETE statement
-func (e *Executor) executeDelete(query string) (*ExecuteResult, error) {
-	result, err := e.db.Exec(query)
+func (e *Executor) executeDelete(ctx context.Context, query string, args ...interface{}) (*ExecuteResult, error) {
+	result, err := e.db.ExecContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute DELETE: %w", err)
 	}
@@ -289,18 +444,19 @@ func (e *Executor) executeDelete(query string) (*ExecuteResult, error) {
 	}
 
 	return &ExecuteResult{
-		RowCount: rowCount,
-		IsQuery:  false,
-		Message:  fmt.Sprintf("%d row(s) deleted", rowCount),
+		RowCount:  rowCount,
+		IsQuery:   false,
+		Message:   fmt.Sprintf("%d row(s) deleted", rowCount),
+		Statement: sqlparser.StatementTypeDelete,
 	}, nil
 }
 
 // executeCreateTable executes a CREATE TABLE statement
-func (e *Executor) executeCreateTable(query string) (*ExecuteResult, error) {
+func (e *Executor) executeCreateTable(ctx context.Context, query string) (*ExecuteResult, error) {
 	// Convert T-SQL CREATE TABLE to SQLite-compatible SQL
 	sqliteQuery := e.convertCreateTable(query)
 
-	_, err := e.db.Exec(sqliteQuery)
+	_, err := e.db.ExecContext(ctx, sqliteQuery)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute CREATE TABLE: %w", err)
 	}
@@ -313,8 +469,8 @@ func (e *Executor) executeCreateTable(query string) (*ExecuteResult, error) {
 }
 
 // executeDropTable executes a DROP TABLE statement
-func (e *Executor) executeDropTable(query string) (*ExecuteResult, error) {
-	_, err := e.db.Exec(query)
+func (e *Executor) executeDropTable(ctx context.Context, query string) (*ExecuteResult, error) {
+	_, err := e.db.ExecContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute DROP TABLE: %w", err)
 	}
@@ -327,7 +483,7 @@ func (e *Executor) executeDropTable(query string) (*ExecuteResult, error) {
 }
 
 // executeAlterTable executes an ALTER TABLE statement
-func (e *Executor) executeAlterTable(query string) (*ExecuteResult, error) {
+func (e *Executor) executeAlterTable(ctx context.Context, query string) (*ExecuteResult, error) {
 	// Parse query to get ALTER TABLE information
 	stmt, err := sqlparser.NewParser().Parse(query)
 	if err != nil {
@@ -341,7 +497,7 @@ func (e *Executor) executeAlterTable(query string) (*ExecuteResult, error) {
 	// Execute ALTER TABLE on SQLite (SQLite supports ALTER TABLE natively)
 	// SQLite supports: ADD COLUMN, RENAME TO, RENAME COLUMN
 	// SQLite doesn't support: DROP COLUMN, ALTER COLUMN
-	_, err = e.db.Exec(query)
+	_, err = e.db.ExecContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute ALTER TABLE: %w", err)
 	}
@@ -366,7 +522,7 @@ func (e *Executor) executeAlterTable(query string) (*ExecuteResult, error) {
 }
 
 // executeCreateView executes a CREATE VIEW statement
-func (e *Executor) executeCreateView(query string) (*ExecuteResult, error) {
+func (e *Executor) executeCreateView(ctx context.Context, query string) (*ExecuteResult, error) {
 	// Parse query to get view information
 	stmt, err := sqlparser.NewParser().Parse(query)
 	if err != nil {
@@ -378,10 +534,12 @@ func (e *Executor) executeCreateView(query string) (*ExecuteResult, error) {
 	}
 
 	// Store view definition
-	e.views[stmt.CreateView.ViewName] = stmt.CreateView.SelectQuery
+	e.shared.mu.Lock()
+	e.shared.views[stmt.CreateView.ViewName] = stmt.CreateView.SelectQuery
+	e.shared.mu.Unlock()
 
 	// Execute CREATE VIEW on SQLite (SQLite supports CREATE VIEW natively)
-	_, err = e.db.Exec(query)
+	_, err = e.db.ExecContext(ctx, query)
 	if err != nil {
 		// If SQLite fails, we still have the view definition stored
 		// This allows us to handle queries against the view
@@ -396,7 +554,7 @@ func (e *Executor) executeCreateView(query string) (*ExecuteResult, error) {
 }
 
 // executeDropView executes a DROP VIEW statement
-func (e *Executor) executeDropView(query string) (*ExecuteResult, error) {
+func (e *Executor) executeDropView(ctx context.Context, query string) (*ExecuteResult, error) {
 	// Parse query to get view name
 	stmt, err := sqlparser.NewParser().Parse(query)
 	if err != nil {
@@ -408,10 +566,12 @@ func (e *Executor) executeDropView(query string) (*ExecuteResult, error) {
 	}
 
 	// Remove view definition
-	delete(e.views, stmt.DropView.ViewName)
+	e.shared.mu.Lock()
+	delete(e.shared.views, stmt.DropView.ViewName)
+	e.shared.mu.Unlock()
 
 	// Execute DROP VIEW on SQLite (SQLite supports DROP VIEW natively)
-	_, err = e.db.Exec(query)
+	_, err = e.db.ExecContext(ctx, query)
 	if err != nil {
 		// If SQLite fails, we still removed the view definition
 		return nil, fmt.Errorf("failed to drop view in SQLite: %w (view definition removed)", err)
@@ -425,7 +585,7 @@ func (e *Executor) executeDropView(query string) (*ExecuteResult, error) {
 }
 
 // executeCreateIndex executes a CREATE INDEX statement
-func (e *Executor) executeCreateIndex(query string) (*ExecuteResult, error) {
+func (e *Executor) executeCreateIndex(ctx context.Context, query string) (*ExecuteResult, error) {
 	// Parse query to get index information
 	stmt, err := sqlparser.NewParser().Parse(query)
 	if err != nil {
@@ -437,7 +597,7 @@ func (e *Executor) executeCreateIndex(query string) (*ExecuteResult, error) {
 	}
 
 	// Execute CREATE INDEX on SQLite (SQLite supports CREATE INDEX natively)
-	_, err = e.db.Exec(query)
+	_, err = e.db.ExecContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create index in SQLite: %w", err)
 	}
@@ -455,7 +615,7 @@ func (e *Executor) executeCreateIndex(query string) (*ExecuteResult, error) {
 }
 
 // executeDropIndex executes a DROP INDEX statement
-func (e *Executor) executeDropIndex(query string) (*ExecuteResult, error) {
+func (e *Executor) executeDropIndex(ctx context.Context, query string) (*ExecuteResult, error) {
 	// Parse query to get index name
 	stmt, err := sqlparser.NewParser().Parse(query)
 	if err != nil {
@@ -467,7 +627,7 @@ func (e *Executor) executeDropIndex(query string) (*ExecuteResult, error) {
 	}
 
 	// Execute DROP INDEX on SQLite (SQLite supports DROP INDEX natively)
-	_, err = e.db.Exec(query)
+	_, err = e.db.ExecContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to drop index in SQLite: %w", err)
 	}
@@ -480,9 +640,9 @@ func (e *Executor) executeDropIndex(query string) (*ExecuteResult, error) {
 }
 
 // executeBeginTransaction executes a BEGIN TRANSACTION statement
-func (e *Executor) executeBeginTransaction(query string) (*ExecuteResult, error) {
-	// Begin a transaction
-	_, err := e.db.Exec(query)
+func (e *Executor) executeBeginTransaction(ctx context.Context, query string) (*ExecuteResult, error) {
+	// Begin a transaction; SQLite doesn't accept the T-SQL TRAN[SACTION] forms
+	_, err := e.db.ExecContext(ctx, "BEGIN")
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
@@ -495,9 +655,9 @@ func (e *Executor) executeBeginTransaction(query string) (*ExecuteResult, error)
 }
 
 // executeCommit executes a COMMIT statement
-func (e *Executor) executeCommit(query string) (*ExecuteResult, error) {
-	// Commit the transaction
-	_, err := e.db.Exec(query)
+func (e *Executor) executeCommit(ctx context.Context, query string) (*ExecuteResult, error) {
+	// Commit the transaction; SQLite doesn't accept the T-SQL TRAN[SACTION] forms
+	_, err := e.db.ExecContext(ctx, "COMMIT")
 	if err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
@@ -510,9 +670,23 @@ func (e *Executor) executeCommit(query string) (*ExecuteResult, error) {
 }
 
 // executeRollback executes a ROLLBACK statement
-func (e *Executor) executeRollback(query string) (*ExecuteResult, error) {
-	// Rollback the transaction
-	_, err := e.db.Exec(query)
+func (e *Executor) executeRollback(ctx context.Context, stmt *sqlparser.Statement) (*ExecuteResult, error) {
+	// Rolling back to a savepoint keeps the transaction open
+	if savepoint := stmt.Rollback.SavepointName; savepoint != "" {
+		_, err := e.db.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+quoteIdentifier(savepoint))
+		if err != nil {
+			return nil, fmt.Errorf("failed to rollback to savepoint: %w", err)
+		}
+
+		return &ExecuteResult{
+			RowCount: 0,
+			IsQuery:  false,
+			Message:  fmt.Sprintf("Transaction rolled back to savepoint '%s'", savepoint),
+		}, nil
+	}
+
+	// Rollback the transaction; SQLite doesn't accept the T-SQL TRAN[SACTION] forms
+	_, err := e.db.ExecContext(ctx, "ROLLBACK")
 	if err != nil {
 		return nil, fmt.Errorf("failed to rollback transaction: %w", err)
 	}
@@ -524,8 +698,39 @@ func (e *Executor) executeRollback(query string) (*ExecuteResult, error) {
 	}, nil
 }
 
+// executeSaveTransaction executes a SAVE TRANSACTION statement as an SQLite savepoint
+func (e *Executor) executeSaveTransaction(ctx context.Context, stmt *sqlparser.Statement) (*ExecuteResult, error) {
+	savepoint := stmt.SaveTransaction.Name
+	if savepoint == "" {
+		return nil, fmt.Errorf("SAVE TRANSACTION requires a savepoint name")
+	}
+
+	_, err := e.db.ExecContext(ctx, "SAVEPOINT "+quoteIdentifier(savepoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save transaction: %w", err)
+	}
+
+	return &ExecuteResult{
+		RowCount: 0,
+		IsQuery:  false,
+		Message:  fmt.Sprintf("Savepoint '%s' saved", savepoint),
+	}, nil
+}
+
+// executeSetOption executes a SET statement turning session options on or off
+// Options are session state kept by the caller; the database is not involved
+func (e *Executor) executeSetOption(stmt *sqlparser.Statement) (*ExecuteResult, error) {
+	return &ExecuteResult{
+		RowCount:  0,
+		IsQuery:   false,
+		Message:   stmt.RawQuery,
+		Statement: sqlparser.StatementTypeSetOption,
+		SetOption: stmt.SetOption,
+	}, nil
+}
+
 // executePrepare executes a PREPARE statement
-func (e *Executor) executePrepare(query string) (*ExecuteResult, error) {
+func (e *Executor) executePrepare(ctx context.Context, query string) (*ExecuteResult, error) {
 	// Parse query to get PREPARE information
 	stmt, err := sqlparser.NewParser().Parse(query)
 	if err != nil {
@@ -537,7 +742,7 @@ func (e *Executor) executePrepare(query string) (*ExecuteResult, error) {
 	}
 
 	// Prepare the statement using SQLite
-	preparedStmt, err := e.db.Prepare(stmt.Prepare.SQL)
+	preparedStmt, err := e.db.PrepareContext(ctx, stmt.Prepare.SQL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare statement: %w", err)
 	}
@@ -554,7 +759,7 @@ func (e *Executor) executePrepare(query string) (*ExecuteResult, error) {
 }
 
 // executeStatement executes an EXECUTE statement
-func (e *Executor) executeStatement(query string) (*ExecuteResult, error) {
+func (e *Executor) executeStatement(ctx context.Context, query string) (*ExecuteResult, error) {
 	// Parse query to get EXECUTE information
 	stmt, err := sqlparser.NewParser().Parse(query)
 	if err != nil {
@@ -593,18 +798,12 @@ func (e *Executor) executeStatement(query string) (*ExecuteResult, error) {
 	}
 
 	// Determine if it's a query or command
-	upperSQL := strings.ToUpper(execSQL)
-	isQuery := strings.HasPrefix(upperSQL, "SELECT ") ||
-	            strings.HasPrefix(upperSQL, "WITH ") ||
-	            strings.HasPrefix(upperSQL, "EXPLAIN ") ||
-	            strings.HasPrefix(upperSQL, "PRAGMA ")
-
-	if isQuery {
+	if ReturnsRows(execSQL) {
 		// Execute as query
-		return e.executeSelect(execSQL)
+		return e.executeSelect(ctx, execSQL)
 	} else {
 		// Execute as command
-		result, err := e.db.Exec(execSQL)
+		result, err := e.db.ExecContext(ctx, execSQL)
 		if err != nil {
 			return nil, fmt.Errorf("failed to execute prepared statement: %w", err)
 		}
@@ -612,15 +811,16 @@ func (e *Executor) executeStatement(query string) (*ExecuteResult, error) {
 		rowsAffected, _ := result.RowsAffected()
 
 		return &ExecuteResult{
-			RowCount: rowsAffected,
-			IsQuery:  false,
-			Message:  fmt.Sprintf("Prepared statement '%s' executed successfully", stmt.Execute.Name),
+			RowCount:  rowsAffected,
+			IsQuery:   false,
+			Message:   fmt.Sprintf("Prepared statement '%s' executed successfully", stmt.Execute.Name),
+			Statement: sqlparser.ParseStatementType(execSQL),
 		}, nil
 	}
 }
 
 // executeDeallocatePrepare executes a DEALLOCATE PREPARE statement
-func (e *Executor) executeDeallocatePrepare(query string) (*ExecuteResult, error) {
+func (e *Executor) executeDeallocatePrepare(ctx context.Context, query string) (*ExecuteResult, error) {
 	// Parse query to get DEALLOCATE PREPARE information
 	stmt, err := sqlparser.NewParser().Parse(query)
 	if err != nil {
@@ -655,46 +855,16 @@ func (e *Executor) executeDeallocatePrepare(query string) (*ExecuteResult, error
 }
 
 // executeRaw executes raw SQL (for unsupported statement types)
-func (e *Executor) executeRaw(query string) (*ExecuteResult, error) {
+func (e *Executor) executeRaw(ctx context.Context, query string, args ...interface{}) (*ExecuteResult, error) {
 	// Try to execute as query first
-	rows, err := e.db.Query(query)
+	rows, err := e.db.QueryContext(ctx, query, args...)
 	if err == nil {
 		defer rows.Close()
-
-		// Get column names
-		columns, err := rows.Columns()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get columns: %w", err)
-		}
-
-		// Read all rows
-		var resultRows [][]interface{}
-		for rows.Next() {
-			// Create slice for values
-			values := make([]interface{}, len(columns))
-			valuePtrs := make([]interface{}, len(columns))
-			for i := range columns {
-				valuePtrs[i] = &values[i]
-			}
-
-			// Scan row
-			if err := rows.Scan(valuePtrs...); err != nil {
-				return nil, fmt.Errorf("failed to scan row: %w", err)
-			}
-
-			resultRows = append(resultRows, values)
-		}
-
-		return &ExecuteResult{
-			Columns:  columns,
-			Rows:     resultRows,
-			RowCount: int64(len(resultRows)),
-			IsQuery:  true,
-		}, nil
+		return ReadRows(rows)
 	}
 
 	// Try to execute as non-query
-	result, err := e.db.Exec(query)
+	result, err := e.db.ExecContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute raw SQL: %w", err)
 	}
@@ -783,6 +953,11 @@ func (e *Executor) executeSysDatabases() (*ExecuteResult, error) {
 		return nil, fmt.Errorf("error getting columns: %w", err)
 	}
 
+	columnTypes, err := readColumns(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error getting column types: %w", err)
+	}
+
 	// Read all rows
 	var rowValues [][]interface{}
 	for rows.Next() {
@@ -800,8 +975,9 @@ func (e *Executor) executeSysDatabases() (*ExecuteResult, error) {
 	}
 
 	return &ExecuteResult{
-		Columns: columns,
-		Rows:    rowValues,
-		IsQuery: true,
+		Columns:     columns,
+		ColumnTypes: columnTypes,
+		Rows:        rowValues,
+		IsQuery:     true,
 	}, nil
 }
@@ -0,0 +1,354 @@
+package sqlexecutor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/factory/mssql-tds-server/pkg/sqlerror"
+	"github.com/factory/mssql-tds-server/pkg/sqlparser"
+)
+
+// defaultBulkBatchRows is how many rows a bulk load commits at a time when the
+// client sets neither ROWS_PER_BATCH nor KILOBYTES_PER_BATCH
+const defaultBulkBatchRows = 10000
+
+// bulkSavepoint wraps each batch; outside a transaction it begins and commits one
+const bulkSavepoint = "bulk_load"
+
+// insertTriggerRegex matches the definition of an INSERT trigger
+var insertTriggerRegex = regexp.MustCompile(`(?is)\b(?:BEFORE|AFTER|INSTEAD\s+OF)\s+INSERT\s+ON\b`)
+
+// BulkRowReader returns the next row of a bulk load, or io.EOF after the last
+type BulkRowReader func() ([]interface{}, error)
+
+// bulkColumn is a column of a bulk load's target table
+type bulkColumn struct {
+	name       string
+	hasDefault bool
+}
+
+// CheckBulkInsert checks that table exists and has the given columns, as
+// INSERT BULK does before the client sends any rows
+func (e *Executor) CheckBulkInsert(ctx context.Context, table string, columns []string) error {
+	_, err := bulkColumns(ctx, e.db, table, columns)
+	return err
+}
+
+// BulkInsert inserts the rows read from next into the columns of table,
+// committing a batch at a time; batches committed before an error are kept
+// Without CheckConstraints, CHECK and FOREIGN KEY constraints are not enforced;
+// without FireTriggers, the table's INSERT triggers do not run; without
+// KeepNulls, NULLs are replaced by column defaults. Inside the session's
+// transaction the batches are part of it. It returns the number of rows inserted
+func (e *Executor) BulkInsert(ctx context.Context, table string, columns []string, options sqlparser.BulkOptions, next BulkRowReader) (int64, error) {
+	// Pragmas and statements stay on one connection: the session's
+	// connection to the current database
+	conn, _ := e.db.(*sql.Conn)
+	if conn == nil {
+		var err error
+		if conn, err = e.currentDB.Conn(ctx); err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+	}
+
+	targets, err := bulkColumns(ctx, conn, table, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	if !options.CheckConstraints {
+		restore, err := disableConstraints(ctx, conn)
+		if err != nil {
+			return 0, err
+		}
+		defer restore()
+	}
+
+	load := &bulkLoad{
+		conn:       conn,
+		table:      table,
+		columns:    targets,
+		options:    options,
+		statements: make(map[string]*sql.Stmt),
+	}
+	defer load.close()
+
+	var total int64
+	for {
+		n, done, err := load.insertBatch(ctx, next)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if done {
+			return total, nil
+		}
+	}
+}
+
+// bulkColumns looks up the target columns of a bulk load
+func bulkColumns(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+}, table string, columns []string) ([]bulkColumn, error) {
+	rows, err := q.QueryContext(ctx, "SELECT name, dflt_value IS NOT NULL FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of '%s': %w", table, err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bulkColumn)
+	for rows.Next() {
+		var col bulkColumn
+		if err := rows.Scan(&col.name, &col.hasDefault); err != nil {
+			return nil, err
+		}
+		existing[strings.ToLower(col.name)] = col
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(existing) == 0 {
+		return nil, sqlerror.New(sqlerror.InvalidObjectName, sqlerror.ClassUserError, "Invalid object name '%s'.", table)
+	}
+
+	targets := make([]bulkColumn, len(columns))
+	for i, name := range columns {
+		col, ok := existing[strings.ToLower(name)]
+		if !ok {
+			return nil, sqlerror.New(sqlerror.InvalidColumnName, sqlerror.ClassUserError, "Invalid column name '%s'.", name)
+		}
+		targets[i] = col
+	}
+	return targets, nil
+}
+
+// disableConstraints turns off CHECK and FOREIGN KEY enforcement on conn and
+// returns a function turning it back on
+func disableConstraints(ctx context.Context, conn *sql.Conn) (func(), error) {
+	var foreignKeys bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return nil, err
+	}
+
+	// foreign_keys cannot change inside a transaction, where it stays on
+	pragmas := []string{"ignore_check_constraints = ON"}
+	if foreignKeys {
+		pragmas = append(pragmas, "foreign_keys = OFF")
+	}
+	for _, pragma := range pragmas {
+		if _, err := conn.ExecContext(ctx, "PRAGMA "+pragma); err != nil {
+			return nil, err
+		}
+	}
+
+	return func() {
+		conn.ExecContext(context.Background(), "PRAGMA ignore_check_constraints = OFF")
+		if foreignKeys {
+			conn.ExecContext(context.Background(), "PRAGMA foreign_keys = ON")
+		}
+	}, nil
+}
+
+// bulkLoad inserts the rows of one bulk load
+type bulkLoad struct {
+	conn    *sql.Conn
+	table   string
+	columns []bulkColumn
+	options sqlparser.BulkOptions
+
+	// INSERT statements by the columns they leave to their defaults
+	statements map[string]*sql.Stmt
+}
+
+// insertBatch inserts rows until the batch is full or the rows run out,
+// inside a savepoint that is released on success and rolled back on error
+func (b *bulkLoad) insertBatch(ctx context.Context, next BulkRowReader) (int64, bool, error) {
+	if _, err := b.conn.ExecContext(ctx, "SAVEPOINT "+bulkSavepoint); err != nil {
+		return 0, false, fmt.Errorf("failed to begin bulk load batch: %w", err)
+	}
+
+	n, done, err := b.insertRows(ctx, next)
+	if err == nil {
+		_, err = b.conn.ExecContext(ctx, "RELEASE "+bulkSavepoint)
+	}
+	if err != nil {
+		// Rolling back also restores any triggers dropped for the batch
+		b.conn.ExecContext(context.Background(), "ROLLBACK TO "+bulkSavepoint)
+		b.conn.ExecContext(context.Background(), "RELEASE "+bulkSavepoint)
+		return 0, false, err
+	}
+	return n, done, nil
+}
+
+// insertRows inserts one batch of rows
+func (b *bulkLoad) insertRows(ctx context.Context, next BulkRowReader) (int64, bool, error) {
+	// SQLite cannot disable a trigger, so INSERT triggers are dropped for the
+	// batch and recreated before the savepoint is released
+	var triggers []string
+	if !b.options.FireTriggers {
+		var err error
+		if triggers, err = b.dropInsertTriggers(ctx); err != nil {
+			return 0, false, err
+		}
+	}
+
+	var rows, size int64
+	done := false
+	for !b.batchFull(rows, size) {
+		row, err := next()
+		if err == io.EOF {
+			done = true
+			break
+		}
+		if err != nil {
+			return rows, false, err
+		}
+		if len(row) != len(b.columns) {
+			return rows, false, fmt.Errorf("bulk load row has %d values, expected %d", len(row), len(b.columns))
+		}
+
+		if err := b.insertRow(ctx, row); err != nil {
+			return rows, false, err
+		}
+		rows++
+		size += rowSize(row)
+	}
+
+	for _, trigger := range triggers {
+		if _, err := b.conn.ExecContext(ctx, trigger); err != nil {
+			return rows, false, fmt.Errorf("failed to restore trigger: %w", err)
+		}
+	}
+	return rows, done, nil
+}
+
+// batchFull reports whether a batch of rows totalling size bytes is complete
+func (b *bulkLoad) batchFull(rows, size int64) bool {
+	switch {
+	case b.options.RowsPerBatch > 0:
+		return rows >= int64(b.options.RowsPerBatch)
+	case b.options.KilobytesPerBatch > 0:
+		return size >= int64(b.options.KilobytesPerBatch)*1024
+	default:
+		return rows >= defaultBulkBatchRows
+	}
+}
+
+// rowSize approximates the size of a row's values in bytes
+func rowSize(row []interface{}) int64 {
+	var size int64
+	for _, value := range row {
+		switch v := value.(type) {
+		case string:
+			size += int64(len(v))
+		case []byte:
+			size += int64(len(v))
+		default:
+			size += 8
+		}
+	}
+	return size
+}
+
+// dropInsertTriggers drops the INSERT triggers of the table and returns the
+// statements recreating them
+func (b *bulkLoad) dropInsertTriggers(ctx context.Context) ([]string, error) {
+	rows, err := b.conn.QueryContext(ctx,
+		"SELECT name, sql FROM sqlite_master WHERE type = 'trigger' AND tbl_name = ? COLLATE NOCASE", b.table)
+	if err != nil {
+		return nil, err
+	}
+
+	var names, triggers []string
+	for rows.Next() {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if insertTriggerRegex.MatchString(definition) {
+			names = append(names, name)
+			triggers = append(triggers, definition)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if _, err := b.conn.ExecContext(ctx, "DROP TRIGGER "+quoteIdentifier(name)); err != nil {
+			return nil, fmt.Errorf("failed to disable trigger '%s': %w", name, err)
+		}
+	}
+	return triggers, nil
+}
+
+// insertRow inserts one row
+// Without KeepNulls, NULL values of columns with a default are left out of
+// the INSERT so the default applies
+func (b *bulkLoad) insertRow(ctx context.Context, row []interface{}) error {
+	var skipped strings.Builder
+	args := make([]interface{}, 0, len(row))
+	for i, value := range row {
+		if value == nil && !b.options.KeepNulls && b.columns[i].hasDefault {
+			skipped.WriteByte('1')
+			continue
+		}
+		skipped.WriteByte('0')
+		args = append(args, value)
+	}
+
+	stmt, err := b.statement(ctx, skipped.String())
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx, args...)
+	return err
+}
+
+// statement returns the INSERT statement leaving out the columns marked '1'
+// in skipped, preparing it on first use
+func (b *bulkLoad) statement(ctx context.Context, skipped string) (*sql.Stmt, error) {
+	if stmt, ok := b.statements[skipped]; ok {
+		return stmt, nil
+	}
+
+	var names, params []string
+	for i, col := range b.columns {
+		if skipped[i] == '0' {
+			names = append(names, quoteIdentifier(col.name))
+			params = append(params, "?")
+		}
+	}
+
+	query := "INSERT INTO " + quoteIdentifier(b.table) + " DEFAULT VALUES"
+	if len(names) > 0 {
+		query = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			quoteIdentifier(b.table), strings.Join(names, ", "), strings.Join(params, ", "))
+	}
+
+	stmt, err := b.conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	b.statements[skipped] = stmt
+	return stmt, nil
+}
+
+// close releases the prepared statements
+func (b *bulkLoad) close() {
+	for _, stmt := range b.statements {
+		stmt.Close()
+	}
+}
+
+// quoteIdentifier double-quotes a SQLite identifier
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -1,95 +1,207 @@
 package sqlexecutor
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"regexp"
 	"strings"
 
 	"github.com/factory/mssql-tds-server/pkg/database"
+	"github.com/factory/mssql-tds-server/pkg/sqlerror"
 	"github.com/factory/mssql-tds-server/pkg/sqlparser"
 )
 
 // ExecuteCreateDatabase executes a CREATE DATABASE statement
 func (e *Executor) ExecuteCreateDatabase(stmt *sqlparser.CreateDatabaseStatement) error {
-	// Create database using catalog
-	var _ database.Catalog
+	name := databaseName(stmt.DatabaseName)
 
 	// Create database using catalog
-	db, err := e.catalog.CreateDatabase(stmt.DatabaseName)
+	db, err := e.catalog.CreateDatabase(name)
 	if err != nil {
-		return fmt.Errorf("error creating database '%s': %w", stmt.DatabaseName, err)
+		return fmt.Errorf("error creating database '%s': %w", name, err)
 	}
 
 	log.Printf("Created database: %s (ID: %d, Path: %s)", db.Name, db.ID, db.FilePath)
 
-	// Open database connection and cache it
-	conn, err := sql.Open("sqlite", db.FilePath)
-	if err != nil {
-		return fmt.Errorf("error opening database '%s': %w", stmt.DatabaseName, err)
-	}
-
-	e.connections[stmt.DatabaseName] = conn
-
 	return nil
 }
 
-// ExecuteDropDatabase executes a DROP DATABASE statement
+// ExecuteDropDatabase executes a DROP DATABASE statement. A database that
+// any session is using, this one included, cannot be dropped
 func (e *Executor) ExecuteDropDatabase(stmt *sqlparser.DropDatabaseStatement) error {
-	// Check if database is currently in use
-	if e.currentDBName == stmt.DatabaseName {
-		return fmt.Errorf("cannot drop database '%s' because it is currently in use", stmt.DatabaseName)
+	name := databaseName(stmt.DatabaseName)
+	key := strings.ToLower(name)
+
+	// Holding the lock keeps other sessions from switching to the database
+	// between the check and the drop
+	e.shared.mu.Lock()
+	defer e.shared.mu.Unlock()
+	if e.shared.inUse[key] > 0 || strings.EqualFold(e.currentDBName, name) {
+		return sqlerror.New(sqlerror.DatabaseInUse, sqlerror.ClassUserError,
+			"Cannot drop database \"%s\" because it is currently in use.", name)
 	}
 
 	// Drop database using catalog (moves file to trash)
-	err := e.catalog.DropDatabase(stmt.DatabaseName)
+	err := e.catalog.DropDatabase(name)
 	if err != nil {
-		return fmt.Errorf("error dropping database '%s': %w", stmt.DatabaseName, err)
+		return fmt.Errorf("error dropping database '%s': %w", name, err)
 	}
 
-	// Close and remove connection
-	if conn, exists := e.connections[stmt.DatabaseName]; exists {
+	// Close and remove connections
+	if conn, exists := e.dbConns[key]; exists {
 		conn.Close()
-		delete(e.connections, stmt.DatabaseName)
+		delete(e.dbConns, key)
+	}
+	if conn, exists := e.shared.connections[key]; exists {
+		conn.Close()
+		delete(e.shared.connections, key)
 	}
 
-	log.Printf("Dropped database: %s (moved to recycle bin/trash)", stmt.DatabaseName)
+	log.Printf("Dropped database: %s (moved to recycle bin/trash)", name)
 
 	return nil
 }
 
 // ExecuteUseDatabase executes a USE statement
+// System databases share the primary connection; user databases get a cached
+// connection pool, from which a session keeps a connection of its own
 func (e *Executor) ExecuteUseDatabase(stmt *sqlparser.UseDatabaseStatement) error {
+	name := databaseName(stmt.DatabaseName)
+
+	e.shared.mu.Lock()
+	defer e.shared.mu.Unlock()
+
 	// Check if database exists
-	db, err := e.catalog.GetDatabase(stmt.DatabaseName)
+	db, err := e.catalog.GetDatabase(name)
 	if err != nil {
-		return fmt.Errorf("database '%s' does not exist", stmt.DatabaseName)
+		return sqlerror.New(sqlerror.DatabaseNotFound, sqlerror.ClassUserError,
+			"Database '%s' does not exist. Make sure that the name is entered correctly.", name)
 	}
 
-	// Close current connection
-	if e.currentDB != nil {
-		e.currentDB.Close()
+	conn := e.pool
+	var current Querier = e.pool
+	if e.conn != nil {
+		current = e.conn
 	}
+	key := strings.ToLower(db.Name)
+	if !db.IsSystem && db.FilePath != "" {
+		cached, ok := e.shared.connections[key]
+		if !ok {
+			// Open new database connection
+			cached, err = sql.Open("sqlite3", db.FilePath)
+			if err != nil {
+				return fmt.Errorf("error opening database '%s': %w", db.Name, err)
+			}
 
-	// Open new database connection
-	conn, err := sql.Open("sqlite", db.FilePath)
-	if err != nil {
-		return fmt.Errorf("error opening database '%s': %w", stmt.DatabaseName, err)
+			// Cache connection
+			e.shared.connections[key] = cached
+
+			// A connection kept from before the database was dropped
+			// belongs to the old file
+			if stale, exists := e.dbConns[key]; exists {
+				stale.Close()
+				delete(e.dbConns, key)
+			}
+		}
+		conn = cached
+		current = cached
+
+		// A session's statements stay on one connection, as on the primary one
+		if e.conn != nil {
+			sessionConn, ok := e.dbConns[key]
+			if !ok {
+				sessionConn, err = cached.Conn(context.Background())
+				if err != nil {
+					return fmt.Errorf("error opening database '%s': %w", db.Name, err)
+				}
+				e.dbConns[key] = sessionConn
+			}
+			current = sessionConn
+		}
 	}
 
 	// Set as current database
+	e.release()
+	e.shared.inUse[key]++
+	e.db = current
 	e.currentDB = conn
-	e.currentDBName = stmt.DatabaseName
+	e.currentDBName = db.Name
 
-	// Cache connection
-	e.connections[stmt.DatabaseName] = conn
+	log.Printf("Using database: %s (Path: %s)", db.Name, db.FilePath)
 
-	log.Printf("Using database: %s (Path: %s)", stmt.DatabaseName, db.FilePath)
+	return nil
+}
 
+// release stops counting the executor as a user of its current database.
+// The caller holds e.shared.mu
+func (e *Executor) release() {
+	if e.currentDBName == "" {
+		return
+	}
+	key := strings.ToLower(e.currentDBName)
+	if e.shared.inUse[key]--; e.shared.inUse[key] <= 0 {
+		delete(e.shared.inUse, key)
+	}
+	e.currentDBName = ""
+}
+
+// databaseName returns a database name from a statement without its brackets
+func databaseName(name string) string {
+	name = strings.TrimSuffix(strings.TrimSpace(name), ";")
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(name), "["), "]")
+}
+
+// Checkpoint copies the write-ahead log of the server's database, the
+// databases attached to it and each open user database back into the
+// database files. Databases not in WAL mode have nothing to checkpoint
+func (e *Executor) Checkpoint(ctx context.Context) error {
+	e.shared.mu.Lock()
+	defer e.shared.mu.Unlock()
+
+	var errs []error
+	if err := checkpoint(ctx, e.pool); err != nil {
+		errs = append(errs, fmt.Errorf("error checkpointing master database: %w", err))
+	}
+	for name, conn := range e.shared.connections {
+		if err := checkpoint(ctx, conn); err != nil {
+			errs = append(errs, fmt.Errorf("error checkpointing database '%s': %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkpoint checkpoints every database attached to db and empties their logs
+func checkpoint(ctx context.Context, db *sql.DB) error {
+	var busy, logFrames, checkpointed int
+	err := db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed)
+	if err != nil {
+		return err
+	}
+	// A connection still reading or writing kept the checkpoint from finishing
+	if busy != 0 {
+		return fmt.Errorf("database is busy")
+	}
 	return nil
 }
 
+// CloseDatabases closes the connections of the user databases
+func (e *Executor) CloseDatabases() error {
+	e.shared.mu.Lock()
+	defer e.shared.mu.Unlock()
+
+	var errs []error
+	for name, conn := range e.shared.connections {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing database '%s': %w", name, err))
+		}
+		delete(e.shared.connections, name)
+	}
+	return errors.Join(errs...)
+}
+
 // ExecuteSysDatabasesQuery executes a sys.databases query
 func (e *Executor) ExecuteSysDatabasesQuery() (*sql.Rows, error) {
 	// Get list of databases from catalog
@@ -158,6 +270,27 @@ func (e *Executor) ExecuteDatabaseCommands(stmt *sqlparser.Statement) error {
 	}
 }
 
+// executeDatabaseCommand executes a CREATE DATABASE or DROP DATABASE statement
+func (e *Executor) executeDatabaseCommand(stmt *sqlparser.Statement) (*ExecuteResult, error) {
+	if stmt.CreateDatabase == nil && stmt.DropDatabase == nil {
+		return nil, fmt.Errorf("database name is missing")
+	}
+	if err := e.ExecuteDatabaseCommands(stmt); err != nil {
+		return nil, err
+	}
+
+	message := "Database created successfully"
+	if stmt.Type == sqlparser.StatementTypeDropDatabase {
+		message = "Database dropped successfully"
+	}
+	return &ExecuteResult{
+		RowCount:  0,
+		IsQuery:   false,
+		Message:   message,
+		Statement: stmt.Type,
+	}, nil
+}
+
 // CreateProcedure creates a stored procedure in the current database
 func (e *Executor) CreateProcedure(procName, definition string) error {
 	if e.currentDBName == "" {
@@ -230,89 +363,164 @@ func (e *Executor) GetFunctions() ([]map[string]interface{}, error) {
 	return e.catalog.GetFunctions(e.currentDBName)
 }
 
-// TranslateDatabaseReferences translates SQL Server database references to SQLite
-func (e *Executor) TranslateDatabaseReferences(query string) string {
-	// SQL Server: DatabaseName.schema.Table
-	// SQLite: DatabaseName.Table (after ATTACH)
-
-	// Find database references and translate them
-	// Pattern: database.schema.table -> database.table
+// databaseReference matches the database part of database.dbo.object and
+// database..object names
+var databaseReference = regexp.MustCompile(`(?i)(\[\w+\]|\b\w+)\.(?:dbo|\[dbo\])?\.`)
 
-	// This is a simple translation - for production, use proper SQL parsing
-	// Remove schema (dbo) from three-part naming
-	// database.dbo.table -> database.table
-
-	// Match database.schema.table pattern
-	re := regexp.MustCompile(`(\w+)\.dbo\.(\w+)`)
-	query = re.ReplaceAllString(query, `$1.$2`)
-
-	// Check for database references
-	dbRefs := e.extractDatabaseReferences(query)
+// TranslateDatabaseReferences translates SQL Server database references to SQLite
+// Objects of the current database lose the database name; those of another
+// database in the catalog are read from it attached to the session's
+// connection under its name: sales.dbo.orders becomes "sales".orders.
+// String literals, quoted identifiers and comments are left as they are
+func (e *Executor) TranslateDatabaseReferences(ctx context.Context, query string) string {
+	if e.catalog == nil || !strings.Contains(query, ".") {
+		return query
+	}
 
-	// Attach databases that aren't already attached
-	for _, dbName := range dbRefs {
-		if !e.attachedDBs[dbName] {
-			err := e.attachDatabase(dbName)
-			if err != nil {
-				log.Printf("Error attaching database %s: %v", dbName, err)
+	// Each database named is looked up once
+	type translation struct {
+		prefix string
+		ok     bool
+	}
+	translations := make(map[string]translation)
+	translate := func(name string) (string, bool) {
+		key := strings.ToLower(name)
+		if t, ok := translations[key]; ok {
+			return t.prefix, t.ok
+		}
+		var t translation
+		db, err := e.catalog.GetDatabase(name)
+		switch {
+		case err != nil:
+			// Not a database, such as a schema.table.column name
+		case e.isCurrentDatabase(db):
+			t.ok = true
+		default:
+			if err := e.attachDatabase(ctx, db.Name); err != nil {
+				log.Printf("Error attaching database %s: %v", db.Name, err)
+				break
 			}
+			t = translation{quoteIdentifier(db.Name) + ".", true}
 		}
+		translations[key] = t
+		return t.prefix, t.ok
 	}
 
-	return query
+	var sb strings.Builder
+	last := 0
+	for _, m := range databaseReference.FindAllStringSubmatchIndex(maskQuoted(query), -1) {
+		prefix, ok := translate(strings.Trim(query[m[2]:m[3]], "[]"))
+		if !ok {
+			continue
+		}
+		sb.WriteString(query[last:m[0]])
+		sb.WriteString(prefix)
+		last = m[1]
+	}
+	sb.WriteString(query[last:])
+	return sb.String()
 }
 
-// extractDatabaseReferences extracts database names from query
-func (e *Executor) extractDatabaseReferences(query string) []string {
-	var dbRefs []string
-	seen := make(map[string]bool)
-
-	// Simple pattern matching: find database.table references
-	// Match: databaseName.tableName (but not schema.tableName)
-	re := regexp.MustCompile(`(\w+)\.(\w+)`)
-	matches := re.FindAllStringSubmatch(query, -1)
-
-	for _, match := range matches {
-		if len(match) > 1 {
-			dbName := match[1]
-			// Filter out schema names
-			if !isSchemaName(dbName) && !seen[dbName] {
-				dbRefs = append(dbRefs, dbName)
-				seen[dbName] = true
+// maskQuoted returns query with the text of its string literals, quoted
+// identifiers and comments blanked out, so that patterns only match code.
+// Bracketed plain names such as [sales] are code; the result is as long as
+// query, so positions in one are positions in the other
+func maskQuoted(query string) string {
+	masked := []byte(query)
+	blank := func(from, to int) {
+		for i := from; i < to && i < len(masked); i++ {
+			masked[i] = ' '
+		}
+	}
+
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			blank(i, i+end)
+			i += end
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i
+			} else {
+				end += 4
 			}
+			blank(i, i+end)
+			i += end - 1
+		case c == '\'' || c == '"' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			// Doubled closing characters ('' ]] "") are escapes and toggle twice
+			end := strings.IndexByte(query[i+1:], closing)
+			if end < 0 {
+				end = len(query) - i - 1
+			}
+			name := query[i+1 : i+1+end]
+			if c != '[' || !plainName.MatchString(name) {
+				blank(i+1, i+1+end)
+			}
+			i += end + 1
 		}
 	}
+	return string(masked)
+}
+
+// plainName matches a bracketed name that could be a database's
+var plainName = regexp.MustCompile(`^\w+$`)
 
-	return dbRefs
+// isCurrentDatabase reports whether db is the database statements run in;
+// the system databases are all the primary one
+func (e *Executor) isCurrentDatabase(db *database.Database) bool {
+	if db.IsSystem {
+		return e.currentDB == e.pool
+	}
+	return strings.EqualFold(db.Name, e.currentDBName)
 }
 
-// isSchemaName checks if name is a schema name
-func isSchemaName(name string) bool {
-	schemas := []string{"dbo", "sys", "information_schema"}
-	for _, schema := range schemas {
-		if strings.EqualFold(name, schema) {
-			return true
+// attachDatabase attaches a database to the session's current connection,
+// unless it is attached already
+func (e *Executor) attachDatabase(ctx context.Context, dbName string) error {
+	conn, ok := e.db.(*sql.Conn)
+	if !ok {
+		return fmt.Errorf("databases can only be attached to a session's connection")
+	}
+
+	// Connections keep the databases attached to them
+	rows, err := conn.QueryContext(ctx, "PRAGMA database_list")
+	if err != nil {
+		return err
+	}
+	attached := false
+	for rows.Next() {
+		var seq int
+		var name, file string
+		if err := rows.Scan(&seq, &name, &file); err == nil && strings.EqualFold(name, dbName) {
+			attached = true
 		}
 	}
-	return false
-}
+	rows.Close()
+	if err := rows.Err(); err != nil || attached {
+		return err
+	}
 
-// attachDatabase attaches a database to the current connection
-func (e *Executor) attachDatabase(dbName string) error {
 	// Get database from catalog
-	db, err := e.catalog.GetDatabase(dbName)
+	path, err := e.catalog.DatabasePath(dbName)
 	if err != nil {
 		return err
 	}
 
 	// Attach using SQLite ATTACH command
-	query := fmt.Sprintf("ATTACH DATABASE '%s' AS %s", db.FilePath, dbName)
-	_, err = e.currentDB.Exec(query)
+	_, err = conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+quoteIdentifier(dbName), path)
 	if err != nil {
 		return err
 	}
 
-	e.attachedDBs[dbName] = true
 	log.Printf("Attached database: %s", dbName)
 
 	return nil
@@ -0,0 +1,62 @@
+package sqlexecutor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/factory/mssql-tds-server/pkg/sqlparser"
+)
+
+// PrepareContext prepares a statement for repeated execution with ExecutePreparedContext
+// The caller owns the returned statement and closes it when it is unprepared
+func (e *Executor) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	query = sqlparser.StripComments(query)
+	query = sqlparser.StripUnicodePrefix(query)
+
+	stmt, err := e.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	return stmt, nil
+}
+
+// ExecutePreparedContext executes a statement prepared with PrepareContext
+// query is the statement's text and decides whether it returns rows
+func (e *Executor) ExecutePreparedContext(ctx context.Context, stmt *sql.Stmt, query string, args ...interface{}) (*ExecuteResult, error) {
+	if ReturnsRows(query) {
+		rows, err := stmt.QueryContext(ctx, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute prepared statement: %w", err)
+		}
+		defer rows.Close()
+		return ReadRows(rows)
+	}
+
+	result, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute prepared statement: %w", err)
+	}
+
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return &ExecuteResult{
+		RowCount:  rowCount,
+		IsQuery:   false,
+		Message:   fmt.Sprintf("%d row(s) affected", rowCount),
+		Statement: sqlparser.ParseStatementType(query),
+	}, nil
+}
+
+// ReturnsRows reports whether a statement produces a result set
+func ReturnsRows(query string) bool {
+	upperSQL := strings.ToUpper(strings.TrimSpace(sqlparser.StripComments(query)))
+	return strings.HasPrefix(upperSQL, "SELECT ") ||
+		strings.HasPrefix(upperSQL, "WITH ") ||
+		strings.HasPrefix(upperSQL, "EXPLAIN ") ||
+		strings.HasPrefix(upperSQL, "PRAGMA ")
+}
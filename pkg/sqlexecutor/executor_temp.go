@@ -0,0 +1,78 @@
+package sqlexecutor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/factory/mssql-tds-server/pkg/temp"
+)
+
+// Each connection's #temp tables are ordinary tables named
+// tempdb_<session>_<name>, dropped when the connection closes or is reset
+const (
+	tempSessionPrefix = "tempdb_"
+	allTempTables     = tempSessionPrefix + "[0-9]*_*" // GLOB pattern
+)
+
+// TempSession names the #temp table namespace of the connection with the given ID
+func TempSession(id uint64) string {
+	return fmt.Sprintf("%s%d", tempSessionPrefix, id)
+}
+
+// RewriteTempTables maps the #temp table names in query to the session's tables
+func RewriteTempTables(query, session string) string {
+	if len(temp.DetectTempTableReference(query)) == 0 {
+		return query
+	}
+	return temp.ReplaceTempTableNames(query, session)
+}
+
+// DropTempTables drops the session's #temp tables, in every database it used
+func (e *Executor) DropTempTables(ctx context.Context, session string) error {
+	if e.conn == nil {
+		return dropTables(ctx, e.pool, session+"_*")
+	}
+	if err := dropTables(ctx, e.conn, session+"_*"); err != nil {
+		return err
+	}
+	for _, conn := range e.dbConns {
+		if err := dropTables(ctx, conn, session+"_*"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropAllTempTables drops the #temp tables of every session, such as those
+// left behind when the server stopped with connections open
+func (e *Executor) DropAllTempTables(ctx context.Context) error {
+	return dropTables(ctx, e.pool, allTempTables)
+}
+
+// dropTables drops the tables of db whose names match a GLOB pattern
+func dropTables(ctx context.Context, db Querier, pattern string) error {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name GLOB ?", pattern)
+	if err != nil {
+		return fmt.Errorf("failed to list temporary tables: %w", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to list temporary tables: %w", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list temporary tables: %w", err)
+	}
+
+	for _, name := range names {
+		if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS "+quoteIdentifier(name)); err != nil {
+			return fmt.Errorf("failed to drop temporary table %s: %w", name, err)
+		}
+	}
+	return nil
+}
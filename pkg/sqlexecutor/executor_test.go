@@ -1,10 +1,19 @@
 package sqlexecutor
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/factory/mssql-tds-server/pkg/database"
+	"github.com/factory/mssql-tds-server/pkg/sqlerror"
+	"github.com/factory/mssql-tds-server/pkg/sqlite"
+	"github.com/factory/mssql-tds-server/pkg/sqlparser"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -13,7 +22,10 @@ func setupTestDB(t *testing.T) (*sql.DB, *database.Catalog) {
 	if err != nil {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
-	catalog := database.NewCatalog("", db)
+	catalog, err := database.NewCatalog("", db)
+	if err != nil {
+		t.Fatalf("Failed to create catalog: %v", err)
+	}
 	return db, catalog
 }
 
@@ -289,3 +301,484 @@ func TestConvertCreateTable(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDeclaredType(t *testing.T) {
+	tests := []struct {
+		declType string
+		want     Column
+	}{
+		{"INTEGER", Column{TypeName: "INTEGER"}},
+		{"varchar(50)", Column{TypeName: "VARCHAR", Length: 50}},
+		{"NVARCHAR(MAX)", Column{TypeName: "NVARCHAR", Length: -1}},
+		{"DECIMAL(10, 2)", Column{TypeName: "DECIMAL", Precision: 10, Scale: 2}},
+		{"double precision", Column{TypeName: "DOUBLE PRECISION"}},
+		{"", Column{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.declType, func(t *testing.T) {
+			got := ParseDeclaredType(tt.declType)
+			if got != tt.want {
+				t.Errorf("ParseDeclaredType(%q) = %+v, want %+v", tt.declType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRowsKeepsNull(t *testing.T) {
+	db, _ := setupTestDB(t)
+	defer db.Close()
+
+	rows, err := db.Query("SELECT NULL AS a, 'NULL' AS b")
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	defer rows.Close()
+
+	result, err := ReadRows(rows)
+	if err != nil {
+		t.Fatalf("ReadRows() error = %v", err)
+	}
+
+	if len(result.Rows) != 1 {
+		t.Fatalf("ReadRows() returned %d rows, want 1", len(result.Rows))
+	}
+	if result.Rows[0][0] != nil {
+		t.Errorf("NULL column = %#v, want nil", result.Rows[0][0])
+	}
+	if result.Rows[0][1] != "NULL" {
+		t.Errorf("'NULL' column = %#v, want \"NULL\"", result.Rows[0][1])
+	}
+}
+
+func TestExecuteContextCancel(t *testing.T) {
+	db, catalog := setupTestDB(t)
+	defer db.Close()
+
+	executor := NewExecutor(db, catalog)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	// Never terminates on its own
+	start := time.Now()
+	_, err := executor.ExecuteContext(ctx, "WITH RECURSIVE r(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM r) SELECT count(*) FROM r")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ExecuteContext() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancellation took %v", elapsed)
+	}
+}
+
+func TestExecuteContextNamedArgs(t *testing.T) {
+	db, catalog := setupTestDB(t)
+	defer db.Close()
+
+	executor := NewExecutor(db, catalog)
+	ctx := context.Background()
+
+	if _, err := executor.ExecuteContext(ctx, "CREATE TABLE users (id INT, name NVARCHAR(50))"); err != nil {
+		t.Fatalf("CREATE TABLE failed: %v", err)
+	}
+
+	result, err := executor.ExecuteContext(ctx, "INSERT INTO users VALUES (@id, @name)", sql.Named("id", int64(1)), sql.Named("name", "alice"))
+	if err != nil {
+		t.Fatalf("INSERT failed: %v", err)
+	}
+	if result.RowCount != 1 {
+		t.Errorf("RowCount = %d, want 1", result.RowCount)
+	}
+
+	result, err = executor.ExecuteContext(ctx, "SELECT name FROM users WHERE id = @id", sql.Named("id", int64(1)))
+	if err != nil {
+		t.Fatalf("SELECT failed: %v", err)
+	}
+	if len(result.Rows) != 1 || result.Rows[0][0] != "alice" {
+		t.Errorf("Rows = %v, want [[alice]]", result.Rows)
+	}
+}
+
+func TestExecuteBatchContext(t *testing.T) {
+	db, catalog := setupTestDB(t)
+	defer db.Close()
+
+	executor := NewExecutor(db, catalog)
+	ctx := context.Background()
+
+	results, err := executor.ExecuteBatchContext(ctx,
+		"CREATE TABLE t (n INT); INSERT INTO t VALUES (1), (2); SELECT n FROM t WHERE n > @min; SELECT 'a;b' AS s",
+		sql.Named("min", int64(0)))
+	if err != nil {
+		t.Fatalf("ExecuteBatchContext() error = %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("len(results) = %d, want 4", len(results))
+	}
+	if results[1].IsQuery || results[1].RowCount != 2 {
+		t.Errorf("INSERT result = %+v, want 2 rows affected", results[1])
+	}
+	if !results[2].IsQuery || len(results[2].Rows) != 2 {
+		t.Errorf("first SELECT rows = %v, want 2 rows", results[2].Rows)
+	}
+	if !results[3].IsQuery || results[3].Rows[0][0] != "a;b" {
+		t.Errorf("second SELECT rows = %v, want [[a;b]]", results[3].Rows)
+	}
+
+	// Results before a failing statement are kept
+	results, err = executor.ExecuteBatchContext(ctx, "SELECT 1; SELECT * FROM missing; SELECT 2")
+	if err == nil {
+		t.Fatal("ExecuteBatchContext() error = nil, want error")
+	}
+	if len(results) != 1 {
+		t.Errorf("len(results) = %d, want 1", len(results))
+	}
+}
+
+func TestBulkInsert(t *testing.T) {
+	db, catalog := setupTestDB(t)
+	defer db.Close()
+	// Every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+
+	executor := NewExecutor(db, catalog)
+	ctx := context.Background()
+
+	setup := []string{
+		"CREATE TABLE items (id INTEGER, name TEXT DEFAULT 'none', qty INTEGER CHECK (qty >= 0))",
+		"CREATE TABLE audit (id INTEGER)",
+		"CREATE TRIGGER items_insert AFTER INSERT ON items BEGIN INSERT INTO audit VALUES (NEW.id); END",
+	}
+	for _, query := range setup {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	columns := []string{"id", "NAME", "qty"}
+	if err := executor.CheckBulkInsert(ctx, "items", columns); err != nil {
+		t.Fatalf("CheckBulkInsert() error = %v", err)
+	}
+	if err := executor.CheckBulkInsert(ctx, "items", []string{"id", "price"}); err == nil {
+		t.Error("CheckBulkInsert() with a missing column error = nil, want error")
+	}
+	if err := executor.CheckBulkInsert(ctx, "missing", columns); err == nil {
+		t.Error("CheckBulkInsert() of a missing table error = nil, want error")
+	}
+
+	rows := func(n int) BulkRowReader {
+		i := 0
+		return func() ([]interface{}, error) {
+			if i == n {
+				return nil, io.EOF
+			}
+			i++
+			return []interface{}{int64(i), nil, int64(-1)}, nil
+		}
+	}
+	count := func(query string) int64 {
+		var n int64
+		if err := db.QueryRow(query).Scan(&n); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return n
+	}
+
+	// Defaults replace NULLs, and neither constraints nor triggers apply
+	n, err := executor.BulkInsert(ctx, "items", columns, sqlparser.BulkOptions{RowsPerBatch: 7}, rows(25))
+	if err != nil || n != 25 {
+		t.Fatalf("BulkInsert() = %d, %v, want 25 rows", n, err)
+	}
+	if got := count("SELECT COUNT(*) FROM items WHERE name = 'none'"); got != 25 {
+		t.Errorf("rows with the default name = %d, want 25", got)
+	}
+	if got := count("SELECT COUNT(*) FROM audit"); got != 0 {
+		t.Errorf("audit rows = %d, want 0", got)
+	}
+	if got := count("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger'"); got != 1 {
+		t.Errorf("triggers after the load = %d, want 1", got)
+	}
+
+	// CHECK_CONSTRAINTS fails the batch with the bad row; earlier batches stay
+	i := 0
+	next := func() ([]interface{}, error) {
+		i++
+		if i > 10 {
+			return nil, io.EOF
+		}
+		qty := int64(1)
+		if i == 8 {
+			qty = -1
+		}
+		return []interface{}{int64(100 + i), "x", qty}, nil
+	}
+	options := sqlparser.BulkOptions{CheckConstraints: true, FireTriggers: true, KeepNulls: true, RowsPerBatch: 5}
+	n, err = executor.BulkInsert(ctx, "items", columns, options, next)
+	if err == nil || n != 5 {
+		t.Fatalf("BulkInsert() = %d, %v, want 5 rows and an error", n, err)
+	}
+	if got := count("SELECT COUNT(*) FROM items WHERE id > 100"); got != 5 {
+		t.Errorf("rows kept = %d, want 5", got)
+	}
+	if got := count("SELECT COUNT(*) FROM audit"); got != 5 {
+		t.Errorf("audit rows = %d, want 5", got)
+	}
+
+	// KEEP_NULLS keeps NULLs over defaults
+	options = sqlparser.BulkOptions{KeepNulls: true}
+	if _, err := executor.BulkInsert(ctx, "items", columns, options, rows(3)); err != nil {
+		t.Fatalf("BulkInsert() error = %v", err)
+	}
+	if got := count("SELECT COUNT(*) FROM items WHERE name IS NULL"); got != 3 {
+		t.Errorf("rows with NULL names = %d, want 3", got)
+	}
+}
+
+func TestDropTempTables(t *testing.T) {
+	db, catalog := setupTestDB(t)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	executor := NewExecutor(db, catalog)
+	ctx := context.Background()
+
+	one, two := TempSession(1), TempSession(2)
+	for _, query := range []string{
+		RewriteTempTables("CREATE TABLE #t (id INTEGER)", one),
+		RewriteTempTables("CREATE TABLE #t (id INTEGER)", two),
+		RewriteTempTables("CREATE TABLE #u (id INTEGER)", two),
+		"CREATE TABLE tempdb_stats (id INTEGER)",
+	} {
+		if _, err := executor.ExecuteContext(ctx, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	tables := func() []string {
+		rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE 'tempdb%' ORDER BY name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			rows.Scan(&name)
+			names = append(names, name)
+		}
+		return names
+	}
+
+	if err := executor.DropTempTables(ctx, two); err != nil {
+		t.Fatalf("DropTempTables() error = %v", err)
+	}
+	if got := strings.Join(tables(), ","); got != "tempdb_1_t,tempdb_stats" {
+		t.Errorf("tables after DropTempTables() = %s, want tempdb_1_t,tempdb_stats", got)
+	}
+
+	if err := executor.DropAllTempTables(ctx); err != nil {
+		t.Fatalf("DropAllTempTables() error = %v", err)
+	}
+	if got := strings.Join(tables(), ","); got != "tempdb_stats" {
+		t.Errorf("tables after DropAllTempTables() = %s, want tempdb_stats", got)
+	}
+}
+
+func TestSessionsHaveOwnTransactions(t *testing.T) {
+	// Sessions hold connections of their own, which :memory: would give separate databases
+	db, err := sql.Open("sqlite3", t.TempDir()+"/sessions.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	executor := NewExecutor(db, nil)
+	if _, err := executor.ExecuteContext(ctx, "CREATE TABLE items (id INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	one, err := executor.NewSession(ctx)
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	two, err := executor.NewSession(ctx)
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	defer two.Close()
+
+	count := func(e *Executor) interface{} {
+		t.Helper()
+		result, err := e.ExecuteContext(ctx, "SELECT COUNT(*) FROM items")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Rows[0][0]
+	}
+
+	for _, query := range []string{"BEGIN TRANSACTION", "INSERT INTO items VALUES (1)"} {
+		if _, err := one.ExecuteContext(ctx, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	if got := count(one); got != int64(1) {
+		t.Errorf("count in the transaction = %v, want 1", got)
+	}
+	if got := count(two); got != int64(0) {
+		t.Errorf("count in another session = %v, want 0", got)
+	}
+
+	// Closing a session rolls back its transaction
+	if err := one.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := count(two); got != int64(0) {
+		t.Errorf("count after closing the session = %v, want 0", got)
+	}
+}
+
+func TestDropDatabaseInUse(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", dir+"/master.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	catalog, err := database.NewCatalog(dir, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := catalog.CreateDatabase("sales"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	executor := NewExecutor(db, catalog)
+
+	user, err := executor.NewSession(ctx)
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	defer user.Close()
+	if err := user.ExecuteUseDatabase(&sqlparser.UseDatabaseStatement{DatabaseName: "sales"}); err != nil {
+		t.Fatalf("USE sales error = %v", err)
+	}
+
+	// Another session cannot drop the database while it is in use
+	drop := &sqlparser.DropDatabaseStatement{DatabaseName: "sales"}
+	err = executor.ExecuteDropDatabase(drop)
+	var sqlErr *sqlerror.Error
+	if !errors.As(err, &sqlErr) || sqlErr.Number != sqlerror.DatabaseInUse {
+		t.Fatalf("DROP DATABASE in use error = %v, want error %d", err, sqlerror.DatabaseInUse)
+	}
+
+	if err := user.ExecuteUseDatabase(&sqlparser.UseDatabaseStatement{DatabaseName: "master"}); err != nil {
+		t.Fatalf("USE master error = %v", err)
+	}
+	if err := executor.ExecuteDropDatabase(drop); err != nil {
+		t.Errorf("DROP DATABASE after USE master error = %v", err)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	path := t.TempDir() + "/wal.db"
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	executor := NewExecutor(db, nil)
+	for _, query := range []string{"CREATE TABLE items (id INTEGER)", "INSERT INTO items VALUES (1)"} {
+		if _, err := executor.ExecuteContext(ctx, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	walSize := func() int64 {
+		t.Helper()
+		info, err := os.Stat(path + "-wal")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+	if walSize() == 0 {
+		t.Fatal("nothing written to the WAL")
+	}
+
+	if err := executor.Checkpoint(ctx); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
+	if size := walSize(); size != 0 {
+		t.Errorf("WAL size after Checkpoint() = %d, want 0", size)
+	}
+}
+
+func TestTranslateDatabaseReferences(t *testing.T) {
+	db, err := sql.Open("sqlite3", sqlite.MemoryURI(t.Name()))
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer db.Close()
+	catalog, err := database.NewMemoryCatalog(t.Name(), db)
+	if err != nil {
+		t.Fatalf("Failed to create catalog: %v", err)
+	}
+	defer catalog.Close()
+
+	ctx := context.Background()
+	session, err := NewExecutor(db, catalog).NewSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if _, err := session.ExecuteContext(ctx, "CREATE DATABASE sales"); err != nil {
+		t.Fatalf("CREATE DATABASE: %v", err)
+	}
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT * FROM sales.dbo.orders", `SELECT * FROM "sales".orders`},
+		{"SELECT * FROM [Sales].[dbo].[orders]", `SELECT * FROM "sales".[orders]`},
+		{"SELECT * FROM sales..orders o JOIN master.dbo.items i ON i.id = o.id", `SELECT * FROM "sales".orders o JOIN items i ON i.id = o.id`},
+		{"SELECT dbo.items.id FROM dbo.items", "SELECT dbo.items.id FROM dbo.items"},
+		{"INSERT INTO notes VALUES ('see sales.dbo.orders')", "INSERT INTO notes VALUES ('see sales.dbo.orders')"},
+		{"SELECT 'it''s sales..orders', [a sales.dbo.b] FROM sales..orders -- sales..orders", `SELECT 'it''s sales..orders', [a sales.dbo.b] FROM "sales".orders -- sales..orders`},
+	}
+	for _, tt := range tests {
+		if result := session.TranslateDatabaseReferences(ctx, tt.query); result != tt.expected {
+			t.Errorf("TranslateDatabaseReferences(%q) = %q, want %q", tt.query, result, tt.expected)
+		}
+	}
+
+	// The attached database is readable from the session's connection
+	if _, err := session.ExecuteContext(ctx, "CREATE TABLE sales.dbo.orders (id INTEGER)"); err != nil {
+		t.Fatalf("CREATE TABLE: %v", err)
+	}
+	if err := session.ExecuteUseDatabase(&sqlparser.UseDatabaseStatement{DatabaseName: "sales"}); err != nil {
+		t.Fatalf("USE sales: %v", err)
+	}
+	if _, err := session.ExecuteContext(ctx, "SELECT id FROM orders"); err != nil {
+		t.Errorf("SELECT from sales after USE: %v", err)
+	}
+
+	// A database name in a string is stored as written
+	for _, query := range []string{"CREATE TABLE notes (note TEXT)", "INSERT INTO notes VALUES ('see sales.dbo.orders')"} {
+		if _, err := session.ExecuteContext(ctx, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	result, err := session.ExecuteContext(ctx, "SELECT note FROM notes")
+	if err != nil {
+		t.Fatalf("SELECT note: %v", err)
+	}
+	if len(result.Rows) != 1 || result.Rows[0][0] != "see sales.dbo.orders" {
+		t.Errorf("note = %v, want [[see sales.dbo.orders]]", result.Rows)
+	}
+}
@@ -1,6 +1,7 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -16,19 +17,24 @@ const (
 
 // Database represents the SQLite database connection
 type Database struct {
-	db *sql.DB
+	db   *sql.DB
+	keep *sql.Conn // Keeps an in-memory database alive; nil for a file
 }
 
 // NewDatabase creates a new database connection
+// dbPath is a file, or a URI from MemoryURI for an in-memory database that
+// lasts until Close
 func NewDatabase(dbPath string) (*Database, error) {
 	if dbPath == "" {
 		dbPath = DefaultDBPath
 	}
 
 	// Ensure directory exists
-	dir := filepath.Dir(dbPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	if !IsMemoryURI(dbPath) {
+		dir := filepath.Dir(dbPath)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
 	}
 
 	db, err := sql.Open("sqlite3", dbPath)
@@ -41,9 +47,20 @@ func NewDatabase(dbPath string) (*Database, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	// A shared in-memory database is freed with its last connection, which
+	// the pool may close while idle
+	var keep *sql.Conn
+	if IsMemoryURI(dbPath) {
+		keep, err = db.Conn(context.Background())
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+	}
+
 	log.Printf("Connected to SQLite database at %s", dbPath)
 
-	return &Database{db: db}, nil
+	return &Database{db: db, keep: keep}, nil
 }
 
 // Initialize creates the necessary tables if they don't exist
@@ -71,7 +88,11 @@ func (d *Database) GetDB() *sql.DB {
 	return d.db
 }
 
-// Close closes the database connection
+// Close closes the database connection; an in-memory database is freed
+// once no other connection uses it
 func (d *Database) Close() error {
+	if d.keep != nil {
+		d.keep.Close()
+	}
 	return d.db.Close()
 }
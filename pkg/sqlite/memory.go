@@ -0,0 +1,97 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// memoryURIPrefix and memoryURIQuery surround the name of a shared in-memory database
+const (
+	memoryURIPrefix = "file:"
+	memoryURIQuery  = "?mode=memory&cache=shared"
+)
+
+// MemoryURI returns the URI of the in-memory database called name. Every
+// connection to the URI in the process shares the database, which nothing
+// writes to disk. It lasts while a connection to it is open
+func MemoryURI(name string) string {
+	return memoryURIPrefix + url.PathEscape(name) + memoryURIQuery
+}
+
+// IsMemoryURI reports whether path is a URI from MemoryURI
+func IsMemoryURI(path string) bool {
+	return strings.HasPrefix(path, memoryURIPrefix) && strings.HasSuffix(path, memoryURIQuery)
+}
+
+// Serialize returns the content of db's main database as the bytes of a
+// database file
+func Serialize(ctx context.Context, db *sql.DB) ([]byte, error) {
+	var data []byte
+	err := raw(ctx, db, func(conn *sqlite3.SQLiteConn) error {
+		var err error
+		data, err = conn.Serialize("main")
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize database: %w", err)
+	}
+	return data, nil
+}
+
+// Restore replaces the content of db's main database with data from
+// Serialize; empty data leaves the database empty. Connections to db see the
+// new content at their next statement
+func Restore(ctx context.Context, db *sql.DB, data []byte) error {
+	// Shared-cache databases cannot be deserialized into; a private copy is
+	// backed up into them instead
+	src, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+	defer src.Close()
+
+	err = raw(ctx, src, func(srcConn *sqlite3.SQLiteConn) error {
+		if len(data) > 0 {
+			if err := srcConn.Deserialize(data, "main"); err != nil {
+				return err
+			}
+		}
+		return raw(ctx, db, func(destConn *sqlite3.SQLiteConn) error {
+			backup, err := destConn.Backup("main", srcConn, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+	return nil
+}
+
+// raw runs f on a driver connection of db
+func raw(ctx context.Context, db *sql.DB, f func(*sqlite3.SQLiteConn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		sqliteConn, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("not a SQLite connection: %T", driverConn)
+		}
+		return f(sqliteConn)
+	})
+}
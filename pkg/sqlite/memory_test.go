@@ -0,0 +1,71 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSerializeRestore(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewDatabase(MemoryURI(t.Name()))
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer db.Close()
+
+	count := func() int {
+		t.Helper()
+		var n int
+		if err := db.GetDB().QueryRow("SELECT COUNT(*) FROM items").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if _, err := db.GetDB().Exec("CREATE TABLE items (id INTEGER); INSERT INTO items VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := Serialize(ctx, db.GetDB())
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	if _, err := db.GetDB().Exec("INSERT INTO items VALUES (2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(ctx, db.GetDB(), data); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("rows after Restore() = %d, want 1", n)
+	}
+
+	// The database outlives the connections the pool closes
+	db.GetDB().SetMaxIdleConns(0)
+	if n := count(); n != 1 {
+		t.Errorf("rows with no idle connection = %d, want 1", n)
+	}
+
+	if err := Restore(ctx, db.GetDB(), nil); err != nil {
+		t.Fatalf("Restore(nil) error = %v", err)
+	}
+	if _, err := db.GetDB().Exec("SELECT * FROM items"); err == nil {
+		t.Error("items still exists after Restore(nil)")
+	}
+}
+
+func TestIsMemoryURI(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{MemoryURI("tds1.sales"), true},
+		{"./data/tds_server.db", false},
+		{":memory:", false},
+	}
+	for _, tt := range tests {
+		if result := IsMemoryURI(tt.path); result != tt.expected {
+			t.Errorf("IsMemoryURI(%q) = %v, want %v", tt.path, result, tt.expected)
+		}
+	}
+}
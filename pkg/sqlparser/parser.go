@@ -31,6 +31,8 @@ func (p *Parser) Parse(query string) (*Statement, error) {
 		stmt = p.parseSelect(query)
 	} else if strings.HasPrefix(upperQuery, "INSERT INTO ") {
 		stmt = p.parseInsert(query)
+	} else if insertBulkRegex.MatchString(query) {
+		return p.parseInsertBulk(query)
 	} else if strings.HasPrefix(upperQuery, "UPDATE ") {
 		stmt = p.parseUpdate(query)
 	} else if strings.HasPrefix(upperQuery, "DELETE FROM ") {
@@ -67,6 +69,10 @@ func (p *Parser) Parse(query string) (*Statement, error) {
 		stmt = p.parseCommit(query)
 	} else if strings.HasPrefix(upperQuery, "ROLLBACK") || strings.HasPrefix(upperQuery, "ROLLBACK TRAN") {
 		stmt = p.parseRollback(query)
+	} else if strings.HasPrefix(upperQuery, "SAVE TRAN") {
+		stmt = p.parseSaveTransaction(query)
+	} else if setOptionRegex.MatchString(query) {
+		stmt = p.parseSetOption(query)
 	} else {
 		// Unknown statement type
 		stmt = &Statement{
@@ -405,7 +411,7 @@ func (p *Parser) parseAggregates(columnsPart string) []AggregateFunction {
 
 		// Check for AS alias (e.g., COUNT(*) AS total)
 		alias := ""
-		aliasPattern := regexp.MustCompile(strings.ToUpper(match) + `\s+AS\s+(\w+)`)
+		aliasPattern := regexp.MustCompile(regexp.QuoteMeta(strings.ToUpper(match)) + `\s+AS\s+(\w+)`)
 		aliasMatches := aliasPattern.FindStringSubmatch(columnsPart)
 		if len(aliasMatches) > 1 {
 			alias = aliasMatches[1]
@@ -1175,6 +1181,25 @@ func (p *Parser) parseCommit(query string) *Statement {
 	}
 }
 
+// parseSaveTransaction parses a SAVE TRANSACTION statement
+func (p *Parser) parseSaveTransaction(query string) *Statement {
+	// Format: SAVE TRAN[SACTION] savepoint_name
+
+	fields := strings.Fields(query)
+	savepointName := ""
+	if len(fields) > 2 {
+		savepointName = fields[2]
+	}
+
+	return &Statement{
+		Type: StatementTypeSaveTransaction,
+		SaveTransaction: &SaveTransactionStatement{
+			Name: savepointName,
+		},
+		RawQuery: query,
+	}
+}
+
 // parseRollback parses a ROLLBACK statement
 func (p *Parser) parseRollback(query string) *Statement {
 	// Format: ROLLBACK [name]
@@ -1516,3 +1541,217 @@ func StripComments(query string) string {
 
 	return strings.TrimSpace(query)
 }
+
+// StripUnicodePrefix removes the N prefix from N'...' string literals
+// SQLite strings are already Unicode, and it doesn't accept the prefix
+func StripUnicodePrefix(query string) string {
+	var sb strings.Builder
+	inString := false
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		if inString {
+			sb.WriteByte(c)
+			if c == '\'' {
+				// '' is an escaped quote inside the literal
+				if i+1 < len(query) && query[i+1] == '\'' {
+					sb.WriteByte('\'')
+					i++
+				} else {
+					inString = false
+				}
+			}
+			continue
+		}
+
+		if (c == 'N' || c == 'n') && i+1 < len(query) && query[i+1] == '\'' &&
+			(i == 0 || !isIdentifierChar(query[i-1])) {
+			continue
+		}
+
+		if c == '\'' {
+			inString = true
+		}
+		sb.WriteByte(c)
+	}
+
+	return sb.String()
+}
+
+// triggerRegex matches the start of a CREATE TRIGGER statement, whose body holds semicolons
+var triggerRegex = regexp.MustCompile(`(?i)^CREATE\s+(?:TEMP\s+|TEMPORARY\s+)?TRIGGER\b`)
+
+// blockEndRegex matches the END closing a trigger body
+var blockEndRegex = regexp.MustCompile(`(?i)\bEND$`)
+
+// routineRegex matches the start of a CREATE PROCEDURE or FUNCTION statement,
+// whose body runs to the end of the batch
+var routineRegex = regexp.MustCompile(`(?i)^CREATE\s+(?:OR\s+ALTER\s+)?(?:PROC|PROCEDURE|FUNCTION)\b`)
+
+// trailingSetRegex matches a SET option that follows another statement
+// without a semicolon, as in SELECT * FROM t SET FMTONLY OFF
+var trailingSetRegex = regexp.MustCompile(`(?is)^(.*\S)\s+(SET\s+\w+(?:\s*,\s*\w+)*\s+(?:ON|OFF))$`)
+
+// cteRegex matches a WITH clause that starts a statement, as opposed to
+// WITH (NOLOCK) or WITH CHECK OPTION
+var cteRegex = regexp.MustCompile(`(?is)^WITH\s+(?:RECURSIVE\b|[\w\[\]"]+\s*(?:\([^()]*\)\s*)?AS\s*\()`)
+
+// statementKeywords start a statement, so one ends where the next begins
+// even without a semicolon, as in SELECT 1 SELECT 2
+var statementKeywords = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true, "WITH": true,
+	"CREATE": true, "DROP": true, "ALTER": true, "TRUNCATE": true,
+	"BEGIN": true, "COMMIT": true, "ROLLBACK": true, "SAVE": true,
+	"USE": true, "EXEC": true, "EXECUTE": true, "DECLARE": true, "PRINT": true,
+}
+
+// continuingKeywords are followed by a statement keyword that is part of the
+// same statement, as in UNION SELECT, ON DELETE CASCADE or INSERT OR ROLLBACK
+var continuingKeywords = map[string]bool{
+	"UNION": true, "ALL": true, "EXCEPT": true, "INTERSECT": true, "AS": true,
+	"ON": true, "DO": true, "FOR": true, "OF": true, "THEN": true, "OR": true,
+	"CONFLICT": true, "AFTER": true, "BEFORE": true, "EXPLAIN": true, "PLAN": true,
+	"GRANT": true, "DENY": true, "REVOKE": true, ",": true,
+}
+
+// batchStatement is the statement SplitBatch is reading
+type batchStatement struct {
+	text     strings.Builder
+	depth    int    // Parentheses open at this point
+	first    string // First keyword
+	cte      bool   // A WITH clause has been read
+	verb     string // SELECT, INSERT, UPDATE or DELETE, after any WITH clause
+	hasQuery bool   // An INSERT's SELECT or VALUES has been read
+	prev     string // Last keyword or punctuation
+}
+
+// continues reports whether keyword, read outside parentheses, belongs to the
+// statement rather than starting the next one
+func (b *batchStatement) continues(keyword, rest string) bool {
+	stmt := strings.TrimSpace(b.text.String())
+	switch {
+	case stmt == "":
+		return true
+	case !statementKeywords[keyword] || continuingKeywords[b.prev]:
+		return true
+	case keyword == "WITH" && !cteRegex.MatchString(rest):
+		return true
+	case routineRegex.MatchString(stmt):
+		return true
+	case triggerRegex.MatchString(stmt) && !blockEndRegex.MatchString(stmt):
+		// A trigger body runs to its END
+		return true
+	case b.cte && b.verb == "":
+		// The CTE's own statement follows its definitions
+		return true
+	case b.verb == "INSERT" && !b.hasQuery:
+		return keyword == "SELECT" || keyword == "EXEC" || keyword == "EXECUTE" || keyword == "WITH"
+	case b.first == "ALTER":
+		// ALTER TABLE t DROP COLUMN c, ALTER TABLE t ALTER COLUMN c
+		return keyword == "DROP" || keyword == "ALTER"
+	}
+	return false
+}
+
+// word records keyword, read outside parentheses
+func (b *batchStatement) word(keyword, rest string) {
+	if b.first == "" {
+		b.first = keyword
+	}
+	switch keyword {
+	case "WITH":
+		b.cte = b.cte || cteRegex.MatchString(rest)
+	case "SELECT", "INSERT", "UPDATE", "DELETE":
+		if b.verb == "" {
+			b.verb = keyword
+		} else if keyword == "SELECT" {
+			b.hasQuery = true
+		}
+	case "VALUES":
+		b.hasQuery = true
+	}
+}
+
+// SplitBatch splits a batch into its statements at semicolons outside string
+// literals and quoted identifiers, where a statement keyword starts the next
+// statement, and ahead of a trailing SET option. Empty statements are dropped
+func SplitBatch(batch string) []string {
+	var statements []string
+	var current batchStatement
+	var quote byte // Closing character of the literal or identifier being read
+
+	flush := func() {
+		if stmt := strings.TrimSpace(current.text.String()); stmt != "" {
+			if m := trailingSetRegex.FindStringSubmatch(stmt); m != nil {
+				statements = append(statements, m[1], m[2])
+			} else {
+				statements = append(statements, stmt)
+			}
+		}
+		current = batchStatement{}
+	}
+
+	for i := 0; i < len(batch); i++ {
+		c := batch[i]
+
+		switch {
+		case quote != 0:
+			// Doubled closing characters ('' ]] "") are escapes and toggle twice
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			current.prev = string(c)
+		case c == '[':
+			quote = ']'
+			current.prev = string(c)
+		case c == '(':
+			current.depth++
+		case c == ')':
+			current.depth--
+			current.prev = string(c)
+		case c == ';':
+			// A trigger body runs to its END
+			stmt := strings.TrimSpace(current.text.String())
+			if !triggerRegex.MatchString(stmt) || blockEndRegex.MatchString(stmt) {
+				flush()
+				continue
+			}
+		case isIdentifierChar(c) && (i == 0 || !isIdentifierChar(batch[i-1])):
+			end := i + 1
+			for end < len(batch) && isIdentifierChar(batch[end]) {
+				end++
+			}
+			if current.depth > 0 {
+				current.text.WriteString(batch[i:end])
+				i = end - 1
+				continue
+			}
+
+			keyword := strings.ToUpper(batch[i:end])
+			if !current.continues(keyword, batch[i:]) {
+				flush()
+			}
+			current.word(keyword, batch[i:])
+			current.prev = keyword
+			current.text.WriteString(batch[i:end])
+			i = end - 1
+			continue
+		case c != ' ' && c != '\t' && c != '\r' && c != '\n':
+			current.prev = string(c)
+		}
+
+		current.text.WriteByte(c)
+	}
+	flush()
+
+	return statements
+}
+
+// isIdentifierChar reports whether c can be part of an unquoted identifier
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '@' || c == '#' || c == '$' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,231 @@
+package sqlparser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// insertBulkRegex matches the start of INSERT BULK
+var insertBulkRegex = regexp.MustCompile(`(?i)^INSERT\s+BULK\s+`)
+
+// bulkOptionRegex matches one WITH option: NAME, NAME = value or ORDER(columns)
+var bulkOptionRegex = regexp.MustCompile(`(?is)^(\w+)\s*(?:=\s*(\d+)|\((.*)\))?$`)
+
+// parseInsertBulk parses an INSERT BULK statement, which a client sends ahead
+// of the BULK_LOAD message carrying the rows
+func (p *Parser) parseInsertBulk(query string) (*Statement, error) {
+	// Format: INSERT BULK table ([column] type, ...) [WITH (option, ...)]
+	rest := query[len(insertBulkRegex.FindString(query)):]
+
+	open := strings.IndexByte(rest, '(')
+	if open == -1 {
+		return nil, fmt.Errorf("INSERT BULK requires a column list")
+	}
+	table := unquoteObjectName(strings.TrimSpace(rest[:open]))
+	if table == "" {
+		return nil, fmt.Errorf("INSERT BULK requires a table name")
+	}
+
+	columnList, rest, err := parenthesized(rest[open:])
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []InsertBulkColumn
+	for _, def := range splitTopLevel(columnList) {
+		name, typ := splitColumnDefinition(def)
+		if name == "" {
+			return nil, fmt.Errorf("invalid INSERT BULK column definition '%s'", def)
+		}
+		columns = append(columns, InsertBulkColumn{Name: name, Type: typ})
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("INSERT BULK requires a column list")
+	}
+
+	var options BulkOptions
+	rest = strings.TrimSpace(rest)
+	if rest != "" {
+		if len(rest) < 4 || !strings.EqualFold(rest[:4], "WITH") {
+			return nil, fmt.Errorf("incorrect syntax near '%s'", rest)
+		}
+
+		optionList, tail, err := parenthesized(strings.TrimSpace(rest[4:]))
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(tail) != "" {
+			return nil, fmt.Errorf("incorrect syntax near '%s'", strings.TrimSpace(tail))
+		}
+
+		if options, err = parseBulkOptions(optionList); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Statement{
+		Type: StatementTypeInsertBulk,
+		InsertBulk: &InsertBulkStatement{
+			Table:   table,
+			Columns: columns,
+			Options: options,
+		},
+		RawQuery: query,
+	}, nil
+}
+
+// parseBulkOptions parses the WITH options of INSERT BULK
+func parseBulkOptions(list string) (BulkOptions, error) {
+	var options BulkOptions
+
+	for _, option := range splitTopLevel(list) {
+		m := bulkOptionRegex.FindStringSubmatch(option)
+		if m == nil {
+			return options, fmt.Errorf("invalid INSERT BULK option '%s'", option)
+		}
+		name, value, columns := strings.ToUpper(m[1]), m[2], m[3]
+
+		switch {
+		case name == "CHECK_CONSTRAINTS" && value == "" && columns == "":
+			options.CheckConstraints = true
+		case name == "FIRE_TRIGGERS" && value == "" && columns == "":
+			options.FireTriggers = true
+		case name == "KEEP_NULLS" && value == "" && columns == "":
+			options.KeepNulls = true
+		case name == "TABLOCK" && value == "" && columns == "":
+			options.Tablock = true
+		case name == "ROWS_PER_BATCH" && value != "":
+			options.RowsPerBatch, _ = strconv.Atoi(value)
+		case name == "KILOBYTES_PER_BATCH" && value != "":
+			options.KilobytesPerBatch, _ = strconv.Atoi(value)
+		case name == "ORDER" && columns != "":
+			for _, column := range splitTopLevel(columns) {
+				options.Order = append(options.Order, column)
+			}
+		default:
+			return options, fmt.Errorf("invalid INSERT BULK option '%s'", option)
+		}
+	}
+
+	return options, nil
+}
+
+// parenthesized returns the text inside the parentheses s starts with, and
+// the text after them
+func parenthesized(s string) (string, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return "", "", fmt.Errorf("incorrect syntax near '%s'", s)
+	}
+
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '[':
+			quote = ']'
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return s[1:i], s[i+1:], nil
+			}
+		}
+	}
+
+	return "", "", fmt.Errorf("missing closing parenthesis in '%s'", s)
+}
+
+// splitTopLevel splits a list at commas outside parentheses and quotes,
+// trimming each item and dropping empty ones
+func splitTopLevel(list string) []string {
+	var items []string
+	depth, start := 0, 0
+	var quote byte
+
+	add := func(item string) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	for i := 0; i < len(list); i++ {
+		c := list[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '[':
+			quote = ']'
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			add(list[start:i])
+			start = i + 1
+		}
+	}
+	add(list[start:])
+
+	return items
+}
+
+// splitColumnDefinition splits "[name] type" into the unquoted name and the type
+func splitColumnDefinition(def string) (string, string) {
+	var name, typ string
+	if strings.HasPrefix(def, "[") {
+		end := strings.Index(def, "]")
+		for end != -1 && end+1 < len(def) && def[end+1] == ']' {
+			// ]] is an escaped ]
+			next := strings.Index(def[end+2:], "]")
+			if next == -1 {
+				end = -1
+				break
+			}
+			end += 2 + next
+		}
+		if end == -1 {
+			return "", ""
+		}
+		name = strings.ReplaceAll(def[1:end], "]]", "]")
+		typ = def[end+1:]
+	} else {
+		fields := strings.SplitN(def, " ", 2)
+		name = fields[0]
+		if len(fields) > 1 {
+			typ = fields[1]
+		}
+	}
+
+	return name, strings.TrimSpace(typ)
+}
+
+// unquoteObjectName returns the object part of a possibly schema-qualified,
+// bracketed name: [dbo].[orders] becomes orders
+func unquoteObjectName(name string) string {
+	if i := strings.LastIndex(name, "]."); i != -1 {
+		name = name[i+2:]
+	} else if !strings.HasPrefix(name, "[") {
+		if i := strings.LastIndex(name, "."); i != -1 {
+			name = name[i+1:]
+		}
+	}
+
+	if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		name = strings.ReplaceAll(name[1:len(name)-1], "]]", "]")
+	}
+	return name
+}
@@ -68,11 +68,8 @@ func (p *Parser) parseDropDatabase(query string) *Statement {
 func (p *Parser) parseUseDatabase(query string) *Statement {
 	// Format: USE database_name
 
-	upperQuery := strings.ToUpper(query)
-
-	// Remove "USE "
-	query = strings.TrimSpace(strings.TrimPrefix(query, "USE "))
-	upperQuery = strings.TrimSpace(strings.TrimPrefix(upperQuery, "USE "))
+	// Remove "USE " (matched case-insensitively by the caller)
+	query = strings.TrimSpace(query[len("USE "):])
 
 	// Extract database name
 	databaseName := strings.TrimSpace(query)
@@ -0,0 +1,29 @@
+package sqlparser
+
+import (
+	"regexp"
+	"strings"
+)
+
+// setOptionRegex matches SET option[, option...] ON|OFF
+var setOptionRegex = regexp.MustCompile(`(?i)^SET\s+(\w+(?:\s*,\s*\w+)*)\s+(ON|OFF)$`)
+
+// parseSetOption parses a SET statement that turns session options on or off
+func (p *Parser) parseSetOption(query string) *Statement {
+	// Format: SET option[, option...] ON|OFF
+	matches := setOptionRegex.FindStringSubmatch(query)
+
+	var options []string
+	for _, option := range strings.Split(matches[1], ",") {
+		options = append(options, strings.ToUpper(strings.TrimSpace(option)))
+	}
+
+	return &Statement{
+		Type: StatementTypeSetOption,
+		SetOption: &SetOptionStatement{
+			Options: options,
+			On:      strings.EqualFold(matches[2], "ON"),
+		},
+		RawQuery: query,
+	}
+}
@@ -1,6 +1,7 @@
 package sqlparser
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -49,6 +50,13 @@ func TestParseSelect(t *testing.T) {
 			table:    "users",
 			columns:  []string{"*"},
 		},
+		{
+			name:     "select count star",
+			query:    "SELECT COUNT(*) AS total FROM users",
+			expected: StatementTypeSelect,
+			table:    "users",
+			columns:  []string{"COUNT(*) AS total"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -293,6 +301,25 @@ func TestParseDropTable(t *testing.T) {
 	}
 }
 
+func TestParseUseDatabase(t *testing.T) {
+	parser := NewParser()
+
+	for _, query := range []string{"USE tempdb", "use tempdb", "Use  tempdb "} {
+		stmt, err := parser.Parse(query)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", query, err)
+		}
+
+		if stmt.UseDatabase == nil {
+			t.Fatalf("Parse(%q): UseDatabase should not be nil", query)
+		}
+
+		if stmt.UseDatabase.DatabaseName != "tempdb" {
+			t.Errorf("Parse(%q): DatabaseName = %v, want tempdb", query, stmt.UseDatabase.DatabaseName)
+		}
+	}
+}
+
 func TestParseStatementType(t *testing.T) {
 	tests := []struct {
 		query    string
@@ -382,3 +409,168 @@ func TestStripComments(t *testing.T) {
 		})
 	}
 }
+
+func TestStripUnicodePrefix(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"SELECT N'héllo'", "SELECT 'héllo'"},
+		{"INSERT INTO t VALUES (n'a', N'b')", "INSERT INTO t VALUES ('a', 'b')"},
+		{"SELECT 'It''s N''x'''", "SELECT 'It''s N''x'''"},
+		{"SELECT colN'x'", "SELECT colN'x'"},
+		{"SELECT name FROM t", "SELECT name FROM t"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result := StripUnicodePrefix(tt.input)
+			if result != tt.expected {
+				t.Errorf("StripUnicodePrefix(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSplitBatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"SELECT 1", []string{"SELECT 1"}},
+		{"SELECT 1; SELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{" ; SELECT 1;; ", []string{"SELECT 1"}},
+		{"SELECT 'a;b', 'it''s; x'; SELECT [c;d], \"e;f\"", []string{"SELECT 'a;b', 'it''s; x'", "SELECT [c;d], \"e;f\""}},
+		{
+			"CREATE TRIGGER t AFTER INSERT ON a BEGIN UPDATE a SET n = 1; DELETE FROM b; END; SELECT 1",
+			[]string{"CREATE TRIGGER t AFTER INSERT ON a BEGIN UPDATE a SET n = 1; DELETE FROM b; END", "SELECT 1"},
+		},
+		{"select * from t SET FMTONLY OFF", []string{"select * from t", "SET FMTONLY OFF"}},
+		{"SET NOCOUNT ON; UPDATE t SET on_hand = 1", []string{"SET NOCOUNT ON", "UPDATE t SET on_hand = 1"}},
+		{"SELECT 1\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"INSERT INTO t VALUES (1) SELECT * FROM t", []string{"INSERT INTO t VALUES (1)", "SELECT * FROM t"}},
+		{"INSERT INTO t SELECT a FROM s UNION ALL SELECT b FROM s", []string{"INSERT INTO t SELECT a FROM s UNION ALL SELECT b FROM s"}},
+		{"SELECT (SELECT 1), 'SELECT' FROM t WITH (NOLOCK) DELETE FROM t", []string{"SELECT (SELECT 1), 'SELECT' FROM t WITH (NOLOCK)", "DELETE FROM t"}},
+		{"WITH c AS (SELECT 1 AS n) SELECT n FROM c SELECT 2", []string{"WITH c AS (SELECT 1 AS n) SELECT n FROM c", "SELECT 2"}},
+		{"CREATE VIEW v AS SELECT a FROM t CREATE TABLE u (id INT REFERENCES t ON DELETE CASCADE)", []string{"CREATE VIEW v AS SELECT a FROM t", "CREATE TABLE u (id INT REFERENCES t ON DELETE CASCADE)"}},
+		{"INSERT INTO t VALUES (1) ON CONFLICT DO UPDATE SET n = 1", []string{"INSERT INTO t VALUES (1) ON CONFLICT DO UPDATE SET n = 1"}},
+		{"BEGIN TRAN UPDATE t SET n = 1 COMMIT", []string{"BEGIN TRAN", "UPDATE t SET n = 1", "COMMIT"}},
+		{
+			"CREATE TRIGGER t AFTER UPDATE ON a BEGIN DELETE FROM b; END SELECT 1",
+			[]string{"CREATE TRIGGER t AFTER UPDATE ON a BEGIN DELETE FROM b; END", "SELECT 1"},
+		},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result := SplitBatch(tt.input)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("SplitBatch(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseSetOption(t *testing.T) {
+	tests := []struct {
+		query string
+		want  *SetOptionStatement
+	}{
+		{"SET NOCOUNT ON", &SetOptionStatement{Options: []string{"NOCOUNT"}, On: true}},
+		{"set nocount off;", &SetOptionStatement{Options: []string{"NOCOUNT"}, On: false}},
+		{"SET ANSI_NULLS, quoted_identifier ON", &SetOptionStatement{Options: []string{"ANSI_NULLS", "QUOTED_IDENTIFIER"}, On: true}},
+		{"SET @x = 1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			stmt, err := NewParser().Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if tt.want == nil {
+				if stmt.Type == StatementTypeSetOption {
+					t.Errorf("Parse() type = %v, want not SET", stmt.Type)
+				}
+				return
+			}
+			if stmt.Type != StatementTypeSetOption || !reflect.DeepEqual(stmt.SetOption, tt.want) {
+				t.Errorf("Parse() = %v %+v, want SET %+v", stmt.Type, stmt.SetOption, tt.want)
+			}
+			if !stmt.SetOption.Sets("nocount") && tt.want.Options[0] == "NOCOUNT" {
+				t.Error("Sets(nocount) = false, want true")
+			}
+		})
+	}
+}
+
+func TestParseSaveTransaction(t *testing.T) {
+	for _, query := range []string{"SAVE TRANSACTION sp1", "save tran sp1;"} {
+		stmt, err := NewParser().Parse(query)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", query, err)
+		}
+		if stmt.Type != StatementTypeSaveTransaction || stmt.SaveTransaction.Name != "sp1" {
+			t.Errorf("Parse(%q) = %v %+v, want SAVE TRANSACTION sp1", query, stmt.Type, stmt.SaveTransaction)
+		}
+	}
+}
+
+func TestParseInsertBulk(t *testing.T) {
+	tests := []struct {
+		query string
+		want  *InsertBulkStatement
+	}{
+		{
+			"INSERT BULK orders ([id] int, [price] decimal(10,2), [a]]b] nvarchar(50) COLLATE Latin1_General_CI_AS) ",
+			&InsertBulkStatement{
+				Table: "orders",
+				Columns: []InsertBulkColumn{
+					{Name: "id", Type: "int"},
+					{Name: "price", Type: "decimal(10,2)"},
+					{Name: "a]b", Type: "nvarchar(50) COLLATE Latin1_General_CI_AS"},
+				},
+			},
+		},
+		{
+			"insert bulk [dbo].[orders] ([id] int) WITH (CHECK_CONSTRAINTS,FIRE_TRIGGERS,KEEP_NULLS,KILOBYTES_PER_BATCH = 10,ROWS_PER_BATCH = 500,ORDER(id,name),TABLOCK)",
+			&InsertBulkStatement{
+				Table:   "orders",
+				Columns: []InsertBulkColumn{{Name: "id", Type: "int"}},
+				Options: BulkOptions{
+					CheckConstraints:  true,
+					FireTriggers:      true,
+					KeepNulls:         true,
+					Tablock:           true,
+					RowsPerBatch:      500,
+					KilobytesPerBatch: 10,
+					Order:             []string{"id", "name"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			stmt, err := NewParser().Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if stmt.Type != StatementTypeInsertBulk || !reflect.DeepEqual(stmt.InsertBulk, tt.want) {
+				t.Errorf("Parse() = %v %+v, want INSERT BULK %+v", stmt.Type, stmt.InsertBulk, tt.want)
+			}
+		})
+	}
+
+	for _, query := range []string{
+		"INSERT BULK orders",
+		"INSERT BULK orders ([id] int",
+		"INSERT BULK orders ([id] int) WITH (NOPE)",
+		"INSERT BULK orders ([id] int) WITH (TABLOCK) x",
+	} {
+		if _, err := NewParser().Parse(query); err == nil {
+			t.Errorf("Parse(%q) error = nil, want error", query)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package sqlparser
 
 import (
 	"database/sql"
+	"strings"
 )
 
 // StatementType represents the type of SQL statement
@@ -29,6 +30,9 @@ const (
 	StatementTypeCreateDatabase
 	StatementTypeDropDatabase
 	StatementTypeUseDatabase
+	StatementTypeSetOption
+	StatementTypeSaveTransaction
+	StatementTypeInsertBulk
 )
 
 // String returns the string representation of StatementType
@@ -74,6 +78,12 @@ func (st StatementType) String() string {
 		return "DROP DATABASE"
 	case StatementTypeUseDatabase:
 		return "USE DATABASE"
+	case StatementTypeSetOption:
+		return "SET"
+	case StatementTypeSaveTransaction:
+		return "SAVE TRANSACTION"
+	case StatementTypeInsertBulk:
+		return "INSERT BULK"
 	default:
 		return "UNKNOWN"
 	}
@@ -220,6 +230,35 @@ type RollbackStatement struct {
 	SavepointName string // Optional savepoint name for ROLLBACK TO SAVEPOINT
 }
 
+// SaveTransactionStatement represents a SAVE TRANSACTION statement
+type SaveTransactionStatement struct {
+	Name string // Savepoint name
+}
+
+// InsertBulkStatement represents an INSERT BULK statement
+type InsertBulkStatement struct {
+	Table   string
+	Columns []InsertBulkColumn
+	Options BulkOptions
+}
+
+// InsertBulkColumn is a column of an INSERT BULK statement
+type InsertBulkColumn struct {
+	Name string
+	Type string // Declared type as sent by the client (e.g. "nvarchar(50)")
+}
+
+// BulkOptions are the WITH options of INSERT BULK
+type BulkOptions struct {
+	CheckConstraints  bool // Enforce CHECK and FOREIGN KEY constraints
+	FireTriggers      bool // Run the table's INSERT triggers
+	KeepNulls         bool // Keep NULLs rather than using column defaults
+	Tablock           bool
+	RowsPerBatch      int // Rows per transaction; 0 when not set
+	KilobytesPerBatch int // Kilobytes of row data per transaction; 0 when not set
+	Order             []string
+}
+
 // CreateDatabaseStatement represents a CREATE DATABASE statement
 type CreateDatabaseStatement struct {
 	DatabaseName string
@@ -235,6 +274,22 @@ type UseDatabaseStatement struct {
 	DatabaseName string
 }
 
+// SetOptionStatement represents a SET statement turning session options on or off
+type SetOptionStatement struct {
+	Options []string // Option names, upper-cased
+	On      bool
+}
+
+// Sets reports whether the statement sets the named option
+func (s *SetOptionStatement) Sets(option string) bool {
+	for _, o := range s.Options {
+		if strings.EqualFold(o, option) {
+			return true
+		}
+	}
+	return false
+}
+
 // ColumnDefinition represents a column definition in CREATE TABLE
 type ColumnDefinition struct {
 	Name       string
@@ -285,5 +340,8 @@ type Statement struct {
 	CreateDatabase      *CreateDatabaseStatement
 	DropDatabase        *DropDatabaseStatement
 	UseDatabase         *UseDatabaseStatement
+	SetOption           *SetOptionStatement
+	SaveTransaction     *SaveTransactionStatement
+	InsertBulk          *InsertBulkStatement
 	RawQuery               string
 }
@@ -0,0 +1,205 @@
+package tds
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// noMetadata is the COLMETADATA column count sent when there are no columns
+const noMetadata = 0xFFFF
+
+// BulkLoadReader decodes the token stream of a BULK_LOAD message: COLMETADATA,
+// then a ROW or NBCROW token per row, then DONE
+// The payload is decoded packet by packet as it arrives, so a load of millions
+// of rows is never held in memory at once
+type BulkLoadReader struct {
+	next    func() ([]byte, error) // Payload of the next packet; io.EOF after the last
+	buf     []byte                 // Received payload not yet decoded
+	columns []ColumnInfo
+	done    bool
+}
+
+// NewBulkLoadReader creates a reader for a BULK_LOAD message
+func NewBulkLoadReader(msg *Message) *BulkLoadReader {
+	br := &BulkLoadReader{buf: msg.Data}
+	if msg.Body != nil {
+		br.next = msg.Body.Next
+	} else {
+		br.next = func() ([]byte, error) { return nil, io.EOF }
+	}
+	return br
+}
+
+// Columns reads the COLMETADATA token that starts the stream
+func (br *BulkLoadReader) Columns() ([]ColumnInfo, error) {
+	if br.columns != nil {
+		return br.columns, nil
+	}
+
+	err := br.decode(func(r *payloadReader) error {
+		token, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		if TokenType(token) != TokenTypeColMetadata {
+			return fmt.Errorf("bulk load data starts with token %#02x, expected COLMETADATA", token)
+		}
+
+		count, err := r.readUint16()
+		if err != nil {
+			return err
+		}
+		if count == noMetadata || count == 0 {
+			return errors.New("bulk load data has no columns")
+		}
+
+		columns := make([]ColumnInfo, count)
+		for i := range columns {
+			if columns[i], err = parseColumnMetadata(r); err != nil {
+				return fmt.Errorf("error parsing column %d metadata: %w", i, err)
+			}
+		}
+		br.columns = columns
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return br.columns, nil
+}
+
+// parseColumnMetadata reads one column of a COLMETADATA token
+func parseColumnMetadata(r *payloadReader) (ColumnInfo, error) {
+	// UserType
+	if _, err := r.readUint32(); err != nil {
+		return ColumnInfo{}, err
+	}
+
+	flags, err := r.readUint16()
+	if err != nil {
+		return ColumnInfo{}, err
+	}
+
+	col, err := parseTypeInfo(r)
+	if err != nil {
+		return col, err
+	}
+	col.Nullable = flags&ColumnFlagNullable != 0
+
+	col.Name, err = r.readBVarchar()
+	if err != nil {
+		return col, err
+	}
+	return col, nil
+}
+
+// ReadRow reads the next row, decoded like an RPC parameter value of its
+// column's type; it returns io.EOF after the last row
+func (br *BulkLoadReader) ReadRow() ([]interface{}, error) {
+	columns, err := br.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if br.done {
+		return nil, io.EOF
+	}
+
+	// A stream may end without DONE between tokens
+	if len(br.buf) == 0 {
+		more, err := br.next()
+		if err == io.EOF {
+			br.done = true
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		br.buf = more
+	}
+
+	var row []interface{}
+	err = br.decode(func(r *payloadReader) error {
+		token, err := r.readByte()
+		if err != nil {
+			return err
+		}
+
+		switch TokenType(token) {
+		case TokenTypeRow:
+			row, err = parseRow(r, columns, nil)
+			return err
+
+		case TokenTypeNBCRow:
+			// A bitmap of the NULL columns, whose values are left out
+			bitmap, err := r.readBytes((len(columns) + 7) / 8)
+			if err != nil {
+				return err
+			}
+			row, err = parseRow(r, columns, bitmap)
+			return err
+
+		case TokenTypeDone:
+			// Status, CurCmd and DoneRowCount carry nothing for the server
+			if _, err := r.readBytes(12); err != nil {
+				return err
+			}
+			br.done = true
+			return nil
+
+		default:
+			return fmt.Errorf("unexpected token %#02x in bulk load data", token)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if br.done {
+		return nil, io.EOF
+	}
+
+	return row, nil
+}
+
+// parseRow reads the values of a ROW or NBCROW token; nullBitmap is nil for ROW
+func parseRow(r *payloadReader, columns []ColumnInfo, nullBitmap []byte) ([]interface{}, error) {
+	row := make([]interface{}, len(columns))
+	for i := range columns {
+		if nullBitmap != nil && nullBitmap[i/8]&(1<<(i%8)) != 0 {
+			continue
+		}
+
+		value, err := parseRPCValue(r, &columns[i])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing column '%s' value: %w", columns[i].Name, err)
+		}
+		row[i] = value
+	}
+	return row, nil
+}
+
+// decode runs fn on the undecoded payload, reading more packets while fn runs
+// out of data. The payload fn reads is consumed only when it succeeds
+func (br *BulkLoadReader) decode(fn func(r *payloadReader) error) error {
+	for {
+		r := &payloadReader{data: br.buf}
+		err := fn(r)
+		if err == nil {
+			br.buf = br.buf[r.pos:]
+			return nil
+		}
+		if !errors.Is(err, errTruncated) {
+			return err
+		}
+
+		more, err := br.next()
+		if err == io.EOF {
+			return errors.New("bulk load data ends in the middle of a token")
+		}
+		if err != nil {
+			return err
+		}
+		br.buf = append(br.buf, more...)
+	}
+}
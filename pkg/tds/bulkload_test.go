@@ -0,0 +1,131 @@
+package tds
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// bulkLoadMessage writes a BULK_LOAD token stream split into small packets and
+// returns it as read by a MessageReader
+func bulkLoadMessage(t *testing.T, data []byte) *Message {
+	t.Helper()
+
+	var out bytes.Buffer
+	if err := NewMessageWriter(&out, MinPacketSize).WriteMessage(PacketTypeBulkLoad, data); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	msg, err := NewMessageReader(&out).ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	return msg
+}
+
+func TestBulkLoadReader(t *testing.T) {
+	columns := []ColumnInfo{
+		{Name: "id", Type: TypeIntN, Size: 4, Nullable: true},
+		{Name: "name", Type: TypeNVarChar, Size: 100, Nullable: true},
+		{Name: "price", Type: TypeDecimalN, Size: 9, Precision: 10, Scale: 2, Nullable: true},
+	}
+
+	// Enough rows to span several packets, so rows are split between them
+	var want [][]interface{}
+	ts := NewTokenStream()
+	ts.ColMetadata(columns)
+	for i := int64(0); i < 200; i++ {
+		row := []interface{}{i, "row name", "12.50"}
+		if i%7 == 0 {
+			row[1] = nil
+		}
+		if err := ts.Row(columns, row); err != nil {
+			t.Fatalf("Row() error = %v", err)
+		}
+		want = append(want, row)
+	}
+	ts.Done(DoneFinal, 0, 0)
+
+	msg := bulkLoadMessage(t, ts.Bytes())
+	if msg.Packets != 1 || msg.Body == nil {
+		t.Fatalf("message not streamed: Packets = %d, Body = %v", msg.Packets, msg.Body)
+	}
+
+	br := NewBulkLoadReader(msg)
+	got, err := br.Columns()
+	if err != nil {
+		t.Fatalf("Columns() error = %v", err)
+	}
+	if len(got) != 3 || got[1].Name != "name" || got[2].Scale != 2 || !got[0].Nullable {
+		t.Errorf("Columns() = %+v, want %+v", got, columns)
+	}
+
+	var rows [][]interface{}
+	for {
+		row, err := br.ReadRow()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadRow() after %d rows error = %v", len(rows), err)
+		}
+		rows = append(rows, row)
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("ReadRow() returned %d rows, want %d; first %v, want %v", len(rows), len(want), rows[0], want[0])
+	}
+}
+
+func TestBulkLoadReaderNBCRow(t *testing.T) {
+	columns := []ColumnInfo{
+		{Name: "a", Type: TypeIntN, Size: 8, Nullable: true},
+		{Name: "b", Type: TypeNVarChar, Size: 20, Nullable: true},
+	}
+
+	ts := NewTokenStream()
+	ts.ColMetadata(columns)
+	ts.writeByte(byte(TokenTypeNBCRow))
+	ts.writeByte(0x01) // a is NULL
+	ts.writeValue(&columns[1], "x")
+
+	br := NewBulkLoadReader(bulkLoadMessage(t, ts.Bytes()))
+	row, err := br.ReadRow()
+	if err != nil || !reflect.DeepEqual(row, []interface{}{nil, "x"}) {
+		t.Fatalf("ReadRow() = %v, %v, want [<nil> x]", row, err)
+	}
+
+	// The stream may end without DONE
+	if _, err := br.ReadRow(); err != io.EOF {
+		t.Errorf("ReadRow() error = %v, want EOF", err)
+	}
+}
+
+func TestBulkLoadReaderInvalid(t *testing.T) {
+	columns := []ColumnInfo{{Name: "a", Type: TypeIntN, Size: 4, Nullable: true}}
+
+	truncated := NewTokenStream()
+	truncated.ColMetadata(columns)
+	truncated.writeByte(byte(TokenTypeRow))
+	truncated.writeByte(4)
+	truncated.writeByte(1)
+
+	unexpected := NewTokenStream()
+	unexpected.ColMetadata(columns)
+	unexpected.writeByte(byte(TokenTypeEnvChange))
+
+	noColumns := NewTokenStream()
+	noColumns.ColMetadata(nil)
+
+	tests := map[string][]byte{
+		"truncated row":    truncated.Bytes(),
+		"unexpected token": unexpected.Bytes(),
+		"no columns":       noColumns.Bytes(),
+		"no metadata":      {byte(TokenTypeRow)},
+	}
+	for name, data := range tests {
+		br := NewBulkLoadReader(bulkLoadMessage(t, data))
+		if _, err := br.ReadRow(); err == nil || err == io.EOF {
+			t.Errorf("%s: ReadRow() error = %v, want error", name, err)
+		}
+	}
+}
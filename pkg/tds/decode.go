@@ -0,0 +1,350 @@
+package tds
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// fixedSizes are the value lengths of fixed-length types, which carry no size in TYPE_INFO
+var fixedSizes = map[DataType]int{
+	TypeNull:      0,
+	TypeInt1:      1,
+	TypeBit:       1,
+	TypeInt2:      2,
+	TypeInt4:      4,
+	TypeInt8:      8,
+	TypeFlt4:      4,
+	TypeFlt8:      8,
+	TypeMoney4:    4,
+	TypeMoney:     8,
+	TypeDateTime4: 4,
+	TypeDateTime:  8,
+}
+
+// nullableTypes maps fixed-length types to the nullable type sharing their value encoding
+var nullableTypes = map[DataType]DataType{
+	TypeInt1:      TypeIntN,
+	TypeBit:       TypeBitN,
+	TypeInt2:      TypeIntN,
+	TypeInt4:      TypeIntN,
+	TypeInt8:      TypeIntN,
+	TypeFlt4:      TypeFltN,
+	TypeFlt8:      TypeFltN,
+	TypeMoney4:    TypeMoneyN,
+	TypeMoney:     TypeMoneyN,
+	TypeDateTime4: TypeDateTimeN,
+	TypeDateTime:  TypeDateTimeN,
+}
+
+// Textual forms of decoded date and time values, as stored in SQLite
+const (
+	dateLayout          = "2006-01-02"
+	clockLayout         = "15:04:05"
+	datetimeLayout      = "2006-01-02 15:04:05.000"
+	smallDatetimeLayout = "2006-01-02 15:04:05"
+)
+
+// datetimeEpoch is the DATETIME and SMALLDATETIME epoch
+var datetimeEpoch = time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// moneyScale is the number of decimal places of MONEY values
+const moneyScale = 4
+
+// parseTypeInfo reads a TYPE_INFO
+func parseTypeInfo(r *payloadReader) (ColumnInfo, error) {
+	var col ColumnInfo
+
+	typeByte, err := r.readByte()
+	if err != nil {
+		return col, fmt.Errorf("error reading data type: %w", err)
+	}
+	col.Type = DataType(typeByte)
+
+	if size, ok := fixedSizes[col.Type]; ok {
+		col.Size = size
+		return col, nil
+	}
+
+	switch col.Type {
+	case TypeIntN, TypeBitN, TypeFltN, TypeMoneyN, TypeDateTimeN, TypeGUID:
+		size, err := r.readByte()
+		if err != nil {
+			return col, fmt.Errorf("error reading type size: %w", err)
+		}
+		col.Size = int(size)
+
+	case TypeDecimalN, TypeNumericN:
+		b, err := r.readBytes(3)
+		if err != nil {
+			return col, fmt.Errorf("error reading decimal size: %w", err)
+		}
+		col.Size, col.Precision, col.Scale = int(b[0]), b[1], b[2]
+
+	case TypeDateN:
+		col.Size = 3
+
+	case TypeTimeN, TypeDateTime2N, TypeDateTimeOffset:
+		col.Scale, err = r.readByte()
+		if err != nil {
+			return col, fmt.Errorf("error reading time scale: %w", err)
+		}
+		if col.Scale > 7 {
+			return col, fmt.Errorf("invalid time scale %d", col.Scale)
+		}
+
+	case TypeNVarChar, TypeNChar, TypeBigVarChar, TypeBigChar, TypeBigVarBinary, TypeBigBinary:
+		size, err := r.readUint16()
+		if err != nil {
+			return col, fmt.Errorf("error reading type size: %w", err)
+		}
+		col.Size = int(size)
+		if size == sizeMax {
+			col.Size = -1
+		}
+
+		if col.Type != TypeBigVarBinary && col.Type != TypeBigBinary {
+			// Collation; values are decoded as UCS-2 or Latin-1 regardless
+			if _, err := r.readBytes(len(defaultCollation)); err != nil {
+				return col, fmt.Errorf("error reading collation: %w", err)
+			}
+		}
+
+	default:
+		return col, fmt.Errorf("unsupported data type: %#02x", typeByte)
+	}
+
+	return col, nil
+}
+
+// parseRPCValue parses a parameter value in the wire format of its TYPE_INFO
+// SQL NULL is returned as nil. Integers are returned as int64, floats as float64,
+// BIT as bool, binary data as []byte and everything else as text in the form
+// SQLite stores it: exact decimals, uppercase GUIDs and ISO dates and times
+func parseRPCValue(r *payloadReader, col *ColumnInfo) (interface{}, error) {
+	switch col.Type {
+	case TypeNull:
+		return nil, nil
+
+	case TypeNVarChar, TypeNChar, TypeBigVarChar, TypeBigChar, TypeBigVarBinary, TypeBigBinary:
+		var data []byte
+		var err error
+		if col.IsMax() {
+			data, err = r.readPLP()
+		} else {
+			data, err = r.readUSVarbyte()
+		}
+		if err != nil || data == nil {
+			return nil, err
+		}
+
+		switch col.Type {
+		case TypeNVarChar, TypeNChar:
+			return DecodeUCS2(data), nil
+		case TypeBigVarChar, TypeBigChar:
+			return decodeLatin1(data), nil
+		default:
+			return data, nil
+		}
+	}
+
+	// Fixed-length types carry no length prefix; the rest have a one-byte length
+	dataType := col.Type
+	length := col.Size
+	if nullable, ok := nullableTypes[col.Type]; ok {
+		dataType = nullable
+	} else {
+		n, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		length = int(n)
+	}
+
+	data, err := r.readBytes(length)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeValue(dataType, col, data)
+}
+
+// decodeValue decodes the bytes of a non-NULL value of a nullable type
+func decodeValue(dataType DataType, col *ColumnInfo, data []byte) (interface{}, error) {
+	switch dataType {
+	case TypeBitN:
+		if len(data) == 1 {
+			return data[0] != 0, nil
+		}
+
+	case TypeIntN:
+		switch len(data) {
+		case 1:
+			return int64(data[0]), nil // TINYINT is unsigned
+		case 2:
+			return int64(int16(binary.LittleEndian.Uint16(data))), nil
+		case 4:
+			return int64(int32(binary.LittleEndian.Uint32(data))), nil
+		case 8:
+			return int64(binary.LittleEndian.Uint64(data)), nil
+		}
+
+	case TypeFltN:
+		switch len(data) {
+		case 4:
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(data))), nil
+		case 8:
+			return math.Float64frombits(binary.LittleEndian.Uint64(data)), nil
+		}
+
+	case TypeMoneyN:
+		switch len(data) {
+		case 4:
+			return formatDecimal(big.NewInt(int64(int32(binary.LittleEndian.Uint32(data)))), moneyScale), nil
+		case 8:
+			// High 32 bits first
+			v := int64(binary.LittleEndian.Uint32(data))<<32 | int64(binary.LittleEndian.Uint32(data[4:]))
+			return formatDecimal(big.NewInt(v), moneyScale), nil
+		}
+
+	case TypeDecimalN, TypeNumericN:
+		if len(data) >= 2 {
+			// Sign byte (1 = positive), then the little-endian magnitude
+			magnitude := make([]byte, len(data)-1)
+			for i, b := range data[1:] {
+				magnitude[len(magnitude)-1-i] = b
+			}
+			v := new(big.Int).SetBytes(magnitude)
+			if data[0] == 0 {
+				v.Neg(v)
+			}
+			return formatDecimal(v, col.Scale), nil
+		}
+
+	case TypeGUID:
+		if len(data) == 16 {
+			return formatGUID(data), nil
+		}
+
+	case TypeDateTimeN:
+		switch len(data) {
+		case 4:
+			days := binary.LittleEndian.Uint16(data)
+			minutes := binary.LittleEndian.Uint16(data[2:])
+			t := datetimeEpoch.AddDate(0, 0, int(days)).Add(time.Duration(minutes) * time.Minute)
+			return t.Format(smallDatetimeLayout), nil
+		case 8:
+			days := int32(binary.LittleEndian.Uint32(data))
+			ticks := int64(binary.LittleEndian.Uint32(data[4:])) // 1/300 second
+			ms := (ticks*10 + 1) / 3
+			t := datetimeEpoch.AddDate(0, 0, int(days)).Add(time.Duration(ms) * time.Millisecond)
+			return t.Format(datetimeLayout), nil
+		}
+
+	case TypeDateN:
+		if len(data) == 3 {
+			return decodeDate(data).Format(dateLayout), nil
+		}
+
+	case TypeTimeN:
+		if len(data) == timeSize(col.Scale) {
+			return dayZero.Add(decodeClock(data, col.Scale)).Format(clockLayout + fractionLayout(col.Scale)), nil
+		}
+
+	case TypeDateTime2N:
+		n := timeSize(col.Scale)
+		if len(data) == n+3 {
+			t := decodeDate(data[n:]).Add(decodeClock(data[:n], col.Scale))
+			return t.Format(dateLayout + " " + clockLayout + fractionLayout(col.Scale)), nil
+		}
+
+	case TypeDateTimeOffset:
+		n := timeSize(col.Scale)
+		if len(data) == n+5 {
+			// Date and time are UTC; the offset gives the client's local time
+			utc := decodeDate(data[n : n+3]).Add(decodeClock(data[:n], col.Scale))
+			offset := int(int16(binary.LittleEndian.Uint16(data[n+3:])))
+			t := utc.In(time.FixedZone("", offset*60))
+			return t.Format(dateLayout + " " + clockLayout + fractionLayout(col.Scale) + "-07:00"), nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid length %d for type %#02x", len(data), byte(dataType))
+}
+
+// timeSize returns the byte length of a TIME value with the given scale
+func timeSize(scale byte) int {
+	switch {
+	case scale <= 2:
+		return 3
+	case scale <= 4:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// decodeClock decodes a TIME value counted in units of 10^-scale seconds
+func decodeClock(data []byte, scale byte) time.Duration {
+	var units uint64
+	for i := len(data) - 1; i >= 0; i-- {
+		units = units<<8 | uint64(data[i])
+	}
+	for ; scale < 7; scale++ {
+		units *= 10
+	}
+	return time.Duration(units) * 100 // 100ns ticks
+}
+
+// decodeDate decodes a 3-byte count of days since 0001-01-01
+func decodeDate(data []byte) time.Time {
+	days := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+	return dayZero.AddDate(0, 0, days)
+}
+
+// fractionLayout returns the time layout of fractional seconds with scale digits
+func fractionLayout(scale byte) string {
+	if scale == 0 {
+		return ""
+	}
+	return "." + strings.Repeat("0", int(scale))
+}
+
+// formatDecimal formats an unscaled integer with scale decimal places
+func formatDecimal(v *big.Int, scale byte) string {
+	digits := new(big.Int).Abs(v).String()
+	if scale > 0 {
+		if len(digits) <= int(scale) {
+			digits = strings.Repeat("0", int(scale)-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-int(scale)] + "." + digits[len(digits)-int(scale):]
+	}
+	if v.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// formatGUID formats a UNIQUEIDENTIFIER; the inverse of toGUID
+func formatGUID(data []byte) string {
+	return fmt.Sprintf("%08X-%04X-%04X-%X-%X",
+		binary.LittleEndian.Uint32(data[0:4]),
+		binary.LittleEndian.Uint16(data[4:6]),
+		binary.LittleEndian.Uint16(data[6:8]),
+		data[8:10], data[10:16])
+}
+
+// decodeLatin1 decodes single-byte character data
+func decodeLatin1(data []byte) string {
+	var sb strings.Builder
+	for _, b := range data {
+		sb.WriteRune(rune(b))
+	}
+	return sb.String()
+}
@@ -0,0 +1,114 @@
+package tds
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// EnvChangeType represents the type of an ENVCHANGE token
+type EnvChangeType byte
+
+const (
+	EnvChangeDatabase       EnvChangeType = 1
+	EnvChangeLanguage       EnvChangeType = 2
+	EnvChangeCharset        EnvChangeType = 3
+	EnvChangePacketSize     EnvChangeType = 4
+	EnvChangeSQLCollation   EnvChangeType = 7
+	EnvChangeBeginTran      EnvChangeType = 8
+	EnvChangeCommitTran     EnvChangeType = 9
+	EnvChangeRollbackTran   EnvChangeType = 10
+	EnvChangeEnlistDTC      EnvChangeType = 11
+	EnvChangeDefectTran     EnvChangeType = 12
+	EnvChangeMirrorPartner  EnvChangeType = 13
+	EnvChangePromoteTran    EnvChangeType = 15
+	EnvChangeTranMgrAddress EnvChangeType = 16
+	EnvChangeTranEnded      EnvChangeType = 17
+	EnvChangeResetConnAck   EnvChangeType = 18
+	EnvChangeUserInstance   EnvChangeType = 19
+	EnvChangeRouting        EnvChangeType = 20
+)
+
+// Session defaults reported to clients
+const (
+	DefaultDatabase = "master"
+	DefaultLanguage = "us_english"
+)
+
+// DefaultCollation returns the SQL collation reported at login (Latin1_General_CI_AS)
+func DefaultCollation() []byte {
+	return append([]byte(nil), defaultCollation...)
+}
+
+// EnvChangeDatabase writes a database ENVCHANGE
+func (ts *TokenStream) EnvChangeDatabase(newDatabase, oldDatabase string) {
+	ts.envChangeString(EnvChangeDatabase, newDatabase, oldDatabase)
+}
+
+// EnvChangeLanguage writes a language ENVCHANGE
+func (ts *TokenStream) EnvChangeLanguage(newLanguage, oldLanguage string) {
+	ts.envChangeString(EnvChangeLanguage, newLanguage, oldLanguage)
+}
+
+// EnvChangePacketSize writes a packet size ENVCHANGE; sizes are sent as decimal text
+func (ts *TokenStream) EnvChangePacketSize(newSize, oldSize int) {
+	ts.envChangeString(EnvChangePacketSize, strconv.Itoa(newSize), strconv.Itoa(oldSize))
+}
+
+// EnvChangeCollation writes a SQL collation ENVCHANGE
+func (ts *TokenStream) EnvChangeCollation(newCollation, oldCollation []byte) {
+	ts.envChangeBytes(EnvChangeSQLCollation, newCollation, oldCollation)
+}
+
+// EnvChangeBeginTran writes a begin transaction ENVCHANGE carrying the new descriptor
+func (ts *TokenStream) EnvChangeBeginTran(descriptor uint64) {
+	ts.envChangeBytes(EnvChangeBeginTran, transactionDescriptor(descriptor), nil)
+}
+
+// EnvChangeCommitTran writes a commit transaction ENVCHANGE carrying the ended descriptor
+func (ts *TokenStream) EnvChangeCommitTran(descriptor uint64) {
+	ts.envChangeBytes(EnvChangeCommitTran, nil, transactionDescriptor(descriptor))
+}
+
+// EnvChangeRollbackTran writes a rollback transaction ENVCHANGE carrying the ended descriptor
+func (ts *TokenStream) EnvChangeRollbackTran(descriptor uint64) {
+	ts.envChangeBytes(EnvChangeRollbackTran, nil, transactionDescriptor(descriptor))
+}
+
+// EnvChangeResetConnAck acknowledges a RESETCONNECTION request; it has no values
+func (ts *TokenStream) EnvChangeResetConnAck() {
+	ts.envChangeBytes(EnvChangeResetConnAck, nil, nil)
+}
+
+// transactionDescriptor encodes a transaction descriptor as sent in ENVCHANGE and ALL_HEADERS
+func transactionDescriptor(descriptor uint64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, descriptor)
+}
+
+// envChangeString writes an ENVCHANGE whose values are B_VARCHARs
+func (ts *TokenStream) envChangeString(envType EnvChangeType, newValue, oldValue string) {
+	newEncoded := EncodeUCS2(newValue)
+	oldEncoded := EncodeUCS2(oldValue)
+
+	// Type(1) + NewValue(1 + n) + OldValue(1 + n)
+	length := 1 + 1 + len(newEncoded) + 1 + len(oldEncoded)
+
+	ts.writeByte(byte(TokenTypeEnvChange))
+	ts.writeUint16(uint16(length))
+	ts.writeByte(byte(envType))
+	ts.writeBVarchar(newValue)
+	ts.writeBVarchar(oldValue)
+}
+
+// envChangeBytes writes an ENVCHANGE whose values are B_VARBYTEs
+func (ts *TokenStream) envChangeBytes(envType EnvChangeType, newValue, oldValue []byte) {
+	// Type(1) + NewValue(1 + n) + OldValue(1 + n)
+	length := 1 + 1 + len(newValue) + 1 + len(oldValue)
+
+	ts.writeByte(byte(TokenTypeEnvChange))
+	ts.writeUint16(uint16(length))
+	ts.writeByte(byte(envType))
+	ts.writeByte(byte(len(newValue)))
+	ts.buf = append(ts.buf, newValue...)
+	ts.writeByte(byte(len(oldValue)))
+	ts.buf = append(ts.buf, oldValue...)
+}
@@ -0,0 +1,68 @@
+package tds
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEnvChange(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(ts *TokenStream)
+		want  []byte
+	}{
+		{
+			name:  "database",
+			write: func(ts *TokenStream) { ts.EnvChangeDatabase("db", "master") },
+			want: []byte{
+				0xE3, 0x13, 0x00, 0x01,
+				0x02, 'd', 0, 'b', 0,
+				0x06, 'm', 0, 'a', 0, 's', 0, 't', 0, 'e', 0, 'r', 0,
+			},
+		},
+		{
+			name:  "packet size",
+			write: func(ts *TokenStream) { ts.EnvChangePacketSize(8192, 4096) },
+			want: []byte{
+				0xE3, 0x13, 0x00, 0x04,
+				0x04, '8', 0, '1', 0, '9', 0, '2', 0,
+				0x04, '4', 0, '0', 0, '9', 0, '6', 0,
+			},
+		},
+		{
+			name:  "collation",
+			write: func(ts *TokenStream) { ts.EnvChangeCollation(DefaultCollation(), nil) },
+			want:  []byte{0xE3, 0x08, 0x00, 0x07, 0x05, 0x09, 0x04, 0xD0, 0x00, 0x34, 0x00},
+		},
+		{
+			name:  "begin transaction",
+			write: func(ts *TokenStream) { ts.EnvChangeBeginTran(0x0102030405060708) },
+			want:  []byte{0xE3, 0x0B, 0x00, 0x08, 0x08, 0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01, 0x00},
+		},
+		{
+			name:  "commit transaction",
+			write: func(ts *TokenStream) { ts.EnvChangeCommitTran(1) },
+			want:  []byte{0xE3, 0x0B, 0x00, 0x09, 0x00, 0x08, 0x01, 0, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:  "rollback transaction",
+			write: func(ts *TokenStream) { ts.EnvChangeRollbackTran(2) },
+			want:  []byte{0xE3, 0x0B, 0x00, 0x0A, 0x00, 0x08, 0x02, 0, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:  "reset connection acknowledgment",
+			write: func(ts *TokenStream) { ts.EnvChangeResetConnAck() },
+			want:  []byte{0xE3, 0x03, 0x00, 0x12, 0x00, 0x00},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewTokenStream()
+			tt.write(ts)
+			if got := ts.Bytes(); !bytes.Equal(got, tt.want) {
+				t.Errorf("token = % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
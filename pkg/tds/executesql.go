@@ -0,0 +1,174 @@
+package tds
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/factory/mssql-tds-server/pkg/sqlerror"
+)
+
+// ParamDeclaration is one entry of a parameter declaration list such as
+// "@id INT, @name NVARCHAR(50) OUTPUT"
+type ParamDeclaration struct {
+	Name     string // Including the leading @
+	TypeName string
+	Output   bool
+}
+
+// ParseParamDeclarations parses the @params argument of sp_executesql
+func ParseParamDeclarations(decl string) ([]ParamDeclaration, error) {
+	var decls []ParamDeclaration
+
+	for _, item := range splitTopLevel(decl, ',') {
+		fields := strings.Fields(item)
+		if len(fields) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(fields[0], "@") || len(fields) < 2 {
+			return nil, sqlerror.New(sqlerror.SyntaxError, sqlerror.ClassSyntax,
+				"Incorrect syntax near '%s'.", fields[0])
+		}
+
+		d := ParamDeclaration{Name: fields[0]}
+		typeFields := fields[1:]
+		if strings.EqualFold(typeFields[0], "AS") {
+			typeFields = typeFields[1:]
+		}
+		if n := len(typeFields); n > 0 {
+			last := strings.ToUpper(typeFields[n-1])
+			if last == "OUTPUT" || last == "OUT" {
+				d.Output = true
+				typeFields = typeFields[:n-1]
+			}
+		}
+		if len(typeFields) == 0 {
+			return nil, sqlerror.New(sqlerror.SyntaxError, sqlerror.ClassSyntax,
+				"Incorrect syntax near '%s'.", d.Name)
+		}
+		d.TypeName = strings.Join(typeFields, " ")
+
+		decls = append(decls, d)
+	}
+
+	return decls, nil
+}
+
+// splitTopLevel splits s on sep, ignoring separators inside parentheses
+// such as the comma in DECIMAL(10, 2)
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// BindParameters matches the value parameters of an sp_executesql call to
+// their declarations and returns them as named arguments for the executor
+// Named values are matched case-insensitively; unnamed values bind by position
+func BindParameters(decls []ParamDeclaration, params []*RPCParameter, statement, declaration string) ([]interface{}, error) {
+	values := make(map[string]*RPCParameter, len(params))
+	for i, param := range params {
+		if param.Name == "" {
+			if i >= len(decls) {
+				return nil, sqlerror.New(sqlerror.TooManyArguments, sqlerror.ClassUserError,
+					"Procedure or function sp_executesql has too many arguments specified.")
+			}
+			values[strings.ToLower(decls[i].Name)] = param
+			continue
+		}
+		values[strings.ToLower(param.Name)] = param
+	}
+
+	args := make([]interface{}, 0, len(decls))
+	for _, d := range decls {
+		param, ok := values[strings.ToLower(d.Name)]
+		if !ok {
+			return nil, sqlerror.New(sqlerror.ParameterNotSupplied, sqlerror.ClassUserError,
+				"The parameterized query '(%s)%s' expects the parameter '%s', which was not supplied.",
+				declaration, statement, d.Name)
+		}
+		delete(values, strings.ToLower(d.Name))
+		args = append(args, sql.Named(strings.TrimPrefix(d.Name, "@"), param.Value))
+	}
+
+	for _, param := range params {
+		if _, ok := values[strings.ToLower(param.Name)]; ok && param.Name != "" {
+			return nil, sqlerror.New(sqlerror.NotAParameter, sqlerror.ClassUserError,
+				"%s is not a parameter for procedure sp_executesql.", param.Name)
+		}
+	}
+
+	return args, nil
+}
+
+// ExecuteSQL executes an sp_executesql request
+// The first parameter is the statement, the second the parameter declaration
+// list and the rest are the values bound to the declared parameters
+func (qp *QueryProcessor) ExecuteSQL(ctx context.Context, req *RPCRequest) (*RPCResult, error) {
+	if len(req.Params) == 0 {
+		return nil, missingParameter(req.ProcName, "@statement")
+	}
+
+	statement, err := textParam(req.Params[0], "@statement")
+	if err != nil {
+		return nil, err
+	}
+
+	var args []interface{}
+	if len(req.Params) > 1 {
+		declaration, err := textParam(req.Params[1], "@params")
+		if err != nil {
+			return nil, err
+		}
+
+		decls, err := ParseParamDeclarations(declaration)
+		if err != nil {
+			return nil, err
+		}
+
+		args, err = BindParameters(decls, req.Params[2:], statement, declaration)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results, err := qp.ExecuteSQLBatch(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RPCResult{Results: results}, nil
+}
+
+// textParam returns the value of a statement or declaration parameter
+// NULL is treated as empty text
+func textParam(param *RPCParameter, name string) (string, error) {
+	if param.Value == nil {
+		return "", nil
+	}
+	text, ok := param.Value.(string)
+	if !ok {
+		return "", sqlerror.New(sqlerror.StatementNotText, sqlerror.ClassUserError,
+			"Procedure expects parameter '%s' of type 'ntext/nchar/nvarchar'.", name)
+	}
+	return text, nil
+}
+
+// missingParameter reports a system procedure parameter that was not supplied
+func missingParameter(procName, name string) error {
+	return sqlerror.New(sqlerror.MissingParameter, sqlerror.ClassUserError,
+		"Procedure or function '%s' expects parameter '%s', which was not supplied.", procName, name)
+}
@@ -0,0 +1,86 @@
+package tds
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/factory/mssql-tds-server/pkg/sqlerror"
+)
+
+func TestParseParamDeclarations(t *testing.T) {
+	decls, err := ParseParamDeclarations("@id INT, @amount decimal(10, 2),@name AS NVARCHAR(50) OUTPUT")
+	if err != nil {
+		t.Fatalf("ParseParamDeclarations() error = %v", err)
+	}
+
+	want := []ParamDeclaration{
+		{Name: "@id", TypeName: "INT"},
+		{Name: "@amount", TypeName: "decimal(10, 2)"},
+		{Name: "@name", TypeName: "NVARCHAR(50)", Output: true},
+	}
+	if !reflect.DeepEqual(decls, want) {
+		t.Errorf("ParseParamDeclarations() = %+v, want %+v", decls, want)
+	}
+
+	if _, err := ParseParamDeclarations("id INT"); err == nil {
+		t.Error("ParseParamDeclarations(missing @) error = nil, want error")
+	}
+}
+
+func TestBindParameters(t *testing.T) {
+	decls := []ParamDeclaration{{Name: "@id", TypeName: "INT"}, {Name: "@Name", TypeName: "NVARCHAR(10)"}}
+	param := func(name string, value interface{}) *RPCParameter {
+		p := &RPCParameter{Value: value}
+		p.Name = name
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		params  []*RPCParameter
+		want    []interface{}
+		errorNo int32
+	}{
+		{
+			name:   "by name",
+			params: []*RPCParameter{param("@name", "x"), param("@ID", int64(1))},
+			want:   []interface{}{sql.Named("id", int64(1)), sql.Named("Name", "x")},
+		},
+		{
+			name:   "by position",
+			params: []*RPCParameter{param("", int64(2)), param("", nil)},
+			want:   []interface{}{sql.Named("id", int64(2)), sql.Named("Name", nil)},
+		},
+		{
+			name:    "missing",
+			params:  []*RPCParameter{param("@id", int64(1))},
+			errorNo: sqlerror.ParameterNotSupplied,
+		},
+		{
+			name:    "undeclared",
+			params:  []*RPCParameter{param("@id", int64(1)), param("@name", "x"), param("@other", "y")},
+			errorNo: sqlerror.NotAParameter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := BindParameters(decls, tt.params, "SELECT @id", "@id INT, @Name NVARCHAR(10)")
+			if tt.errorNo != 0 {
+				var sqlErr *sqlerror.Error
+				if !errors.As(err, &sqlErr) || sqlErr.Number != tt.errorNo {
+					t.Fatalf("BindParameters() error = %v, want error %d", err, tt.errorNo)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BindParameters() error = %v", err)
+			}
+			if !reflect.DeepEqual(args, tt.want) {
+				t.Errorf("BindParameters() = %+v, want %+v", args, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package tds
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// LOGIN7 fixed header size (up to and including cbSSPILong)
+const login7HeaderSize = 94
+
+// TDS protocol versions
+const (
+	VersionTDS71 uint32 = 0x71000001
+	VersionTDS72 uint32 = 0x72090002
+	VersionTDS73 uint32 = 0x730B0003
+	VersionTDS74 uint32 = 0x74000004
+)
+
+// OptionFlags1 bits
+const (
+	OptionFlag1UseDB   = 0x20 // fUseDB: fail if initial database can't be used
+	OptionFlag1SetLang = 0x80 // fSetLang: warn on language change
+)
+
+// OptionFlags2 bits
+const (
+	OptionFlag2LanguageFatal = 0x01
+	OptionFlag2ODBC          = 0x02
+	OptionFlag2IntSecurity   = 0x80 // Integrated (SSPI) security
+)
+
+// OptionFlags3 bits
+const (
+	OptionFlag3ChangePassword = 0x01
+	OptionFlag3Extension      = 0x10 // ibExtension points to FeatureExt
+)
+
+// Login7Request represents a decoded LOGIN7 message
+type Login7Request struct {
+	TDSVersion     uint32
+	PacketSize     uint32
+	ClientProgVer  uint32
+	ClientPID      uint32
+	ConnectionID   uint32
+	OptionFlags1   byte
+	OptionFlags2   byte
+	TypeFlags      byte
+	OptionFlags3   byte
+	ClientTimeZone int32
+	ClientLCID     uint32
+
+	HostName       string
+	UserName       string
+	Password       string
+	AppName        string
+	ServerName     string
+	CltIntName     string
+	Language       string
+	Database       string
+	ClientID       []byte
+	SSPI           []byte
+	AttachDBFile   string
+	ChangePassword string
+	FeatureExt     []byte
+}
+
+// ParseLogin7Request parses a LOGIN7 message payload
+func ParseLogin7Request(data []byte) (*Login7Request, error) {
+	if len(data) < login7HeaderSize {
+		return nil, fmt.Errorf("LOGIN7 too short: %d bytes", len(data))
+	}
+
+	length := binary.LittleEndian.Uint32(data[0:4])
+	if int(length) > len(data) {
+		return nil, fmt.Errorf("LOGIN7 length %d exceeds message size %d", length, len(data))
+	}
+
+	req := &Login7Request{
+		TDSVersion:     binary.LittleEndian.Uint32(data[4:8]),
+		PacketSize:     binary.LittleEndian.Uint32(data[8:12]),
+		ClientProgVer:  binary.LittleEndian.Uint32(data[12:16]),
+		ClientPID:      binary.LittleEndian.Uint32(data[16:20]),
+		ConnectionID:   binary.LittleEndian.Uint32(data[20:24]),
+		OptionFlags1:   data[24],
+		OptionFlags2:   data[25],
+		TypeFlags:      data[26],
+		OptionFlags3:   data[27],
+		ClientTimeZone: int32(binary.LittleEndian.Uint32(data[28:32])),
+		ClientLCID:     binary.LittleEndian.Uint32(data[32:36]),
+	}
+
+	// Offset/length table: lengths are in UTF-16 characters
+	var err error
+	readString := func(pos int) string {
+		if err != nil {
+			return ""
+		}
+		var raw []byte
+		raw, err = login7Field(data, pos, 2)
+		return DecodeUCS2(raw)
+	}
+
+	req.HostName = readString(36)
+	req.UserName = readString(40)
+
+	rawPassword, pwErr := login7Field(data, 44, 2)
+	if pwErr != nil {
+		return nil, fmt.Errorf("error reading password: %w", pwErr)
+	}
+	req.Password = DecodePassword(rawPassword)
+
+	req.AppName = readString(48)
+	req.ServerName = readString(52)
+	req.CltIntName = readString(60)
+	req.Language = readString(64)
+	req.Database = readString(68)
+	req.ClientID = append([]byte(nil), data[72:78]...)
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading LOGIN7 field: %w", err)
+	}
+
+	// SSPI length is in bytes; cbSSPILong takes over when cbSSPI is 0xFFFF
+	sspiOffset := int(binary.LittleEndian.Uint16(data[78:80]))
+	sspiLength := int(binary.LittleEndian.Uint16(data[80:82]))
+	if sspiLength == 0xFFFF {
+		sspiLength = int(binary.LittleEndian.Uint32(data[90:94]))
+	}
+	if sspiLength > 0 {
+		if sspiOffset+sspiLength > len(data) {
+			return nil, fmt.Errorf("SSPI data out of range")
+		}
+		req.SSPI = append([]byte(nil), data[sspiOffset:sspiOffset+sspiLength]...)
+	}
+
+	req.AttachDBFile = readString(82)
+
+	rawChangePassword, cpErr := login7Field(data, 86, 2)
+	if cpErr != nil {
+		return nil, fmt.Errorf("error reading change password: %w", cpErr)
+	}
+	req.ChangePassword = DecodePassword(rawChangePassword)
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading LOGIN7 field: %w", err)
+	}
+
+	// FeatureExt: ibExtension points to a DWORD holding the real offset
+	if req.OptionFlags3&OptionFlag3Extension != 0 {
+		extOffset := int(binary.LittleEndian.Uint16(data[56:58]))
+		extLength := int(binary.LittleEndian.Uint16(data[58:60]))
+		if extLength >= 4 && extOffset+4 <= len(data) {
+			featureOffset := int(binary.LittleEndian.Uint32(data[extOffset : extOffset+4]))
+			if featureOffset > 0 && featureOffset < len(data) {
+				req.FeatureExt = append([]byte(nil), data[featureOffset:]...)
+			}
+		}
+	}
+
+	return req, nil
+}
+
+// login7Field returns the raw bytes of an offset/length pair at pos
+// unitSize is the number of bytes per length unit (2 for UTF-16 strings)
+func login7Field(data []byte, pos int, unitSize int) ([]byte, error) {
+	offset := int(binary.LittleEndian.Uint16(data[pos : pos+2]))
+	length := int(binary.LittleEndian.Uint16(data[pos+2:pos+4])) * unitSize
+	if length == 0 {
+		return nil, nil
+	}
+	if offset+length > len(data) {
+		return nil, fmt.Errorf("field at %d out of range (offset=%d, length=%d)", pos, offset, length)
+	}
+	return data[offset : offset+length], nil
+}
+
+// DecodePassword reverses the LOGIN7 password obfuscation
+// Each byte was nibble-swapped and then XORed with 0xA5 by the client
+func DecodePassword(data []byte) string {
+	decoded := make([]byte, len(data))
+	for i, b := range data {
+		b ^= 0xA5
+		decoded[i] = b<<4 | b>>4
+	}
+	return DecodeUCS2(decoded)
+}
+
+// EncodePassword applies the LOGIN7 password obfuscation
+func EncodePassword(password string) []byte {
+	encoded := EncodeUCS2(password)
+	for i, b := range encoded {
+		encoded[i] = (b<<4 | b>>4) ^ 0xA5
+	}
+	return encoded
+}
+
+// NegotiateVersion returns the TDS version the server will acknowledge
+func NegotiateVersion(clientVersion uint32) uint32 {
+	// TDS 8.0 clients report 0x08000000, which also falls outside the 7.x range
+	if clientVersion < 0x70000000 || clientVersion > VersionTDS74 {
+		return VersionTDS74
+	}
+	return clientVersion
+}
@@ -0,0 +1,115 @@
+package tds
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// buildLogin7 builds a LOGIN7 payload the way a client driver would
+func buildLogin7(user, password, host, app, database string) []byte {
+	fields := []struct {
+		pos  int
+		data []byte
+	}{
+		{36, EncodeUCS2(host)},
+		{40, EncodeUCS2(user)},
+		{44, EncodePassword(password)},
+		{48, EncodeUCS2(app)},
+		{68, EncodeUCS2(database)},
+	}
+
+	data := make([]byte, login7HeaderSize)
+	binary.LittleEndian.PutUint32(data[4:8], VersionTDS74)
+	binary.LittleEndian.PutUint32(data[8:12], 4096)
+
+	for _, f := range fields {
+		binary.LittleEndian.PutUint16(data[f.pos:], uint16(len(data)))
+		binary.LittleEndian.PutUint16(data[f.pos+2:], uint16(len(f.data)/2))
+		data = append(data, f.data...)
+	}
+	binary.LittleEndian.PutUint32(data[0:4], uint32(len(data)))
+
+	return data
+}
+
+func TestParseLogin7Request(t *testing.T) {
+	data := buildLogin7("sa", "P@ssw0rd!", "workstation", "go-mssqldb", "sales")
+
+	req, err := ParseLogin7Request(data)
+	if err != nil {
+		t.Fatalf("ParseLogin7Request() error = %v", err)
+	}
+
+	if req.UserName != "sa" {
+		t.Errorf("UserName = %q, want %q", req.UserName, "sa")
+	}
+	if req.Password != "P@ssw0rd!" {
+		t.Errorf("Password = %q, want %q", req.Password, "P@ssw0rd!")
+	}
+	if req.HostName != "workstation" {
+		t.Errorf("HostName = %q, want %q", req.HostName, "workstation")
+	}
+	if req.AppName != "go-mssqldb" {
+		t.Errorf("AppName = %q, want %q", req.AppName, "go-mssqldb")
+	}
+	if req.Database != "sales" {
+		t.Errorf("Database = %q, want %q", req.Database, "sales")
+	}
+	if req.TDSVersion != VersionTDS74 {
+		t.Errorf("TDSVersion = %#x, want %#x", req.TDSVersion, VersionTDS74)
+	}
+	if req.PacketSize != 4096 {
+		t.Errorf("PacketSize = %d, want 4096", req.PacketSize)
+	}
+}
+
+func TestParseLogin7RequestInvalid(t *testing.T) {
+	if _, err := ParseLogin7Request([]byte{0x01, 0x02}); err == nil {
+		t.Error("expected error for short LOGIN7")
+	}
+
+	data := buildLogin7("sa", "", "", "", "")
+	// Point the user name past the end of the message
+	binary.LittleEndian.PutUint16(data[40:], uint16(len(data)))
+	binary.LittleEndian.PutUint16(data[42:], 10)
+	if _, err := ParseLogin7Request(data); err == nil {
+		t.Error("expected error for out of range field")
+	}
+}
+
+func TestPasswordObfuscation(t *testing.T) {
+	tests := []string{"", "secret", "pässwörd", "P@ssw0rd!"}
+
+	for _, password := range tests {
+		t.Run(password, func(t *testing.T) {
+			if got := DecodePassword(EncodePassword(password)); got != password {
+				t.Errorf("DecodePassword(EncodePassword(%q)) = %q", password, got)
+			}
+		})
+	}
+
+	// "a" is 0x61 0x00 in UTF-16LE: swapped 0x16 0x00, XOR 0xA5 -> 0xB3 0xA5
+	encoded := EncodePassword("a")
+	if encoded[0] != 0xB3 || encoded[1] != 0xA5 {
+		t.Errorf("EncodePassword(\"a\") = %#v, want []byte{0xb3, 0xa5}", encoded)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		client uint32
+		want   uint32
+	}{
+		{VersionTDS74, VersionTDS74},
+		{VersionTDS73, VersionTDS73},
+		{VersionTDS71, VersionTDS71},
+		{0x08000000, VersionTDS74},
+		{0, VersionTDS74},
+	}
+
+	for _, tt := range tests {
+		if got := NegotiateVersion(tt.client); got != tt.want {
+			t.Errorf("NegotiateVersion(%#x) = %#x, want %#x", tt.client, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package tds
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Packet size limits
+const (
+	HeaderSize        = 8
+	DefaultPacketSize = 4096
+	MinPacketSize     = 512
+	MaxPacketSize     = 32767
+)
+
+// DefaultMaxMessagePackets limits a message read from the client to this many
+// packets of the negotiated size, so that a client that never sets EOM cannot
+// make the server allocate without bound
+const DefaultMaxMessagePackets = 16384
+
+// Message represents a complete TDS message reassembled from one or more packets
+type Message struct {
+	Type    PacketType
+	Status  PacketStatus // Status of the first packet (carries the reset bits)
+	SPID    uint16
+	Data    []byte
+	Packets int
+
+	// Body streams the rest of a BULK_LOAD message, which can be far larger
+	// than anything else a client sends: Data holds only its first packet.
+	// Body must be drained or closed before the next message is read; it is
+	// nil for other messages
+	Body *MessageBody
+}
+
+// MessageBody reads the remaining packets of a streamed message
+type MessageBody struct {
+	mr   *MessageReader
+	eom  bool
+	done chan struct{}
+}
+
+func newMessageBody(mr *MessageReader, eom bool) *MessageBody {
+	b := &MessageBody{mr: mr, done: make(chan struct{})}
+	if eom {
+		b.finish()
+	}
+	return b
+}
+
+// Next returns the payload of the message's next packet, or io.EOF after the last one
+func (b *MessageBody) Next() ([]byte, error) {
+	if b.eom {
+		return nil, io.EOF
+	}
+
+	packet, err := b.mr.ReadPacket()
+	if err != nil {
+		b.finish()
+		return nil, err
+	}
+	if packet.Header.Type != PacketTypeBulkLoad {
+		b.finish()
+		return nil, fmt.Errorf("packet type changed mid-message: %#02x then %#02x", PacketTypeBulkLoad, packet.Header.Type)
+	}
+
+	if packet.Header.Status&StatusEOM != 0 {
+		b.finish()
+		if packet.Header.Status&StatusIgnore != 0 {
+			return nil, ErrMessageIgnored
+		}
+	}
+	return packet.Data, nil
+}
+
+// Close discards the rest of the message
+func (b *MessageBody) Close() error {
+	for {
+		if _, err := b.Next(); err != nil {
+			if err == io.EOF || err == ErrMessageIgnored {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Done is closed once the last packet of the message has been read
+func (b *MessageBody) Done() <-chan struct{} {
+	return b.done
+}
+
+func (b *MessageBody) finish() {
+	if !b.eom {
+		b.eom = true
+		close(b.done)
+	}
+}
+
+// ErrMessageIgnored is returned by MessageBody.Next when the client cancels a
+// streamed message by setting the ignore bit on its last packet
+var ErrMessageIgnored = errors.New("message ignored by the client")
+
+// ErrMessageTooLarge is returned by ReadMessage when a message grows past the
+// reader's maximum size; the connection cannot be resynchronized after it
+var ErrMessageTooLarge = errors.New("protocol error: message exceeds the maximum size")
+
+// MessageReader reads complete TDS messages from a stream
+type MessageReader struct {
+	r       io.Reader
+	maxSize int
+}
+
+// NewMessageReader creates a new message reader limited to
+// DefaultMaxMessagePackets packets of the default size
+func NewMessageReader(r io.Reader) *MessageReader {
+	return &MessageReader{r: r, maxSize: DefaultMaxMessagePackets * DefaultPacketSize}
+}
+
+// SetMaxMessageSize sets the largest message payload ReadMessage accepts;
+// 0 removes the limit. Streamed BULK_LOAD bodies are not limited
+func (mr *MessageReader) SetMaxMessageSize(maxSize int) {
+	mr.maxSize = maxSize
+}
+
+// ReadPacket reads exactly one TDS packet
+func (mr *MessageReader) ReadPacket() (*Packet, error) {
+	headerBuf := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(mr.r, headerBuf); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	header, err := ParseHeader(headerBuf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse header: %w", err)
+	}
+
+	if header.Length < HeaderSize {
+		return nil, fmt.Errorf("invalid packet length %d", header.Length)
+	}
+
+	data := make([]byte, int(header.Length)-HeaderSize)
+	if _, err := io.ReadFull(mr.r, data); err != nil {
+		return nil, fmt.Errorf("failed to read data: %w", err)
+	}
+
+	return &Packet{
+		Header: header,
+		Data:   data,
+	}, nil
+}
+
+// ReadMessage reads packets until end of message and joins their payloads
+// Messages the client flagged with the ignore bit are discarded. BULK_LOAD
+// messages are returned after their first packet; see Message.Body
+func (mr *MessageReader) ReadMessage() (*Message, error) {
+	for {
+		packet, err := mr.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+
+		msg := &Message{
+			Type:    packet.Header.Type,
+			Status:  packet.Header.Status,
+			SPID:    packet.Header.SPID,
+			Data:    packet.Data,
+			Packets: 1,
+		}
+
+		eom := packet.Header.Status&StatusEOM != 0
+		if msg.Type == PacketTypeBulkLoad && !(eom && packet.Header.Status&StatusIgnore != 0) {
+			msg.Body = newMessageBody(mr, eom)
+			return msg, nil
+		}
+
+		for packet.Header.Status&StatusEOM == 0 {
+			packet, err = mr.ReadPacket()
+			if err != nil {
+				return nil, err
+			}
+
+			if packet.Header.Type != msg.Type {
+				return nil, fmt.Errorf("packet type changed mid-message: %#02x then %#02x", msg.Type, packet.Header.Type)
+			}
+
+			if mr.maxSize > 0 && len(msg.Data)+len(packet.Data) > mr.maxSize {
+				return nil, fmt.Errorf("%w (%d bytes)", ErrMessageTooLarge, mr.maxSize)
+			}
+
+			msg.Data = append(msg.Data, packet.Data...)
+			msg.Packets++
+		}
+
+		if packet.Header.Status&StatusIgnore != 0 {
+			continue
+		}
+
+		return msg, nil
+	}
+}
+
+// MessageWriter splits outgoing messages into packets of the negotiated size
+type MessageWriter struct {
+	w          io.Writer
+	packetSize int
+	spid       uint16
+}
+
+// NewMessageWriter creates a new message writer
+func NewMessageWriter(w io.Writer, packetSize int) *MessageWriter {
+	mw := &MessageWriter{w: w}
+	mw.SetPacketSize(packetSize)
+	return mw
+}
+
+// SetPacketSize sets the maximum packet size (header included)
+func (mw *MessageWriter) SetPacketSize(packetSize int) {
+	mw.packetSize = ClampPacketSize(packetSize)
+}
+
+// PacketSize returns the maximum packet size
+func (mw *MessageWriter) PacketSize() int {
+	return mw.packetSize
+}
+
+// SetSPID sets the server process ID written into packet headers
+func (mw *MessageWriter) SetSPID(spid uint16) {
+	mw.spid = spid
+}
+
+// WriteMessage writes data as one message, split into packets with increasing PacketID
+func (mw *MessageWriter) WriteMessage(packetType PacketType, data []byte) error {
+	maxData := mw.packetSize - HeaderSize
+	packetID := uint8(1)
+
+	for {
+		chunk := data
+		status := StatusEOM
+		if len(chunk) > maxData {
+			chunk = chunk[:maxData]
+			status = 0
+		}
+
+		packet := NewPacket(packetType, status, packetID, chunk)
+		packet.Header.SPID = mw.spid
+		if _, err := mw.w.Write(packet.Serialize()); err != nil {
+			return err
+		}
+
+		data = data[len(chunk):]
+		if status == StatusEOM {
+			return nil
+		}
+
+		packetID++ // Wraps around after 255
+	}
+}
+
+// ClampPacketSize limits a requested packet size to the range allowed by TDS
+func ClampPacketSize(packetSize int) int {
+	if packetSize == 0 {
+		return DefaultPacketSize
+	}
+	if packetSize < MinPacketSize {
+		return MinPacketSize
+	}
+	if packetSize > MaxPacketSize {
+		return MaxPacketSize
+	}
+	return packetSize
+}
+
+// Conn wraps a network connection with TDS message framing
+type Conn struct {
+	net.Conn
+	reader     *MessageReader
+	writer     *MessageWriter
+	maxPackets int
+}
+
+// NewConn creates a TDS connection using the default packet size
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{
+		Conn:       conn,
+		reader:     NewMessageReader(conn),
+		writer:     NewMessageWriter(conn, DefaultPacketSize),
+		maxPackets: DefaultMaxMessagePackets,
+	}
+}
+
+// ReadMessage reads the next complete message from the client
+func (c *Conn) ReadMessage() (*Message, error) {
+	return c.reader.ReadMessage()
+}
+
+// WriteMessage writes a complete message to the client
+func (c *Conn) WriteMessage(packetType PacketType, data []byte) error {
+	return c.writer.WriteMessage(packetType, data)
+}
+
+// SetPacketSize sets the negotiated packet size for outgoing messages and
+// scales the limit on incoming messages with it
+func (c *Conn) SetPacketSize(packetSize int) {
+	c.writer.SetPacketSize(packetSize)
+	c.reader.SetMaxMessageSize(c.maxPackets * c.writer.PacketSize())
+}
+
+// SetMaxMessagePackets limits incoming messages to maxPackets packets of the
+// negotiated size; 0 removes the limit
+func (c *Conn) SetMaxMessagePackets(maxPackets int) {
+	c.maxPackets = maxPackets
+	c.reader.SetMaxMessageSize(maxPackets * c.writer.PacketSize())
+}
+
+// PacketSize returns the negotiated packet size
+func (c *Conn) PacketSize() int {
+	return c.writer.PacketSize()
+}
+
+// SetSPID sets the server process ID written into outgoing packet headers
+func (c *Conn) SetSPID(spid uint16) {
+	c.writer.SetSPID(spid)
+}
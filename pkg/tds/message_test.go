@@ -0,0 +1,207 @@
+package tds
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestMessageWriterSplitsPackets(t *testing.T) {
+	var out bytes.Buffer
+	mw := NewMessageWriter(&out, MinPacketSize)
+
+	payload := bytes.Repeat([]byte("0123456789"), 200) // 2000 bytes
+	if err := mw.WriteMessage(PacketTypeTabular, payload); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+
+	// 504 data bytes per packet -> 4 packets
+	data := out.Bytes()
+	packetID := uint8(1)
+	var joined []byte
+	for len(data) > 0 {
+		header, err := ParseHeader(data)
+		if err != nil {
+			t.Fatalf("ParseHeader() error = %v", err)
+		}
+		if int(header.Length) > MinPacketSize {
+			t.Errorf("packet length %d exceeds packet size %d", header.Length, MinPacketSize)
+		}
+		if header.PacketID != packetID {
+			t.Errorf("PacketID = %d, want %d", header.PacketID, packetID)
+		}
+
+		last := len(data) == int(header.Length)
+		if last != (header.Status&StatusEOM != 0) {
+			t.Errorf("packet %d: EOM = %v, want %v", packetID, !last, last)
+		}
+
+		joined = append(joined, data[HeaderSize:header.Length]...)
+		data = data[header.Length:]
+		packetID++
+	}
+
+	if packetID-1 != 4 {
+		t.Errorf("wrote %d packets, want 4", packetID-1)
+	}
+	if !bytes.Equal(joined, payload) {
+		t.Error("joined packet data does not match payload")
+	}
+}
+
+func TestMessageReaderReassembles(t *testing.T) {
+	var out bytes.Buffer
+	mw := NewMessageWriter(&out, MinPacketSize)
+
+	first := bytes.Repeat([]byte{0xAB}, 5000)
+	second := []byte("SELECT 1")
+	mw.WriteMessage(PacketTypeSQLBatch, first)
+	mw.WriteMessage(PacketTypeRPC, second)
+
+	// One byte at a time exercises short reads
+	mr := NewMessageReader(iotest.OneByteReader(&out))
+
+	msg, err := mr.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if msg.Type != PacketTypeSQLBatch {
+		t.Errorf("Type = %#02x, want %#02x", msg.Type, PacketTypeSQLBatch)
+	}
+	if !bytes.Equal(msg.Data, first) {
+		t.Errorf("Data length = %d, want %d", len(msg.Data), len(first))
+	}
+	if msg.Packets != 10 {
+		t.Errorf("Packets = %d, want 10", msg.Packets)
+	}
+
+	msg, err = mr.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if msg.Type != PacketTypeRPC || string(msg.Data) != "SELECT 1" {
+		t.Errorf("second message = %#02x %q", msg.Type, msg.Data)
+	}
+
+	if _, err := mr.ReadMessage(); err == nil {
+		t.Error("expected error at end of stream")
+	}
+}
+
+func TestMessageReaderSkipsIgnoredMessage(t *testing.T) {
+	var out bytes.Buffer
+	out.Write(NewPacket(PacketTypeSQLBatch, 0, 1, []byte("partial")).Serialize())
+	out.Write(NewPacket(PacketTypeSQLBatch, StatusEOM|StatusIgnore, 2, nil).Serialize())
+	out.Write(NewPacket(PacketTypeSQLBatch, StatusEOM, 1, []byte("next")).Serialize())
+
+	msg, err := NewMessageReader(&out).ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if string(msg.Data) != "next" {
+		t.Errorf("Data = %q, want %q", msg.Data, "next")
+	}
+}
+
+// endlessMessage is a stream of packets that never sets EOM
+type endlessMessage struct {
+	packet []byte
+	pos    int
+}
+
+func (e *endlessMessage) Read(p []byte) (int, error) {
+	n := copy(p, e.packet[e.pos:])
+	e.pos = (e.pos + n) % len(e.packet)
+	return n, nil
+}
+
+func TestMessageReaderLimitsMessageSize(t *testing.T) {
+	var out bytes.Buffer
+	NewMessageWriter(&out, MinPacketSize).WriteMessage(PacketTypeSQLBatch, make([]byte, 2000))
+
+	mr := NewMessageReader(&out)
+	mr.SetMaxMessageSize(2000)
+	if _, err := mr.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage() error = %v for a message at the limit", err)
+	}
+
+	payload := make([]byte, MinPacketSize-HeaderSize)
+	mr = NewMessageReader(&endlessMessage{packet: NewPacket(PacketTypeSQLBatch, 0, 1, payload).Serialize()})
+	mr.SetMaxMessageSize(10 * len(payload))
+	if _, err := mr.ReadMessage(); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("ReadMessage() error = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestMessageReaderStreamsBulkLoad(t *testing.T) {
+	var out bytes.Buffer
+	out.Write(NewPacket(PacketTypeBulkLoad, 0, 1, []byte("one")).Serialize())
+	out.Write(NewPacket(PacketTypeBulkLoad, 0, 2, []byte("two")).Serialize())
+	out.Write(NewPacket(PacketTypeBulkLoad, StatusEOM, 3, []byte("three")).Serialize())
+	out.Write(NewPacket(PacketTypeSQLBatch, StatusEOM, 1, []byte("next")).Serialize())
+
+	mr := NewMessageReader(&out)
+	msg, err := mr.ReadMessage()
+	if err != nil || msg.Body == nil || string(msg.Data) != "one" {
+		t.Fatalf("ReadMessage() = %+v, %v, want the first packet and a body", msg, err)
+	}
+
+	for _, want := range []string{"two", "three"} {
+		data, err := msg.Body.Next()
+		if err != nil || string(data) != want {
+			t.Fatalf("Next() = %q, %v, want %q", data, err, want)
+		}
+	}
+	if _, err := msg.Body.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, want EOF", err)
+	}
+	select {
+	case <-msg.Body.Done():
+	default:
+		t.Error("Done() not closed after the last packet")
+	}
+
+	msg, err = mr.ReadMessage()
+	if err != nil || msg.Body != nil || string(msg.Data) != "next" {
+		t.Errorf("ReadMessage() = %+v, %v, want the next batch", msg, err)
+	}
+}
+
+func TestMessageBodyClose(t *testing.T) {
+	var out bytes.Buffer
+	out.Write(NewPacket(PacketTypeBulkLoad, 0, 1, []byte("one")).Serialize())
+	out.Write(NewPacket(PacketTypeBulkLoad, 0, 2, []byte("two")).Serialize())
+	out.Write(NewPacket(PacketTypeBulkLoad, StatusEOM|StatusIgnore, 3, nil).Serialize())
+	out.Write(NewPacket(PacketTypeSQLBatch, StatusEOM, 1, []byte("next")).Serialize())
+
+	mr := NewMessageReader(&out)
+	msg, _ := mr.ReadMessage()
+	if err := msg.Body.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	msg, err := mr.ReadMessage()
+	if err != nil || string(msg.Data) != "next" {
+		t.Errorf("ReadMessage() = %+v, %v, want the next batch", msg, err)
+	}
+}
+
+func TestClampPacketSize(t *testing.T) {
+	tests := []struct {
+		size int
+		want int
+	}{
+		{0, DefaultPacketSize},
+		{100, MinPacketSize},
+		{8000, 8000},
+		{65536, MaxPacketSize},
+	}
+
+	for _, tt := range tests {
+		if got := ClampPacketSize(tt.size); got != tt.want {
+			t.Errorf("ClampPacketSize(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
@@ -11,7 +11,7 @@ import (
 type PacketType byte
 
 const (
-	PacketTypePreLogin  PacketType = 0x04
+	PacketTypePreLogin  PacketType = 0x12
 	PacketTypeLogin     PacketType = 0x10
 	PacketTypeSQLBatch  PacketType = 0x01
 	PacketTypeRPC       PacketType = 0x03
@@ -96,6 +96,7 @@ func ParsePacket(data []byte) (*Packet, error) {
 }
 
 // Serialize serializes a complete TDS packet to bytes
+// Data must fit in a single packet; MessageWriter splits larger payloads
 func (p *Packet) Serialize() []byte {
 	p.Header.Length = uint16(8 + len(p.Data))
 	buf := make([]byte, p.Header.Length)
@@ -159,8 +160,8 @@ func (b *Buffer) ReadUint32() (uint32, error) {
 }
 
 // WriteByte writes a single byte
-func (b *Buffer) WriteByte(v byte) {
-	b.buf.WriteByte(v)
+func (b *Buffer) WriteByte(v byte) error {
+	return b.buf.WriteByte(v)
 }
 
 // WriteBytes writes bytes
@@ -19,9 +19,10 @@ const (
 
 // Encryption level constants
 const (
-	EncryptionOff      = 0x00 // No encryption (cleartext)
-	EncryptionOn       = 0x01 // SSL/TLS encryption if supported
-	EncryptionRequired = 0x02 // Encryption required (reject if not supported)
+	EncryptionOff          = 0x00 // Encryption available but off (login packet only)
+	EncryptionOn           = 0x01 // SSL/TLS encryption if supported
+	EncryptionNotSupported = 0x02 // Encryption not available (cleartext)
+	EncryptionRequired     = 0x03 // Encryption required (reject if not supported)
 )
 
 // PreLoginOption represents a pre-login option
@@ -115,8 +116,11 @@ func ParsePreLoginRequest(data []byte) (*PreLoginRequest, error) {
 // SerializePreLoginResponse serializes a pre-login response
 func SerializePreLoginResponse(resp *PreLoginResponse) []byte {
 
-	// Build data section
-	dataOffset := uint16(0)
+	// Option table: 5 options of 5 bytes each plus the terminator
+	headerSize := 5*5 + 1
+
+	// Build data section (offsets are relative to the start of the message)
+	dataOffset := uint16(headerSize)
 
 	// Version (6 bytes)
 	versionData := []byte{0x09, 0x00, 0x00, 0x00, 0x00, 0x00} // TDS 7.3
@@ -153,16 +157,7 @@ func SerializePreLoginResponse(resp *PreLoginResponse) []byte {
 	marsOffset := dataOffset
 	dataOffset += uint16(len(marsData))
 
-	// Calculate header size
-	headerSize := 0
-	headerSize += 5 // Version option
-	headerSize += 5 // Encryption option
-	headerSize += 5 // Instance option
-	headerSize += 5 // Thread ID option
-	headerSize += 5 // MARS option
-	headerSize += 1 // Terminator
-
-	totalSize := headerSize + int(dataOffset)
+	totalSize := int(dataOffset)
 	result := make([]byte, totalSize)
 	offset := 0
 
@@ -243,9 +238,9 @@ func SerializePreLoginResponse(resp *PreLoginResponse) []byte {
 func DefaultPreLoginResponse(encryption byte) *PreLoginResponse {
 	return &PreLoginResponse{
 		Version:    []byte{0x09, 0x00, 0x00, 0x00, 0x00, 0x00},
-		Encryption: encryption, // Encryption level (0x00=OFF, 0x01=ON, 0x02=REQUIRED)
+		Encryption: encryption, // Encryption level (0x00=OFF, 0x01=ON, 0x02=NOT_SUP, 0x03=REQ)
 		Instance:   []byte("MSSQLServer"),
 		ThreadID:   []byte{0x00, 0x00, 0x00, 0x00},
-		MARS:       0x00, // No MARS
+		MARS:       0x00, // Set by the server when the client asks for MARS
 	}
 }
@@ -0,0 +1,270 @@
+package tds
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/factory/mssql-tds-server/pkg/sqlerror"
+	"github.com/factory/mssql-tds-server/pkg/sqlexecutor"
+)
+
+// PreparedStatement is a statement prepared with sp_prepare or sp_prepexec
+type PreparedStatement struct {
+	Handle      int32
+	SQL         string
+	Declaration string // The @params declaration list as sent
+	Params      []ParamDeclaration
+	Stmt        *sql.Stmt
+}
+
+// PreparedStatements holds the prepared statement handles of one connection
+// Handles are numbered from 1 and never reused while the connection is open
+type PreparedStatements struct {
+	lastHandle int32
+	stmts      map[int32]*PreparedStatement
+}
+
+// NewPreparedStatements creates an empty handle table
+func NewPreparedStatements() *PreparedStatements {
+	return &PreparedStatements{stmts: make(map[int32]*PreparedStatement)}
+}
+
+// add registers a prepared statement and assigns its handle
+func (ps *PreparedStatements) add(p *PreparedStatement) int32 {
+	ps.lastHandle++
+	p.Handle = ps.lastHandle
+	ps.stmts[p.Handle] = p
+	return p.Handle
+}
+
+// Get returns the statement prepared under handle
+func (ps *PreparedStatements) Get(handle int32) (*PreparedStatement, error) {
+	p, ok := ps.stmts[handle]
+	if !ok {
+		return nil, sqlerror.New(sqlerror.PreparedStatementNotFound, sqlerror.ClassUserError,
+			"Could not find prepared statement with handle %d.", handle)
+	}
+	return p, nil
+}
+
+// Remove closes and forgets the statement prepared under handle
+func (ps *PreparedStatements) Remove(handle int32) error {
+	p, err := ps.Get(handle)
+	if err != nil {
+		return err
+	}
+	delete(ps.stmts, handle)
+	return p.Stmt.Close()
+}
+
+// Close closes every prepared statement; called when the connection ends
+func (ps *PreparedStatements) Close() error {
+	var errs []error
+	for handle, p := range ps.stmts {
+		errs = append(errs, p.Stmt.Close())
+		delete(ps.stmts, handle)
+	}
+	return errors.Join(errs...)
+}
+
+// Len returns the number of prepared statements
+func (ps *PreparedStatements) Len() int {
+	return len(ps.stmts)
+}
+
+// ReturnValue is an OUTPUT parameter value sent back in a RETURNVALUE token
+type ReturnValue struct {
+	Ordinal uint16 // Position of the parameter in the RPC
+	Name    string
+	Column  ColumnInfo
+	Value   interface{}
+}
+
+// NewReturnValue returns value as the RETURNVALUE of an OUTPUT parameter
+// It keeps the parameter's declared type when value can be sent as that type;
+// otherwise, or when declared is nil, the type is inferred from value
+func NewReturnValue(ordinal uint16, name string, declared *ColumnInfo, value interface{}) ReturnValue {
+	return ReturnValue{
+		Ordinal: ordinal,
+		Name:    name,
+		Column:  returnColumn(declared, value),
+		Value:   value,
+	}
+}
+
+// returnColumn picks the type of an OUTPUT parameter's RETURNVALUE
+func returnColumn(declared *ColumnInfo, value interface{}) ColumnInfo {
+	if declared != nil {
+		col := *declared
+		col.Nullable = true
+		if nullable, ok := nullableTypes[col.Type]; ok {
+			col.Type = nullable
+		}
+
+		// Sizes follow the value, as they do for result columns
+		switch col.Type {
+		case TypeFltN:
+			col.Size = 8
+		case TypeDateTime2N:
+			col.Scale = datetime2Scale
+		case TypeNVarChar:
+			col.Size = stringSize(int64(col.Size/2), []interface{}{value})
+		case TypeBigVarBinary:
+			col.Size = binarySize(int64(col.Size), []interface{}{value})
+		}
+
+		switch col.Type {
+		case TypeIntN, TypeBitN, TypeFltN, TypeDecimalN, TypeNumericN, TypeDateTime2N,
+			TypeGUID, TypeNVarChar, TypeBigVarBinary:
+			if acceptsAll(&col, []interface{}{value}) {
+				return col
+			}
+		}
+	}
+
+	return inferColumn(ColumnInfo{Nullable: true}, []interface{}{value})
+}
+
+// RPCResult is the outcome of a procedure call
+type RPCResult struct {
+	Results      []*sqlexecutor.ExecuteResult // In execution order; empty when no statement was executed
+	ReturnStatus int32
+	ReturnValues []ReturnValue
+}
+
+// Prepare executes an sp_prepare request:
+// @handle INT OUTPUT, @params NVARCHAR, @stmt NVARCHAR [, @options INT]
+func (qp *QueryProcessor) Prepare(ctx context.Context, stmts *PreparedStatements, req *RPCRequest) (*RPCResult, error) {
+	p, err := qp.prepare(ctx, stmts, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RPCResult{ReturnValues: []ReturnValue{handleReturnValue(req.Params[0], p.Handle)}}, nil
+}
+
+// PrepExec executes an sp_prepexec request, which prepares a statement and
+// runs it once: @handle INT OUTPUT, @params NVARCHAR, @stmt NVARCHAR, values...
+func (qp *QueryProcessor) PrepExec(ctx context.Context, stmts *PreparedStatements, req *RPCRequest) (*RPCResult, error) {
+	p, err := qp.prepare(ctx, stmts, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := qp.executePrepared(ctx, p, req.Params[3:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &RPCResult{
+		Results:      []*sqlexecutor.ExecuteResult{result},
+		ReturnValues: []ReturnValue{handleReturnValue(req.Params[0], p.Handle)},
+	}, nil
+}
+
+// ExecutePrepared executes an sp_execute request: @handle INT, values...
+func (qp *QueryProcessor) ExecutePrepared(ctx context.Context, stmts *PreparedStatements, req *RPCRequest) (*RPCResult, error) {
+	handle, err := handleParam(req, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := stmts.Get(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := qp.executePrepared(ctx, p, req.Params[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &RPCResult{Results: []*sqlexecutor.ExecuteResult{result}}, nil
+}
+
+// Unprepare executes an sp_unprepare request: @handle INT
+func (qp *QueryProcessor) Unprepare(stmts *PreparedStatements, req *RPCRequest) (*RPCResult, error) {
+	handle, err := handleParam(req, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stmts.Remove(handle); err != nil {
+		return nil, err
+	}
+
+	return &RPCResult{}, nil
+}
+
+// prepare prepares the statement of an sp_prepare or sp_prepexec request and
+// registers it under a new handle
+func (qp *QueryProcessor) prepare(ctx context.Context, stmts *PreparedStatements, req *RPCRequest) (*PreparedStatement, error) {
+	if len(req.Params) < 3 {
+		return nil, missingParameter(req.ProcName, []string{"@handle", "@params", "@stmt"}[len(req.Params)])
+	}
+
+	declaration, err := textParam(req.Params[1], "@params")
+	if err != nil {
+		return nil, err
+	}
+
+	statement, err := textParam(req.Params[2], "@stmt")
+	if err != nil {
+		return nil, err
+	}
+
+	decls, err := ParseParamDeclarations(declaration)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := qp.executor.PrepareContext(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PreparedStatement{
+		SQL:         statement,
+		Declaration: declaration,
+		Params:      decls,
+		Stmt:        stmt,
+	}
+	stmts.add(p)
+
+	return p, nil
+}
+
+// executePrepared binds the value parameters and runs a prepared statement
+func (qp *QueryProcessor) executePrepared(ctx context.Context, p *PreparedStatement, params []*RPCParameter) (*sqlexecutor.ExecuteResult, error) {
+	args, err := BindParameters(p.Params, params, p.SQL, p.Declaration)
+	if err != nil {
+		return nil, err
+	}
+
+	return qp.executor.ExecutePreparedContext(ctx, p.Stmt, p.SQL, args...)
+}
+
+// handleParam returns the prepared statement handle passed at position i
+func handleParam(req *RPCRequest, i int) (int32, error) {
+	if len(req.Params) <= i {
+		return 0, missingParameter(req.ProcName, "@handle")
+	}
+
+	handle, ok := req.Params[i].Value.(int64)
+	if !ok {
+		return 0, sqlerror.New(sqlerror.PreparedStatementNotFound, sqlerror.ClassUserError,
+			"Could not find prepared statement with handle %v.", req.Params[i].Value)
+	}
+	return int32(handle), nil
+}
+
+// handleReturnValue returns a new handle through the @handle OUTPUT parameter
+func handleReturnValue(param *RPCParameter, handle int32) ReturnValue {
+	return ReturnValue{
+		Ordinal: 0,
+		Name:    param.Name,
+		Column:  ColumnInfo{Type: TypeIntN, Size: 4, Nullable: true},
+		Value:   int64(handle),
+	}
+}
@@ -0,0 +1,125 @@
+package tds
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/factory/mssql-tds-server/pkg/sqlerror"
+	"github.com/factory/mssql-tds-server/pkg/sqlexecutor"
+)
+
+func newTestQueryProcessor(t *testing.T) *QueryProcessor {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	qp := NewQueryProcessor()
+	qp.SetExecutor(sqlexecutor.NewExecutor(db, nil))
+	return qp
+}
+
+// testRPC builds an RPC request with positional parameters
+func testRPC(procName string, values ...interface{}) *RPCRequest {
+	req := &RPCRequest{ProcName: procName}
+	for _, v := range values {
+		req.Params = append(req.Params, &RPCParameter{Value: v})
+	}
+	return req
+}
+
+func TestPreparedStatements(t *testing.T) {
+	qp := newTestQueryProcessor(t)
+	stmts := NewPreparedStatements()
+	defer stmts.Close()
+	ctx := context.Background()
+
+	result, err := qp.Prepare(ctx, stmts, testRPC("sp_prepare", nil, "@a INT, @b NVARCHAR(10)", "SELECT @a + 1, @b", int64(1)))
+	if err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	if len(result.ReturnValues) != 1 || result.ReturnValues[0].Value != int64(1) {
+		t.Fatalf("Prepare() return values = %+v, want handle 1", result.ReturnValues)
+	}
+
+	for _, a := range []int64{1, 41} {
+		result, err = qp.ExecutePrepared(ctx, stmts, testRPC("sp_execute", int64(1), a, "x"))
+		if err != nil {
+			t.Fatalf("ExecutePrepared() error = %v", err)
+		}
+		rows := result.Results[0].Rows
+		if len(rows) != 1 || rows[0][0] != a+1 || rows[0][1] != "x" {
+			t.Errorf("ExecutePrepared(%d) rows = %v, want [[%d x]]", a, rows, a+1)
+		}
+	}
+
+	result, err = qp.PrepExec(ctx, stmts, testRPC("sp_prepexec", nil, "@v INT", "SELECT @v * 2", int64(21)))
+	if err != nil {
+		t.Fatalf("PrepExec() error = %v", err)
+	}
+	if result.ReturnValues[0].Value != int64(2) || result.Results[0].Rows[0][0] != int64(42) {
+		t.Errorf("PrepExec() = handle %v rows %v, want handle 2 rows [[42]]", result.ReturnValues[0].Value, result.Results[0].Rows)
+	}
+
+	if _, err := qp.Unprepare(stmts, testRPC("sp_unprepare", int64(1))); err != nil {
+		t.Fatalf("Unprepare() error = %v", err)
+	}
+	if stmts.Len() != 1 {
+		t.Errorf("Len() = %d after unprepare, want 1", stmts.Len())
+	}
+
+	_, err = qp.ExecutePrepared(ctx, stmts, testRPC("sp_execute", int64(1), int64(1), "x"))
+	var sqlErr *sqlerror.Error
+	if !errors.As(err, &sqlErr) || sqlErr.Number != sqlerror.PreparedStatementNotFound {
+		t.Errorf("ExecutePrepared(unprepared) error = %v, want error %d", err, sqlerror.PreparedStatementNotFound)
+	}
+}
+
+func TestPrepareMissingStatement(t *testing.T) {
+	qp := newTestQueryProcessor(t)
+	stmts := NewPreparedStatements()
+
+	_, err := qp.Prepare(context.Background(), stmts, testRPC("sp_prepare", nil, ""))
+	var sqlErr *sqlerror.Error
+	if !errors.As(err, &sqlErr) || sqlErr.Number != sqlerror.MissingParameter {
+		t.Errorf("Prepare() error = %v, want error %d", err, sqlerror.MissingParameter)
+	}
+	if stmts.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", stmts.Len())
+	}
+}
+
+func TestNewReturnValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		declared *ColumnInfo
+		value    interface{}
+		want     ColumnInfo
+	}{
+		{"declared int", &ColumnInfo{Type: TypeInt4, Size: 4}, int64(7), ColumnInfo{Type: TypeIntN, Size: 4, Nullable: true}},
+		{"declared nvarchar", &ColumnInfo{Type: TypeNVarChar, Size: 20}, "abc", ColumnInfo{Type: TypeNVarChar, Size: 20, Nullable: true}},
+		{"nvarchar too short", &ColumnInfo{Type: TypeNVarChar, Size: 2}, "abc", ColumnInfo{Type: TypeNVarChar, Size: maxVarSize, Nullable: true}},
+		{"int too small", &ColumnInfo{Type: TypeIntN, Size: 1}, int64(300), ColumnInfo{Type: TypeIntN, Size: 4, Nullable: true}},
+		{"string for int", &ColumnInfo{Type: TypeIntN, Size: 4}, "n=1", ColumnInfo{Type: TypeNVarChar, Size: maxVarSize, Nullable: true}},
+		{"undeclared", nil, 1.5, ColumnInfo{Type: TypeFltN, Size: 8, Nullable: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rv := NewReturnValue(1, "@v", tt.declared, tt.value)
+			if rv.Column != tt.want {
+				t.Errorf("Column = %+v, want %+v", rv.Column, tt.want)
+			}
+
+			ts := NewTokenStream()
+			if err := ts.ReturnValue(rv.Ordinal, rv.Name, &rv.Column, rv.Value); err != nil {
+				t.Errorf("ReturnValue() error = %v", err)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package tds
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -24,12 +25,16 @@ func (qp *QueryProcessor) SetExecutor(executor *sqlexecutor.Executor) {
 
 // ProcessQuery processes a SQL query and returns results
 // This is the main entry point for SQL query execution
-func (qp *QueryProcessor) ProcessQuery(query string) ([][]string, error) {
-	return qp.ExecuteSQLBatch(query)
+func (qp *QueryProcessor) ProcessQuery(ctx context.Context, query string) ([]*sqlexecutor.ExecuteResult, error) {
+	return qp.ExecuteSQLBatch(ctx, query)
 }
 
-// ExecuteSQLBatch executes a SQL batch command
-func (qp *QueryProcessor) ExecuteSQLBatch(batch string) ([][]string, error) {
+// ExecuteSQLBatch executes a SQL batch command and returns one result per statement
+// Values are returned as the driver produced them; SQL NULL stays nil
+// Cancelling ctx (on Attention) interrupts the running statement
+// args are bound to the batch's parameters (see ExecuteSQL)
+// On error the results of the statements that ran before it are returned too
+func (qp *QueryProcessor) ExecuteSQLBatch(ctx context.Context, batch string, args ...interface{}) ([]*sqlexecutor.ExecuteResult, error) {
 	batch = strings.TrimSpace(batch)
 	if batch == "" {
 		return nil, fmt.Errorf("empty query")
@@ -40,47 +45,11 @@ func (qp *QueryProcessor) ExecuteSQLBatch(batch string) ([][]string, error) {
 		return nil, fmt.Errorf("SQL executor not initialized")
 	}
 
-	// Execute the query using the SQL executor
-	result, err := qp.executor.Execute(batch)
+	// Execute the batch using the SQL executor
+	results, err := qp.executor.ExecuteBatchContext(ctx, batch, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %w", err)
+		return results, fmt.Errorf("failed to execute query: %w", err)
 	}
 
-	// If it's a query (SELECT), return the rows
-	if result.IsQuery {
-		return convertResultRows(result), nil
-	}
-
-	// If it's a non-query (INSERT, UPDATE, DELETE, DDL), return a message row
-	return [][]string{{result.Message}}, nil
-}
-
-// convertResultRows converts ExecuteResult rows to [][]string format
-func convertResultRows(result *sqlexecutor.ExecuteResult) [][]string {
-	if result == nil {
-		return [][]string{}
-	}
-
-	rows := make([][]string, 0, len(result.Rows)+1)
-
-	// Add column headers as the first row
-	if len(result.Columns) > 0 {
-		rows = append(rows, result.Columns)
-	}
-
-	// Add data rows
-	for _, row := range result.Rows {
-		stringRow := make([]string, len(row))
-		for i, value := range row {
-			stringRow[i] = sqlexecutor.ConvertValueToString(value)
-		}
-		rows = append(rows, stringRow)
-	}
-
-	// If no rows, return empty result set
-	if len(rows) == 0 {
-		rows = append(rows, []string{"No rows found"})
-	}
-
-	return rows
+	return results, nil
 }
@@ -0,0 +1,380 @@
+package tds
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/factory/mssql-tds-server/pkg/sqlexecutor"
+	"github.com/factory/mssql-tds-server/pkg/sqlparser"
+)
+
+// CurCmd values reported in DONE tokens
+const (
+	CurCmdSelect uint16 = 0xC1
+	CurCmdInsert uint16 = 0xC3
+	CurCmdDelete uint16 = 0xC4
+	CurCmdUpdate uint16 = 0xC5
+)
+
+// ResultSet is a typed result set ready to be sent as COLMETADATA and ROW tokens
+type ResultSet struct {
+	Columns []ColumnInfo
+	Rows    [][]interface{}
+}
+
+// NewResultSet builds a typed result set from an executor result
+// Column types come from the declared types and are checked against the values;
+// a column whose values don't fit its declared type is typed from the values instead
+func NewResultSet(result *sqlexecutor.ExecuteResult) *ResultSet {
+	rs := &ResultSet{
+		Columns: make([]ColumnInfo, len(result.Columns)),
+		Rows:    result.Rows,
+	}
+
+	for i, name := range result.Columns {
+		var declared sqlexecutor.Column
+		if i < len(result.ColumnTypes) {
+			declared = result.ColumnTypes[i]
+		}
+
+		values := make([]interface{}, len(result.Rows))
+		for r, row := range result.Rows {
+			if i < len(row) {
+				values[r] = row[i]
+			}
+		}
+
+		col := resolveColumn(declared, values)
+		col.Name = name
+		rs.Columns[i] = col
+	}
+
+	return rs
+}
+
+// NewStringResultSet builds a result set of NVARCHAR columns
+func NewStringResultSet(columns []string, rows [][]string) *ResultSet {
+	rs := &ResultSet{
+		Columns: make([]ColumnInfo, len(columns)),
+		Rows:    make([][]interface{}, len(rows)),
+	}
+
+	for i, name := range columns {
+		rs.Columns[i] = ColumnInfo{Name: name, Type: TypeNVarChar, Nullable: true}
+	}
+
+	for r, row := range rows {
+		values := make([]interface{}, len(columns))
+		for i := range columns {
+			if i < len(row) {
+				values[i] = row[i]
+			}
+		}
+		rs.Rows[r] = values
+	}
+
+	for i := range rs.Columns {
+		rs.Columns[i].Size = stringSize(0, columnValues(rs.Rows, i))
+	}
+
+	return rs
+}
+
+// columnValues returns the values of one column
+func columnValues(rows [][]interface{}, index int) []interface{} {
+	values := make([]interface{}, len(rows))
+	for r, row := range rows {
+		values[r] = row[index]
+	}
+	return values
+}
+
+// resolveColumn picks the TDS type for a column
+func resolveColumn(declared sqlexecutor.Column, values []interface{}) ColumnInfo {
+	col := ColumnInfo{Nullable: true}
+	if declared.TypeName != "" {
+		col.Nullable = declared.Nullable
+	}
+
+	switch declared.TypeName {
+	case "BIGINT", "INT8", "UNSIGNED BIG INT":
+		col.Type, col.Size = TypeIntN, 8
+	case "INT", "INTEGER", "MEDIUMINT", "INT4":
+		col.Type, col.Size = TypeIntN, 4
+	case "SMALLINT", "INT2":
+		col.Type, col.Size = TypeIntN, 2
+	case "TINYINT":
+		col.Type, col.Size = TypeIntN, 1
+	case "BIT", "BOOL", "BOOLEAN":
+		col.Type, col.Size = TypeBitN, 1
+	case "FLOAT", "REAL", "DOUBLE", "DOUBLE PRECISION":
+		col.Type, col.Size = TypeFltN, 8
+	case "DECIMAL", "NUMERIC", "MONEY", "SMALLMONEY":
+		col.Type = TypeDecimalN
+		col.Precision, col.Scale = decimalPrecision(declared)
+		col.Size = decimalSize(col.Precision)
+	case "DATE", "DATETIME", "DATETIME2", "SMALLDATETIME", "TIMESTAMP":
+		col.Type, col.Scale = TypeDateTime2N, datetime2Scale
+	case "UNIQUEIDENTIFIER", "UUID", "GUID":
+		col.Type, col.Size = TypeGUID, 16
+	case "BLOB", "BINARY", "VARBINARY", "IMAGE":
+		col.Type = TypeBigVarBinary
+		col.Size = binarySize(declared.Length, values)
+	case "CHAR", "VARCHAR", "NCHAR", "NVARCHAR", "TEXT", "NTEXT", "CLOB",
+		"CHARACTER", "VARYING CHARACTER", "NATIVE CHARACTER":
+		col.Type = TypeNVarChar
+		col.Size = stringSize(declared.Length, values)
+	default:
+		return inferColumn(col, values)
+	}
+
+	// SQLite columns can hold values of any type; fall back when they don't fit
+	if col.Type == TypeIntN {
+		col.Size = widenInt(col.Size, values)
+	}
+	if !acceptsAll(&col, values) {
+		return inferColumn(col, values)
+	}
+
+	return col
+}
+
+// inferColumn types a column from its values alone (expressions, untyped or mismatched columns)
+// Mixed or unrecognised values are sent as NVARCHAR
+func inferColumn(col ColumnInfo, values []interface{}) ColumnInfo {
+	var kind DataType
+	for _, v := range values {
+		var k DataType
+		switch v.(type) {
+		case nil:
+			continue
+		case int64, int, int32, int16, int8, uint8, uint16, uint32:
+			k = TypeIntN
+		case float64, float32:
+			k = TypeFltN
+		case bool:
+			k = TypeBitN
+		case time.Time:
+			k = TypeDateTime2N
+		case []byte:
+			k = TypeBigVarBinary
+		default:
+			k = TypeNVarChar
+		}
+
+		switch {
+		case kind == 0:
+			kind = k
+		case kind == k:
+		case (kind == TypeIntN && k == TypeFltN) || (kind == TypeFltN && k == TypeIntN):
+			kind = TypeFltN
+		default:
+			kind = TypeNVarChar
+		}
+	}
+
+	col.Precision, col.Scale = 0, 0
+	switch kind {
+	case TypeIntN:
+		col.Type, col.Size = TypeIntN, widenInt(4, values)
+	case TypeFltN:
+		col.Type, col.Size = TypeFltN, 8
+	case TypeBitN:
+		col.Type, col.Size = TypeBitN, 1
+	case TypeDateTime2N:
+		col.Type, col.Size, col.Scale = TypeDateTime2N, 0, datetime2Scale
+	case TypeBigVarBinary:
+		col.Type, col.Size = TypeBigVarBinary, binarySize(0, values)
+	default:
+		col.Type, col.Size = TypeNVarChar, stringSize(0, values)
+	}
+	return col
+}
+
+// decimalPrecision returns the precision and scale of a DECIMAL column
+func decimalPrecision(declared sqlexecutor.Column) (byte, byte) {
+	switch declared.TypeName {
+	case "MONEY":
+		return 19, 4
+	case "SMALLMONEY":
+		return 10, 4
+	}
+
+	precision := declared.Precision
+	if precision <= 0 || precision > MaxDecimalPrecision {
+		precision = DefaultDecimalPrecision
+	}
+	scale := declared.Scale
+	if scale < 0 || scale > precision {
+		scale = 0
+	}
+	return byte(precision), byte(scale)
+}
+
+// widenInt grows an integer column to BIGINT when a value doesn't fit its declared size
+func widenInt(size int, values []interface{}) int {
+	for _, v := range values {
+		if i, ok := toInt64(v); ok && !fitsInt(i, size) {
+			return 8
+		}
+	}
+	return size
+}
+
+// stringSize returns the NVARCHAR byte size for a column: the declared length when
+// every value fits, otherwise NVARCHAR(4000), otherwise NVARCHAR(MAX)
+func stringSize(declaredLength int64, values []interface{}) int {
+	longest := 0
+	for _, v := range values {
+		if v != nil {
+			longest = max(longest, len(EncodeUCS2(toString(v))))
+		}
+	}
+	return varColumnSize(declaredLength*2, longest)
+}
+
+// binarySize returns the VARBINARY byte size for a column
+func binarySize(declaredLength int64, values []interface{}) int {
+	longest := 0
+	for _, v := range values {
+		if b, ok := toBytes(v); ok {
+			longest = max(longest, len(b))
+		}
+	}
+	return varColumnSize(declaredLength, longest)
+}
+
+// varColumnSize picks a variable-length column size in bytes; -1 means (MAX)
+func varColumnSize(declared int64, longest int) int {
+	switch {
+	case declared > 0 && declared <= maxVarSize && int64(longest) <= declared:
+		return int(declared)
+	case longest <= maxVarSize && declared >= 0:
+		return maxVarSize
+	default:
+		return -1
+	}
+}
+
+// acceptsAll reports whether every value can be encoded as the column's type
+func acceptsAll(col *ColumnInfo, values []interface{}) bool {
+	ts := NewTokenStream()
+	for _, v := range values {
+		if err := ts.writeValue(col, v); err != nil {
+			return false
+		}
+		ts.Reset()
+	}
+	return true
+}
+
+// ColMetadata writes a COLMETADATA token
+func (ts *TokenStream) ColMetadata(columns []ColumnInfo) {
+	ts.writeByte(byte(TokenTypeColMetadata))
+	if len(columns) == 0 {
+		// No metadata
+		ts.writeUint16(0xFFFF)
+		return
+	}
+
+	ts.writeUint16(uint16(len(columns)))
+	for i := range columns {
+		col := &columns[i]
+
+		// UserType
+		ts.writeUint32(0)
+
+		var flags uint16
+		if col.Nullable {
+			flags |= ColumnFlagNullable
+		}
+		ts.writeUint16(flags)
+
+		ts.writeTypeInfo(col)
+		ts.writeBVarchar(col.Name)
+	}
+}
+
+// Row writes a ROW token
+func (ts *TokenStream) Row(columns []ColumnInfo, values []interface{}) error {
+	if len(values) != len(columns) {
+		return fmt.Errorf("row has %d values, expected %d", len(values), len(columns))
+	}
+
+	ts.writeByte(byte(TokenTypeRow))
+	for i := range columns {
+		if err := ts.writeValue(&columns[i], values[i]); err != nil {
+			return fmt.Errorf("column '%s': %w", columns[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// ResultSet writes COLMETADATA followed by one ROW token per row
+func (ts *TokenStream) ResultSet(rs *ResultSet) error {
+	ts.ColMetadata(rs.Columns)
+	for _, row := range rs.Rows {
+		if err := ts.Row(rs.Columns, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildResultResponse builds a tabular response carrying one result set and a final DONE
+func BuildResultResponse(rs *ResultSet) (*Packet, error) {
+	ts := NewTokenStream()
+	if err := ts.ResultSet(rs); err != nil {
+		return nil, err
+	}
+	ts.Done(DoneCount, CurCmdSelect, uint64(len(rs.Rows)))
+
+	return NewPacket(PacketTypeTabular, StatusEOM, 1, ts.Bytes()), nil
+}
+
+// Result writes an executor result ended by DONE, or DONEINPROC inside a procedure
+// Queries send their rows as COLMETADATA and ROW tokens; other statements send
+// only the DONE token. DONE_COUNT is set for queries, INSERT, UPDATE and DELETE
+// unless noCount is set, as by SET NOCOUNT ON
+func (ts *TokenStream) Result(result *sqlexecutor.ExecuteResult, status uint16, inProc bool, noCount bool) error {
+	rowCount := uint64(result.RowCount)
+	if result.IsQuery {
+		rs := NewResultSet(result)
+		if err := ts.ResultSet(rs); err != nil {
+			return err
+		}
+		rowCount = uint64(len(rs.Rows))
+	}
+
+	curCmd, counted := resultCurCmd(result)
+	if counted && !noCount {
+		status |= DoneCount
+	} else {
+		rowCount = 0
+	}
+
+	tokenType := TokenTypeDone
+	if inProc {
+		tokenType = TokenTypeDoneInProc
+	}
+	ts.done(tokenType, status, curCmd, rowCount)
+	return nil
+}
+
+// resultCurCmd returns the CurCmd of a result and whether its row count is reported
+func resultCurCmd(result *sqlexecutor.ExecuteResult) (uint16, bool) {
+	if result.IsQuery {
+		return CurCmdSelect, true
+	}
+
+	switch result.Statement {
+	case sqlparser.StatementTypeInsert:
+		return CurCmdInsert, true
+	case sqlparser.StatementTypeUpdate:
+		return CurCmdUpdate, true
+	case sqlparser.StatementTypeDelete:
+		return CurCmdDelete, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,239 @@
+package tds
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/factory/mssql-tds-server/pkg/sqlexecutor"
+	"github.com/factory/mssql-tds-server/pkg/sqlparser"
+)
+
+func TestResolveColumn(t *testing.T) {
+	tests := []struct {
+		name     string
+		declType string
+		values   []interface{}
+		wantType DataType
+		wantSize int
+	}{
+		{"int", "INT", []interface{}{int64(1), nil}, TypeIntN, 4},
+		{"int overflow widens", "INTEGER", []interface{}{int64(1) << 40}, TypeIntN, 8},
+		{"bigint", "BIGINT", []interface{}{int64(1)}, TypeIntN, 8},
+		{"bit", "BIT", []interface{}{int64(1), int64(0)}, TypeBitN, 1},
+		{"float", "REAL", []interface{}{1.5}, TypeFltN, 8},
+		{"decimal", "DECIMAL(10,2)", []interface{}{12.34}, TypeDecimalN, 9},
+		{"datetime", "DATETIME", []interface{}{time.Now()}, TypeDateTime2N, 0},
+		{"datetime2 text", "DATETIME2", []interface{}{"2024-01-02 03:04:05"}, TypeDateTime2N, 0},
+		{"guid", "UNIQUEIDENTIFIER", []interface{}{"6F9619FF-8B86-D011-B42D-00C04FC964FF"}, TypeGUID, 16},
+		{"varbinary", "VARBINARY(16)", []interface{}{[]byte{1, 2}}, TypeBigVarBinary, 16},
+		{"nvarchar", "NVARCHAR(50)", []interface{}{"abc"}, TypeNVarChar, 100},
+		{"nvarchar max", "NVARCHAR(MAX)", []interface{}{"abc"}, TypeNVarChar, -1},
+		{"int holding text", "INT", []interface{}{int64(1), "abc"}, TypeNVarChar, maxVarSize},
+		{"expression int", "", []interface{}{int64(7)}, TypeIntN, 4},
+		{"expression mixed numbers", "", []interface{}{int64(7), 1.5}, TypeFltN, 8},
+		{"expression null", "", []interface{}{nil}, TypeNVarChar, maxVarSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			declared := sqlexecutor.ParseDeclaredType(tt.declType)
+			declared.Nullable = true
+			col := resolveColumn(declared, tt.values)
+			if col.Type != tt.wantType {
+				t.Errorf("Type = %#02x, want %#02x", col.Type, tt.wantType)
+			}
+			if col.Size != tt.wantSize {
+				t.Errorf("Size = %d, want %d", col.Size, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestWriteValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		col   ColumnInfo
+		value interface{}
+		want  []byte
+	}{
+		{"int", ColumnInfo{Type: TypeIntN, Size: 4}, int64(-2), []byte{4, 0xFE, 0xFF, 0xFF, 0xFF}},
+		{"int null", ColumnInfo{Type: TypeIntN, Size: 4}, nil, []byte{0}},
+		{"bit", ColumnInfo{Type: TypeBitN, Size: 1}, true, []byte{1, 1}},
+		{"float", ColumnInfo{Type: TypeFltN, Size: 8}, 1.0, []byte{8, 0, 0, 0, 0, 0, 0, 0xF0, 0x3F}},
+		{"decimal", ColumnInfo{Type: TypeDecimalN, Size: 5, Precision: 5, Scale: 2}, -12.345, []byte{5, 0, 0xD3, 0x04, 0, 0}},
+		{"datetime2", ColumnInfo{Type: TypeDateTime2N, Scale: 7}, time.Date(1, 1, 2, 0, 0, 1, 0, time.UTC),
+			[]byte{8, 0x80, 0x96, 0x98, 0, 0, 1, 0, 0}},
+		{"guid", ColumnInfo{Type: TypeGUID, Size: 16}, "6F9619FF-8B86-D011-B42D-00C04FC964FF",
+			[]byte{16, 0xFF, 0x19, 0x96, 0x6F, 0x86, 0x8B, 0x11, 0xD0, 0xB4, 0x2D, 0x00, 0xC0, 0x4F, 0xC9, 0x64, 0xFF}},
+		{"nvarchar", ColumnInfo{Type: TypeNVarChar, Size: 10}, "ab", []byte{4, 0, 'a', 0, 'b', 0}},
+		{"nvarchar null", ColumnInfo{Type: TypeNVarChar, Size: 10}, nil, []byte{0xFF, 0xFF}},
+		{"nvarchar max", ColumnInfo{Type: TypeNVarChar, Size: -1}, "a",
+			[]byte{2, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 'a', 0, 0, 0, 0, 0}},
+		{"nvarchar max null", ColumnInfo{Type: TypeNVarChar, Size: -1}, nil,
+			[]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{"varbinary", ColumnInfo{Type: TypeBigVarBinary, Size: 4}, []byte{1, 2}, []byte{2, 0, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewTokenStream()
+			if err := ts.writeValue(&tt.col, tt.value); err != nil {
+				t.Fatalf("writeValue() error = %v", err)
+			}
+			if !bytes.Equal(ts.Bytes(), tt.want) {
+				t.Errorf("writeValue() = % X, want % X", ts.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteValueRejectsMismatch(t *testing.T) {
+	ts := NewTokenStream()
+	col := ColumnInfo{Type: TypeIntN, Size: 2}
+	if err := ts.writeValue(&col, int64(70000)); err == nil {
+		t.Error("writeValue() accepted a value too large for SMALLINT")
+	}
+	col = ColumnInfo{Type: TypeDecimalN, Size: 5, Precision: 3, Scale: 1}
+	if err := ts.writeValue(&col, 1234.5); err == nil {
+		t.Error("writeValue() accepted a value too large for DECIMAL(3,1)")
+	}
+}
+
+func TestColMetadata(t *testing.T) {
+	ts := NewTokenStream()
+	ts.ColMetadata([]ColumnInfo{
+		{Name: "id", Type: TypeIntN, Size: 4},
+		{Name: "n", Type: TypeNVarChar, Size: 20, Nullable: true},
+	})
+
+	want := []byte{
+		0x81, 2, 0,
+		0, 0, 0, 0, 0, 0, 0x26, 4, 2, 'i', 0, 'd', 0,
+		0, 0, 0, 0, 1, 0, 0xE7, 20, 0, 0x09, 0x04, 0xD0, 0x00, 0x34, 1, 'n', 0,
+	}
+	if !bytes.Equal(ts.Bytes(), want) {
+		t.Errorf("ColMetadata() = % X, want % X", ts.Bytes(), want)
+	}
+}
+
+func TestNewResultSet(t *testing.T) {
+	result := &sqlexecutor.ExecuteResult{
+		Columns: []string{"id", "name"},
+		ColumnTypes: []sqlexecutor.Column{
+			{Name: "id", TypeName: "INTEGER", Nullable: true},
+			{Name: "name", TypeName: "VARCHAR", Length: 10, Nullable: true},
+		},
+		Rows: [][]interface{}{
+			{int64(1), "a"},
+			{int64(2), nil},
+		},
+	}
+
+	rs := NewResultSet(result)
+	if rs.Columns[0].Name != "id" || rs.Columns[0].Type != TypeIntN {
+		t.Errorf("column 0 = %+v", rs.Columns[0])
+	}
+	if rs.Columns[1].Name != "name" || rs.Columns[1].Type != TypeNVarChar || rs.Columns[1].Size != 20 {
+		t.Errorf("column 1 = %+v", rs.Columns[1])
+	}
+
+	if _, err := BuildResultResponse(rs); err != nil {
+		t.Fatalf("BuildResultResponse() error = %v", err)
+	}
+}
+
+func TestRowDistinguishesNullFromText(t *testing.T) {
+	columns := []ColumnInfo{{Name: "v", Type: TypeNVarChar, Size: 20, Nullable: true}}
+
+	ts := NewTokenStream()
+	if err := ts.Row(columns, []interface{}{nil}); err != nil {
+		t.Fatalf("Row() error = %v", err)
+	}
+	if want := []byte{0xD1, 0xFF, 0xFF}; !bytes.Equal(ts.Bytes(), want) {
+		t.Errorf("Row(nil) = % X, want % X", ts.Bytes(), want)
+	}
+
+	ts.Reset()
+	if err := ts.Row(columns, []interface{}{"NULL"}); err != nil {
+		t.Fatalf("Row() error = %v", err)
+	}
+	if want := append([]byte{0xD1, 8, 0}, EncodeUCS2("NULL")...); !bytes.Equal(ts.Bytes(), want) {
+		t.Errorf("Row(\"NULL\") = % X, want % X", ts.Bytes(), want)
+	}
+}
+
+func TestResultDoneToken(t *testing.T) {
+	done := func(tokenType TokenType, status, curCmd uint16, rowCount uint64) []byte {
+		ts := NewTokenStream()
+		ts.done(tokenType, status, curCmd, rowCount)
+		return ts.Bytes()
+	}
+
+	tests := []struct {
+		name    string
+		result  *sqlexecutor.ExecuteResult
+		inProc  bool
+		noCount bool
+		want    []byte
+	}{
+		{
+			name:   "update",
+			result: &sqlexecutor.ExecuteResult{RowCount: 300, Statement: sqlparser.StatementTypeUpdate},
+			want:   done(TokenTypeDone, DoneMore|DoneCount, CurCmdUpdate, 300),
+		},
+		{
+			name:   "insert in procedure",
+			result: &sqlexecutor.ExecuteResult{RowCount: 2, Statement: sqlparser.StatementTypeInsert},
+			inProc: true,
+			want:   done(TokenTypeDoneInProc, DoneMore|DoneCount, CurCmdInsert, 2),
+		},
+		{
+			name:    "delete with NOCOUNT",
+			result:  &sqlexecutor.ExecuteResult{RowCount: 3, Statement: sqlparser.StatementTypeDelete},
+			noCount: true,
+			want:    done(TokenTypeDone, DoneMore, CurCmdDelete, 0),
+		},
+		{
+			name:   "create table",
+			result: &sqlexecutor.ExecuteResult{RowCount: 5, Statement: sqlparser.StatementTypeCreateTable},
+			want:   done(TokenTypeDone, DoneMore, 0, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewTokenStream()
+			if err := ts.Result(tt.result, DoneMore, tt.inProc, tt.noCount); err != nil {
+				t.Fatalf("Result() error = %v", err)
+			}
+			if !bytes.Equal(ts.Bytes(), tt.want) {
+				t.Errorf("Result() = % X, want % X", ts.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+func TestResultQuery(t *testing.T) {
+	result := &sqlexecutor.ExecuteResult{
+		Columns: []string{"id"},
+		Rows:    [][]interface{}{{int64(1)}, {int64(2)}},
+		IsQuery: true,
+	}
+
+	ts := NewTokenStream()
+	if err := ts.Result(result, DoneFinal, false, false); err != nil {
+		t.Fatalf("Result() error = %v", err)
+	}
+
+	got := ts.Bytes()
+	if got[0] != byte(TokenTypeColMetadata) {
+		t.Errorf("first token = %#x, want COLMETADATA", got[0])
+	}
+
+	want := NewTokenStream()
+	want.Done(DoneCount, CurCmdSelect, 2)
+	if !bytes.HasSuffix(got, want.Bytes()) {
+		t.Errorf("Result() ends with % X, want % X", got[len(got)-len(want.Bytes()):], want.Bytes())
+	}
+}
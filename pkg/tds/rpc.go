@@ -2,226 +2,297 @@ package tds
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
+// Well-known procedure IDs sent as 0xFFFF followed by the ID instead of a name
+const (
+	ProcIDCursor          uint16 = 1
+	ProcIDCursorOpen      uint16 = 2
+	ProcIDCursorPrepare   uint16 = 3
+	ProcIDCursorExecute   uint16 = 4
+	ProcIDCursorPrepExec  uint16 = 5
+	ProcIDCursorUnprepare uint16 = 6
+	ProcIDCursorFetch     uint16 = 7
+	ProcIDCursorOption    uint16 = 8
+	ProcIDCursorClose     uint16 = 9
+	ProcIDExecuteSQL      uint16 = 10
+	ProcIDPrepare         uint16 = 11
+	ProcIDExecute         uint16 = 12
+	ProcIDPrepExec        uint16 = 13
+	ProcIDPrepExecRPC     uint16 = 14
+	ProcIDUnprepare       uint16 = 15
+)
+
+// wellKnownProcs maps well-known procedure IDs to their names
+var wellKnownProcs = map[uint16]string{
+	ProcIDCursor:          "sp_cursor",
+	ProcIDCursorOpen:      "sp_cursoropen",
+	ProcIDCursorPrepare:   "sp_cursorprepare",
+	ProcIDCursorExecute:   "sp_cursorexecute",
+	ProcIDCursorPrepExec:  "sp_cursorprepexec",
+	ProcIDCursorUnprepare: "sp_cursorunprepare",
+	ProcIDCursorFetch:     "sp_cursorfetch",
+	ProcIDCursorOption:    "sp_cursoroption",
+	ProcIDCursorClose:     "sp_cursorclose",
+	ProcIDExecuteSQL:      "sp_executesql",
+	ProcIDPrepare:         "sp_prepare",
+	ProcIDExecute:         "sp_execute",
+	ProcIDPrepExec:        "sp_prepexec",
+	ProcIDPrepExecRPC:     "sp_prepexecrpc",
+	ProcIDUnprepare:       "sp_unprepare",
+}
+
+// WellKnownProcName returns the name of a well-known procedure ID
+func WellKnownProcName(procID uint16) string {
+	return wellKnownProcs[procID]
+}
+
+// RPC option flags
+const (
+	RPCOptionWithRecompile uint16 = 0x0001
+	RPCOptionNoMetadata    uint16 = 0x0002
+	RPCOptionReuseMetadata uint16 = 0x0004
+)
+
+// RPC parameter status flags
+const (
+	RPCParamByRefValue   byte = 0x01 // OUTPUT parameter
+	RPCParamDefaultValue byte = 0x02
+)
+
+// rpcBatchFlags separate RPC requests batched in one message
+const (
+	rpcBatchFlag   = 0x80
+	rpcNoExecFlag  = 0xFE
+	rpcBatchFlag71 = 0xFF
+)
+
 // RPCParameter represents a parameter in an RPC call
+// The embedded ColumnInfo carries the parameter name and its TYPE_INFO
 type RPCParameter struct {
-	Name      string
-	Type      byte
-	MaxLength uint16
-	Value     interface{}
+	ColumnInfo
+	Status byte
+	Value  interface{}
+}
+
+// IsOutput reports whether the parameter was passed by reference (OUTPUT)
+func (p *RPCParameter) IsOutput() bool {
+	return p.Status&RPCParamByRefValue != 0
 }
 
 // RPCRequest represents an RPC (Remote Procedure Call) request
 type RPCRequest struct {
-	ProcName       string
-	ProcID         uint16
-	Options        uint16
-	Params         []*RPCParameter
-	TransactionID   uint16
+	Headers  *AllHeaders // nil when the client sent no ALL_HEADERS (TDS 7.1)
+	ProcName string      // Name of the procedure; filled in from ProcID for well-known procedures
+	ProcID   uint16      // Non-zero when the procedure was sent as a well-known ID
+	Options  uint16
+	Params   []*RPCParameter
 }
 
-// ParseRPCRequest parses an RPC packet from TDS data
+// ParseRPCRequest parses an RPC request message
 func ParseRPCRequest(data []byte) (*RPCRequest, error) {
 	req := &RPCRequest{}
 
-	buf := NewBuffer(data)
-
-	// Read total length (4 bytes)
-	_, err := buf.ReadUint16()
+	headers, n, err := ParseAllHeaders(data)
 	if err != nil {
-		return nil, fmt.Errorf("error reading RPC total length: %w", err)
+		return nil, err
 	}
-	// Skip next 2 bytes (reserved)
-	buf.ReadBytes(2)
+	req.Headers = headers
 
-	// Read parameter count (4 bytes)
-	paramCount, err := buf.ReadUint16()
+	r := &payloadReader{data: data, pos: n}
+
+	// NameLenProcID: name length in characters, or 0xFFFF followed by a ProcID
+	nameLength, err := r.readUint16()
 	if err != nil {
-		return nil, fmt.Errorf("error reading parameter count: %w", err)
+		return nil, fmt.Errorf("error reading procedure name length: %w", err)
+	}
+	if nameLength == 0xFFFF {
+		req.ProcID, err = r.readUint16()
+		if err != nil {
+			return nil, fmt.Errorf("error reading procedure ID: %w", err)
+		}
+		name, ok := wellKnownProcs[req.ProcID]
+		if !ok {
+			return nil, fmt.Errorf("unknown procedure ID %d", req.ProcID)
+		}
+		req.ProcName = name
+	} else {
+		nameBytes, err := r.readBytes(int(nameLength) * 2)
+		if err != nil {
+			return nil, fmt.Errorf("error reading procedure name: %w", err)
+		}
+		req.ProcName = DecodeUCS2(nameBytes)
 	}
-	// Skip next 2 bytes (reserved)
-	buf.ReadBytes(2)
 
-	// Read option flags (4 bytes)
-	options, err := buf.ReadUint32()
+	req.Options, err = r.readUint16()
 	if err != nil {
 		return nil, fmt.Errorf("error reading RPC options: %w", err)
 	}
-	req.Options = uint16(options)
 
-	// Read parameter data
-	req.Params = make([]*RPCParameter, 0, paramCount)
+	for r.remaining() > 0 {
+		switch r.peek() {
+		case rpcBatchFlag, rpcBatchFlag71, rpcNoExecFlag:
+			return nil, fmt.Errorf("batched RPC requests are not supported")
+		}
 
-	for i := uint16(0); i < paramCount; i++ {
-		param, err := parseRPCParameter(buf)
+		param, err := parseRPCParameter(r)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing parameter %d: %w", i, err)
+			return nil, fmt.Errorf("error parsing parameter %d: %w", len(req.Params), err)
 		}
 		req.Params = append(req.Params, param)
 	}
 
-	// Extract procedure name from first parameter (if it's the procedure name parameter)
-	if len(req.Params) > 0 {
-		// In TDS RPC, the first parameter often contains the procedure name
-		if name, ok := req.Params[0].Value.(string); ok {
-			req.ProcName = name
-		}
+	return req, nil
+}
+
+// parseRPCParameter parses a single RPC parameter: name, status flags, TYPE_INFO and value
+func parseRPCParameter(r *payloadReader) (*RPCParameter, error) {
+	param := &RPCParameter{}
+
+	name, err := r.readBVarchar()
+	if err != nil {
+		return nil, fmt.Errorf("error reading parameter name: %w", err)
 	}
 
-	// Try to read procedure ID from data stream
-	if len(data) >= 8 {
-		req.ProcID = binary.BigEndian.Uint16(data[0:2])
+	param.Status, err = r.readByte()
+	if err != nil {
+		return nil, fmt.Errorf("error reading parameter status: %w", err)
 	}
 
-	return req, nil
+	param.ColumnInfo, err = parseTypeInfo(r)
+	if err != nil {
+		return nil, err
+	}
+	param.Name = name
+	param.Nullable = true
+
+	param.Value, err = parseRPCValue(r, &param.ColumnInfo)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing parameter '%s' value: %w", name, err)
+	}
+
+	return param, nil
 }
 
-// parseRPCParameter parses a single RPC parameter
-func parseRPCParameter(buf *Buffer) (*RPCParameter, error) {
-	param := &RPCParameter{}
+// payloadReader reads little-endian fields from a request payload
+type payloadReader struct {
+	data []byte
+	pos  int
+}
 
-	// Read name length and name
-	nameLen, err := buf.ReadByte()
-	if err != nil {
-		return nil, fmt.Errorf("error reading parameter name length: %w", err)
+// remaining returns the number of unread bytes
+func (r *payloadReader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+// peek returns the next byte without consuming it
+func (r *payloadReader) peek() byte {
+	return r.data[r.pos]
+}
+
+// errTruncated is returned when a payload ends before the value being read
+var errTruncated = errors.New("unexpected end of data")
+
+func (r *payloadReader) readBytes(n int) ([]byte, error) {
+	if n < 0 || n > r.remaining() {
+		return nil, fmt.Errorf("%w at offset %d (need %d bytes)", errTruncated, r.pos, n)
 	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
 
-	if nameLen > 0 {
-		nameBytes, err := buf.ReadBytes(int(nameLen))
-		if err != nil {
-			return nil, fmt.Errorf("error reading parameter name: %w", err)
-		}
-		param.Name = string(nameBytes)
+func (r *payloadReader) readByte() (byte, error) {
+	b, err := r.readBytes(1)
+	if err != nil {
+		return 0, err
 	}
+	return b[0], nil
+}
 
-	// Read status flags
-	status, err := buf.ReadByte()
+func (r *payloadReader) readUint16() (uint16, error) {
+	b, err := r.readBytes(2)
 	if err != nil {
-		return nil, fmt.Errorf("error reading parameter status: %w", err)
+		return 0, err
 	}
-	_ = status // Status not used for now
+	return binary.LittleEndian.Uint16(b), nil
+}
 
-	// Read user type (4 bytes)
-	userType, err := buf.ReadUint32()
+func (r *payloadReader) readUint32() (uint32, error) {
+	b, err := r.readBytes(4)
 	if err != nil {
-		return nil, fmt.Errorf("error reading user type: %w", err)
+		return 0, err
 	}
-	_ = userType // Not used for now
+	return binary.LittleEndian.Uint32(b), nil
+}
 
-	// Read data type (1 byte)
-	dataType, err := buf.ReadByte()
+func (r *payloadReader) readUint64() (uint64, error) {
+	b, err := r.readBytes(8)
 	if err != nil {
-		return nil, fmt.Errorf("error reading data type: %w", err)
+		return 0, err
 	}
-	param.Type = dataType
+	return binary.LittleEndian.Uint64(b), nil
+}
 
-	// Parse value based on data type
-	value, err := parseRPCValue(buf, dataType)
+// readBVarchar reads a string with a one-byte length in characters
+func (r *payloadReader) readBVarchar() (string, error) {
+	length, err := r.readByte()
 	if err != nil {
-		return nil, fmt.Errorf("error parsing parameter value: %w", err)
+		return "", err
 	}
-	param.Value = value
+	b, err := r.readBytes(int(length) * 2)
+	if err != nil {
+		return "", err
+	}
+	return DecodeUCS2(b), nil
+}
 
-	return param, nil
+// readUSVarbyte reads bytes with a two-byte length; 0xFFFF is NULL (nil)
+func (r *payloadReader) readUSVarbyte() ([]byte, error) {
+	length, err := r.readUint16()
+	if err != nil {
+		return nil, err
+	}
+	if length == 0xFFFF {
+		return nil, nil
+	}
+	b, err := r.readBytes(int(length))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, b...), nil
 }
 
-// parseRPCValue parses a parameter value based on data type
-func parseRPCValue(buf *Buffer, dataType byte) (interface{}, error) {
-	switch dataType {
-	case 0x38: // VARCHAR
-		length, err := buf.ReadUint16()
-		if err != nil {
-			return nil, fmt.Errorf("error reading varchar length: %w", err)
-		}
-		if length == 0xFFFF {
-			// NULL value
-			return nil, nil
-		}
-		valueBytes, err := buf.ReadBytes(int(length))
-		if err != nil {
-			return nil, fmt.Errorf("error reading varchar value: %w", err)
-		}
-		return string(valueBytes), nil
+// readPLP reads a partially length-prefixed value; NULL is returned as nil
+func (r *payloadReader) readPLP() ([]byte, error) {
+	total, err := r.readUint64()
+	if err != nil {
+		return nil, err
+	}
+	if total == plpNull {
+		return nil, nil
+	}
 
-	case 0x68: // BIGINT
-		valueBytes, err := buf.ReadBytes(8)
+	data := []byte{}
+	for {
+		chunkLength, err := r.readUint32()
 		if err != nil {
-			return nil, fmt.Errorf("error reading bigint value: %w", err)
+			return nil, err
 		}
-		return int64(binary.BigEndian.Uint64(valueBytes)), nil
-
-	case 0x30: // INT
-		valueBytes, err := buf.ReadBytes(4)
-		if err != nil {
-			return nil, fmt.Errorf("error reading int value: %w", err)
+		if chunkLength == 0 {
+			break
 		}
-		return int32(binary.BigEndian.Uint32(valueBytes)), nil
-
-	case 0x34: // SMALLINT
-		valueBytes, err := buf.ReadBytes(2)
+		chunk, err := r.readBytes(int(chunkLength))
 		if err != nil {
-			return nil, fmt.Errorf("error reading smallint value: %w", err)
-		}
-		return int16(binary.BigEndian.Uint16(valueBytes)), nil
-
-	default:
-		return nil, fmt.Errorf("unsupported data type: %#02x", dataType)
-	}
-}
-
-// BuildRPCResponse builds an RPC response packet with a result set
-func BuildRPCResponse(rows [][]string) *Packet {
-	var buf []byte
-
-	// Token type: COLMETADATA (0x81)
-	buf = append(buf, 0x81)
-	// Column count
-	colCount := len(rows[0])
-	buf = append(buf, byte(colCount))
-
-	// Column metadata
-	for i := 0; i < colCount; i++ {
-		// User type (4 bytes)
-		buf = append(buf, 0x00, 0x00, 0x00, 0x00)
-		// Flags (2 bytes)
-		buf = append(buf, 0x00, 0x00)
-		// Type info (VARCHAR)
-		buf = append(buf, 0xA7) // VARCHAR
-		// Length
-		buf = append(buf, 0xFF, 0xFF) // MAX length
-		// Collation (5 bytes)
-		buf = append(buf, 0x09, 0x04, 0xD0, 0x00, 0x34)
-		// Table name (empty)
-		buf = append(buf, 0x00)
-		// Column name
-		colName := fmt.Sprintf("Column%d", i+1)
-		buf = append(buf, byte(len(colName)))
-		buf = append(buf, []byte(colName)...)
-	}
-
-	// Token type: ROW (0xD1)
-	for _, row := range rows {
-		buf = append(buf, 0xD1)
-		// Column values
-		for _, val := range row {
-			// Column length
-			length := len(val)
-			buf = append(buf, byte(length>>8), byte(length))
-			// Column value
-			buf = append(buf, []byte(val)...)
+			return nil, err
 		}
+		data = append(data, chunk...)
 	}
 
-	// Token type: DONE (0xFD)
-	buf = append(buf, 0xFD)
-	// Status
-	buf = append(buf, 0x00, 0x10) // FINAL
-	// Current command
-	buf = append(buf, 0x00, 0x00)
-	// Row count
-	buf = append(buf, 0x00, 0x00, 0x00, 0x00, byte(len(rows)))
-
-	return NewPacket(PacketTypeTabular, StatusEOM, 3, buf)
+	if total != plpUnknown && uint64(len(data)) != total {
+		return nil, fmt.Errorf("PLP value has %d bytes, expected %d", len(data), total)
+	}
+	return data, nil
 }
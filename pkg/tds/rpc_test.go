@@ -0,0 +1,184 @@
+package tds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// rpcParam builds an RPC parameter: B_VARCHAR name, status flags, then TYPE_INFO and value
+func rpcParam(name string, status byte, typeInfoAndValue ...byte) []byte {
+	b := []byte{byte(len([]rune(name)))}
+	b = append(b, EncodeUCS2(name)...)
+	b = append(b, status)
+	return append(b, typeInfoAndValue...)
+}
+
+// nvarcharParam builds the TYPE_INFO and value of an NVARCHAR(4000) parameter
+func nvarcharParam(value string) []byte {
+	text := EncodeUCS2(value)
+	b := []byte{byte(TypeNVarChar), 0x40, 0x1F}
+	b = append(b, defaultCollation...)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(text)))
+	return append(b, text...)
+}
+
+// rpcRequest builds an RPC payload calling a well-known procedure by ID
+func rpcRequest(procID uint16, params ...[]byte) []byte {
+	b := buildAllHeaders(0, 1)
+	b = binary.LittleEndian.AppendUint16(b, 0xFFFF)
+	b = binary.LittleEndian.AppendUint16(b, procID)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	for _, p := range params {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func TestParseRPCRequestExecuteSQL(t *testing.T) {
+	data := rpcRequest(ProcIDExecuteSQL,
+		rpcParam("", 0, nvarcharParam("SELECT @p1, @p2")...),
+		rpcParam("", 0, nvarcharParam("@p1 bigint,@p2 nvarchar(5)")...),
+		rpcParam("@p1", 0, byte(TypeIntN), 8, 8, 0xD6, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF),
+		rpcParam("@p2", 0, nvarcharParam("héllo")...),
+	)
+
+	req, err := ParseRPCRequest(data)
+	if err != nil {
+		t.Fatalf("ParseRPCRequest() error = %v", err)
+	}
+
+	if req.ProcID != ProcIDExecuteSQL || req.ProcName != "sp_executesql" {
+		t.Errorf("procedure = %d/%q, want %d/sp_executesql", req.ProcID, req.ProcName, ProcIDExecuteSQL)
+	}
+	if req.Headers == nil || req.Headers.OutstandingRequestCount != 1 {
+		t.Errorf("Headers = %+v, want parsed ALL_HEADERS", req.Headers)
+	}
+	if len(req.Params) != 4 {
+		t.Fatalf("len(Params) = %d, want 4", len(req.Params))
+	}
+
+	want := []interface{}{"SELECT @p1, @p2", "@p1 bigint,@p2 nvarchar(5)", int64(-42), "héllo"}
+	for i, w := range want {
+		if req.Params[i].Value != w {
+			t.Errorf("Params[%d].Value = %#v, want %#v", i, req.Params[i].Value, w)
+		}
+	}
+	if req.Params[2].Name != "@p1" || req.Params[2].Type != TypeIntN || req.Params[2].Size != 8 {
+		t.Errorf("Params[2] = %+v, want @p1 INTN(8)", req.Params[2].ColumnInfo)
+	}
+}
+
+func TestParseRPCRequestByName(t *testing.T) {
+	data := buildAllHeaders(0, 1)
+	data = binary.LittleEndian.AppendUint16(data, 8)
+	data = append(data, EncodeUCS2("sp_hello")...)
+	data = binary.LittleEndian.AppendUint16(data, RPCOptionNoMetadata)
+	data = append(data, rpcParam("@name", RPCParamByRefValue, nvarcharParam("x")...)...)
+
+	req, err := ParseRPCRequest(data)
+	if err != nil {
+		t.Fatalf("ParseRPCRequest() error = %v", err)
+	}
+	if req.ProcName != "sp_hello" || req.ProcID != 0 || req.Options != RPCOptionNoMetadata {
+		t.Errorf("request = %q/%d/%#x, want sp_hello/0/%#x", req.ProcName, req.ProcID, req.Options, RPCOptionNoMetadata)
+	}
+	if len(req.Params) != 1 || !req.Params[0].IsOutput() || req.Params[0].Value != "x" {
+		t.Errorf("Params = %+v, want one OUTPUT parameter 'x'", req.Params)
+	}
+}
+
+func TestParseRPCValues(t *testing.T) {
+	nvarcharMax := []byte{byte(TypeNVarChar), 0xFF, 0xFF}
+	nvarcharMax = append(nvarcharMax, defaultCollation...)
+	nvarcharMax = binary.LittleEndian.AppendUint64(nvarcharMax, 6)
+	nvarcharMax = append(nvarcharMax, 4, 0, 0, 0, 'a', 0, 'b', 0)
+	nvarcharMax = append(nvarcharMax, 2, 0, 0, 0, 'c', 0)
+	nvarcharMax = append(nvarcharMax, 0, 0, 0, 0)
+
+	nullNVarchar := []byte{byte(TypeNVarChar), 0x40, 0x1F}
+	nullNVarchar = append(nullNVarchar, defaultCollation...)
+	nullNVarchar = append(nullNVarchar, 0xFF, 0xFF)
+
+	tests := []struct {
+		name  string
+		param []byte
+		want  interface{}
+	}{
+		{"tinyint", []byte{byte(TypeIntN), 1, 1, 0xFF}, int64(255)},
+		{"smallint", []byte{byte(TypeIntN), 2, 2, 0xFE, 0xFF}, int64(-2)},
+		{"int", []byte{byte(TypeIntN), 4, 4, 0x2A, 0, 0, 0}, int64(42)},
+		{"int null", []byte{byte(TypeIntN), 4, 0}, nil},
+		{"fixed int", []byte{byte(TypeInt4), 0x07, 0, 0, 0}, int64(7)},
+		{"bit", []byte{byte(TypeBitN), 1, 1, 1}, true},
+		{"float", append([]byte{byte(TypeFltN), 8, 8}, binary.LittleEndian.AppendUint64(nil, math.Float64bits(2.5))...), 2.5},
+		{"real", append([]byte{byte(TypeFltN), 4, 4}, binary.LittleEndian.AppendUint32(nil, math.Float32bits(0.5))...), 0.5},
+		{"null type", []byte{byte(TypeNull)}, nil},
+		{"nvarchar null", nullNVarchar, nil},
+		{"nvarchar max", nvarcharMax, "abc"},
+		{"varbinary", []byte{byte(TypeBigVarBinary), 0x40, 0x1F, 2, 0, 0xDE, 0xAD}, []byte{0xDE, 0xAD}},
+		{"binary", []byte{byte(TypeBigBinary), 0x02, 0x00, 2, 0, 0xBE, 0xEF}, []byte{0xBE, 0xEF}},
+		{"nchar", append(append([]byte{byte(TypeNChar), 0x04, 0x00}, defaultCollation...), 4, 0, 'o', 0, 'k', 0), "ok"},
+		{"varchar", append(append([]byte{byte(TypeBigVarChar), 0x0A, 0x00}, defaultCollation...), 2, 0, 'c', 0xE9), "cé"},
+		{"decimal", []byte{byte(TypeDecimalN), 5, 10, 2, 5, 1, 0x39, 0x30, 0, 0}, "123.45"},
+		{"numeric negative", []byte{byte(TypeNumericN), 5, 5, 2, 5, 0, 5, 0, 0, 0}, "-0.05"},
+		{"decimal null", []byte{byte(TypeDecimalN), 5, 10, 2, 0}, nil},
+		{"money", []byte{byte(TypeMoneyN), 8, 8, 0, 0, 0, 0, 0x40, 0xE2, 0x01, 0x00}, "12.3456"},
+		{"smallmoney", []byte{byte(TypeMoneyN), 4, 4, 0xFF, 0xFF, 0xFF, 0xFF}, "-0.0001"},
+		{"fixed money", []byte{byte(TypeMoney), 0, 0, 0, 0, 0x10, 0x27, 0, 0}, "1.0000"},
+		{
+			"guid",
+			[]byte{byte(TypeGUID), 16, 16, 0xFF, 0x19, 0x96, 0x6F, 0x86, 0x8B, 0x11, 0xD0, 0xB4, 0x2D, 0x00, 0xC0, 0x4F, 0xC9, 0x64, 0xFF},
+			"6F9619FF-8B86-D011-B42D-00C04FC964FF",
+		},
+		{"date", []byte{byte(TypeDateN), 3, 0x46, 0x46, 0x0B}, "2024-01-02"},
+		{"date null", []byte{byte(TypeDateN), 0}, nil},
+		{"time", []byte{byte(TypeTimeN), 7, 5, 0x87, 0xEE, 0x97, 0x76, 0x69}, "12:34:56.1234567"},
+		{"datetime2", []byte{byte(TypeDateTime2N), 3, 7, 0x2E, 0x8B, 0xA8, 0x00, 0x46, 0x46, 0x0B}, "2024-01-02 03:04:05.678"},
+		{"datetimeoffset", []byte{byte(TypeDateTimeOffset), 0, 8, 0xA0, 0x8C, 0x00, 0x46, 0x46, 0x0B, 120, 0}, "2024-01-02 12:00:00+02:00"},
+		{"datetime", []byte{byte(TypeDateTimeN), 8, 8, 1, 0, 0, 0, 0x2C, 0x01, 0, 0}, "1900-01-02 00:00:01.000"},
+		{"smalldatetime", []byte{byte(TypeDateTime4), 2, 0, 61, 0}, "1900-01-03 01:01:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ParseRPCRequest(rpcRequest(ProcIDExecuteSQL, rpcParam("@v", 0, tt.param...)))
+			if err != nil {
+				t.Fatalf("ParseRPCRequest() error = %v", err)
+			}
+			got := req.Params[0].Value
+			if b, ok := tt.want.([]byte); ok {
+				if !bytes.Equal(got.([]byte), b) {
+					t.Errorf("Value = %x, want %x", got, b)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Value = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRPCRequestInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"unknown procedure ID", rpcRequest(99)},
+		{"truncated value", rpcRequest(ProcIDExecuteSQL, rpcParam("@v", 0, byte(TypeIntN), 4, 4, 1))},
+		{"batched", append(rpcRequest(ProcIDExecuteSQL), rpcBatchFlag)},
+		{"unsupported type", rpcRequest(ProcIDExecuteSQL, rpcParam("@v", 0, byte(TypeXML), 0))},
+		{"time scale", rpcRequest(ProcIDExecuteSQL, rpcParam("@v", 0, byte(TypeTimeN), 8, 0))},
+		{"decimal length", rpcRequest(ProcIDExecuteSQL, rpcParam("@v", 0, byte(TypeDecimalN), 5, 10, 2, 1, 1))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseRPCRequest(tt.data); err == nil {
+				t.Error("ParseRPCRequest() error = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,463 @@
+package tds
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// SMP (Session Multiplexing Protocol, [MC-SMP]) carries the MARS sessions of a
+// connection. Once PRELOGIN negotiates MARS, every TDS packet travels in an SMP
+// DATA packet of one of the sessions the client opens with SYN
+const (
+	SMPIdentifier byte = 0x53
+	SMPHeaderSize      = 16
+)
+
+// SMP flags; a packet carries exactly one of them
+const (
+	SMPFlagSYN  byte = 0x01
+	SMPFlagACK  byte = 0x02
+	SMPFlagFIN  byte = 0x04
+	SMPFlagDATA byte = 0x08
+)
+
+const (
+	// smpWindow is how many DATA packets each side accepts ahead of the ones it has consumed
+	smpWindow = 4
+
+	// smpAckThreshold is how far the receive window grows before it is advertised with an ACK
+	smpAckThreshold = 2
+)
+
+// SMPHeader is the 16-byte header of an SMP packet
+type SMPHeader struct {
+	Flags  byte
+	SID    uint16
+	Length uint32 // Header included
+	SeqNum uint32
+	Window uint32
+}
+
+// ParseSMPHeader parses an SMP header
+func ParseSMPHeader(data []byte) (*SMPHeader, error) {
+	if len(data) < SMPHeaderSize {
+		return nil, errors.New("invalid SMP header: too short")
+	}
+	if data[0] != SMPIdentifier {
+		return nil, fmt.Errorf("invalid SMP header: identifier %#02x", data[0])
+	}
+
+	header := &SMPHeader{
+		Flags:  data[1],
+		SID:    binary.LittleEndian.Uint16(data[2:4]),
+		Length: binary.LittleEndian.Uint32(data[4:8]),
+		SeqNum: binary.LittleEndian.Uint32(data[8:12]),
+		Window: binary.LittleEndian.Uint32(data[12:16]),
+	}
+
+	if header.Length < SMPHeaderSize {
+		return nil, fmt.Errorf("invalid SMP packet length %d", header.Length)
+	}
+	if header.Flags != SMPFlagDATA && header.Length != SMPHeaderSize {
+		return nil, fmt.Errorf("SMP control packet %#02x carries data", header.Flags)
+	}
+
+	return header, nil
+}
+
+// Serialize serializes an SMP header
+func (h *SMPHeader) Serialize() []byte {
+	buf := make([]byte, SMPHeaderSize)
+	buf[0] = SMPIdentifier
+	buf[1] = h.Flags
+	binary.LittleEndian.PutUint16(buf[2:4], h.SID)
+	binary.LittleEndian.PutUint32(buf[4:8], h.Length)
+	binary.LittleEndian.PutUint32(buf[8:12], h.SeqNum)
+	binary.LittleEndian.PutUint32(buf[12:16], h.Window)
+	return buf
+}
+
+// SMPMux demultiplexes the SMP sessions of a connection
+// The client opens sessions; Accept returns each one as a net.Conn carrying TDS packets
+type SMPMux struct {
+	conn    net.Conn
+	writeMu sync.Mutex // Serializes packets on the connection
+
+	mu       sync.Mutex
+	sessions map[uint16]*SMPSession
+	err      error // Why the connection failed; set once
+
+	accept chan *SMPSession
+	done   chan struct{}
+}
+
+// NewSMPMux starts reading SMP packets from conn
+func NewSMPMux(conn net.Conn) *SMPMux {
+	m := &SMPMux{
+		conn:     conn,
+		sessions: make(map[uint16]*SMPSession),
+		accept:   make(chan *SMPSession),
+		done:     make(chan struct{}),
+	}
+	go m.readLoop()
+	return m
+}
+
+// Accept waits for the client to open a session
+func (m *SMPMux) Accept() (*SMPSession, error) {
+	select {
+	case s := <-m.accept:
+		return s, nil
+	case <-m.done:
+		return nil, m.failure()
+	}
+}
+
+// Close closes the connection and every session on it
+func (m *SMPMux) Close() error {
+	err := m.conn.Close()
+	m.fail(net.ErrClosed)
+	return err
+}
+
+// readLoop dispatches incoming packets to their sessions until the connection fails
+func (m *SMPMux) readLoop() {
+	headerBuf := make([]byte, SMPHeaderSize)
+	for {
+		if _, err := io.ReadFull(m.conn, headerBuf); err != nil {
+			m.fail(err)
+			return
+		}
+
+		header, err := ParseSMPHeader(headerBuf)
+		if err != nil {
+			m.fail(err)
+			return
+		}
+
+		payload := make([]byte, header.Length-SMPHeaderSize)
+		if _, err := io.ReadFull(m.conn, payload); err != nil {
+			m.fail(err)
+			return
+		}
+
+		if err := m.dispatch(header, payload); err != nil {
+			m.fail(err)
+			return
+		}
+	}
+}
+
+// dispatch handles one packet; an error is a protocol violation that ends the connection
+func (m *SMPMux) dispatch(header *SMPHeader, payload []byte) error {
+	m.mu.Lock()
+	s := m.sessions[header.SID]
+	m.mu.Unlock()
+
+	switch header.Flags {
+	case SMPFlagSYN:
+		if s != nil {
+			return fmt.Errorf("SMP session %d opened twice", header.SID)
+		}
+		s = newSMPSession(m, header.SID, header.Window)
+
+		m.mu.Lock()
+		m.sessions[header.SID] = s
+		m.mu.Unlock()
+
+		select {
+		case m.accept <- s:
+		case <-m.done:
+		}
+		return nil
+
+	case SMPFlagDATA:
+		if s == nil {
+			return fmt.Errorf("SMP data for unknown session %d", header.SID)
+		}
+		return s.receive(header.SeqNum, header.Window, payload)
+
+	case SMPFlagACK:
+		if s != nil {
+			return s.updateWindow(header.Window)
+		}
+		return nil
+
+	case SMPFlagFIN:
+		if s != nil {
+			s.remoteClose()
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("invalid SMP flags %#02x", header.Flags)
+	}
+}
+
+// writePacket writes one SMP packet
+func (m *SMPMux) writePacket(flags byte, sid uint16, seqNum, window uint32, payload []byte) error {
+	header := &SMPHeader{
+		Flags:  flags,
+		SID:    sid,
+		Length: uint32(SMPHeaderSize + len(payload)),
+		SeqNum: seqNum,
+		Window: window,
+	}
+
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	// One write per packet keeps the header and payload together
+	_, err := m.conn.Write(append(header.Serialize(), payload...))
+	return err
+}
+
+// remove forgets a closed session; its ID may be opened again
+func (m *SMPMux) remove(s *SMPSession) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions[s.id] == s {
+		delete(m.sessions, s.id)
+	}
+}
+
+// fail ends the connection: Accept and every session's Read return err
+func (m *SMPMux) fail(err error) {
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return
+	}
+	m.err = err
+	close(m.done)
+	m.conn.Close()
+	sessions := make([]*SMPSession, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mu.Unlock()
+
+	for _, s := range sessions {
+		s.abort(err)
+	}
+}
+
+// failure returns why the connection failed
+func (m *SMPMux) failure() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// SMPSession is one MARS session; it reads and writes whole TDS packets
+// Writes wait for the client's window, so a client that stops reading a
+// session's results holds up that session's writer and no others
+type SMPSession struct {
+	mux *SMPMux
+	id  uint16
+
+	mu   sync.Mutex
+	cond *sync.Cond // Signals received data, a wider client window and closing
+
+	// Receiving
+	received   [][]byte // DATA payloads not yet read
+	recvSeq    uint32   // Sequence number of the last DATA received
+	consumed   uint32   // DATA payloads read completely
+	advertised uint32   // Receive window last sent to the client
+	readErr    error    // Returned once received is drained
+
+	// Sending
+	sendSeq    uint32 // Sequence number of the last DATA sent
+	peerWindow uint32 // Highest sequence number the client accepts
+	closed     bool
+}
+
+func newSMPSession(mux *SMPMux, id uint16, peerWindow uint32) *SMPSession {
+	s := &SMPSession{
+		mux:        mux,
+		id:         id,
+		advertised: smpWindow,
+		peerWindow: peerWindow,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// ID returns the session identifier chosen by the client
+func (s *SMPSession) ID() uint16 {
+	return s.id
+}
+
+// Read reads the payload of the session's DATA packets
+// The receive window grows as payloads are consumed and is advertised with an ACK
+func (s *SMPSession) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.received) == 0 && s.readErr == nil {
+		s.cond.Wait()
+	}
+	if len(s.received) == 0 {
+		return 0, s.readErr
+	}
+
+	n := copy(p, s.received[0])
+	s.received[0] = s.received[0][n:]
+	if len(s.received[0]) > 0 {
+		return n, nil
+	}
+
+	s.received = s.received[1:]
+	s.consumed++
+	if s.readErr == nil && s.window()-s.advertised >= smpAckThreshold {
+		s.advertised = s.window()
+		if err := s.mux.writePacket(SMPFlagACK, s.id, s.sendSeq, s.advertised, nil); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Write sends p as one DATA packet, blocking until the client's window allows
+// it. A blocked Write returns an error when the session or connection closes
+func (s *SMPSession) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !s.closed && int32(s.peerWindow-s.sendSeq) <= 0 {
+		s.cond.Wait()
+	}
+	if err := s.mux.failure(); err != nil {
+		return 0, err
+	}
+	if s.closed {
+		return 0, net.ErrClosed
+	}
+
+	s.sendSeq++
+	s.advertised = s.window()
+	if err := s.mux.writePacket(SMPFlagDATA, s.id, s.sendSeq, s.advertised, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close ends the session with a FIN; blocked writes return net.ErrClosed
+func (s *SMPSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.close()
+}
+
+// LocalAddr returns the local address of the connection
+func (s *SMPSession) LocalAddr() net.Addr {
+	return s.mux.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the connection
+func (s *SMPSession) RemoteAddr() net.Addr {
+	return s.mux.conn.RemoteAddr()
+}
+
+// SetDeadline is not supported; sessions share the connection's deadlines
+func (s *SMPSession) SetDeadline(t time.Time) error {
+	return errors.New("tds: SMP sessions do not support deadlines")
+}
+
+// SetReadDeadline is not supported; sessions share the connection's deadlines
+func (s *SMPSession) SetReadDeadline(t time.Time) error {
+	return s.SetDeadline(t)
+}
+
+// SetWriteDeadline is not supported; sessions share the connection's deadlines
+func (s *SMPSession) SetWriteDeadline(t time.Time) error {
+	return s.SetDeadline(t)
+}
+
+// window returns the highest sequence number the session accepts
+func (s *SMPSession) window() uint32 {
+	return s.consumed + smpWindow
+}
+
+// receive queues a DATA payload for Read
+func (s *SMPSession) receive(seqNum, window uint32, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seqNum != s.recvSeq+1 || seqNum > s.advertised {
+		return fmt.Errorf("SMP session %d: DATA sequence number %d outside window (last %d, window %d)",
+			s.id, seqNum, s.recvSeq, s.advertised)
+	}
+	s.recvSeq = seqNum
+
+	if !s.closed {
+		s.received = append(s.received, payload)
+		s.cond.Broadcast()
+	}
+
+	return s.setPeerWindow(window)
+}
+
+// updateWindow applies the client's window from an ACK
+func (s *SMPSession) updateWindow(window uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setPeerWindow(window)
+}
+
+// setPeerWindow records the client's window and wakes writers waiting for it
+func (s *SMPSession) setPeerWindow(window uint32) error {
+	// Sequence numbers wrap; a window behind the current one is stale
+	if int32(window-s.peerWindow) > 0 {
+		s.peerWindow = window
+		s.cond.Broadcast()
+	}
+	return nil
+}
+
+// remoteClose handles the client's FIN: reads end and the FIN is answered
+func (s *SMPSession) remoteClose() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.readErr == nil {
+		s.readErr = io.EOF
+	}
+	s.cond.Broadcast()
+	_ = s.close()
+}
+
+// close sends the session's FIN once and forgets the session
+func (s *SMPSession) close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.readErr == nil {
+		s.readErr = net.ErrClosed
+	}
+	s.cond.Broadcast()
+	s.mux.remove(s)
+
+	if s.mux.failure() != nil {
+		return nil
+	}
+	return s.mux.writePacket(SMPFlagFIN, s.id, s.sendSeq, s.window(), nil)
+}
+
+// abort ends the session when the connection fails
+func (s *SMPSession) abort(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.readErr == nil {
+		s.readErr = err
+	}
+	s.cond.Broadcast()
+}
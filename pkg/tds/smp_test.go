@@ -0,0 +1,285 @@
+package tds
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// smpClient is the client end of an SMP connection in tests
+type smpClient struct {
+	t       *testing.T
+	conn    net.Conn
+	packets chan smpPacket
+}
+
+type smpPacket struct {
+	header  *SMPHeader
+	payload []byte
+}
+
+// newSMPTest connects a mux to a test client over an in-memory pipe
+func newSMPTest(t *testing.T) (*SMPMux, *smpClient) {
+	server, client := net.Pipe()
+	mux := NewSMPMux(server)
+	t.Cleanup(func() {
+		mux.Close()
+		client.Close()
+	})
+
+	c := &smpClient{t: t, conn: client, packets: make(chan smpPacket, 16)}
+	go func() {
+		defer close(c.packets)
+		buf := make([]byte, SMPHeaderSize)
+		for {
+			if _, err := io.ReadFull(client, buf); err != nil {
+				return
+			}
+			header, err := ParseSMPHeader(buf)
+			if err != nil {
+				return
+			}
+			payload := make([]byte, header.Length-SMPHeaderSize)
+			if _, err := io.ReadFull(client, payload); err != nil {
+				return
+			}
+			c.packets <- smpPacket{header, payload}
+		}
+	}()
+	return mux, c
+}
+
+func (c *smpClient) send(flags byte, sid uint16, seqNum, window uint32, payload []byte) {
+	c.t.Helper()
+	header := &SMPHeader{Flags: flags, SID: sid, Length: uint32(SMPHeaderSize + len(payload)), SeqNum: seqNum, Window: window}
+	if _, err := c.conn.Write(append(header.Serialize(), payload...)); err != nil {
+		c.t.Fatalf("client write: %v", err)
+	}
+}
+
+func (c *smpClient) expect(flags byte, seqNum uint32, payload []byte) *SMPHeader {
+	c.t.Helper()
+	select {
+	case p, ok := <-c.packets:
+		if !ok {
+			c.t.Fatal("connection closed, want a packet")
+		}
+		if p.header.Flags != flags || p.header.SeqNum != seqNum || !bytes.Equal(p.payload, payload) {
+			c.t.Fatalf("got flags %#02x seq %d payload %q, want flags %#02x seq %d payload %q",
+				p.header.Flags, p.header.SeqNum, p.payload, flags, seqNum, payload)
+		}
+		return p.header
+	case <-time.After(time.Second):
+		c.t.Fatalf("timed out waiting for flags %#02x seq %d", flags, seqNum)
+	}
+	return nil
+}
+
+func (c *smpClient) expectNothing() {
+	c.t.Helper()
+	select {
+	case p := <-c.packets:
+		c.t.Fatalf("unexpected packet: flags %#02x seq %d", p.header.Flags, p.header.SeqNum)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSMPHeader(t *testing.T) {
+	header := &SMPHeader{Flags: SMPFlagDATA, SID: 3, Length: 24, SeqNum: 7, Window: 10}
+	data := header.Serialize()
+
+	want := []byte{0x53, 0x08, 3, 0, 24, 0, 0, 0, 7, 0, 0, 0, 10, 0, 0, 0}
+	if !bytes.Equal(data, want) {
+		t.Errorf("Serialize() = % X, want % X", data, want)
+	}
+
+	parsed, err := ParseSMPHeader(data)
+	if err != nil || *parsed != *header {
+		t.Errorf("ParseSMPHeader() = %+v, %v, want %+v", parsed, err, header)
+	}
+
+	invalid := map[string][]byte{
+		"identifier":    append([]byte{0x12}, data[1:]...),
+		"short length":  {0x53, 0x08, 3, 0, 8, 0, 0, 0, 7, 0, 0, 0, 10, 0, 0, 0},
+		"ack with data": {0x53, 0x02, 3, 0, 20, 0, 0, 0, 7, 0, 0, 0, 10, 0, 0, 0},
+		"truncated":     data[:10],
+	}
+	for name, b := range invalid {
+		if _, err := ParseSMPHeader(b); err == nil {
+			t.Errorf("ParseSMPHeader(%s) error = nil, want error", name)
+		}
+	}
+}
+
+func TestSMPSessionWindows(t *testing.T) {
+	mux, client := newSMPTest(t)
+
+	// The client accepts one DATA packet until it acknowledges more
+	client.send(SMPFlagSYN, 1, 0, 1, nil)
+	session, err := mux.Accept()
+	if err != nil || session.ID() != 1 {
+		t.Fatalf("Accept() = %v, %v, want session 1", session, err)
+	}
+
+	client.send(SMPFlagDATA, 1, 1, 1, []byte("request"))
+	buf := make([]byte, 16)
+	if n, err := session.Read(buf); err != nil || string(buf[:n]) != "request" {
+		t.Fatalf("Read() = %q, %v, want request", buf[:n], err)
+	}
+
+	if _, err := session.Write([]byte("one")); err != nil {
+		t.Fatalf("Write(one) error = %v", err)
+	}
+	if h := client.expect(SMPFlagDATA, 1, []byte("one")); h.Window != smpWindow+1 {
+		t.Errorf("DATA window = %d, want %d", h.Window, smpWindow+1)
+	}
+
+	// The second packet blocks its writer until the client's ACK opens the window
+	written := make(chan error, 1)
+	go func() {
+		_, err := session.Write([]byte("two"))
+		written <- err
+	}()
+	client.expectNothing()
+	select {
+	case err := <-written:
+		t.Fatalf("Write(two) = %v before the ACK, want it to block", err)
+	default:
+	}
+
+	client.send(SMPFlagACK, 1, 1, 2, nil)
+	client.expect(SMPFlagDATA, 2, []byte("two"))
+	if err := <-written; err != nil {
+		t.Fatalf("Write(two) error = %v", err)
+	}
+
+	// Consuming packets opens the server's window with an ACK
+	client.send(SMPFlagDATA, 1, 2, 2, []byte("a"))
+	client.send(SMPFlagDATA, 1, 3, 2, []byte("b"))
+	for _, want := range []string{"a", "b"} {
+		if n, err := session.Read(buf); err != nil || string(buf[:n]) != want {
+			t.Fatalf("Read() = %q, %v, want %s", buf[:n], err, want)
+		}
+	}
+	if h := client.expect(SMPFlagACK, 2, nil); h.Window != 3+smpWindow {
+		t.Errorf("ACK window = %d, want %d", h.Window, 3+smpWindow)
+	}
+}
+
+func TestSMPSessionsAreIndependent(t *testing.T) {
+	mux, client := newSMPTest(t)
+
+	client.send(SMPFlagSYN, 0, 0, 0, nil)
+	first, _ := mux.Accept()
+	client.send(SMPFlagSYN, 1, 0, 4, nil)
+	second, _ := mux.Accept()
+
+	// Session 0 has no window, so its writer waits while session 1 is answered
+	written := make(chan error, 1)
+	go func() {
+		_, err := first.Write([]byte("blocked"))
+		written <- err
+	}()
+	if _, err := second.Write([]byte("free")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if h := client.expect(SMPFlagDATA, 1, []byte("free")); h.SID != 1 {
+		t.Errorf("DATA session = %d, want 1", h.SID)
+	}
+
+	client.send(SMPFlagACK, 0, 0, 1, nil)
+	if h := client.expect(SMPFlagDATA, 1, []byte("blocked")); h.SID != 0 {
+		t.Errorf("DATA session = %d, want 0", h.SID)
+	}
+	if err := <-written; err != nil {
+		t.Errorf("Write() error = %v", err)
+	}
+}
+
+func TestSMPSessionCloseUnblocksWrite(t *testing.T) {
+	mux, client := newSMPTest(t)
+
+	client.send(SMPFlagSYN, 2, 0, 0, nil)
+	session, _ := mux.Accept()
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := session.Write([]byte("never sent"))
+		written <- err
+	}()
+	client.expectNothing()
+
+	session.Close()
+	client.expect(SMPFlagFIN, 0, nil)
+	select {
+	case err := <-written:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Write() error = %v, want net.ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write() still blocked after Close()")
+	}
+}
+
+func TestSMPSessionFIN(t *testing.T) {
+	mux, client := newSMPTest(t)
+
+	client.send(SMPFlagSYN, 5, 0, 4, nil)
+	session, _ := mux.Accept()
+
+	client.send(SMPFlagDATA, 5, 1, 4, []byte("last"))
+	client.send(SMPFlagFIN, 5, 1, 4, nil)
+	client.expect(SMPFlagFIN, 0, nil)
+
+	// Data received before the FIN is still read
+	buf := make([]byte, 16)
+	if n, err := session.Read(buf); err != nil || string(buf[:n]) != "last" {
+		t.Fatalf("Read() = %q, %v, want last", buf[:n], err)
+	}
+	if _, err := session.Read(buf); err != io.EOF {
+		t.Errorf("Read() error = %v, want EOF", err)
+	}
+	if _, err := session.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Write() error = %v, want net.ErrClosed", err)
+	}
+
+	// The ID can be used again
+	client.send(SMPFlagSYN, 5, 0, 4, nil)
+	if s, err := mux.Accept(); err != nil || s == session {
+		t.Errorf("Accept() = %p, %v, want a new session", s, err)
+	}
+}
+
+func TestSMPProtocolViolation(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags byte
+		sid   uint16
+		seq   uint32
+	}{
+		{"data beyond window", SMPFlagDATA, 1, smpWindow + 1},
+		{"data out of order", SMPFlagDATA, 1, 2},
+		{"data for unknown session", SMPFlagDATA, 9, 1},
+		{"duplicate SYN", SMPFlagSYN, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, client := newSMPTest(t)
+			client.send(SMPFlagSYN, 1, 0, 4, nil)
+			session, _ := mux.Accept()
+
+			client.send(tt.flags, tt.sid, tt.seq, 4, nil)
+
+			if _, err := mux.Accept(); err == nil {
+				t.Error("Accept() error = nil, want protocol error")
+			}
+			if _, err := session.Read(make([]byte, 1)); err == nil {
+				t.Error("Read() error = nil, want protocol error")
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package tds
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ALL_HEADERS header types
+const (
+	HeaderTypeQueryNotifications    uint16 = 0x0001
+	HeaderTypeTransactionDescriptor uint16 = 0x0002
+	HeaderTypeTraceActivity         uint16 = 0x0003
+)
+
+// AllHeaders holds the ALL_HEADERS block that precedes SQLBatch and RPC requests (TDS 7.2+)
+type AllHeaders struct {
+	// Transaction descriptor header
+	HasTransactionDescriptor bool
+	TransactionDescriptor    uint64
+	OutstandingRequestCount  uint32
+
+	// Trace activity header
+	ActivityID       []byte // 16-byte GUID
+	ActivitySequence uint32
+
+	// Query notifications header
+	NotifyID         string
+	SSBDeployment    string
+	NotifyTimeout    uint32
+	HasNotifyTimeout bool
+}
+
+// SQLBatch represents a decoded SQLBatch request
+type SQLBatch struct {
+	Headers *AllHeaders // nil when the client sent no ALL_HEADERS (TDS 7.1)
+	SQL     string
+}
+
+// ParseSQLBatch decodes a SQLBatch payload: optional ALL_HEADERS followed by UTF-16LE text
+func ParseSQLBatch(data []byte) (*SQLBatch, error) {
+	batch := &SQLBatch{}
+
+	headers, n, err := ParseAllHeaders(data)
+	if err != nil {
+		return nil, err
+	}
+	batch.Headers = headers
+	data = data[n:]
+
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("SQL batch text has odd length %d", len(data))
+	}
+	batch.SQL = DecodeUCS2(data)
+
+	return batch, nil
+}
+
+// ParseAllHeaders parses an ALL_HEADERS block at the start of data
+// It returns nil and 0 when data doesn't start with one (TDS 7.1 clients send none)
+func ParseAllHeaders(data []byte) (*AllHeaders, int, error) {
+	if !hasAllHeaders(data) {
+		return nil, 0, nil
+	}
+
+	totalLength := int(binary.LittleEndian.Uint32(data[0:4]))
+	headers := &AllHeaders{}
+
+	pos := 4
+	for pos < totalLength {
+		headerLength := int(binary.LittleEndian.Uint32(data[pos : pos+4]))
+		headerType := binary.LittleEndian.Uint16(data[pos+4 : pos+6])
+		headerData := data[pos+6 : pos+headerLength]
+
+		switch headerType {
+		case HeaderTypeTransactionDescriptor:
+			if len(headerData) < 12 {
+				return nil, 0, fmt.Errorf("transaction descriptor header too short: %d bytes", len(headerData))
+			}
+			headers.HasTransactionDescriptor = true
+			headers.TransactionDescriptor = binary.LittleEndian.Uint64(headerData[0:8])
+			headers.OutstandingRequestCount = binary.LittleEndian.Uint32(headerData[8:12])
+
+		case HeaderTypeTraceActivity:
+			if len(headerData) < 20 {
+				return nil, 0, fmt.Errorf("trace activity header too short: %d bytes", len(headerData))
+			}
+			headers.ActivityID = append([]byte(nil), headerData[0:16]...)
+			headers.ActivitySequence = binary.LittleEndian.Uint32(headerData[16:20])
+
+		case HeaderTypeQueryNotifications:
+			if err := parseQueryNotifications(headers, headerData); err != nil {
+				return nil, 0, err
+			}
+
+		default:
+			return nil, 0, fmt.Errorf("unknown ALL_HEADERS header type %#04x", headerType)
+		}
+
+		pos += headerLength
+	}
+
+	return headers, totalLength, nil
+}
+
+// hasAllHeaders reports whether data starts with a well-formed ALL_HEADERS block
+// The block's total length must be covered exactly by its headers
+func hasAllHeaders(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+
+	totalLength := int(binary.LittleEndian.Uint32(data[0:4]))
+	if totalLength < 4 || totalLength > len(data) {
+		return false
+	}
+
+	pos := 4
+	for pos < totalLength {
+		if pos+6 > totalLength {
+			return false
+		}
+		headerLength := int(binary.LittleEndian.Uint32(data[pos : pos+4]))
+		if headerLength < 6 || pos+headerLength > totalLength {
+			return false
+		}
+		pos += headerLength
+	}
+
+	return pos == totalLength
+}
+
+// parseQueryNotifications parses the query notifications header data
+func parseQueryNotifications(headers *AllHeaders, data []byte) error {
+	pos := 0
+	readUSVarchar := func() (string, error) {
+		if pos+2 > len(data) {
+			return "", fmt.Errorf("query notifications header truncated")
+		}
+		length := int(binary.LittleEndian.Uint16(data[pos : pos+2]))
+		pos += 2
+		if pos+length > len(data) {
+			return "", fmt.Errorf("query notifications header truncated")
+		}
+		s := DecodeUCS2(data[pos : pos+length])
+		pos += length
+		return s, nil
+	}
+
+	var err error
+	if headers.NotifyID, err = readUSVarchar(); err != nil {
+		return err
+	}
+	if headers.SSBDeployment, err = readUSVarchar(); err != nil {
+		return err
+	}
+	if pos+4 <= len(data) {
+		headers.NotifyTimeout = binary.LittleEndian.Uint32(data[pos : pos+4])
+		headers.HasNotifyTimeout = true
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package tds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// buildAllHeaders builds an ALL_HEADERS block with a transaction descriptor and trace activity header
+func buildAllHeaders(descriptor uint64, outstanding uint32) []byte {
+	var headers []byte
+
+	txn := binary.LittleEndian.AppendUint32(nil, 18)
+	txn = binary.LittleEndian.AppendUint16(txn, HeaderTypeTransactionDescriptor)
+	txn = binary.LittleEndian.AppendUint64(txn, descriptor)
+	txn = binary.LittleEndian.AppendUint32(txn, outstanding)
+	headers = append(headers, txn...)
+
+	trace := binary.LittleEndian.AppendUint32(nil, 26)
+	trace = binary.LittleEndian.AppendUint16(trace, HeaderTypeTraceActivity)
+	trace = append(trace, bytes.Repeat([]byte{0xAB}, 16)...)
+	trace = binary.LittleEndian.AppendUint32(trace, 7)
+	headers = append(headers, trace...)
+
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(4+len(headers))), headers...)
+}
+
+func TestParseSQLBatch(t *testing.T) {
+	sql := "SELECT N'héllo wörld ✓', 1 FROM [tablé]"
+	data := append(buildAllHeaders(0x1122334455667788, 1), EncodeUCS2(sql)...)
+
+	batch, err := ParseSQLBatch(data)
+	if err != nil {
+		t.Fatalf("ParseSQLBatch() error = %v", err)
+	}
+
+	if batch.SQL != sql {
+		t.Errorf("SQL = %q, want %q", batch.SQL, sql)
+	}
+	if batch.Headers == nil {
+		t.Fatal("Headers = nil, want parsed ALL_HEADERS")
+	}
+	if !batch.Headers.HasTransactionDescriptor || batch.Headers.TransactionDescriptor != 0x1122334455667788 {
+		t.Errorf("TransactionDescriptor = %#x, want 0x1122334455667788", batch.Headers.TransactionDescriptor)
+	}
+	if batch.Headers.OutstandingRequestCount != 1 {
+		t.Errorf("OutstandingRequestCount = %d, want 1", batch.Headers.OutstandingRequestCount)
+	}
+	if len(batch.Headers.ActivityID) != 16 || batch.Headers.ActivitySequence != 7 {
+		t.Errorf("trace activity = %x/%d, want 16 bytes/7", batch.Headers.ActivityID, batch.Headers.ActivitySequence)
+	}
+}
+
+func TestParseSQLBatchWithoutHeaders(t *testing.T) {
+	// TDS 7.1 clients send the text alone
+	sql := "SELECT 1"
+	batch, err := ParseSQLBatch(EncodeUCS2(sql))
+	if err != nil {
+		t.Fatalf("ParseSQLBatch() error = %v", err)
+	}
+	if batch.Headers != nil {
+		t.Errorf("Headers = %+v, want nil", batch.Headers)
+	}
+	if batch.SQL != sql {
+		t.Errorf("SQL = %q, want %q", batch.SQL, sql)
+	}
+}
+
+func TestParseSQLBatchInvalid(t *testing.T) {
+	// Odd-length text
+	data := append(buildAllHeaders(0, 1), 'S', 0, 'E')
+	if _, err := ParseSQLBatch(data); err == nil {
+		t.Error("ParseSQLBatch() accepted odd-length text")
+	}
+
+	// Unknown header type
+	bad := binary.LittleEndian.AppendUint32(nil, 10)
+	bad = binary.LittleEndian.AppendUint32(bad, 6)
+	bad = binary.LittleEndian.AppendUint16(bad, 0x0009)
+	if _, err := ParseSQLBatch(append(bad, EncodeUCS2("SELECT 1")...)); err == nil {
+		t.Error("ParseSQLBatch() accepted an unknown header type")
+	}
+}
@@ -3,6 +3,9 @@ package tds
 import (
 	"fmt"
 	"strings"
+
+	"github.com/factory/mssql-tds-server/pkg/sqlerror"
+	"github.com/factory/mssql-tds-server/pkg/sqlexecutor"
 )
 
 // StoredProcedureHandler manages stored procedure execution
@@ -14,25 +17,45 @@ func NewStoredProcedureHandler() *StoredProcedureHandler {
 }
 
 // Execute executes a stored procedure with given parameters and returns results
-func (h *StoredProcedureHandler) Execute(procName string, params []*RPCParameter) ([][]string, error) {
+func (h *StoredProcedureHandler) Execute(procName string, params []*RPCParameter) (*sqlexecutor.ExecuteResult, error) {
 	// Normalize procedure name
 	procName = strings.ToUpper(strings.TrimSpace(procName))
 
 	// Handle different stored procedures
+	var rows [][]interface{}
+	var err error
 	switch procName {
 	case "SP_HELLO", "HELLO_WORLD", "TEST_PROC":
-		return h.executeHelloWorld(params)
+		rows, err = h.executeHelloWorld(params)
 	case "SP_ECHO", "ECHO_PROC":
-		return h.executeEcho(params)
+		rows, err = h.executeEcho(params)
 	case "SP_GET_DATA", "GET_DATA":
-		return h.executeGetData(params)
+		rows, err = h.executeGetData(params)
 	default:
-		return nil, fmt.Errorf("stored procedure '%s' not found", procName)
+		return nil, sqlerror.New(sqlerror.ProcedureNotFound, sqlerror.ClassUserError,
+			"Could not find stored procedure '%s'.", procName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := h.GetResultColumns(procName)
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.Name
 	}
+
+	return &sqlexecutor.ExecuteResult{
+		Columns:     names,
+		ColumnTypes: columns,
+		Rows:        rows,
+		RowCount:    int64(len(rows)),
+		IsQuery:     true,
+	}, nil
 }
 
 // executeHelloWorld is a simple test stored procedure
-func (h *StoredProcedureHandler) executeHelloWorld(params []*RPCParameter) ([][]string, error) {
+func (h *StoredProcedureHandler) executeHelloWorld(params []*RPCParameter) ([][]interface{}, error) {
 	var greeting string
 	if len(params) > 0 {
 		if name, ok := params[0].Value.(string); ok {
@@ -44,23 +67,26 @@ func (h *StoredProcedureHandler) executeHelloWorld(params []*RPCParameter) ([][]
 		greeting = "Hello, World!"
 	}
 
-	return [][]string{
+	return [][]interface{}{
 		{greeting},
 	}, nil
 }
 
 // executeEcho echoes back the input parameters
-func (h *StoredProcedureHandler) executeEcho(params []*RPCParameter) ([][]string, error) {
+// A NULL parameter is echoed back as NULL
+func (h *StoredProcedureHandler) executeEcho(params []*RPCParameter) ([][]interface{}, error) {
 	if len(params) == 0 {
-		return [][]string{
+		return [][]interface{}{
 			{"No parameters provided"},
 		}, nil
 	}
 
-	results := make([][]string, 0)
+	results := make([][]interface{}, 0)
 	for i, param := range params {
-		var value string
+		var value interface{}
 		switch v := param.Value.(type) {
+		case nil:
+			value = nil
 		case string:
 			value = fmt.Sprintf("Param %d: %s", i+1, strings.ToUpper(v))
 		case int32:
@@ -72,20 +98,20 @@ func (h *StoredProcedureHandler) executeEcho(params []*RPCParameter) ([][]string
 		default:
 			value = fmt.Sprintf("Param %d: %v (UNKNOWN TYPE)", i+1, v)
 		}
-		results = append(results, []string{value})
+		results = append(results, []interface{}{value})
 	}
 
 	return results, nil
 }
 
 // executeGetData returns sample data for testing
-func (h *StoredProcedureHandler) executeGetData(params []*RPCParameter) ([][]string, error) {
+func (h *StoredProcedureHandler) executeGetData(params []*RPCParameter) ([][]interface{}, error) {
 	// Return sample data set
-	results := [][]string{
-		{"1", "John", "Doe", "Engineering"},
-		{"2", "Jane", "Smith", "Marketing"},
-		{"3", "Bob", "Johnson", "Sales"},
-		{"4", "Alice", "Williams", "HR"},
+	results := [][]interface{}{
+		{int64(1), "John", "Doe", "Engineering"},
+		{int64(2), "Jane", "Smith", "Marketing"},
+		{int64(3), "Bob", "Johnson", "Sales"},
+		{int64(4), "Alice", "Williams", "HR"},
 	}
 
 	// Filter results if parameters are provided
@@ -96,9 +122,9 @@ func (h *StoredProcedureHandler) executeGetData(params []*RPCParameter) ([][]str
 		}
 
 		if deptFilter != "" {
-			filtered := make([][]string, 0)
+			filtered := make([][]interface{}, 0)
 			for _, row := range results {
-				if len(row) >= 4 && strings.Contains(strings.ToUpper(row[3]), deptFilter) {
+				if dept, ok := row[3].(string); ok && strings.Contains(strings.ToUpper(dept), deptFilter) {
 					filtered = append(filtered, row)
 				}
 			}
@@ -124,3 +150,24 @@ func (h *StoredProcedureHandler) GetProcedureInfo(procName string) (string, []st
 		return "", []string{}
 	}
 }
+
+// GetResultColumns returns the result columns of a stored procedure
+func (h *StoredProcedureHandler) GetResultColumns(procName string) []sqlexecutor.Column {
+	_, descriptions := h.GetProcedureInfo(procName)
+
+	columns := make([]sqlexecutor.Column, len(descriptions))
+	for i, desc := range descriptions {
+		// Descriptions look like "FirstName (VARCHAR)"
+		name, declType := desc, ""
+		if idx := strings.Index(desc, " ("); idx >= 0 {
+			name = desc[:idx]
+			declType = strings.TrimSuffix(desc[idx+2:], ")")
+		}
+
+		col := sqlexecutor.ParseDeclaredType(declType)
+		col.Name = name
+		col.Nullable = true
+		columns[i] = col
+	}
+	return columns
+}
@@ -0,0 +1,213 @@
+package tds
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// TokenType represents a TDS tabular response token type
+type TokenType byte
+
+const (
+	TokenTypeReturnStatus TokenType = 0x79
+	TokenTypeColMetadata  TokenType = 0x81
+	TokenTypeOrder        TokenType = 0xA9
+	TokenTypeError        TokenType = 0xAA
+	TokenTypeInfo         TokenType = 0xAB
+	TokenTypeReturnValue  TokenType = 0xAC
+	TokenTypeLoginAck     TokenType = 0xAD
+	TokenTypeFeatureAck   TokenType = 0xAE
+	TokenTypeRow          TokenType = 0xD1
+	TokenTypeNBCRow       TokenType = 0xD2
+	TokenTypeEnvChange    TokenType = 0xE3
+	TokenTypeDone         TokenType = 0xFD
+	TokenTypeDoneProc     TokenType = 0xFE
+	TokenTypeDoneInProc   TokenType = 0xFF
+)
+
+// DONE token status bits
+const (
+	DoneFinal    uint16 = 0x0000
+	DoneMore     uint16 = 0x0001
+	DoneError    uint16 = 0x0002
+	DoneInxact   uint16 = 0x0004
+	DoneCount    uint16 = 0x0010
+	DoneAttn     uint16 = 0x0020
+	DoneSrvError uint16 = 0x0100
+)
+
+// RETURNVALUE status values
+const (
+	ReturnValueStatusOutput byte = 0x01 // Value of an OUTPUT parameter
+	ReturnValueStatusUDF    byte = 0x02 // Return value of a user-defined function
+)
+
+// LOGINACK interface values
+const (
+	LoginAckInterfaceSQLDefault = 0x00
+	LoginAckInterfaceTSQL       = 0x01
+)
+
+// Server identity reported in LOGINACK
+const (
+	ServerProgName     = "Microsoft SQL Server"
+	ServerVersionMajor = 16
+	ServerVersionMinor = 0
+	ServerVersionBuild = 1000
+)
+
+// TokenStream accumulates TDS tokens for a tabular response message
+type TokenStream struct {
+	buf []byte
+}
+
+// NewTokenStream creates an empty token stream
+func NewTokenStream() *TokenStream {
+	return &TokenStream{}
+}
+
+// Bytes returns the encoded token stream
+func (ts *TokenStream) Bytes() []byte {
+	return ts.buf
+}
+
+// Len returns the encoded token stream length
+func (ts *TokenStream) Len() int {
+	return len(ts.buf)
+}
+
+// Reset discards all buffered tokens
+func (ts *TokenStream) Reset() {
+	ts.buf = ts.buf[:0]
+}
+
+func (ts *TokenStream) writeByte(v byte) {
+	ts.buf = append(ts.buf, v)
+}
+
+func (ts *TokenStream) writeUint16(v uint16) {
+	ts.buf = binary.LittleEndian.AppendUint16(ts.buf, v)
+}
+
+func (ts *TokenStream) writeUint32(v uint32) {
+	ts.buf = binary.LittleEndian.AppendUint32(ts.buf, v)
+}
+
+func (ts *TokenStream) writeUint64(v uint64) {
+	ts.buf = binary.LittleEndian.AppendUint64(ts.buf, v)
+}
+
+// writeBVarchar writes a B_VARCHAR (byte character count + UTF-16LE)
+func (ts *TokenStream) writeBVarchar(s string) {
+	encoded := EncodeUCS2(s)
+	ts.writeByte(byte(len(encoded) / 2))
+	ts.buf = append(ts.buf, encoded...)
+}
+
+// writeUSVarchar writes a US_VARCHAR (uint16 character count + UTF-16LE)
+func (ts *TokenStream) writeUSVarchar(s string) {
+	encoded := EncodeUCS2(s)
+	ts.writeUint16(uint16(len(encoded) / 2))
+	ts.buf = append(ts.buf, encoded...)
+}
+
+// LoginAck writes a LOGINACK token
+func (ts *TokenStream) LoginAck(tdsVersion uint32) {
+	progName := EncodeUCS2(ServerProgName)
+
+	// Interface(1) + TDSVersion(4) + ProgName(1 + n) + ProgVersion(4)
+	length := 1 + 4 + 1 + len(progName) + 4
+
+	ts.writeByte(byte(TokenTypeLoginAck))
+	ts.writeUint16(uint16(length))
+	ts.writeByte(LoginAckInterfaceTSQL)
+	// TDS version is sent big-endian in LOGINACK
+	ts.buf = binary.BigEndian.AppendUint32(ts.buf, tdsVersion)
+	ts.writeByte(byte(len(progName) / 2))
+	ts.buf = append(ts.buf, progName...)
+	ts.writeByte(ServerVersionMajor)
+	ts.writeByte(ServerVersionMinor)
+	ts.writeByte(byte(ServerVersionBuild >> 8))
+	ts.writeByte(byte(ServerVersionBuild & 0xFF))
+}
+
+// Error writes an ERROR token
+func (ts *TokenStream) Error(number int32, state byte, class byte, message, serverName, procName string, lineNumber int32) {
+	ts.message(TokenTypeError, number, state, class, message, serverName, procName, lineNumber)
+}
+
+// Info writes an INFO token
+func (ts *TokenStream) Info(number int32, state byte, class byte, message, serverName, procName string, lineNumber int32) {
+	ts.message(TokenTypeInfo, number, state, class, message, serverName, procName, lineNumber)
+}
+
+// message writes an ERROR or INFO token; both share the same layout
+func (ts *TokenStream) message(tokenType TokenType, number int32, state byte, class byte, message, serverName, procName string, lineNumber int32) {
+	msg := EncodeUCS2(message)
+	server := EncodeUCS2(serverName)
+	proc := EncodeUCS2(procName)
+
+	// Number(4) + State(1) + Class(1) + MsgText(2 + n) + ServerName(1 + n) + ProcName(1 + n) + LineNumber(4)
+	length := 4 + 1 + 1 + 2 + len(msg) + 1 + len(server) + 1 + len(proc) + 4
+
+	ts.writeByte(byte(tokenType))
+	ts.writeUint16(uint16(length))
+	ts.writeUint32(uint32(number))
+	ts.writeByte(state)
+	ts.writeByte(class)
+	ts.writeUSVarchar(message)
+	ts.writeBVarchar(serverName)
+	ts.writeBVarchar(procName)
+	ts.writeUint32(uint32(lineNumber))
+}
+
+// Done writes a DONE token
+func (ts *TokenStream) Done(status uint16, curCmd uint16, rowCount uint64) {
+	ts.done(TokenTypeDone, status, curCmd, rowCount)
+}
+
+// DoneProc writes a DONEPROC token ending an RPC
+func (ts *TokenStream) DoneProc(status uint16, curCmd uint16, rowCount uint64) {
+	ts.done(TokenTypeDoneProc, status, curCmd, rowCount)
+}
+
+// DoneInProc writes a DONEINPROC token ending a statement within an RPC
+func (ts *TokenStream) DoneInProc(status uint16, curCmd uint16, rowCount uint64) {
+	ts.done(TokenTypeDoneInProc, status, curCmd, rowCount)
+}
+
+// ReturnStatus writes a RETURNSTATUS token with a procedure's return code
+func (ts *TokenStream) ReturnStatus(value int32) {
+	ts.writeByte(byte(TokenTypeReturnStatus))
+	ts.writeUint32(uint32(value))
+}
+
+// ReturnValue writes a RETURNVALUE token carrying an OUTPUT parameter's value
+// Nothing is written when the value cannot be encoded as the column's type
+func (ts *TokenStream) ReturnValue(ordinal uint16, name string, col *ColumnInfo, value interface{}) error {
+	encoded := NewTokenStream()
+	if err := encoded.writeValue(col, value); err != nil {
+		return fmt.Errorf("parameter '%s': %w", name, err)
+	}
+
+	ts.writeByte(byte(TokenTypeReturnValue))
+	ts.writeUint16(ordinal)
+	ts.writeBVarchar(name)
+	ts.writeByte(ReturnValueStatusOutput)
+
+	// UserType and flags
+	ts.writeUint32(0)
+	ts.writeUint16(ColumnFlagNullable)
+
+	ts.writeTypeInfo(col)
+	ts.buf = append(ts.buf, encoded.buf...)
+	return nil
+}
+
+// done writes a DONE, DONEPROC or DONEINPROC token
+func (ts *TokenStream) done(tokenType TokenType, status uint16, curCmd uint16, rowCount uint64) {
+	ts.writeByte(byte(tokenType))
+	ts.writeUint16(status)
+	ts.writeUint16(curCmd)
+	ts.writeUint64(rowCount)
+}
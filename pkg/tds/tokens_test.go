@@ -0,0 +1,80 @@
+package tds
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestErrorToken(t *testing.T) {
+	ts := NewTokenStream()
+	ts.Error(208, 1, 16, "Bad", "S", "p", 3)
+
+	want := []byte{
+		0xAA, 0x18, 0x00, // Token, length 24
+		0xD0, 0x00, 0x00, 0x00, // Number 208
+		0x01, 0x10, // State, class
+		0x03, 0x00, 'B', 0, 'a', 0, 'd', 0, // US_VARCHAR message
+		0x01, 'S', 0, // B_VARCHAR server name
+		0x01, 'p', 0, // B_VARCHAR procedure name
+		0x03, 0x00, 0x00, 0x00, // Line number
+	}
+	if got := ts.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("ERROR token = % X, want % X", got, want)
+	}
+}
+
+func TestInfoToken(t *testing.T) {
+	ts := NewTokenStream()
+	ts.Info(5701, 2, 0, "", "", "", 1)
+
+	want := []byte{
+		0xAB, 0x0E, 0x00,
+		0x45, 0x16, 0x00, 0x00,
+		0x02, 0x00,
+		0x00, 0x00,
+		0x00,
+		0x00,
+		0x01, 0x00, 0x00, 0x00,
+	}
+	if got := ts.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("INFO token = % X, want % X", got, want)
+	}
+}
+
+func TestReturnStatusToken(t *testing.T) {
+	ts := NewTokenStream()
+	ts.ReturnStatus(-1)
+
+	want := []byte{0x79, 0xFF, 0xFF, 0xFF, 0xFF}
+	if got := ts.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("RETURNSTATUS token = % X, want % X", got, want)
+	}
+}
+
+func TestReturnValueToken(t *testing.T) {
+	ts := NewTokenStream()
+	col := &ColumnInfo{Type: TypeIntN, Size: 4, Nullable: true}
+	if err := ts.ReturnValue(0, "@h", col, int64(5)); err != nil {
+		t.Fatalf("ReturnValue() error = %v", err)
+	}
+
+	want := []byte{
+		0xAC,
+		0x00, 0x00, // Ordinal
+		0x02, '@', 0, 'h', 0, // B_VARCHAR name
+		0x01,                   // Status: output parameter
+		0x00, 0x00, 0x00, 0x00, // UserType
+		0x01, 0x00, // Flags: nullable
+		0x26, 0x04, // TYPE_INFO INTN(4)
+		0x04, 0x05, 0x00, 0x00, 0x00, // Value
+	}
+	if got := ts.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("RETURNVALUE token = % X, want % X", got, want)
+	}
+
+	// Unencodable values write nothing
+	ts.Reset()
+	if err := ts.ReturnValue(0, "@h", col, "x"); err == nil || ts.Len() != 0 {
+		t.Errorf("ReturnValue(string) = %v with %d bytes, want error and no output", err, ts.Len())
+	}
+}
@@ -0,0 +1,130 @@
+package tds
+
+import "fmt"
+
+// Transaction manager request types
+const (
+	TMGetDTCAddress uint16 = 0
+	TMPropagateXact uint16 = 1
+	TMBeginXact     uint16 = 5
+	TMPromoteXact   uint16 = 6
+	TMCommitXact    uint16 = 7
+	TMRollbackXact  uint16 = 8
+	TMSaveXact      uint16 = 9
+)
+
+// Isolation levels carried by TM_BEGIN_XACT
+const (
+	IsolationLevelUnchanged       byte = 0x00
+	IsolationLevelReadUncommitted byte = 0x01
+	IsolationLevelReadCommitted   byte = 0x02
+	IsolationLevelRepeatableRead  byte = 0x03
+	IsolationLevelSerializable    byte = 0x04
+	IsolationLevelSnapshot        byte = 0x05
+)
+
+// fBeginXact in TM_COMMIT_XACT and TM_ROLLBACK_XACT: start a new transaction afterwards
+const tmBeginXactFlag byte = 0x01
+
+// TransMgrRequest represents a transaction manager request
+type TransMgrRequest struct {
+	Headers *AllHeaders // nil when the client sent no ALL_HEADERS (TDS 7.1)
+	Type    uint16
+
+	// Name of the transaction to begin, commit or roll back, or of the savepoint
+	// to save or roll back to
+	Name string
+
+	// IsolationLevel of the transaction TM_BEGIN_XACT starts
+	IsolationLevel byte
+
+	// BeginNew asks TM_COMMIT_XACT and TM_ROLLBACK_XACT to start a new
+	// transaction, named NewName with NewIsolationLevel, once the current one ends
+	BeginNew          bool
+	NewName           string
+	NewIsolationLevel byte
+}
+
+// ParseTransMgrRequest parses a transaction manager request message
+func ParseTransMgrRequest(data []byte) (*TransMgrRequest, error) {
+	req := &TransMgrRequest{}
+
+	headers, n, err := ParseAllHeaders(data)
+	if err != nil {
+		return nil, err
+	}
+	req.Headers = headers
+
+	r := &payloadReader{data: data, pos: n}
+
+	req.Type, err = r.readUint16()
+	if err != nil {
+		return nil, fmt.Errorf("error reading transaction manager request type: %w", err)
+	}
+
+	switch req.Type {
+	case TMBeginXact:
+		req.IsolationLevel, err = r.readByte()
+		if err != nil {
+			return nil, fmt.Errorf("error reading isolation level: %w", err)
+		}
+		req.Name, err = r.readBVarchar()
+		if err != nil {
+			return nil, fmt.Errorf("error reading transaction name: %w", err)
+		}
+
+	case TMCommitXact, TMRollbackXact:
+		req.Name, err = r.readBVarchar()
+		if err != nil {
+			return nil, fmt.Errorf("error reading transaction name: %w", err)
+		}
+		flags, err := r.readByte()
+		if err != nil {
+			return nil, fmt.Errorf("error reading transaction flags: %w", err)
+		}
+		if flags&tmBeginXactFlag != 0 {
+			req.BeginNew = true
+			req.NewIsolationLevel, err = r.readByte()
+			if err != nil {
+				return nil, fmt.Errorf("error reading new isolation level: %w", err)
+			}
+			req.NewName, err = r.readBVarchar()
+			if err != nil {
+				return nil, fmt.Errorf("error reading new transaction name: %w", err)
+			}
+		}
+
+	case TMSaveXact:
+		req.Name, err = r.readBVarchar()
+		if err != nil {
+			return nil, fmt.Errorf("error reading savepoint name: %w", err)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported transaction manager request type %d", req.Type)
+	}
+
+	if req.IsolationLevel > IsolationLevelSnapshot || req.NewIsolationLevel > IsolationLevelSnapshot {
+		return nil, fmt.Errorf("invalid isolation level %d", max(req.IsolationLevel, req.NewIsolationLevel))
+	}
+
+	return req, nil
+}
+
+// IsolationLevelName returns the T-SQL name of an isolation level
+func IsolationLevelName(level byte) string {
+	switch level {
+	case IsolationLevelReadUncommitted:
+		return "READ UNCOMMITTED"
+	case IsolationLevelReadCommitted:
+		return "READ COMMITTED"
+	case IsolationLevelRepeatableRead:
+		return "REPEATABLE READ"
+	case IsolationLevelSerializable:
+		return "SERIALIZABLE"
+	case IsolationLevelSnapshot:
+		return "SNAPSHOT"
+	default:
+		return "UNCHANGED"
+	}
+}
@@ -0,0 +1,79 @@
+package tds
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// buildTransMgr builds a transaction manager request the way a client driver would
+func buildTransMgr(descriptor uint64, requestType uint16, payload ...byte) []byte {
+	data := buildAllHeaders(descriptor, 1)
+	data = binary.LittleEndian.AppendUint16(data, requestType)
+	return append(data, payload...)
+}
+
+// bVarchar encodes a B_VARCHAR
+func bVarchar(s string) []byte {
+	return append([]byte{byte(len([]rune(s)))}, EncodeUCS2(s)...)
+}
+
+func TestParseTransMgrRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want TransMgrRequest
+	}{
+		{
+			name: "begin",
+			data: buildTransMgr(0, TMBeginXact, append([]byte{IsolationLevelSerializable}, bVarchar("t1")...)...),
+			want: TransMgrRequest{Type: TMBeginXact, IsolationLevel: IsolationLevelSerializable, Name: "t1"},
+		},
+		{
+			name: "commit",
+			data: buildTransMgr(7, TMCommitXact, 0, 0),
+			want: TransMgrRequest{Type: TMCommitXact},
+		},
+		{
+			name: "rollback and begin",
+			data: buildTransMgr(7, TMRollbackXact, append(append(bVarchar(""), tmBeginXactFlag, IsolationLevelReadCommitted), bVarchar("next")...)...),
+			want: TransMgrRequest{Type: TMRollbackXact, BeginNew: true, NewIsolationLevel: IsolationLevelReadCommitted, NewName: "next"},
+		},
+		{
+			name: "save",
+			data: buildTransMgr(7, TMSaveXact, bVarchar("sp1")...),
+			want: TransMgrRequest{Type: TMSaveXact, Name: "sp1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ParseTransMgrRequest(tt.data)
+			if err != nil {
+				t.Fatalf("ParseTransMgrRequest() error = %v", err)
+			}
+			if req.Headers == nil || !req.Headers.HasTransactionDescriptor {
+				t.Fatalf("Headers = %+v, want a transaction descriptor", req.Headers)
+			}
+			req.Headers = nil
+			if *req != tt.want {
+				t.Errorf("ParseTransMgrRequest() = %+v, want %+v", *req, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTransMgrRequestInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"unsupported type":  buildTransMgr(0, TMPromoteXact),
+		"isolation level":   buildTransMgr(0, TMBeginXact, 9, 0),
+		"truncated name":    buildTransMgr(0, TMSaveXact, 3, 'a', 0),
+		"missing flags":     buildTransMgr(0, TMCommitXact, 0),
+		"missing new level": buildTransMgr(0, TMCommitXact, 0, tmBeginXactFlag),
+	}
+
+	for name, data := range tests {
+		if _, err := ParseTransMgrRequest(data); err == nil {
+			t.Errorf("ParseTransMgrRequest(%s) error = nil, want error", name)
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package tds
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DataType represents a TDS data type code (TYPE_INFO)
+type DataType byte
+
+const (
+	// Fixed-length types
+	TypeNull      DataType = 0x1F
+	TypeInt1      DataType = 0x30
+	TypeBit       DataType = 0x32
+	TypeInt2      DataType = 0x34
+	TypeInt4      DataType = 0x38
+	TypeDateTime4 DataType = 0x3A
+	TypeFlt4      DataType = 0x3B
+	TypeMoney     DataType = 0x3C
+	TypeDateTime  DataType = 0x3D
+	TypeFlt8      DataType = 0x3E
+	TypeMoney4    DataType = 0x7A
+	TypeInt8      DataType = 0x7F
+
+	// Variable-length types
+	TypeGUID           DataType = 0x24
+	TypeIntN           DataType = 0x26
+	TypeDateN          DataType = 0x28
+	TypeTimeN          DataType = 0x29
+	TypeDateTime2N     DataType = 0x2A
+	TypeDateTimeOffset DataType = 0x2B
+	TypeBitN           DataType = 0x68
+	TypeDecimalN       DataType = 0x6A
+	TypeNumericN       DataType = 0x6C
+	TypeFltN           DataType = 0x6D
+	TypeMoneyN         DataType = 0x6E
+	TypeDateTimeN      DataType = 0x6F
+	TypeBigVarBinary   DataType = 0xA5
+	TypeBigVarChar     DataType = 0xA7
+	TypeBigBinary      DataType = 0xAD
+	TypeBigChar        DataType = 0xAF
+	TypeNVarChar       DataType = 0xE7
+	TypeNChar          DataType = 0xEF
+	TypeXML            DataType = 0xF1
+	TypeText           DataType = 0x23
+	TypeImage          DataType = 0x22
+	TypeNText          DataType = 0x63
+)
+
+// Column metadata flags
+const (
+	ColumnFlagNullable = 0x0001
+)
+
+// Size limits for variable-length columns
+const (
+	maxVarSize = 8000   // Largest non-MAX NVARCHAR/VARBINARY size in bytes
+	sizeMax    = 0xFFFF // Size sent in TYPE_INFO for (MAX) columns
+)
+
+// Default precision and scale for DECIMAL columns without a declared size
+const (
+	DefaultDecimalPrecision = 18
+	MaxDecimalPrecision     = 38
+)
+
+// PLP (partially length-prefixed) markers
+const (
+	plpNull    = 0xFFFFFFFFFFFFFFFF
+	plpUnknown = 0xFFFFFFFFFFFFFFFE
+)
+
+// defaultCollation is Latin1_General_CI_AS (LCID 0x0409)
+var defaultCollation = []byte{0x09, 0x04, 0xD0, 0x00, 0x34}
+
+// datetime2Scale is the fractional second precision sent for DATETIME2 columns
+const datetime2Scale = 7
+
+// dayZero is the DATE/DATETIME2 epoch
+var dayZero = time.Date(1, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ColumnInfo describes a result set column as sent in COLMETADATA
+type ColumnInfo struct {
+	Name      string
+	Type      DataType
+	Size      int // Byte length; -1 for (MAX) columns sent as PLP
+	Precision byte
+	Scale     byte
+	Nullable  bool
+}
+
+// IsMax reports whether the column is sent as a PLP (MAX) value
+func (c *ColumnInfo) IsMax() bool {
+	return c.Size < 0
+}
+
+// writeTypeInfo writes the TYPE_INFO of a column
+func (ts *TokenStream) writeTypeInfo(col *ColumnInfo) {
+	ts.writeByte(byte(col.Type))
+
+	switch col.Type {
+	case TypeIntN, TypeFltN, TypeBitN, TypeGUID:
+		ts.writeByte(byte(col.Size))
+	case TypeDecimalN, TypeNumericN:
+		ts.writeByte(byte(col.Size))
+		ts.writeByte(col.Precision)
+		ts.writeByte(col.Scale)
+	case TypeDateTime2N:
+		ts.writeByte(col.Scale)
+	case TypeNVarChar, TypeBigVarChar:
+		ts.writeUint16(varSize(col))
+		ts.buf = append(ts.buf, defaultCollation...)
+	case TypeBigVarBinary:
+		ts.writeUint16(varSize(col))
+	}
+}
+
+// varSize returns the TYPE_INFO max length of a variable-length column
+func varSize(col *ColumnInfo) uint16 {
+	if col.IsMax() {
+		return sizeMax
+	}
+	return uint16(col.Size)
+}
+
+// writeValue writes a single column value in the column's wire format
+func (ts *TokenStream) writeValue(col *ColumnInfo, value interface{}) error {
+	if value == nil {
+		ts.writeNull(col)
+		return nil
+	}
+
+	switch col.Type {
+	case TypeIntN:
+		v, ok := toInt64(value)
+		if !ok || !fitsInt(v, col.Size) {
+			return fmt.Errorf("cannot convert %T to INT(%d)", value, col.Size)
+		}
+		ts.writeByte(byte(col.Size))
+		switch col.Size {
+		case 1:
+			ts.writeByte(byte(v))
+		case 2:
+			ts.writeUint16(uint16(v))
+		case 4:
+			ts.writeUint32(uint32(v))
+		default:
+			ts.writeUint64(uint64(v))
+		}
+
+	case TypeBitN:
+		v, ok := toBool(value)
+		if !ok {
+			return fmt.Errorf("cannot convert %T to BIT", value)
+		}
+		ts.writeByte(1)
+		if v {
+			ts.writeByte(1)
+		} else {
+			ts.writeByte(0)
+		}
+
+	case TypeFltN:
+		v, ok := toFloat64(value)
+		if !ok {
+			return fmt.Errorf("cannot convert %T to FLOAT", value)
+		}
+		ts.writeByte(8)
+		ts.writeUint64(math.Float64bits(v))
+
+	case TypeDecimalN, TypeNumericN:
+		v, ok := toDecimal(value, col.Precision, col.Scale)
+		if !ok {
+			return fmt.Errorf("cannot convert %v to DECIMAL(%d,%d)", value, col.Precision, col.Scale)
+		}
+		ts.writeDecimal(v, col.Size)
+
+	case TypeDateTime2N:
+		v, ok := toTime(value)
+		if !ok {
+			return fmt.Errorf("cannot convert %v to DATETIME2", value)
+		}
+		ts.writeDateTime2(v)
+
+	case TypeGUID:
+		v, ok := toGUID(value)
+		if !ok {
+			return fmt.Errorf("cannot convert %v to UNIQUEIDENTIFIER", value)
+		}
+		ts.writeByte(16)
+		ts.buf = append(ts.buf, v...)
+
+	case TypeNVarChar:
+		ts.writeVarBytes(col, EncodeUCS2(toString(value)))
+
+	case TypeBigVarBinary:
+		v, ok := toBytes(value)
+		if !ok {
+			return fmt.Errorf("cannot convert %T to VARBINARY", value)
+		}
+		ts.writeVarBytes(col, v)
+
+	default:
+		return fmt.Errorf("unsupported column type %#02x", col.Type)
+	}
+
+	return nil
+}
+
+// writeNull writes a NULL value for a column
+func (ts *TokenStream) writeNull(col *ColumnInfo) {
+	switch col.Type {
+	case TypeNVarChar, TypeBigVarChar, TypeBigVarBinary:
+		if col.IsMax() {
+			ts.writeUint64(plpNull)
+		} else {
+			ts.writeUint16(0xFFFF)
+		}
+	default:
+		// Nullable fixed-size types use a zero length prefix
+		ts.writeByte(0)
+	}
+}
+
+// writeVarBytes writes a USHORTLEN or PLP value
+func (ts *TokenStream) writeVarBytes(col *ColumnInfo, data []byte) {
+	if !col.IsMax() {
+		ts.writeUint16(uint16(len(data)))
+		ts.buf = append(ts.buf, data...)
+		return
+	}
+
+	// PLP: total length, one chunk, terminator
+	ts.writeUint64(uint64(len(data)))
+	if len(data) > 0 {
+		ts.writeUint32(uint32(len(data)))
+		ts.buf = append(ts.buf, data...)
+	}
+	ts.writeUint32(0)
+}
+
+// writeDecimal writes a DECIMALN value: length, sign byte, little-endian magnitude
+func (ts *TokenStream) writeDecimal(v *big.Int, size int) {
+	ts.writeByte(byte(size))
+	if v.Sign() < 0 {
+		ts.writeByte(0)
+	} else {
+		ts.writeByte(1)
+	}
+
+	magnitude := new(big.Int).Abs(v).Bytes() // Big-endian
+	le := make([]byte, size-1)
+	for i := 0; i < len(magnitude) && i < len(le); i++ {
+		le[i] = magnitude[len(magnitude)-1-i]
+	}
+	ts.buf = append(ts.buf, le...)
+}
+
+// writeDateTime2 writes a DATETIME2N(7) value: 5-byte time in 100ns units, 3-byte days since 0001-01-01
+func (ts *TokenStream) writeDateTime2(t time.Time) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	clock := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	ticks := uint64(clock.Sub(midnight) / 100)
+	days := uint32((midnight.Unix() - dayZero.Unix()) / 86400)
+
+	ts.writeByte(8)
+	ts.buf = append(ts.buf, byte(ticks), byte(ticks>>8), byte(ticks>>16), byte(ticks>>24), byte(ticks>>32))
+	ts.buf = append(ts.buf, byte(days), byte(days>>8), byte(days>>16))
+}
+
+// decimalSize returns the DECIMALN value length for a precision
+func decimalSize(precision byte) int {
+	switch {
+	case precision <= 9:
+		return 5
+	case precision <= 19:
+		return 9
+	case precision <= 28:
+		return 13
+	default:
+		return 17
+	}
+}
+
+// fitsInt reports whether v fits in an integer of the given byte size
+func fitsInt(v int64, size int) bool {
+	switch size {
+	case 1:
+		return v >= 0 && v <= math.MaxUint8 // TINYINT is unsigned
+	case 2:
+		return v >= math.MinInt16 && v <= math.MaxInt16
+	case 4:
+		return v >= math.MinInt32 && v <= math.MaxInt32
+	default:
+		return true
+	}
+}
+
+// toInt64 converts an integer value
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// toBool converts a BIT value; integers are true when non-zero
+func toBool(value interface{}) (bool, bool) {
+	if v, ok := value.(bool); ok {
+		return v, true
+	}
+	if v, ok := toInt64(value); ok {
+		return v != 0, true
+	}
+	return false, false
+}
+
+// toFloat64 converts a floating point or integer value
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case bool:
+		return 0, false
+	}
+	if v, ok := toInt64(value); ok {
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// toDecimal converts a value to an unscaled integer with the given scale
+// The result is nil when the value does not fit the precision
+func toDecimal(value interface{}, precision, scale byte) (*big.Int, bool) {
+	r := new(big.Rat)
+	switch v := value.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, false
+		}
+		// Round through the decimal representation to avoid binary artefacts
+		if _, ok := r.SetString(strconv.FormatFloat(v, 'f', int(scale), 64)); !ok {
+			return nil, false
+		}
+	case string:
+		if _, ok := r.SetString(strings.TrimSpace(v)); !ok {
+			return nil, false
+		}
+	case []byte:
+		if _, ok := r.SetString(strings.TrimSpace(string(v))); !ok {
+			return nil, false
+		}
+	default:
+		i, ok := toInt64(value)
+		if !ok {
+			return nil, false
+		}
+		r.SetInt64(i)
+	}
+
+	// Scale and round half away from zero
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	r.Mul(r, new(big.Rat).SetInt(pow))
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		if r.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	if new(big.Int).Abs(q).Cmp(limit) >= 0 {
+		return nil, false
+	}
+	return q, true
+}
+
+// timeLayouts are the textual date formats accepted for DATETIME2 columns
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	time.RFC3339Nano,
+}
+
+// toTime converts a time value or a timestamp string
+func toTime(value interface{}) (time.Time, bool) {
+	var s string
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return time.Time{}, false
+	}
+
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// toGUID converts a UNIQUEIDENTIFIER to its 16-byte wire form
+// The first three groups of the textual form are stored little-endian
+func toGUID(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case []byte:
+		if len(v) == 16 {
+			return v, true
+		}
+		return toGUID(string(v))
+	case string:
+		s := strings.Trim(strings.TrimSpace(v), "{}")
+		if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			return nil, false
+		}
+		raw, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+		if err != nil {
+			return nil, false
+		}
+		guid := make([]byte, 16)
+		binary.LittleEndian.PutUint32(guid[0:4], binary.BigEndian.Uint32(raw[0:4]))
+		binary.LittleEndian.PutUint16(guid[4:6], binary.BigEndian.Uint16(raw[4:6]))
+		binary.LittleEndian.PutUint16(guid[6:8], binary.BigEndian.Uint16(raw[6:8]))
+		copy(guid[8:], raw[8:])
+		return guid, true
+	default:
+		return nil, false
+	}
+}
+
+// toBytes converts a binary value
+func toBytes(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	default:
+		return nil, false
+	}
+}
+
+// toString converts any value to its NVARCHAR text
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.9999999")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,26 @@
+package tds
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+// EncodeUCS2 converts a UTF-8 string to UTF-16LE bytes as used on the wire
+func EncodeUCS2(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	buf := make([]byte, len(units)*2)
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(buf[i*2:], u)
+	}
+	return buf
+}
+
+// DecodeUCS2 converts UTF-16LE bytes to a UTF-8 string
+// A trailing odd byte is ignored
+func DecodeUCS2(data []byte) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(units))
+}
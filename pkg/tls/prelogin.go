@@ -0,0 +1,120 @@
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/factory/mssql-tds-server/pkg/tds"
+)
+
+// clientEncryptionMask strips the ENCRYPT_CLIENT_CERT bit from a client's ENCRYPTION option
+const clientEncryptionMask = 0x0F
+
+// NegotiateEncryption returns the ENCRYPTION value answering a client's PRELOGIN
+// EncryptionNotSupported means no TLS, EncryptionOff means only LOGIN7 is
+// encrypted, and EncryptionOn or EncryptionRequired mean the whole connection
+// is. An error means the connection must be closed after the response
+func NegotiateEncryption(config *Config, client byte) (byte, error) {
+	client &= clientEncryptionMask
+
+	if !config.Enabled {
+		// A client requiring encryption closes the connection itself
+		return EncryptionNotSupported, nil
+	}
+
+	switch client {
+	case EncryptionNotSupported:
+		if config.ForceEncryption {
+			return EncryptionRequired, fmt.Errorf("client does not support encryption, which the server requires")
+		}
+		return EncryptionNotSupported, nil
+	case EncryptionOff:
+		if config.ForceEncryption {
+			return EncryptionRequired, nil
+		}
+		return EncryptionOff, nil
+	case EncryptionOn, EncryptionRequired:
+		return EncryptionOn, nil
+	default:
+		return EncryptionNotSupported, fmt.Errorf("unsupported client encryption option %#02x", client)
+	}
+}
+
+// ServerHandshake runs the server side of the TLS handshake that follows
+// PRELOGIN. Handshake records travel in PRELOGIN packets; once the handshake
+// is done, the returned connection reads and writes TLS records directly on conn
+// TLS 1.3 is left to TDS 8.0: a 1.3 client sends its last handshake flight
+// without waiting for a reply, and TDS 7.x drivers only send a PRELOGIN
+// packet once they wait for one
+func ServerHandshake(conn net.Conn, config *tls.Config) (*tls.Conn, error) {
+	config = config.Clone()
+	if config.MaxVersion == 0 || config.MaxVersion > tls.VersionTLS12 {
+		config.MaxVersion = tls.VersionTLS12
+	}
+	// Session tickets would be sent after the handshake and framed wrongly
+	config.SessionTicketsDisabled = true
+	// The tds/8.0 protocol is for strict connections
+	config.NextProtos = nil
+
+	hc := newHandshakeConn(conn)
+	tlsConn := tls.Server(hc, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	hc.handshakeDone = true
+
+	return tlsConn, nil
+}
+
+// handshakeConn frames TLS handshake records in PRELOGIN packets, then
+// passes data through unframed
+type handshakeConn struct {
+	net.Conn
+	reader        *tds.MessageReader
+	writer        *tds.MessageWriter
+	pending       []byte // Received handshake data not yet read
+	handshakeDone bool
+}
+
+func newHandshakeConn(conn net.Conn) *handshakeConn {
+	return &handshakeConn{
+		Conn:   conn,
+		reader: tds.NewMessageReader(conn),
+		writer: tds.NewMessageWriter(conn, tds.DefaultPacketSize),
+	}
+}
+
+// Read returns handshake data from PRELOGIN messages, or raw data after the handshake
+func (c *handshakeConn) Read(b []byte) (int, error) {
+	if c.handshakeDone && len(c.pending) == 0 {
+		return c.Conn.Read(b)
+	}
+
+	for len(c.pending) == 0 {
+		msg, err := c.reader.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if msg.Type != tds.PacketTypePreLogin {
+			return 0, fmt.Errorf("unexpected packet type %#02x during TLS handshake", msg.Type)
+		}
+		c.pending = msg.Data
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write sends handshake data as a PRELOGIN message, or raw data after the handshake
+func (c *handshakeConn) Write(b []byte) (int, error) {
+	if c.handshakeDone {
+		return c.Conn.Write(b)
+	}
+
+	if err := c.writer.WriteMessage(tds.PacketTypePreLogin, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,141 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCertificate creates a certificate quickly; GenerateSelfSignedCertificate uses 4096-bit RSA
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServerHandshake(t *testing.T) {
+	// TLS 1.3 is not negotiated in PRELOGIN
+	for _, maxVersion := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		t.Run(tls.VersionName(maxVersion), func(t *testing.T) {
+			serverConn, clientConn := net.Pipe()
+			defer serverConn.Close()
+			defer clientConn.Close()
+
+			config := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}, MaxVersion: maxVersion}
+
+			// The client frames its side of the handshake the same way
+			done := make(chan error, 1)
+			go func() {
+				hc := newHandshakeConn(clientConn)
+				client := tls.Client(hc, &tls.Config{InsecureSkipVerify: true})
+				if err := client.Handshake(); err != nil {
+					done <- err
+					return
+				}
+				hc.handshakeDone = true
+
+				// Encrypted login, then a cleartext reply as with login-only encryption
+				if _, err := client.Write([]byte("login")); err != nil {
+					done <- err
+					return
+				}
+				reply := make([]byte, 5)
+				_, err := io.ReadFull(clientConn, reply)
+				if err == nil && string(reply) != "reply" {
+					t.Errorf("client read %q, want reply", reply)
+				}
+				done <- err
+			}()
+
+			tlsConn, err := ServerHandshake(serverConn, config)
+			if err != nil {
+				t.Fatalf("ServerHandshake() error = %v", err)
+			}
+			if got := tlsConn.ConnectionState().Version; got != tls.VersionTLS12 {
+				t.Errorf("negotiated %s, want TLS 1.2", tls.VersionName(got))
+			}
+
+			login := make([]byte, 5)
+			if _, err := io.ReadFull(tlsConn, login); err != nil || string(login) != "login" {
+				t.Fatalf("server read %q, %v, want login", login, err)
+			}
+			if _, err := serverConn.Write([]byte("reply")); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("client error = %v", err)
+			}
+		})
+	}
+}
+
+func TestServerHandshakeUnexpectedPacket(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		// LOGIN7 where the ClientHello should be
+		clientConn.Write([]byte{0x10, 0x01, 0x00, 0x09, 0, 0, 1, 0, 0})
+		clientConn.Close()
+	}()
+
+	config := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+	if _, err := ServerHandshake(serverConn, config); err == nil {
+		t.Error("ServerHandshake() error = nil, want error")
+	}
+}
+
+func TestNegotiateEncryption(t *testing.T) {
+	off := &Config{}
+	on := &Config{Enabled: true}
+	forced := &Config{Enabled: true, ForceEncryption: true}
+
+	tests := []struct {
+		name    string
+		config  *Config
+		client  byte
+		want    byte
+		wantErr bool
+	}{
+		{"disabled, client off", off, EncryptionOff, EncryptionNotSupported, false},
+		{"disabled, client on", off, EncryptionOn, EncryptionNotSupported, false},
+		{"enabled, client not supported", on, EncryptionNotSupported, EncryptionNotSupported, false},
+		{"enabled, client off", on, EncryptionOff, EncryptionOff, false},
+		{"enabled, client on", on, EncryptionOn, EncryptionOn, false},
+		{"enabled, client required", on, EncryptionRequired, EncryptionOn, false},
+		{"enabled, client certificate bit", on, 0x80 | EncryptionOn, EncryptionOn, false},
+		{"forced, client off", forced, EncryptionOff, EncryptionRequired, false},
+		{"forced, client on", forced, EncryptionOn, EncryptionOn, false},
+		{"forced, client not supported", forced, EncryptionNotSupported, EncryptionRequired, true},
+		{"enabled, unknown option", on, 0x07, EncryptionNotSupported, true},
+	}
+
+	for _, tt := range tests {
+		got, err := NegotiateEncryption(tt.config, tt.client)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: NegotiateEncryption() = %#02x, %v, want %#02x (error %v)", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// StrictMode selects whether connections use TDS 8.0 strict encryption, where
+// TLS comes before PRELOGIN instead of being negotiated in it
+type StrictMode int
+
+const (
+	StrictDisabled StrictMode = iota // TLS only as negotiated in PRELOGIN
+	StrictAllowed                    // Each connection may start with TLS or with PRELOGIN
+	StrictRequired                   // Every connection starts with TLS
+)
+
+// ALPNProtocol is the application protocol strict clients offer in their ClientHello
+const ALPNProtocol = "tds/8.0"
+
+// recordTypeHandshake is the first byte of a TLS ClientHello; a PRELOGIN packet starts with 0x12
+const recordTypeHandshake = 0x16
+
+// Listen listens for TDS connections on addr. Connections using strict
+// encryption are returned as TLS connections whose handshake runs on the
+// first read; with StrictAllowed, that first read tells them apart
+func Listen(addr string, mode StrictMode, config *tls.Config) (net.Listener, error) {
+	if mode != StrictDisabled && config == nil {
+		return nil, fmt.Errorf("strict encryption requires a TLS configuration")
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{Listener: l, mode: mode, config: config}, nil
+}
+
+type listener struct {
+	net.Listener
+	mode   StrictMode
+	config *tls.Config
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	switch l.mode {
+	case StrictRequired:
+		return tls.Server(conn, l.config), nil
+	case StrictAllowed:
+		return &detectConn{Conn: conn, config: l.config}, nil
+	default:
+		return conn, nil
+	}
+}
+
+// IsStrict reports whether a connection from Listen uses strict encryption;
+// for StrictAllowed it is known once the connection has been read from
+func IsStrict(conn net.Conn) bool {
+	switch c := conn.(type) {
+	case *tls.Conn:
+		return true
+	case *detectConn:
+		_, ok := c.conn.(*tls.Conn)
+		return ok
+	default:
+		return false
+	}
+}
+
+// detectConn becomes a TLS or a plain connection depending on its first byte
+type detectConn struct {
+	net.Conn
+	config *tls.Config
+
+	once sync.Once
+	conn net.Conn // Set by detect
+	err  error
+}
+
+func (c *detectConn) detect() {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(c.Conn, first); err != nil {
+		c.err = err
+		return
+	}
+
+	replay := &replayConn{Conn: c.Conn, pending: first}
+	if first[0] == recordTypeHandshake {
+		c.conn = tls.Server(replay, c.config)
+	} else {
+		c.conn = replay
+	}
+}
+
+func (c *detectConn) Read(b []byte) (int, error) {
+	c.once.Do(c.detect)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.conn.Read(b)
+}
+
+func (c *detectConn) Write(b []byte) (int, error) {
+	c.once.Do(c.detect)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.conn.Write(b)
+}
+
+// replayConn returns already read bytes before reading the connection again
+type replayConn struct {
+	net.Conn
+	pending []byte
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		return c.Conn.Read(b)
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,114 @@
+package tls
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+)
+
+// acceptOne accepts a connection on l and reads n bytes from it
+func acceptOne(t *testing.T, l net.Listener, n int) (net.Conn, []byte, error) {
+	t.Helper()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(conn, data)
+	return conn, data, err
+}
+
+func TestListenStrictAllowed(t *testing.T) {
+	config := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}, NextProtos: []string{ALPNProtocol}}
+	l, err := Listen("127.0.0.1:0", StrictAllowed, config)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+
+	// A strict client starts with a ClientHello offering tds/8.0
+	go func() {
+		client, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{ALPNProtocol}})
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		client.Write([]byte("prelogin"))
+		io.ReadAll(client)
+	}()
+
+	conn, data, err := acceptOne(t, l, 8)
+	if err != nil || string(data) != "prelogin" {
+		t.Fatalf("strict read %q, %v, want prelogin", data, err)
+	}
+	if !IsStrict(conn) {
+		t.Error("IsStrict() = false for a TLS connection")
+	}
+	if got := conn.(*detectConn).conn.(*tls.Conn).ConnectionState().NegotiatedProtocol; got != ALPNProtocol {
+		t.Errorf("NegotiatedProtocol = %q, want %q", got, ALPNProtocol)
+	}
+	conn.Close()
+
+	// Other clients start with PRELOGIN, first byte included
+	go func() {
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		client.Write([]byte{0x12, 0x01})
+		io.ReadAll(client)
+	}()
+
+	conn, data, err = acceptOne(t, l, 2)
+	if err != nil || data[0] != 0x12 || data[1] != 0x01 {
+		t.Fatalf("plain read %v, %v, want [18 1]", data, err)
+	}
+	if IsStrict(conn) {
+		t.Error("IsStrict() = true for a plain connection")
+	}
+	conn.Close()
+}
+
+func TestListenStrictRequired(t *testing.T) {
+	config := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}, NextProtos: []string{ALPNProtocol}}
+	l, err := Listen("127.0.0.1:0", StrictRequired, config)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+
+	// PRELOGIN first fails the handshake
+	go func() {
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		client.Write([]byte{0x12, 0x01, 0x00, 0x08, 0, 0, 1, 0})
+		io.ReadAll(client)
+	}()
+
+	conn, _, err := acceptOne(t, l, 1)
+	if err == nil {
+		t.Error("read from a PRELOGIN-first connection error = nil, want handshake error")
+	}
+	if !IsStrict(conn) {
+		t.Error("IsStrict() = false in strict-only mode")
+	}
+	conn.Close()
+}
+
+func TestListenStrictRequiresConfig(t *testing.T) {
+	if _, err := Listen("127.0.0.1:0", StrictAllowed, nil); err == nil {
+		t.Error("Listen() without a TLS config error = nil, want error")
+	}
+
+	l, err := Listen("127.0.0.1:0", StrictDisabled, nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	l.Close()
+}
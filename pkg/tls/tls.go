@@ -11,14 +11,16 @@ import (
 	"math/big"
 	"net"
 	"os"
+	"path/filepath"
 	"time"
 )
 
 const (
-	// Encryption levels
-	EncryptionOff      = 0x00 // No encryption (cleartext)
-	EncryptionOn       = 0x01 // SSL/TLS encryption if supported
-	EncryptionRequired = 0x02 // Encryption required (reject if not supported)
+	// Encryption levels (PRELOGIN ENCRYPTION option values)
+	EncryptionOff          = 0x00 // Encryption available but off (login packet only)
+	EncryptionOn           = 0x01 // SSL/TLS encryption if supported
+	EncryptionNotSupported = 0x02 // Encryption not available (cleartext)
+	EncryptionRequired     = 0x03 // Encryption required (reject if not supported)
 )
 
 // Config represents SSL/TLS configuration
@@ -31,6 +33,7 @@ type Config struct {
 	MaxVersion     uint16 // Maximum TLS version
 	ClientAuth     tls.ClientAuthType // Client certificate auth
 	TrustServerCert bool // Trust server certificate (development only)
+	Strict         StrictMode // TDS 8.0 strict encryption: TLS before PRELOGIN
 }
 
 // DefaultConfig returns default SSL/TLS configuration
@@ -44,6 +47,7 @@ func DefaultConfig() *Config {
 		MaxVersion:     tls.VersionTLS13,
 		ClientAuth:     tls.NoClientCert,
 		TrustServerCert: false,
+		Strict:         StrictDisabled,
 	}
 }
 
@@ -78,6 +82,9 @@ func GenerateSelfSignedCertificate(commonName string, validityDays int) (*tls.Ce
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
+		// Clients verifying the certificate match the host they dialed against these
+		DNSNames:    []string{commonName, "localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
 	}
 
 	// Generate certificate
@@ -153,9 +160,9 @@ func LoadOrCreateCertificate(certFile, keyFile string, commonName string) (*tls.
 		}
 	}
 
-	// Ensure directory exists
-	certDir := "./certs"
-	os.MkdirAll(certDir, 0755)
+	// Ensure directories exist
+	os.MkdirAll(filepath.Dir(certFile), 0755)
+	os.MkdirAll(filepath.Dir(keyFile), 0755)
 
 	// Generate new self-signed certificate
 	cert, err := GenerateSelfSignedCertificate(commonName, 365)
@@ -215,6 +222,11 @@ func CreateTLSConfig(config *Config) (*tls.Config, error) {
 		)
 	}
 
+	// Strict clients offer the TDS 8.0 protocol in ALPN
+	if config.Strict != StrictDisabled {
+		tlsConfig.NextProtos = []string{ALPNProtocol}
+	}
+
 	return tlsConfig, nil
 }
 
@@ -238,7 +250,7 @@ func CreateTLSListener(addr string, config *Config) (net.Listener, error) {
 // GetEncryptionLevel returns encryption level based on configuration
 func GetEncryptionLevel(config *Config) byte {
 	if !config.Enabled {
-		return EncryptionOff
+		return EncryptionNotSupported
 	}
 
 	if config.ForceEncryption {
@@ -257,6 +269,9 @@ func IsEncryptionEnabled(config *Config) bool {
 func ValidateConfig(config *Config) error {
 	// Check if SSL/TLS is enabled
 	if !config.Enabled {
+		if config.Strict != StrictDisabled {
+			return fmt.Errorf("strict encryption requires SSL/TLS to be enabled")
+		}
 		return nil
 	}
 
@@ -278,6 +293,10 @@ func ValidateConfig(config *Config) error {
 		return fmt.Errorf("key file not found: %w", err)
 	}
 
+	if config.Strict < StrictDisabled || config.Strict > StrictRequired {
+		return fmt.Errorf("invalid strict encryption mode %d", config.Strict)
+	}
+
 	// Validate TLS versions
 	if config.MinVersion < tls.VersionTLS12 {
 		return fmt.Errorf("minimum TLS version must be at least TLS 1.2")
@@ -310,6 +329,9 @@ func GenerateCertificatePEM(commonName string, validityDays int) (certPEM, keyPE
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
+		// Clients verifying the certificate match the host they dialed against these
+		DNSNames:    []string{commonName, "localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
 	}
 
 	// Generate certificate
@@ -1,6 +1,7 @@
 package transaction
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
@@ -34,10 +35,20 @@ func NewContext() *Context {
 
 // Begin begins a new transaction
 func (c *Context) Begin(db *sql.DB) (*sql.Tx, error) {
+	return c.BeginContext(context.Background(), db)
+}
+
+// Beginner begins transactions, as *sql.DB and *sql.Conn do
+type Beginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// BeginContext begins a new transaction on db; cancelling ctx rolls it back
+func (c *Context) BeginContext(ctx context.Context, db Beginner) (*sql.Tx, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
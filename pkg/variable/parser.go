@@ -97,11 +97,13 @@ func ParseProcedureBody(body string) ([]string, error) {
 	return statements, nil
 }
 
+// variableRegex matches a variable reference
+var variableRegex = regexp.MustCompile(`@\w+`)
+
 // FindVariableReferences finds all variable references in a SQL statement
 func FindVariableReferences(sql string) []string {
 	// Find all @variable references
-	re := regexp.MustCompile(`@\w+`)
-	matches := re.FindAllString(sql, -1)
+	matches := variableRegex.FindAllString(sql, -1)
 
 	// Deduplicate
 	seen := make(map[string]bool)
@@ -118,28 +120,25 @@ func FindVariableReferences(sql string) []string {
 }
 
 // ReplaceVariables replaces variable references with their values in SQL
+// NULL variables are replaced with NULL
 func ReplaceVariables(sql string, context *Context) (string, error) {
-	// Find all variable references
-	references := FindVariableReferences(sql)
+	var replaceErr error
 
-	result := sql
-	for _, ref := range references {
-		// Get variable from context
+	// Replace whole references only, so @id is not replaced inside @idx
+	result := variableRegex.ReplaceAllStringFunc(sql, func(ref string) string {
 		variable, exists := context.Get(ref)
 		if !exists {
-			return "", fmt.Errorf("variable '%s' not declared", ref)
-		}
-
-		// Skip if variable is NULL (we handle this in SQL generation)
-		if variable.IsNull {
-			return "", fmt.Errorf("variable '%s' is NULL", ref)
+			if replaceErr == nil {
+				replaceErr = fmt.Errorf("variable '%s' not declared", ref)
+			}
+			return ref
 		}
 
 		// Format value based on type
-		formattedValue := FormatValue(variable.Value, variable.Type)
-
-		// Replace in SQL (case-sensitive replacement to avoid partial matches)
-		result = strings.ReplaceAll(result, ref, formattedValue)
+		return FormatValue(variable.Value, variable.Type)
+	})
+	if replaceErr != nil {
+		return "", replaceErr
 	}
 
 	return result, nil